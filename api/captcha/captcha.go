@@ -0,0 +1,224 @@
+// Package captcha checks the tokens that captcha widgets give commenters.
+// Turnstile, hCaptcha and reCAPTCHA v3 all work the same way: the server
+// posts the token and its secret to the provider's siteverify endpoint and
+// gets back whether the challenge was passed, on which page, and for
+// reCAPTCHA a score.
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The providers' siteverify endpoints.
+const (
+	TurnstileURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	HCaptchaURL  = "https://api.hcaptcha.com/siteverify"
+	ReCaptchaURL = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+// DefaultMinScore is the reCAPTCHA v3 score below which a token is refused,
+// as Google suggests to start with.
+const DefaultMinScore = 0.5
+
+// The most of a siteverify response that is read.
+const maxResponseSize = 64 << 10
+
+// ErrRejected means the provider or the checks here turned down the token,
+// as opposed to the provider not answering.
+var ErrRejected = errors.New("captcha rejected")
+
+// Verifier checks a token from the comment form. remoteIP is passed on to the
+// provider, which may use it as a signal; it may be empty.
+type Verifier interface {
+	Verify(ctx context.Context, token, remoteIP string) error
+}
+
+// Disabled accepts every token, including none, for local development.
+type Disabled struct{}
+
+func (Disabled) Verify(ctx context.Context, token, remoteIP string) error {
+	return nil
+}
+
+// Turnstile is Cloudflare's captcha. Hostnames are the pages it may have been
+// solved on; Action, if set, must match the widget's data-action.
+type Turnstile struct {
+	Secret    string
+	VerifyURL string
+	Hostnames []string
+	Action    string
+	Client    *http.Client
+}
+
+func (t *Turnstile) Verify(ctx context.Context, token, remoteIP string) error {
+	result, err := siteverify(ctx, t.Client, orDefault(t.VerifyURL, TurnstileURL), url.Values{
+		"secret":   {t.Secret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	})
+	if err != nil {
+		return err
+	}
+	if err := result.check(t.Hostnames); err != nil {
+		return err
+	}
+	return result.checkAction(t.Action)
+}
+
+// HCaptcha is hCaptcha. SiteKey, if set, is sent along so that hCaptcha
+// refuses tokens from another of the account's sites.
+type HCaptcha struct {
+	Secret    string
+	SiteKey   string
+	VerifyURL string
+	Hostnames []string
+	Client    *http.Client
+}
+
+func (h *HCaptcha) Verify(ctx context.Context, token, remoteIP string) error {
+	form := url.Values{
+		"secret":   {h.Secret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	}
+	if h.SiteKey != "" {
+		form.Set("sitekey", h.SiteKey)
+	}
+
+	result, err := siteverify(ctx, h.Client, orDefault(h.VerifyURL, HCaptchaURL), form)
+	if err != nil {
+		return err
+	}
+	return result.check(h.Hostnames)
+}
+
+// ReCaptcha is Google's reCAPTCHA v3, which never shows a challenge and
+// instead scores how likely the visitor is human, from 0 to 1.
+type ReCaptcha struct {
+	Secret    string
+	VerifyURL string
+	Hostnames []string
+	Action    string
+	MinScore  float64 // DefaultMinScore if zero
+	Client    *http.Client
+}
+
+func (rc *ReCaptcha) Verify(ctx context.Context, token, remoteIP string) error {
+	result, err := siteverify(ctx, rc.Client, orDefault(rc.VerifyURL, ReCaptchaURL), url.Values{
+		"secret":   {rc.Secret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	})
+	if err != nil {
+		return err
+	}
+	if err := result.check(rc.Hostnames); err != nil {
+		return err
+	}
+	if err := result.checkAction(rc.Action); err != nil {
+		return err
+	}
+
+	minScore := rc.MinScore
+	if minScore == 0 {
+		minScore = DefaultMinScore
+	}
+	if result.Score == nil {
+		return fmt.Errorf("%w: no score, not a v3 token", ErrRejected)
+	}
+	if *result.Score < minScore {
+		return fmt.Errorf("%w: score %.1f is below %.1f", ErrRejected, *result.Score, minScore)
+	}
+
+	return nil
+}
+
+// response is what the three providers have in common.
+type response struct {
+	Success    bool     `json:"success"`
+	Hostname   string   `json:"hostname"`
+	Action     string   `json:"action"`
+	Score      *float64 `json:"score"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func siteverify(ctx context.Context, client *http.Client, verifyURL string, form url.Values) (*response, error) {
+	if form.Get("response") == "" {
+		return nil, fmt.Errorf("%w: no token", ErrRejected)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if form.Get("remoteip") == "" {
+		form.Del("remoteip")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("siteverify returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var result response
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("bad siteverify response: %v", err)
+	}
+
+	return &result, nil
+}
+
+// check is the part every provider shares: the challenge was passed, on one
+// of our pages. An empty list of hostnames allows any.
+func (r *response) check(hostnames []string) error {
+	if !r.Success {
+		return fmt.Errorf("%w: %s", ErrRejected, strings.Join(r.ErrorCodes, ", "))
+	}
+	if len(hostnames) > 0 && !slices.Contains(hostnames, strings.ToLower(r.Hostname)) {
+		return fmt.Errorf("%w: solved on %q", ErrRejected, r.Hostname)
+	}
+	return nil
+}
+
+func (r *response) checkAction(action string) error {
+	if action != "" && r.Action != action {
+		return fmt.Errorf("%w: action %q, expected %q", ErrRejected, r.Action, action)
+	}
+	return nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// NewClient is an HTTP client for siteverify requests, which are abandoned
+// after timeout.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout}
+}
@@ -0,0 +1,134 @@
+package captcha_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carlohamalainen/carlo-comments/captcha"
+)
+
+// siteverify answers every request with status and body, and keeps the form
+// it was sent.
+func siteverify(t *testing.T, status int, body string) (*httptest.Server, *http.Request) {
+	t.Helper()
+
+	seen := &http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		*seen = *r
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, seen
+}
+
+func TestVerifiers(t *testing.T) {
+	hostnames := []string{"example.com"}
+
+	newTurnstile := func(url string) captcha.Verifier {
+		return &captcha.Turnstile{Secret: "secret", VerifyURL: url, Hostnames: hostnames, Action: "comment"}
+	}
+	newHCaptcha := func(url string) captcha.Verifier {
+		return &captcha.HCaptcha{Secret: "secret", SiteKey: "sitekey", VerifyURL: url, Hostnames: hostnames}
+	}
+	newReCaptcha := func(url string) captcha.Verifier {
+		return &captcha.ReCaptcha{Secret: "secret", VerifyURL: url, Hostnames: hostnames, Action: "comment", MinScore: 0.5}
+	}
+
+	tests := []struct {
+		name     string
+		verifier func(url string) captcha.Verifier
+		status   int
+		body     string
+		token    string
+		wantErr  bool
+		rejected bool
+	}{
+		{"turnstile success", newTurnstile, http.StatusOK,
+			`{"success": true, "hostname": "example.com", "action": "comment"}`, "token", false, false},
+		{"turnstile failure", newTurnstile, http.StatusOK,
+			`{"success": false, "error-codes": ["invalid-input-response"]}`, "token", true, true},
+		{"turnstile wrong action", newTurnstile, http.StatusOK,
+			`{"success": true, "hostname": "example.com", "action": "login"}`, "token", true, true},
+		{"turnstile wrong hostname", newTurnstile, http.StatusOK,
+			`{"success": true, "hostname": "evil.example.net", "action": "comment"}`, "token", true, true},
+		{"turnstile upstream error", newTurnstile, http.StatusBadGateway,
+			`bad gateway`, "token", true, false},
+		{"turnstile no token", newTurnstile, http.StatusOK,
+			`{"success": true, "hostname": "example.com", "action": "comment"}`, "", true, true},
+
+		{"hcaptcha success", newHCaptcha, http.StatusOK,
+			`{"success": true, "hostname": "Example.com"}`, "token", false, false},
+		{"hcaptcha failure", newHCaptcha, http.StatusOK,
+			`{"success": false, "error-codes": ["invalid-or-already-seen-response"]}`, "token", true, true},
+		{"hcaptcha wrong hostname", newHCaptcha, http.StatusOK,
+			`{"success": true, "hostname": "evil.example.net"}`, "token", true, true},
+		{"hcaptcha upstream error", newHCaptcha, http.StatusInternalServerError,
+			`oops`, "token", true, false},
+
+		{"recaptcha success", newReCaptcha, http.StatusOK,
+			`{"success": true, "hostname": "example.com", "action": "comment", "score": 0.9}`, "token", false, false},
+		{"recaptcha failure", newReCaptcha, http.StatusOK,
+			`{"success": false, "error-codes": ["timeout-or-duplicate"]}`, "token", true, true},
+		{"recaptcha low score", newReCaptcha, http.StatusOK,
+			`{"success": true, "hostname": "example.com", "action": "comment", "score": 0.1}`, "token", true, true},
+		{"recaptcha no score", newReCaptcha, http.StatusOK,
+			`{"success": true, "hostname": "example.com", "action": "comment"}`, "token", true, true},
+		{"recaptcha wrong action", newReCaptcha, http.StatusOK,
+			`{"success": true, "hostname": "example.com", "action": "login", "score": 0.9}`, "token", true, true},
+		{"recaptcha wrong hostname", newReCaptcha, http.StatusOK,
+			`{"success": true, "hostname": "evil.example.net", "action": "comment", "score": 0.9}`, "token", true, true},
+		{"recaptcha upstream error", newReCaptcha, http.StatusServiceUnavailable,
+			`unavailable`, "token", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, seen := siteverify(t, tt.status, tt.body)
+
+			err := tt.verifier(server.URL).Verify(context.Background(), tt.token, "192.0.2.1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, captcha.ErrRejected) != tt.rejected {
+				t.Fatalf("got error %v, want rejected %v", err, tt.rejected)
+			}
+
+			if tt.token == "" {
+				return
+			}
+			if seen.PostForm.Get("secret") != "secret" || seen.PostForm.Get("response") != tt.token || seen.PostForm.Get("remoteip") != "192.0.2.1" {
+				t.Fatalf("sent %v", seen.PostForm)
+			}
+		})
+	}
+}
+
+func TestHCaptchaSendsSiteKey(t *testing.T) {
+	server, seen := siteverify(t, http.StatusOK, `{"success": true, "hostname": "example.com"}`)
+
+	verifier := &captcha.HCaptcha{Secret: "secret", SiteKey: "sitekey", VerifyURL: server.URL}
+	if err := verifier.Verify(context.Background(), "token", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if seen.PostForm.Get("sitekey") != "sitekey" || seen.PostForm.Has("remoteip") {
+		t.Fatalf("sent %v", seen.PostForm)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	for _, token := range []string{"", "anything"} {
+		if err := (captcha.Disabled{}).Verify(context.Background(), token, ""); err != nil {
+			t.Fatalf("token %q: %v", token, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package conduit
+
+import (
+	"context"
+	"time"
+)
+
+type AddressList string
+
+const (
+	AddressBlock AddressList = "block" // refuse comments from the network
+	AddressAllow AddressList = "allow" // an exception to a wider block
+)
+
+// AddressRule puts an address or a network on the block list or the allow
+// list. Where rules overlap, the one with the longest prefix decides, so an
+// allowed address inside a blocked network is let through.
+type AddressRule struct {
+	Prefix    string      `json:"prefix"` // a masked CIDR; a single address is a /32 or /128
+	List      AddressList `json:"list"`
+	Reason    string      `json:"reason"`
+	CreatedBy string      `json:"createdBy"`
+	CreatedAt Timestamp   `json:"createdAt"`
+	ExpiresAt *Timestamp  `json:"expiresAt,omitempty"` // nil for a rule that doesn't expire
+}
+
+func (rule AddressRule) Expired(now time.Time) bool {
+	return rule.ExpiresAt != nil && !now.Before(time.Time(*rule.ExpiresAt))
+}
+
+// AddressRuleStore persists the rules, which apply to every site. There is
+// one rule per prefix.
+//
+//   - AddressRules lists every rule, expired or not, in no particular order.
+//   - PutAddressRule inserts or replaces by Prefix.
+//   - DeleteAddressRule is not an error for an unknown prefix.
+type AddressRuleStore interface {
+	AddressRules(ctx context.Context) ([]AddressRule, error)
+	PutAddressRule(ctx context.Context, rule AddressRule) error
+	DeleteAddressRule(ctx context.Context, prefix string) error
+}
@@ -9,6 +9,14 @@ import (
 
 type Timestamp time.Time
 
+// CommentKind says how a comment arrived.
+type CommentKind string
+
+const (
+	KindComment CommentKind = ""        // posted through the comment form
+	KindMention CommentKind = "mention" // a Webmention from another site
+)
+
 func (t Timestamp) MarshalJSON() ([]byte, error) {
 	milliseconds := time.Time(t).UnixMilli()
 	return []byte(strconv.FormatInt(milliseconds, 10)), nil
@@ -27,34 +35,101 @@ type Comment struct {
 	CommentID     string    `json:"commentID"`
 	SiteID        string    `json:"siteID"`
 	PostID        string    `json:"postID"`
+	ParentID      string    `json:"parentID"` // empty for a top level comment
 	Timestamp     Timestamp `json:"timestamp"`
 	SourceAddress string    `json:"sourceAddress"`
 	Author        string    `json:"author"`
 	AuthorEmail   string    `json:"authorEmail"`
 	CommentBody   string    `json:"commentBody"`
-	IsActive      bool      `json:"isActive"`
+
+	Status  ModerationStatus `json:"status"`
+	History []StatusChange   `json:"history"`
+
+	Kind      CommentKind `json:"kind"`
+	SourceURL string      `json:"sourceURL"` // the mentioning page, for KindMention
+
+	// What the spam checks made of the comment when it arrived. Only admins
+	// see these.
+	SpamScore   float64  `json:"spamScore,omitempty"`
+	SpamReasons []string `json:"spamReasons,omitempty"`
+
+	// The classifier's estimate, 0 if it hadn't learnt enough to say.
+	SpamProbability float64 `json:"spamProbability,omitempty"`
+
+	// Same as Status == StatusApproved, kept for clients that predate Status.
+	IsActive bool `json:"isActive"`
 }
 
 type NewComment struct {
 	SiteID         string `json:"siteID"`
 	PostID         string `json:"postID"`
+	ParentID       string `json:"parentID"`
 	Author         string `json:"author"`
 	AuthorEmail    string `json:"authorEmail"`
 	CommentBody    string `json:"commentBody"`
-	TurnstileToken string `json:"turnstileToken"`
+	CaptchaToken   string `json:"captchaToken"`
+	TurnstileToken string `json:"turnstileToken"` // what CaptchaToken was called before there was a choice
+
+	// Subscribe asks for emails about new comments on the post, sent to
+	// AuthorEmail once they confirm.
+	Subscribe bool `json:"subscribe"`
 }
 
 type CommentFilter struct {
 	CommentID *string
 	SiteID    *string
 	PostID    *string
+	ParentID  *string // pointer to "" selects top level comments
+	Status    *ModerationStatus
 	IsActive  *bool
 }
 
+// Matches checks a comment against every field of the filter, for backends
+// that can't push the whole filter down into a query.
+func (filter CommentFilter) Matches(c Comment) bool {
+	if filter.CommentID != nil && c.CommentID != *filter.CommentID {
+		return false
+	}
+	if filter.SiteID != nil && c.SiteID != *filter.SiteID {
+		return false
+	}
+	if filter.PostID != nil && c.PostID != *filter.PostID {
+		return false
+	}
+	if filter.ParentID != nil && c.ParentID != *filter.ParentID {
+		return false
+	}
+	if filter.Status != nil && c.Status != *filter.Status {
+		return false
+	}
+	if filter.IsActive != nil && c.IsActive != *filter.IsActive {
+		return false
+	}
+	return true
+}
+
+// CommentService is implemented by each storage backend. They must all agree
+// on the following, which conduit/conduittest checks:
+//
+//   - NrComments needs SiteID and PostID and counts every comment on that post
+//     that matches the rest of the filter; a nil IsActive counts both states.
+//   - NrCommentsByPost is NrComments for each of the PostIDs at once, in
+//     place of the filter's PostID. Every PostID asked for is in the result.
+//   - UpsertComment inserts, or replaces the comment with the same SiteID and
+//     CommentID.
+//   - Comments needs SiteID; CommentID, PostID, ParentID, Status and IsActive
+//     narrow the result when set. No match is an empty result, not an error.
+//   - Comments are ordered by Timestamp (then CommentID) as the PageRequest
+//     asks, and walking NextCursor visits every match exactly once.
+//   - Comments written before Status existed read back with the status from
+//     conduit.StatusFromIsActive, and IsActive always agrees with Status.
+//   - DeleteComment removes the comment with the same SiteID and CommentID.
+//     Deleting a comment that does not exist is not an error.
 type CommentService interface {
 	NrComments(context.Context, CommentFilter) (int, error)
+	NrCommentsByPost(ctx context.Context, filter CommentFilter, postIDs []string) (map[string]int, error)
 	UpsertComment(context.Context, *Comment) error
-	Comments(context.Context, CommentFilter) ([]Comment, error)
+	Comments(context.Context, CommentFilter, PageRequest) (CommentPage, error)
 	DeleteComment(context.Context, *Comment) error
 }
 
@@ -67,7 +142,12 @@ func IsValidEmail(email string) bool {
 	return err == nil
 }
 
+// IsValidPostID accepts a non-empty path of letters, digits, slashes,
+// hyphens and underscores.
 func IsValidPostID(key string) bool {
+	if key == "" {
+		return false
+	}
 	for _, c := range key {
 		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '/' || c == '-' || c == '_') {
 			return false
@@ -0,0 +1,28 @@
+package conduit_test
+
+import (
+	"testing"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+func TestIsValidPostID(t *testing.T) {
+	tests := []struct {
+		postID string
+		want   bool
+	}{
+		{"/2024/01/01/a-post", true},
+		{"/posts/hello_world/", true},
+		{"/", true},
+		{"", false},
+		{"/posts/hello world", false},
+		{"/posts/../admin", false},
+		{"/posts/?q=1", false},
+	}
+
+	for _, tt := range tests {
+		if got := conduit.IsValidPostID(tt.postID); got != tt.want {
+			t.Errorf("IsValidPostID(%q) = %v, want %v", tt.postID, got, tt.want)
+		}
+	}
+}
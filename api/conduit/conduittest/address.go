@@ -0,0 +1,148 @@
+package conduittest
+
+import (
+	"crypto/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// TestAddressRuleStore checks a conduit.AddressRuleStore, in the same way as
+// TestCommentService. The rules aren't per site, so each test uses prefixes
+// of its own and ignores any other rules in the store.
+func TestAddressRuleStore(t *testing.T, as conduit.AddressRuleStore) {
+	t.Run("PutAndList", func(t *testing.T) { testAddressRulePutAndList(t, as) })
+	t.Run("Expiry", func(t *testing.T) { testAddressRuleExpiry(t, as) })
+	t.Run("Replace", func(t *testing.T) { testAddressRuleReplace(t, as) })
+	t.Run("Delete", func(t *testing.T) { testAddressRuleDelete(t, as) })
+}
+
+// newPrefix is a random network in the IPv6 documentation range.
+func newPrefix() string {
+	var addr [16]byte
+	rand.Read(addr[:])
+	addr[0], addr[1], addr[2], addr[3] = 0x20, 0x01, 0x0d, 0xb8
+	return netip.PrefixFrom(netip.AddrFrom16(addr), 64).Masked().String()
+}
+
+func newAddressRule(list conduit.AddressList) conduit.AddressRule {
+	return conduit.AddressRule{
+		Prefix:    newPrefix(),
+		List:      list,
+		Reason:    "conduittest",
+		CreatedBy: "admin@example.com",
+		CreatedAt: conduit.Timestamp(time.UnixMilli(time.Now().UnixMilli())),
+	}
+}
+
+func putAddressRule(t *testing.T, as conduit.AddressRuleStore, rule conduit.AddressRule) {
+	t.Helper()
+	if err := as.PutAddressRule(Context(), rule); err != nil {
+		t.Fatalf("PutAddressRule: %v", err)
+	}
+}
+
+// addressRules lists the rules with the given prefixes.
+func addressRules(t *testing.T, as conduit.AddressRuleStore, prefixes ...string) map[string]conduit.AddressRule {
+	t.Helper()
+	all, err := as.AddressRules(Context())
+	if err != nil {
+		t.Fatalf("AddressRules: %v", err)
+	}
+
+	rules := make(map[string]conduit.AddressRule)
+	for _, rule := range all {
+		for _, prefix := range prefixes {
+			if rule.Prefix == prefix {
+				rules[prefix] = rule
+			}
+		}
+	}
+	return rules
+}
+
+func sameAddressRule(t *testing.T, got, want conduit.AddressRule) {
+	t.Helper()
+	millis := func(ts *conduit.Timestamp) int64 {
+		if ts == nil {
+			return 0
+		}
+		return time.Time(*ts).UnixMilli()
+	}
+	if got.Prefix != want.Prefix || got.List != want.List || got.Reason != want.Reason || got.CreatedBy != want.CreatedBy ||
+		millis(&got.CreatedAt) != millis(&want.CreatedAt) ||
+		(got.ExpiresAt == nil) != (want.ExpiresAt == nil) || millis(got.ExpiresAt) != millis(want.ExpiresAt) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func testAddressRulePutAndList(t *testing.T, as conduit.AddressRuleStore) {
+	block := newAddressRule(conduit.AddressBlock)
+	allow := newAddressRule(conduit.AddressAllow)
+	putAddressRule(t, as, block)
+	putAddressRule(t, as, allow)
+
+	rules := addressRules(t, as, block.Prefix, allow.Prefix)
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(rules))
+	}
+	sameAddressRule(t, rules[block.Prefix], block)
+	sameAddressRule(t, rules[allow.Prefix], allow)
+}
+
+func testAddressRuleExpiry(t *testing.T, as conduit.AddressRuleStore) {
+	rule := newAddressRule(conduit.AddressBlock)
+	expiresAt := conduit.Timestamp(time.Time(rule.CreatedAt).Add(time.Hour))
+	rule.ExpiresAt = &expiresAt
+	putAddressRule(t, as, rule)
+
+	got, ok := addressRules(t, as, rule.Prefix)[rule.Prefix]
+	if !ok {
+		t.Fatal("rule not found")
+	}
+	sameAddressRule(t, got, rule)
+
+	if got.Expired(time.Time(rule.CreatedAt)) || !got.Expired(time.Time(expiresAt)) {
+		t.Errorf("Expired wrong for %+v", got)
+	}
+}
+
+func testAddressRuleReplace(t *testing.T, as conduit.AddressRuleStore) {
+	rule := newAddressRule(conduit.AddressBlock)
+	putAddressRule(t, as, rule)
+
+	rule.List = conduit.AddressAllow
+	rule.Reason = "changed my mind"
+	putAddressRule(t, as, rule)
+
+	rules := addressRules(t, as, rule.Prefix)
+	if len(rules) != 1 {
+		t.Fatalf("got %d rules, want 1", len(rules))
+	}
+	sameAddressRule(t, rules[rule.Prefix], rule)
+}
+
+func testAddressRuleDelete(t *testing.T, as conduit.AddressRuleStore) {
+	rule := newAddressRule(conduit.AddressBlock)
+	other := newAddressRule(conduit.AddressBlock)
+	putAddressRule(t, as, rule)
+	putAddressRule(t, as, other)
+
+	if err := as.DeleteAddressRule(Context(), rule.Prefix); err != nil {
+		t.Fatalf("DeleteAddressRule: %v", err)
+	}
+	rules := addressRules(t, as, rule.Prefix, other.Prefix)
+	if _, ok := rules[rule.Prefix]; ok {
+		t.Error("rule still there after delete")
+	}
+	if _, ok := rules[other.Prefix]; !ok {
+		t.Error("delete removed another rule")
+	}
+
+	// Deleting again is fine.
+	if err := as.DeleteAddressRule(Context(), rule.Prefix); err != nil {
+		t.Errorf("DeleteAddressRule of a missing rule: %v", err)
+	}
+}
@@ -0,0 +1,718 @@
+// Package conduittest checks that a backend's stores behave the same way as
+// every other backend's. Each backend runs every suite from its own tests,
+// as in sqlite/conduit_test.go:
+//
+//	func TestConduit(t *testing.T) {
+//		db, err := sqlite.Open(conduittest.Context(), config.Config{SqlitePath: filepath.Join(t.TempDir(), "comments.db")})
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		t.Run("CommentService", func(t *testing.T) { conduittest.TestCommentService(t, sqlite.NewCommentService(db)) })
+//		t.Run("PostRegistry", func(t *testing.T) { conduittest.TestPostRegistry(t, sqlite.NewPostRegistry(db)) })
+//		...
+//	}
+//
+// The S3 and DynamoDB tests run against a local stand-in at S3_ENDPOINT and
+// DYNAMODB_ENDPOINT, and are skipped when those aren't set.
+//
+// Every subtest works on its own freshly generated SiteID, so the suite can
+// share a bucket or table with other data and does not clean up after itself.
+package conduittest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// Context returns a context carrying a logger that discards everything, since
+// the backends insist on finding one.
+func Context() context.Context {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return conduit.WithLogger(context.Background(), logger)
+}
+
+func newSiteID() string {
+	return "conduittest-" + uuid.NewString() + ".example.com"
+}
+
+func newComment(siteID, postID string, isActive bool) conduit.Comment {
+	return conduit.Comment{
+		CommentID:     uuid.NewString(),
+		SiteID:        siteID,
+		PostID:        postID,
+		Timestamp:     conduit.Timestamp(time.Now().Truncate(time.Millisecond)),
+		SourceAddress: "192.0.2.1",
+		Author:        "Author",
+		AuthorEmail:   "author@example.com",
+		CommentBody:   "body of " + postID,
+		Status:        conduit.StatusFromIsActive(isActive),
+		IsActive:      isActive,
+	}
+}
+
+func upsert(t *testing.T, cs conduit.CommentService, comments ...conduit.Comment) {
+	t.Helper()
+	for i := range comments {
+		if err := cs.UpsertComment(Context(), &comments[i]); err != nil {
+			t.Fatalf("UpsertComment(%s): %v", comments[i].CommentID, err)
+		}
+	}
+}
+
+func fetch(t *testing.T, cs conduit.CommentService, filter conduit.CommentFilter) []conduit.Comment {
+	t.Helper()
+	page, err := cs.Comments(Context(), filter, conduit.PageRequest{})
+	if err != nil {
+		t.Fatalf("Comments: %v", err)
+	}
+	if page.NextCursor != "" {
+		t.Fatalf("Comments without a limit returned a NextCursor")
+	}
+	return page.Comments
+}
+
+func count(t *testing.T, cs conduit.CommentService, filter conduit.CommentFilter) int {
+	t.Helper()
+	nr, err := cs.NrComments(Context(), filter)
+	if err != nil {
+		t.Fatalf("NrComments: %v", err)
+	}
+	return nr
+}
+
+func ids(comments []conduit.Comment) []string {
+	var result []string
+	for _, c := range comments {
+		result = append(result, c.CommentID)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func sameIDs(t *testing.T, got []conduit.Comment, want ...conduit.Comment) {
+	t.Helper()
+	g, w := ids(got), ids(want)
+	if len(g) != len(w) {
+		t.Fatalf("got comments %v, want %v", g, w)
+	}
+	for i := range g {
+		if g[i] != w[i] {
+			t.Fatalf("got comments %v, want %v", g, w)
+		}
+	}
+}
+
+// Backends store time with different precision, milliseconds is the coarsest
+// (and what the JSON API uses).
+func toMillis(c conduit.Comment) conduit.Comment {
+	c.Timestamp = conduit.Timestamp(time.UnixMilli(time.Time(c.Timestamp).UnixMilli()))
+
+	var history []conduit.StatusChange
+	for _, h := range c.History {
+		h.At = conduit.Timestamp(time.UnixMilli(time.Time(h.At).UnixMilli()))
+		history = append(history, h)
+	}
+	c.History = history
+
+	return c
+}
+
+func sameComment(t *testing.T, got, want conduit.Comment) {
+	t.Helper()
+
+	got, want = toMillis(got), toMillis(want)
+
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("got %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+// TestCommentService runs the whole suite against cs.
+func TestCommentService(t *testing.T, cs conduit.CommentService) {
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, cs) })
+	t.Run("Mention", func(t *testing.T) { testMention(t, cs) })
+	t.Run("SpamScore", func(t *testing.T) { testSpamScore(t, cs) })
+	t.Run("ModerationHistory", func(t *testing.T) { testModerationHistory(t, cs) })
+	t.Run("LegacyIsActive", func(t *testing.T) { testLegacyIsActive(t, cs) })
+	t.Run("UpsertReplaces", func(t *testing.T) { testUpsertReplaces(t, cs) })
+	t.Run("SameIDOnTwoSites", func(t *testing.T) { testSameIDOnTwoSites(t, cs) })
+	t.Run("FilterByCommentID", func(t *testing.T) { testFilterByCommentID(t, cs) })
+	t.Run("FilterBySite", func(t *testing.T) { testFilterBySite(t, cs) })
+	t.Run("FilterByPost", func(t *testing.T) { testFilterByPost(t, cs) })
+	t.Run("FilterByParentID", func(t *testing.T) { testFilterByParentID(t, cs) })
+	t.Run("FilterByStatus", func(t *testing.T) { testFilterByStatus(t, cs) })
+	t.Run("FilterByIsActive", func(t *testing.T) { testFilterByIsActive(t, cs) })
+	t.Run("NrComments", func(t *testing.T) { testNrComments(t, cs) })
+	t.Run("NrCommentsByPost", func(t *testing.T) { testNrCommentsByPost(t, cs) })
+	t.Run("NrCommentsTracksChanges", func(t *testing.T) { testNrCommentsTracksChanges(t, cs) })
+	t.Run("Order", func(t *testing.T) { testOrder(t, cs) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, cs) })
+	t.Run("Empty", func(t *testing.T) { testEmpty(t, cs) })
+	t.Run("RequiredFields", func(t *testing.T) { testRequiredFields(t, cs) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, cs) })
+}
+
+func testRoundTrip(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	c := newComment(siteID, "/2024/01/01/round-trip", true)
+	c.ParentID = uuid.NewString()
+	upsert(t, cs, c)
+
+	got := fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &c.PostID})
+	if len(got) != 1 {
+		t.Fatalf("got %d comments, want 1", len(got))
+	}
+	sameComment(t, got[0], c)
+}
+
+func testMention(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	c := newComment(siteID, "/2024/01/01/mentioned", false)
+	c.Kind = conduit.KindMention
+	c.SourceURL = "https://elsewhere.example.org/2024/01/02/reply"
+	c.AuthorEmail = ""
+	upsert(t, cs, c)
+
+	got := fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, CommentID: &c.CommentID})
+	if len(got) != 1 {
+		t.Fatalf("got %d comments, want 1", len(got))
+	}
+	sameComment(t, got[0], c)
+}
+
+func testSpamScore(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	c := newComment(siteID, "/2024/01/01/scored", false)
+	c.SpamScore = 7.5
+	c.SpamReasons = []string{"links: 4 links", "words: blocked word \"casino\""}
+	c.SpamProbability = 0.875
+	upsert(t, cs, c)
+
+	got := fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, CommentID: &c.CommentID})
+	if len(got) != 1 {
+		t.Fatalf("got %d comments, want 1", len(got))
+	}
+	sameComment(t, got[0], c)
+}
+
+func testModerationHistory(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	c := newComment(siteID, "/2024/01/01/moderated", false)
+
+	at := time.Now()
+	if err := c.Moderate(conduit.StatusApproved, "admin@example.com", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Moderate(conduit.StatusSpam, "admin@example.com", at.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	upsert(t, cs, c)
+
+	got := fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, CommentID: &c.CommentID})
+	if len(got) != 1 {
+		t.Fatalf("got %d comments, want 1", len(got))
+	}
+	sameComment(t, got[0], c)
+}
+
+func testLegacyIsActive(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+
+	// As written by a client that only knows about IsActive.
+	active := newComment(siteID, "/2024/01/01/legacy", true)
+	active.Status = ""
+	inactive := newComment(siteID, "/2024/01/01/legacy", false)
+	inactive.Status = ""
+	upsert(t, cs, active, inactive)
+
+	approved, pending := conduit.StatusApproved, conduit.StatusPending
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, Status: &approved}), active)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, Status: &pending}), inactive)
+
+	for _, c := range fetch(t, cs, conduit.CommentFilter{SiteID: &siteID}) {
+		if c.IsActive != (c.Status == conduit.StatusApproved) {
+			t.Errorf("comment %s has IsActive %v but status %s", c.CommentID, c.IsActive, c.Status)
+		}
+	}
+}
+
+func testUpsertReplaces(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	c := newComment(siteID, "/2024/01/01/upsert", false)
+	upsert(t, cs, c)
+
+	c.CommentBody = "edited"
+	if err := c.Moderate(conduit.StatusApproved, "admin@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	upsert(t, cs, c)
+
+	if nr := count(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &c.PostID}); nr != 1 {
+		t.Fatalf("NrComments = %d after upserting twice, want 1", nr)
+	}
+
+	got := fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, CommentID: &c.CommentID})
+	if len(got) != 1 {
+		t.Fatalf("got %d comments, want 1", len(got))
+	}
+	sameComment(t, got[0], c)
+}
+
+// CommentIDs only need to be unique within a site: an import may bring the
+// same IDs to two sites.
+func testSameIDOnTwoSites(t *testing.T, cs conduit.CommentService) {
+	ctx := Context()
+
+	a := newComment(newSiteID(), "/2024/01/01/same", true)
+	b := newComment(newSiteID(), "/2024/01/01/same", false)
+	b.CommentID = a.CommentID
+	b.CommentBody = "on the other site"
+	upsert(t, cs, a, b)
+
+	for _, c := range []conduit.Comment{a, b} {
+		got := fetch(t, cs, conduit.CommentFilter{SiteID: &c.SiteID, CommentID: &c.CommentID})
+		if len(got) != 1 {
+			t.Fatalf("got %d comments on %s, want 1", len(got), c.SiteID)
+		}
+		sameComment(t, got[0], c)
+	}
+
+	// Upserting one again leaves the other alone.
+	a.CommentBody = "edited"
+	upsert(t, cs, a)
+	sameComment(t, fetch(t, cs, conduit.CommentFilter{SiteID: &b.SiteID, CommentID: &b.CommentID})[0], b)
+
+	if err := cs.DeleteComment(ctx, &a); err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &a.SiteID}))
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &b.SiteID}), b)
+}
+
+func testFilterByCommentID(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	a := newComment(siteID, "/2024/01/01/a", true)
+	b := newComment(siteID, "/2024/01/01/a", true)
+	c := newComment(siteID, "/2024/01/01/c", true)
+	upsert(t, cs, a, b, c)
+
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, CommentID: &b.CommentID}), b)
+
+	// A CommentID on the wrong post finds nothing.
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &c.PostID, CommentID: &b.CommentID}))
+
+	// Nor on the wrong site.
+	otherSite := newSiteID()
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &otherSite, CommentID: &b.CommentID}))
+}
+
+func testFilterBySite(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	otherSiteID := newSiteID()
+
+	a := newComment(siteID, "/2024/01/01/a", true)
+	b := newComment(siteID, "/2024/02/02/b", false)
+	c := newComment(otherSiteID, "/2024/01/01/a", true)
+	upsert(t, cs, a, b, c)
+
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID}), a, b)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &otherSiteID}), c)
+}
+
+func testFilterByPost(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+
+	// PostIDs that are prefixes of each other must not bleed together.
+	a := newComment(siteID, "/2024/01/01/post", true)
+	b := newComment(siteID, "/2024/01/01/post/nested", true)
+	c := newComment(siteID, "/2024/01/01/postscript", true)
+	upsert(t, cs, a, b, c)
+
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &a.PostID}), a)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &b.PostID}), b)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &c.PostID}), c)
+
+	if nr := count(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &a.PostID}); nr != 1 {
+		t.Errorf("NrComments = %d, want 1", nr)
+	}
+}
+
+func testFilterByParentID(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	postID := "/2024/01/01/thread"
+
+	root := newComment(siteID, postID, true)
+	reply := newComment(siteID, postID, true)
+	reply.ParentID = root.CommentID
+	inactiveReply := newComment(siteID, postID, false)
+	inactiveReply.ParentID = root.CommentID
+	nested := newComment(siteID, postID, true)
+	nested.ParentID = reply.CommentID
+	otherRoot := newComment(siteID, "/2024/01/01/other", true)
+	upsert(t, cs, root, reply, inactiveReply, nested, otherRoot)
+
+	yes := true
+	topLevel := ""
+
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, ParentID: &root.CommentID}), reply, inactiveReply)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, ParentID: &root.CommentID, IsActive: &yes}), reply)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, ParentID: &reply.CommentID}), nested)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, ParentID: &topLevel}), root, otherRoot)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, ParentID: &topLevel}), root)
+}
+
+func testFilterByStatus(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	postID := "/2024/01/01/status"
+
+	var all []conduit.Comment
+	for _, status := range []conduit.ModerationStatus{conduit.StatusPending, conduit.StatusApproved, conduit.StatusRejected, conduit.StatusSpam, conduit.StatusDeleted} {
+		c := newComment(siteID, postID, false)
+		c.Status = status
+		c.IsActive = status == conduit.StatusApproved
+		all = append(all, c)
+	}
+	upsert(t, cs, all...)
+
+	for _, c := range all {
+		status := c.Status
+		sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, Status: &status}), c)
+	}
+
+	yes := true
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, IsActive: &yes}), all[1])
+
+	spam := conduit.StatusSpam
+	if nr := count(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, Status: &spam}); nr != 1 {
+		t.Errorf("NrComments(spam) = %d, want 1", nr)
+	}
+}
+
+func testFilterByIsActive(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	postID := "/2024/01/01/active"
+
+	active := newComment(siteID, postID, true)
+	inactive := newComment(siteID, postID, false)
+	upsert(t, cs, active, inactive)
+
+	yes, no := true, false
+
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID}), active, inactive)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, IsActive: &yes}), active)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, IsActive: &no}), inactive)
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, IsActive: &yes}), active)
+}
+
+func testNrComments(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	postID := "/2024/01/01/count"
+
+	upsert(t, cs,
+		newComment(siteID, postID, true),
+		newComment(siteID, postID, true),
+		newComment(siteID, postID, false),
+		newComment(siteID, "/2024/01/01/elsewhere", true),
+	)
+
+	yes, no := true, false
+
+	if nr := count(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID}); nr != 3 {
+		t.Errorf("NrComments = %d, want 3", nr)
+	}
+	if nr := count(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, IsActive: &yes}); nr != 2 {
+		t.Errorf("NrComments(active) = %d, want 2", nr)
+	}
+	if nr := count(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, IsActive: &no}); nr != 1 {
+		t.Errorf("NrComments(inactive) = %d, want 1", nr)
+	}
+}
+
+// timeline makes n comments on one post a second apart, except that the
+// last two share a Timestamp so that the CommentID tie break matters.
+func timeline(siteID, postID string, n int) []conduit.Comment {
+	start := time.Now().Truncate(time.Second).Add(-time.Hour)
+
+	var comments []conduit.Comment
+	for i := 0; i < n; i++ {
+		c := newComment(siteID, postID, true)
+		at := i
+		if i == n-1 {
+			at = i - 1
+		}
+		c.Timestamp = conduit.Timestamp(start.Add(time.Duration(at) * time.Second))
+		comments = append(comments, c)
+	}
+
+	// Expected order: oldest first, then by CommentID.
+	conduit.PageRequest{}.SortComments(comments)
+	return comments
+}
+
+func reversed(comments []conduit.Comment) []conduit.Comment {
+	var result []conduit.Comment
+	for i := len(comments) - 1; i >= 0; i-- {
+		result = append(result, comments[i])
+	}
+	return result
+}
+
+func sameOrder(t *testing.T, got, want []conduit.Comment) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d comments, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].CommentID != want[i].CommentID {
+			t.Fatalf("comment %d is %s, want %s", i, got[i].CommentID, want[i].CommentID)
+		}
+	}
+}
+
+func testOrder(t *testing.T, cs conduit.CommentService) {
+	ctx := Context()
+	siteID := newSiteID()
+	postID := "/2024/01/01/order"
+
+	want := timeline(siteID, postID, 5)
+
+	// Insert out of order.
+	upsert(t, cs, want[3], want[0], want[4], want[2], want[1])
+
+	filter := conduit.CommentFilter{SiteID: &siteID, PostID: &postID}
+
+	oldest, err := cs.Comments(ctx, filter, conduit.PageRequest{Order: conduit.OldestFirst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameOrder(t, oldest.Comments, want)
+
+	byDefault, err := cs.Comments(ctx, filter, conduit.PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameOrder(t, byDefault.Comments, want)
+
+	newest, err := cs.Comments(ctx, filter, conduit.PageRequest{Order: conduit.NewestFirst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameOrder(t, newest.Comments, reversed(want))
+}
+
+func testPagination(t *testing.T, cs conduit.CommentService) {
+	ctx := Context()
+	siteID := newSiteID()
+	postID := "/2024/01/01/pages"
+
+	want := timeline(siteID, postID, 7)
+	upsert(t, cs, want...)
+
+	for _, order := range []conduit.SortOrder{conduit.OldestFirst, conduit.NewestFirst} {
+		for _, limit := range []int{1, 2, 3, 7, 8} {
+			expected := want
+			if order == conduit.NewestFirst {
+				expected = reversed(want)
+			}
+
+			var got []conduit.Comment
+			cursor := ""
+			pages := 0
+			for {
+				page, err := cs.Comments(ctx, conduit.CommentFilter{SiteID: &siteID}, conduit.PageRequest{Cursor: cursor, Limit: limit, Order: order})
+				if err != nil {
+					t.Fatalf("%s limit %d: %v", order, limit, err)
+				}
+				if len(page.Comments) > limit {
+					t.Fatalf("%s limit %d: page has %d comments", order, limit, len(page.Comments))
+				}
+				got = append(got, page.Comments...)
+				pages++
+
+				if page.NextCursor == "" {
+					break
+				}
+				if pages > len(want) {
+					t.Fatalf("%s limit %d: too many pages", order, limit)
+				}
+				cursor = page.NextCursor
+			}
+
+			sameOrder(t, got, expected)
+
+			wantPages := (len(want) + limit - 1) / limit
+			if pages != wantPages {
+				t.Errorf("%s limit %d: %d pages, want %d", order, limit, pages, wantPages)
+			}
+		}
+	}
+
+	if _, err := cs.Comments(ctx, conduit.CommentFilter{SiteID: &siteID}, conduit.PageRequest{Cursor: "not a cursor!", Limit: 2}); err == nil {
+		t.Error("Comments with a garbage cursor succeeded")
+	}
+}
+
+func testNrCommentsByPost(t *testing.T, cs conduit.CommentService) {
+	ctx := Context()
+	siteID := newSiteID()
+	otherSiteID := newSiteID()
+
+	upsert(t, cs,
+		newComment(siteID, "/2024/01/01/a", true),
+		newComment(siteID, "/2024/01/01/a", true),
+		newComment(siteID, "/2024/01/01/a", false),
+		newComment(siteID, "/2024/01/01/b", true),
+		newComment(siteID, "/2024/01/01/not-asked-for", true),
+		newComment(otherSiteID, "/2024/01/01/c", true),
+	)
+
+	postIDs := []string{"/2024/01/01/a", "/2024/01/01/b", "/2024/01/01/c"}
+
+	yes := true
+	counts, err := cs.NrCommentsByPost(ctx, conduit.CommentFilter{SiteID: &siteID, IsActive: &yes}, postIDs)
+	if err != nil {
+		t.Fatalf("NrCommentsByPost: %v", err)
+	}
+
+	want := map[string]int{"/2024/01/01/a": 2, "/2024/01/01/b": 1, "/2024/01/01/c": 0}
+	if len(counts) != len(want) {
+		t.Errorf("got counts %v, want %v", counts, want)
+	}
+	for postID, nr := range want {
+		if got, ok := counts[postID]; !ok || got != nr {
+			t.Errorf("got counts %v, want %v", counts, want)
+			break
+		}
+	}
+
+	all, err := cs.NrCommentsByPost(ctx, conduit.CommentFilter{SiteID: &siteID}, postIDs[:1])
+	if err != nil {
+		t.Fatalf("NrCommentsByPost: %v", err)
+	}
+	if all["/2024/01/01/a"] != 3 {
+		t.Errorf("got counts %v, want 3 on /2024/01/01/a", all)
+	}
+
+	if _, err := cs.NrCommentsByPost(ctx, conduit.CommentFilter{}, postIDs); err == nil {
+		t.Error("NrCommentsByPost without SiteID succeeded")
+	}
+}
+
+// Backends may keep counts on the side rather than count on demand, so check
+// they follow every kind of write.
+func testNrCommentsTracksChanges(t *testing.T, cs conduit.CommentService) {
+	ctx := Context()
+	siteID := newSiteID()
+	postID := "/2024/01/01/counted"
+
+	all := conduit.CommentFilter{SiteID: &siteID, PostID: &postID}
+	approved := conduit.StatusApproved
+	active := conduit.CommentFilter{SiteID: &siteID, PostID: &postID, Status: &approved}
+
+	check := func(wantAll, wantActive int) {
+		t.Helper()
+		if nr := count(t, cs, all); nr != wantAll {
+			t.Errorf("NrComments = %d, want %d", nr, wantAll)
+		}
+		if nr := count(t, cs, active); nr != wantActive {
+			t.Errorf("NrComments(approved) = %d, want %d", nr, wantActive)
+		}
+	}
+
+	check(0, 0)
+
+	a := newComment(siteID, postID, false)
+	b := newComment(siteID, postID, false)
+	upsert(t, cs, a, b)
+	check(2, 0)
+
+	if err := a.Moderate(conduit.StatusApproved, "admin@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	upsert(t, cs, a)
+	check(2, 1)
+
+	// Writing the same comment again changes nothing.
+	upsert(t, cs, a)
+	check(2, 1)
+
+	if err := cs.DeleteComment(ctx, &a); err != nil {
+		t.Fatal(err)
+	}
+	check(1, 0)
+
+	if err := cs.DeleteComment(ctx, &a); err != nil {
+		t.Fatal(err)
+	}
+	check(1, 0)
+}
+
+func testEmpty(t *testing.T, cs conduit.CommentService) {
+	siteID := newSiteID()
+	postID := "/2024/01/01/nothing-here"
+
+	if nr := count(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID}); nr != 0 {
+		t.Errorf("NrComments = %d, want 0", nr)
+	}
+	if got := fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID}); len(got) != 0 {
+		t.Errorf("got %d comments, want 0", len(got))
+	}
+	if got := fetch(t, cs, conduit.CommentFilter{SiteID: &siteID}); len(got) != 0 {
+		t.Errorf("got %d comments, want 0", len(got))
+	}
+}
+
+func testRequiredFields(t *testing.T, cs conduit.CommentService) {
+	ctx := Context()
+	siteID := newSiteID()
+	postID := "/2024/01/01/required"
+
+	if _, err := cs.NrComments(ctx, conduit.CommentFilter{PostID: &postID}); err == nil {
+		t.Error("NrComments without SiteID succeeded")
+	}
+	if _, err := cs.NrComments(ctx, conduit.CommentFilter{SiteID: &siteID}); err == nil {
+		t.Error("NrComments without PostID succeeded")
+	}
+	if _, err := cs.Comments(ctx, conduit.CommentFilter{PostID: &postID}, conduit.PageRequest{}); err == nil {
+		t.Error("Comments without SiteID succeeded")
+	}
+}
+
+func testDelete(t *testing.T, cs conduit.CommentService) {
+	ctx := Context()
+	siteID := newSiteID()
+	postID := "/2024/01/01/delete"
+
+	keep := newComment(siteID, postID, true)
+	gone := newComment(siteID, postID, true)
+	upsert(t, cs, keep, gone)
+
+	if err := cs.DeleteComment(ctx, &gone); err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID}), keep)
+
+	// Only SiteID and CommentID identify a comment.
+	byKey := conduit.Comment{SiteID: siteID, CommentID: keep.CommentID}
+	if err := cs.DeleteComment(ctx, &byKey); err != nil {
+		t.Fatalf("DeleteComment by key: %v", err)
+	}
+	sameIDs(t, fetch(t, cs, conduit.CommentFilter{SiteID: &siteID, PostID: &postID}))
+
+	// Deleting twice is fine.
+	if err := cs.DeleteComment(ctx, &gone); err != nil {
+		t.Errorf("DeleteComment of a missing comment: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package conduittest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// TestOutbox checks a conduit.Outbox, in the same way as TestCommentService.
+// The outbox is shared by all sites, so each test only looks at the entries
+// it created.
+func TestOutbox(t *testing.T, o conduit.Outbox) {
+	t.Run("PutAndList", func(t *testing.T) { testOutboxPutAndList(t, o) })
+	t.Run("Replace", func(t *testing.T) { testOutboxReplace(t, o) })
+	t.Run("Filters", func(t *testing.T) { testOutboxFilters(t, o) })
+	t.Run("Order", func(t *testing.T) { testOutboxOrder(t, o) })
+	t.Run("Delete", func(t *testing.T) { testOutboxDelete(t, o) })
+}
+
+func newOutboxEntry(createdAt time.Time) conduit.OutboxEntry {
+	createdAt = time.UnixMilli(createdAt.UnixMilli())
+	return conduit.OutboxEntry{
+		EntryID:     uuid.NewString(),
+		Kind:        conduit.OutboxNewComment,
+		SiteID:      newSiteID(),
+		CommentID:   uuid.NewString(),
+		Recipient:   "moderator@example.com",
+		Status:      conduit.OutboxPending,
+		NextAttempt: conduit.Timestamp(createdAt),
+		CreatedAt:   conduit.Timestamp(createdAt),
+	}
+}
+
+func putEntry(t *testing.T, o conduit.Outbox, entry conduit.OutboxEntry) {
+	t.Helper()
+	if err := o.PutOutboxEntry(Context(), entry); err != nil {
+		t.Fatalf("PutOutboxEntry: %v", err)
+	}
+}
+
+// ownEntries lists the entries matching the filter, keeping only the given
+// EntryIDs and their order.
+func ownEntries(t *testing.T, o conduit.Outbox, filter conduit.OutboxFilter, entries ...conduit.OutboxEntry) []conduit.OutboxEntry {
+	t.Helper()
+	all, err := o.OutboxEntries(Context(), filter)
+	if err != nil {
+		t.Fatalf("OutboxEntries: %v", err)
+	}
+	ours := make(map[string]bool)
+	for _, e := range entries {
+		ours[e.EntryID] = true
+	}
+	found := make([]conduit.OutboxEntry, 0)
+	for _, e := range all {
+		if ours[e.EntryID] {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func sameOutboxEntry(t *testing.T, got, want conduit.OutboxEntry) {
+	t.Helper()
+	if got.EntryID != want.EntryID || got.Kind != want.Kind || got.SiteID != want.SiteID ||
+		got.CommentID != want.CommentID || got.Recipient != want.Recipient || got.Status != want.Status ||
+		got.Attempts != want.Attempts || got.LastError != want.LastError ||
+		time.Time(got.NextAttempt).UnixMilli() != time.Time(want.NextAttempt).UnixMilli() ||
+		time.Time(got.CreatedAt).UnixMilli() != time.Time(want.CreatedAt).UnixMilli() {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func testOutboxPutAndList(t *testing.T, o conduit.Outbox) {
+	entry := newOutboxEntry(time.Now())
+	putEntry(t, o, entry)
+
+	found := ownEntries(t, o, conduit.OutboxFilter{EntryID: &entry.EntryID}, entry)
+	if len(found) != 1 {
+		t.Fatalf("got %d entries, want 1", len(found))
+	}
+	sameOutboxEntry(t, found[0], entry)
+}
+
+func testOutboxReplace(t *testing.T, o conduit.Outbox) {
+	entry := newOutboxEntry(time.Now())
+	putEntry(t, o, entry)
+
+	entry.Attempts = 3
+	entry.LastError = "connection refused"
+	entry.Status = conduit.OutboxDead
+	entry.NextAttempt = conduit.Timestamp(time.UnixMilli(time.Now().Add(time.Hour).UnixMilli()))
+	putEntry(t, o, entry)
+
+	found := ownEntries(t, o, conduit.OutboxFilter{EntryID: &entry.EntryID}, entry)
+	if len(found) != 1 {
+		t.Fatalf("got %d entries, want 1", len(found))
+	}
+	sameOutboxEntry(t, found[0], entry)
+}
+
+func testOutboxFilters(t *testing.T, o conduit.Outbox) {
+	now := time.Now()
+
+	due := newOutboxEntry(now.Add(-time.Minute))
+
+	later := newOutboxEntry(now.Add(-time.Minute))
+	later.NextAttempt = conduit.Timestamp(time.UnixMilli(now.Add(time.Hour).UnixMilli()))
+
+	dead := newOutboxEntry(now.Add(-time.Minute))
+	dead.Status = conduit.OutboxDead
+
+	for _, e := range []conduit.OutboxEntry{due, later, dead} {
+		putEntry(t, o, e)
+	}
+
+	pending := conduit.OutboxPending
+	found := ownEntries(t, o, conduit.OutboxFilter{Status: &pending, DueBy: &now}, due, later, dead)
+	if len(found) != 1 || found[0].EntryID != due.EntryID {
+		t.Errorf("due pending: got %+v, want only %s", found, due.EntryID)
+	}
+
+	found = ownEntries(t, o, conduit.OutboxFilter{Status: &pending}, due, later, dead)
+	if len(found) != 2 {
+		t.Errorf("pending: got %d entries, want 2", len(found))
+	}
+
+	deadStatus := conduit.OutboxDead
+	found = ownEntries(t, o, conduit.OutboxFilter{Status: &deadStatus}, due, later, dead)
+	if len(found) != 1 || found[0].EntryID != dead.EntryID {
+		t.Errorf("dead: got %+v, want only %s", found, dead.EntryID)
+	}
+
+	found = ownEntries(t, o, conduit.OutboxFilter{}, due, later, dead)
+	if len(found) != 3 {
+		t.Errorf("all: got %d entries, want 3", len(found))
+	}
+}
+
+func testOutboxOrder(t *testing.T, o conduit.Outbox) {
+	now := time.Now()
+
+	newest := newOutboxEntry(now)
+	oldest := newOutboxEntry(now.Add(-2 * time.Minute))
+	middle := newOutboxEntry(now.Add(-time.Minute))
+
+	for _, e := range []conduit.OutboxEntry{newest, oldest, middle} {
+		putEntry(t, o, e)
+	}
+
+	found := ownEntries(t, o, conduit.OutboxFilter{}, newest, oldest, middle)
+	if len(found) != 3 {
+		t.Fatalf("got %d entries, want 3", len(found))
+	}
+	for i, want := range []conduit.OutboxEntry{oldest, middle, newest} {
+		if found[i].EntryID != want.EntryID {
+			t.Errorf("position %d: got %s, want %s", i, found[i].EntryID, want.EntryID)
+		}
+	}
+}
+
+func testOutboxDelete(t *testing.T, o conduit.Outbox) {
+	entry := newOutboxEntry(time.Now())
+	putEntry(t, o, entry)
+
+	if err := o.DeleteOutboxEntry(Context(), entry.EntryID); err != nil {
+		t.Fatalf("DeleteOutboxEntry: %v", err)
+	}
+
+	found := ownEntries(t, o, conduit.OutboxFilter{}, entry)
+	if len(found) != 0 {
+		t.Errorf("got %d entries after delete, want 0", len(found))
+	}
+
+	// Deleting again is not an error.
+	if err := o.DeleteOutboxEntry(Context(), entry.EntryID); err != nil {
+		t.Errorf("DeleteOutboxEntry of a missing entry: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package conduittest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// TestPostRegistry checks a conduit.PostRegistry, in the same way as
+// TestCommentService.
+func TestPostRegistry(t *testing.T, pr conduit.PostRegistry) {
+	t.Run("AddAndList", func(t *testing.T) { testAddAndList(t, pr) })
+	t.Run("AddKeepsFirst", func(t *testing.T) { testAddKeepsFirst(t, pr) })
+	t.Run("Remove", func(t *testing.T) { testRemove(t, pr) })
+	t.Run("SitesApart", func(t *testing.T) { testSitesApart(t, pr) })
+}
+
+func newKnownPost(siteID, postID, source string) conduit.KnownPost {
+	return conduit.KnownPost{
+		SiteID:       siteID,
+		PostID:       postID,
+		DiscoveredAt: conduit.Timestamp(time.UnixMilli(time.Now().UnixMilli())),
+		Source:       source,
+	}
+}
+
+func addKnown(t *testing.T, pr conduit.PostRegistry, post conduit.KnownPost) bool {
+	t.Helper()
+	added, err := pr.AddKnownPost(Context(), post)
+	if err != nil {
+		t.Fatalf("AddKnownPost: %v", err)
+	}
+	return added
+}
+
+// knownPosts lists a site's posts by PostID.
+func knownPosts(t *testing.T, pr conduit.PostRegistry, siteID string) map[string]conduit.KnownPost {
+	t.Helper()
+	posts, err := pr.KnownPosts(Context(), siteID)
+	if err != nil {
+		t.Fatalf("KnownPosts: %v", err)
+	}
+	byID := make(map[string]conduit.KnownPost)
+	for _, p := range posts {
+		byID[p.PostID] = p
+	}
+	return byID
+}
+
+func sameKnownPost(t *testing.T, got, want conduit.KnownPost) {
+	t.Helper()
+	if got.SiteID != want.SiteID || got.PostID != want.PostID || got.Source != want.Source ||
+		time.Time(got.DiscoveredAt).UnixMilli() != time.Time(want.DiscoveredAt).UnixMilli() {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func testAddAndList(t *testing.T, pr conduit.PostRegistry) {
+	siteID := newSiteID()
+
+	if posts := knownPosts(t, pr, siteID); len(posts) != 0 {
+		t.Fatalf("new site has %d posts", len(posts))
+	}
+
+	a := newKnownPost(siteID, "/2024/01/01/a", "sitemap")
+	b := newKnownPost(siteID, "/2024/01/02/b", conduit.PostSourceAdmin)
+
+	if !addKnown(t, pr, a) || !addKnown(t, pr, b) {
+		t.Fatal("new post not reported as added")
+	}
+
+	posts := knownPosts(t, pr, siteID)
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want 2", len(posts))
+	}
+	sameKnownPost(t, posts[a.PostID], a)
+	sameKnownPost(t, posts[b.PostID], b)
+}
+
+func testAddKeepsFirst(t *testing.T, pr conduit.PostRegistry) {
+	siteID := newSiteID()
+
+	first := newKnownPost(siteID, "/2024/01/01/a", "feed")
+	addKnown(t, pr, first)
+
+	again := first
+	again.Source = "archive"
+	again.DiscoveredAt = conduit.Timestamp(time.Time(first.DiscoveredAt).Add(time.Hour))
+	if addKnown(t, pr, again) {
+		t.Error("known post reported as added")
+	}
+
+	sameKnownPost(t, knownPosts(t, pr, siteID)[first.PostID], first)
+}
+
+func testRemove(t *testing.T, pr conduit.PostRegistry) {
+	siteID := newSiteID()
+
+	a := newKnownPost(siteID, "/2024/01/01/a", "sitemap")
+	b := newKnownPost(siteID, "/2024/01/02/b", "sitemap")
+	addKnown(t, pr, a)
+	addKnown(t, pr, b)
+
+	if err := pr.RemoveKnownPost(Context(), siteID, a.PostID); err != nil {
+		t.Fatalf("RemoveKnownPost: %v", err)
+	}
+	if err := pr.RemoveKnownPost(Context(), siteID, "/2024/01/03/never-added"); err != nil {
+		t.Fatalf("RemoveKnownPost of unknown post: %v", err)
+	}
+
+	posts := knownPosts(t, pr, siteID)
+	if _, ok := posts[a.PostID]; ok || len(posts) != 1 {
+		t.Errorf("got %v after removing %s", posts, a.PostID)
+	}
+
+	// A removed post can come back.
+	if !addKnown(t, pr, a) {
+		t.Error("removed post not reported as added")
+	}
+}
+
+func testSitesApart(t *testing.T, pr conduit.PostRegistry) {
+	site1, site2 := newSiteID(), newSiteID()
+
+	addKnown(t, pr, newKnownPost(site1, "/2024/01/01/a", "sitemap"))
+	if !addKnown(t, pr, newKnownPost(site2, "/2024/01/01/a", "sitemap")) {
+		t.Error("same PostID on another site not reported as added")
+	}
+
+	if posts := knownPosts(t, pr, site1); len(posts) != 1 {
+		t.Errorf("site1 has %d posts, want 1", len(posts))
+	}
+}
@@ -0,0 +1,111 @@
+package conduittest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// TestSpamModelStore checks a conduit.SpamModelStore, in the same way as
+// TestCommentService.
+func TestSpamModelStore(t *testing.T, ms conduit.SpamModelStore) {
+	t.Run("Missing", func(t *testing.T) { testSpamModelMissing(t, ms) })
+	t.Run("PutAndGet", func(t *testing.T) { testSpamModelPutAndGet(t, ms) })
+	t.Run("Replace", func(t *testing.T) { testSpamModelReplace(t, ms) })
+	t.Run("Delete", func(t *testing.T) { testSpamModelDelete(t, ms) })
+}
+
+func newSpamModel(siteID string) conduit.SpamModel {
+	model := conduit.NewSpamModel(siteID)
+	model.NrSpam = 2
+	model.NrHam = 3
+	model.SpamTokens["casino"] = 2
+	model.SpamTokens["domain:spam.example"] = 1
+	model.HamTokens["thanks"] = 3
+	model.HamTokens["author:alice"] = 1
+	model.UpdatedAt = conduit.Timestamp(time.UnixMilli(time.Now().UnixMilli()))
+	return model
+}
+
+func putSpamModel(t *testing.T, ms conduit.SpamModelStore, model conduit.SpamModel) {
+	t.Helper()
+	if err := ms.PutSpamModel(Context(), model); err != nil {
+		t.Fatalf("PutSpamModel: %v", err)
+	}
+}
+
+func getSpamModel(t *testing.T, ms conduit.SpamModelStore, siteID string) (conduit.SpamModel, bool) {
+	t.Helper()
+	model, ok, err := ms.SpamModel(Context(), siteID)
+	if err != nil {
+		t.Fatalf("SpamModel: %v", err)
+	}
+	return model, ok
+}
+
+func sameSpamModel(t *testing.T, got, want conduit.SpamModel) {
+	t.Helper()
+	if got.SiteID != want.SiteID || got.NrSpam != want.NrSpam || got.NrHam != want.NrHam ||
+		!reflect.DeepEqual(got.SpamTokens, want.SpamTokens) || !reflect.DeepEqual(got.HamTokens, want.HamTokens) ||
+		time.Time(got.UpdatedAt).UnixMilli() != time.Time(want.UpdatedAt).UnixMilli() {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func testSpamModelMissing(t *testing.T, ms conduit.SpamModelStore) {
+	if _, ok := getSpamModel(t, ms, newSiteID()); ok {
+		t.Error("found a model for a new site")
+	}
+}
+
+func testSpamModelPutAndGet(t *testing.T, ms conduit.SpamModelStore) {
+	model := newSpamModel(newSiteID())
+	putSpamModel(t, ms, model)
+
+	got, ok := getSpamModel(t, ms, model.SiteID)
+	if !ok {
+		t.Fatal("model not found")
+	}
+	sameSpamModel(t, got, model)
+
+	// Sites have models of their own.
+	if _, ok := getSpamModel(t, ms, newSiteID()); ok {
+		t.Error("found a model for another site")
+	}
+}
+
+func testSpamModelReplace(t *testing.T, ms conduit.SpamModelStore) {
+	model := newSpamModel(newSiteID())
+	putSpamModel(t, ms, model)
+
+	model = newSpamModel(model.SiteID)
+	model.NrHam++
+	model.HamTokens["thanks"]++
+	delete(model.SpamTokens, "casino")
+	putSpamModel(t, ms, model)
+
+	got, ok := getSpamModel(t, ms, model.SiteID)
+	if !ok {
+		t.Fatal("model not found")
+	}
+	sameSpamModel(t, got, model)
+}
+
+func testSpamModelDelete(t *testing.T, ms conduit.SpamModelStore) {
+	model := newSpamModel(newSiteID())
+	putSpamModel(t, ms, model)
+
+	if err := ms.DeleteSpamModel(Context(), model.SiteID); err != nil {
+		t.Fatalf("DeleteSpamModel: %v", err)
+	}
+	if _, ok := getSpamModel(t, ms, model.SiteID); ok {
+		t.Error("model still there after delete")
+	}
+
+	// Deleting again is fine.
+	if err := ms.DeleteSpamModel(Context(), model.SiteID); err != nil {
+		t.Errorf("DeleteSpamModel of a missing model: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package conduittest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// TestSubscriptionStore checks a conduit.SubscriptionStore, in the same way
+// as TestCommentService.
+func TestSubscriptionStore(t *testing.T, ss conduit.SubscriptionStore) {
+	t.Run("PutAndList", func(t *testing.T) { testSubscriptionPutAndList(t, ss) })
+	t.Run("Replace", func(t *testing.T) { testSubscriptionReplace(t, ss) })
+	t.Run("Delete", func(t *testing.T) { testSubscriptionDelete(t, ss) })
+	t.Run("PostsApart", func(t *testing.T) { testSubscriptionPostsApart(t, ss) })
+}
+
+func newSubscription(siteID, postID, email string) conduit.Subscription {
+	return conduit.Subscription{
+		SubscriptionID: uuid.NewString(),
+		SiteID:         siteID,
+		PostID:         postID,
+		Email:          email,
+		Status:         conduit.SubscriptionPending,
+		CreatedAt:      conduit.Timestamp(time.UnixMilli(time.Now().UnixMilli())),
+	}
+}
+
+func putSubscription(t *testing.T, ss conduit.SubscriptionStore, sub conduit.Subscription) {
+	t.Helper()
+	if err := ss.PutSubscription(Context(), sub); err != nil {
+		t.Fatalf("PutSubscription: %v", err)
+	}
+}
+
+// postSubscriptions lists a post's subscriptions by SubscriptionID.
+func postSubscriptions(t *testing.T, ss conduit.SubscriptionStore, siteID, postID string) map[string]conduit.Subscription {
+	t.Helper()
+	subs, err := ss.PostSubscriptions(Context(), siteID, postID)
+	if err != nil {
+		t.Fatalf("PostSubscriptions: %v", err)
+	}
+	byID := make(map[string]conduit.Subscription)
+	for _, sub := range subs {
+		byID[sub.SubscriptionID] = sub
+	}
+	return byID
+}
+
+func sameSubscription(t *testing.T, got, want conduit.Subscription) {
+	t.Helper()
+	if got.SubscriptionID != want.SubscriptionID || got.SiteID != want.SiteID || got.PostID != want.PostID ||
+		got.Email != want.Email || got.Status != want.Status ||
+		time.Time(got.CreatedAt).UnixMilli() != time.Time(want.CreatedAt).UnixMilli() {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func testSubscriptionPutAndList(t *testing.T, ss conduit.SubscriptionStore) {
+	siteID := newSiteID()
+	a := newSubscription(siteID, "/2024/01/01/a-post", "a@example.com")
+	b := newSubscription(siteID, "/2024/01/01/a-post", "b@example.com")
+	putSubscription(t, ss, a)
+	putSubscription(t, ss, b)
+
+	subs := postSubscriptions(t, ss, siteID, a.PostID)
+	if len(subs) != 2 {
+		t.Fatalf("got %d subscriptions, want 2", len(subs))
+	}
+	sameSubscription(t, subs[a.SubscriptionID], a)
+	sameSubscription(t, subs[b.SubscriptionID], b)
+}
+
+func testSubscriptionReplace(t *testing.T, ss conduit.SubscriptionStore) {
+	siteID := newSiteID()
+	sub := newSubscription(siteID, "/2024/01/01/a-post", "a@example.com")
+	putSubscription(t, ss, sub)
+
+	sub.Status = conduit.SubscriptionActive
+	putSubscription(t, ss, sub)
+
+	subs := postSubscriptions(t, ss, siteID, sub.PostID)
+	if len(subs) != 1 {
+		t.Fatalf("got %d subscriptions, want 1", len(subs))
+	}
+	sameSubscription(t, subs[sub.SubscriptionID], sub)
+}
+
+func testSubscriptionDelete(t *testing.T, ss conduit.SubscriptionStore) {
+	siteID := newSiteID()
+	a := newSubscription(siteID, "/2024/01/01/a-post", "a@example.com")
+	b := newSubscription(siteID, "/2024/01/01/a-post", "b@example.com")
+	putSubscription(t, ss, a)
+	putSubscription(t, ss, b)
+
+	if err := ss.DeleteSubscription(Context(), siteID, a.PostID, a.SubscriptionID); err != nil {
+		t.Fatalf("DeleteSubscription: %v", err)
+	}
+
+	subs := postSubscriptions(t, ss, siteID, a.PostID)
+	if _, ok := subs[a.SubscriptionID]; ok || len(subs) != 1 {
+		t.Errorf("got %+v after delete, want only %s", subs, b.SubscriptionID)
+	}
+
+	// Deleting again is not an error.
+	if err := ss.DeleteSubscription(Context(), siteID, a.PostID, a.SubscriptionID); err != nil {
+		t.Errorf("DeleteSubscription of a missing subscription: %v", err)
+	}
+}
+
+func testSubscriptionPostsApart(t *testing.T, ss conduit.SubscriptionStore) {
+	siteID := newSiteID()
+	otherSiteID := newSiteID()
+
+	a := newSubscription(siteID, "/2024/01/01/a-post", "a@example.com")
+	b := newSubscription(siteID, "/2024/01/02/b-post", "a@example.com")
+	c := newSubscription(otherSiteID, "/2024/01/01/a-post", "a@example.com")
+	for _, sub := range []conduit.Subscription{a, b, c} {
+		putSubscription(t, ss, sub)
+	}
+
+	for _, want := range []conduit.Subscription{a, b, c} {
+		subs := postSubscriptions(t, ss, want.SiteID, want.PostID)
+		if len(subs) != 1 {
+			t.Errorf("%s%s: got %d subscriptions, want 1", want.SiteID, want.PostID, len(subs))
+			continue
+		}
+		sameSubscription(t, subs[want.SubscriptionID], want)
+	}
+}
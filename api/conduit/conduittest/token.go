@@ -0,0 +1,71 @@
+package conduittest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// TestUsedTokens checks a conduit.UsedTokens, in the same way as
+// TestCommentService.
+func TestUsedTokens(t *testing.T, ut conduit.UsedTokens) {
+	t.Run("OnlyOnce", func(t *testing.T) { testTokenOnlyOnce(t, ut) })
+	t.Run("Concurrent", func(t *testing.T) { testTokenConcurrent(t, ut) })
+}
+
+func useToken(t *testing.T, ut conduit.UsedTokens, tokenID string) bool {
+	t.Helper()
+	first, err := ut.UseToken(Context(), tokenID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("UseToken: %v", err)
+	}
+	return first
+}
+
+func testTokenOnlyOnce(t *testing.T, ut conduit.UsedTokens) {
+	a, b := uuid.NewString(), uuid.NewString()
+
+	if !useToken(t, ut, a) {
+		t.Errorf("first use of %s was refused", a)
+	}
+	if useToken(t, ut, a) {
+		t.Errorf("second use of %s was allowed", a)
+	}
+	if !useToken(t, ut, b) {
+		t.Errorf("first use of %s was refused", b)
+	}
+}
+
+func testTokenConcurrent(t *testing.T, ut conduit.UsedTokens) {
+	tokenID := uuid.NewString()
+
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	nrFirst := 0
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first, err := ut.UseToken(Context(), tokenID, time.Now().Add(time.Hour))
+			if err != nil {
+				t.Errorf("UseToken: %v", err)
+				return
+			}
+			if first {
+				mtx.Lock()
+				nrFirst++
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if nrFirst != 1 {
+		t.Errorf("%d concurrent uses succeeded, want 1", nrFirst)
+	}
+}
@@ -0,0 +1,8 @@
+package conduit
+
+// AdminLinks point a moderator at comments in the backend's own console, for
+// notifications. A backend without a console returns empty strings.
+type AdminLinks interface {
+	CommentLink(c *Comment) string
+	PendingLink(siteID string) string
+}
@@ -0,0 +1,102 @@
+package conduit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("invalid moderation transition")
+
+type ModerationStatus string
+
+const (
+	StatusPending  ModerationStatus = "pending"  // waiting for a moderator
+	StatusApproved ModerationStatus = "approved" // visible to readers
+	StatusRejected ModerationStatus = "rejected"
+	StatusSpam     ModerationStatus = "spam"
+	StatusDeleted  ModerationStatus = "deleted" // soft delete, DeleteComment removes for good
+)
+
+// transitions lists where each status may move to. Deleted comments can only
+// be restored to the moderation queue.
+var transitions = map[ModerationStatus][]ModerationStatus{
+	StatusPending:  {StatusApproved, StatusRejected, StatusSpam, StatusDeleted},
+	StatusApproved: {StatusPending, StatusRejected, StatusSpam, StatusDeleted},
+	StatusRejected: {StatusApproved, StatusSpam, StatusDeleted},
+	StatusSpam:     {StatusApproved, StatusRejected, StatusDeleted},
+	StatusDeleted:  {StatusPending},
+}
+
+func (s ModerationStatus) IsValid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+func CanTransition(from, to ModerationStatus) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusFromIsActive maps the old boolean onto a status. Comments that were
+// never made active might have been rejected, but we can't tell, so they go
+// back into the queue.
+func StatusFromIsActive(isActive bool) ModerationStatus {
+	if isActive {
+		return StatusApproved
+	}
+	return StatusPending
+}
+
+type StatusChange struct {
+	From ModerationStatus `json:"from"`
+	To   ModerationStatus `json:"to"`
+	At   Timestamp        `json:"at"`
+	By   string           `json:"by"` // moderator, e.g. the admin's email
+}
+
+// ResolveStatus fills in Status for comments stored before it existed and
+// keeps IsActive in step with it. Backends call it on everything they read and
+// write, which is the whole of the migration from IsActive.
+func (c *Comment) ResolveStatus() {
+	if c.Status == "" {
+		c.Status = StatusFromIsActive(c.IsActive)
+	}
+	c.IsActive = c.Status == StatusApproved
+}
+
+// Moderate moves the comment to a new status and records who did it.
+func (c *Comment) Moderate(to ModerationStatus, by string, at time.Time) error {
+	c.ResolveStatus()
+
+	if !to.IsValid() {
+		return fmt.Errorf("%w: unknown moderation status %q", ErrInvalidTransition, to)
+	}
+
+	if !CanTransition(c.Status, to) {
+		return fmt.Errorf("%w: can't move comment from %s to %s", ErrInvalidTransition, c.Status, to)
+	}
+
+	c.History = append(c.History, StatusChange{
+		From: c.Status,
+		To:   to,
+		At:   Timestamp(at),
+		By:   by,
+	})
+	c.Status = to
+	c.IsActive = to == StatusApproved
+
+	return nil
+}
+
+// LastChange is the most recent moderation decision, if there has been one.
+func (c *Comment) LastChange() (StatusChange, bool) {
+	if len(c.History) == 0 {
+		return StatusChange{}, false
+	}
+	return c.History[len(c.History)-1], true
+}
@@ -0,0 +1,83 @@
+package conduit
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending" // waiting for its next attempt
+	OutboxDead    OutboxStatus = "dead"    // gave up, until an admin retries it
+)
+
+// What an outbox entry is about.
+const (
+	OutboxNewComment          = "new_comment"          // tell the moderator
+	OutboxConfirmSubscription = "confirm_subscription" // ask the commenter, Recipient, to confirm
+	OutboxCommentOnPost       = "comment_on_post"      // tell a subscriber, Recipient, about an approved comment
+	OutboxDigest              = "digest"               // summarise the site's queue; no CommentID
+)
+
+// OutboxEntry is a notification that has yet to be delivered. Delivered
+// entries are deleted. The entry only refers to its comment, which is read
+// again at delivery. A digest covers the interval up to its CreatedAt.
+type OutboxEntry struct {
+	EntryID     string       `json:"entryID"`
+	Kind        string       `json:"kind"`
+	SiteID      string       `json:"siteID"`
+	CommentID   string       `json:"commentID"`
+	Recipient   string       `json:"recipient"`
+	Status      OutboxStatus `json:"status"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt Timestamp    `json:"nextAttempt"`
+	LastError   string       `json:"lastError"`
+	CreatedAt   Timestamp    `json:"createdAt"`
+}
+
+// OutboxFilter works like CommentFilter: nil fields match everything.
+// DueBy selects entries whose NextAttempt is at or before it.
+type OutboxFilter struct {
+	EntryID *string
+	Status  *OutboxStatus
+	DueBy   *time.Time
+}
+
+func (filter OutboxFilter) Matches(e OutboxEntry) bool {
+	if filter.EntryID != nil && e.EntryID != *filter.EntryID {
+		return false
+	}
+	if filter.Status != nil && e.Status != *filter.Status {
+		return false
+	}
+	if filter.DueBy != nil && time.Time(e.NextAttempt).After(*filter.DueBy) {
+		return false
+	}
+	return true
+}
+
+// Outbox persists notifications so that a failed send or a restart doesn't
+// lose them.
+//
+//   - PutOutboxEntry inserts or replaces by EntryID.
+//   - OutboxEntries returns matching entries, oldest CreatedAt first.
+//   - DeleteOutboxEntry is not an error for an unknown entry.
+type Outbox interface {
+	PutOutboxEntry(ctx context.Context, entry OutboxEntry) error
+	OutboxEntries(ctx context.Context, filter OutboxFilter) ([]OutboxEntry, error)
+	DeleteOutboxEntry(ctx context.Context, entryID string) error
+}
+
+// SortOutboxEntries puts entries in the order OutboxEntries promises, for
+// backends that can't sort.
+func SortOutboxEntries(entries []OutboxEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		ti, tj := time.Time(entries[i].CreatedAt), time.Time(entries[j].CreatedAt)
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return entries[i].EntryID < entries[j].EntryID
+	})
+}
@@ -0,0 +1,130 @@
+package conduit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type SortOrder string
+
+const (
+	OldestFirst SortOrder = "oldest"
+	NewestFirst SortOrder = "newest"
+)
+
+// PageRequest asks for one page of a comment listing. The zero value asks
+// for everything, oldest first.
+type PageRequest struct {
+	Cursor string    `json:"cursor"` // NextCursor from the previous page
+	Limit  int       `json:"limit"`  // 0 for no limit
+	Order  SortOrder `json:"order"`
+}
+
+type CommentPage struct {
+	Comments   []Comment
+	NextCursor string // empty on the last page
+}
+
+// IsValid checks everything a client sends, including that the cursor is one
+// we handed out, so that a bad one is the client's error and not the
+// backend's.
+func (p PageRequest) IsValid() bool {
+	if p.Cursor != "" {
+		if _, _, err := DecodeCursor(p.Cursor); err != nil {
+			return false
+		}
+	}
+	return p.Limit >= 0 && (p.Order == "" || p.Order == OldestFirst || p.Order == NewestFirst)
+}
+
+func (p PageRequest) newestFirst() bool {
+	return p.Order == NewestFirst
+}
+
+// Every backend uses the same keyset cursor: the position of the last comment
+// on the page in (Timestamp, CommentID) order. Timestamps are compared in
+// milliseconds, the coarsest precision any backend stores.
+type cursor struct {
+	Timestamp int64  `json:"t"`
+	CommentID string `json:"c"`
+}
+
+func encodeCursor(c Comment) string {
+	data, _ := json.Marshal(cursor{Timestamp: time.Time(c.Timestamp).UnixMilli(), CommentID: c.CommentID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor returns the position encoded in a cursor, for backends that
+// can seek to it natively.
+func DecodeCursor(s string) (int64, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return 0, "", fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	return c.Timestamp, c.CommentID, nil
+}
+
+func before(a, b Comment) bool {
+	ta, tb := time.Time(a.Timestamp).UnixMilli(), time.Time(b.Timestamp).UnixMilli()
+	if ta != tb {
+		return ta < tb
+	}
+	return a.CommentID < b.CommentID
+}
+
+// SortComments puts comments in page order.
+func (p PageRequest) SortComments(comments []Comment) {
+	sort.Slice(comments, func(i, j int) bool {
+		if p.newestFirst() {
+			return before(comments[j], comments[i])
+		}
+		return before(comments[i], comments[j])
+	})
+}
+
+// NewPage builds a page out of comments that are already sorted and limited
+// to Limit+1 entries past the cursor; the extra one tells us there is more.
+func (p PageRequest) NewPage(comments []Comment) CommentPage {
+	if p.Limit > 0 && len(comments) > p.Limit {
+		comments = comments[:p.Limit]
+		return CommentPage{Comments: comments, NextCursor: encodeCursor(comments[len(comments)-1])}
+	}
+	return CommentPage{Comments: comments}
+}
+
+// Paginate cuts a page out of the complete set of matching comments, for
+// backends that have to fetch everything to sort by Timestamp anyway.
+func (p PageRequest) Paginate(comments []Comment) (CommentPage, error) {
+	p.SortComments(comments)
+
+	if p.Cursor != "" {
+		ms, commentID, err := DecodeCursor(p.Cursor)
+		if err != nil {
+			return CommentPage{}, err
+		}
+		position := Comment{CommentID: commentID, Timestamp: Timestamp(time.UnixMilli(ms))}
+
+		start := sort.Search(len(comments), func(i int) bool {
+			if p.newestFirst() {
+				return before(comments[i], position)
+			}
+			return before(position, comments[i])
+		})
+		comments = comments[start:]
+	}
+
+	if p.Limit > 0 && len(comments) > p.Limit+1 {
+		comments = comments[:p.Limit+1]
+	}
+
+	return p.NewPage(comments), nil
+}
@@ -0,0 +1,28 @@
+package conduit
+
+import "context"
+
+// PostSourceAdmin marks a post added by hand. Discovered posts carry the
+// discovery source instead: sitemap, feed or archive.
+const PostSourceAdmin = "admin"
+
+// KnownPost is a post that may receive comments.
+type KnownPost struct {
+	SiteID       string    `json:"siteID"`
+	PostID       string    `json:"postID"`
+	DiscoveredAt Timestamp `json:"discoveredAt"`
+	Source       string    `json:"source"`
+}
+
+// PostRegistry persists the known posts so that they survive a restart, even
+// one during an outage of the blog itself.
+//
+//   - KnownPosts lists a site's posts, in no particular order.
+//   - AddKnownPost records a post unless it is already known, and reports
+//     whether it was added. The first DiscoveredAt and Source are kept.
+//   - RemoveKnownPost is not an error for an unknown post.
+type PostRegistry interface {
+	KnownPosts(ctx context.Context, siteID string) ([]KnownPost, error)
+	AddKnownPost(ctx context.Context, post KnownPost) (bool, error)
+	RemoveKnownPost(ctx context.Context, siteID string, postID string) error
+}
@@ -0,0 +1,35 @@
+package conduit
+
+import "context"
+
+// SpamModel is what the spam classifier has learnt about a site from its
+// moderators: how many comments of each class it has seen, and in how many
+// of them each feature (a word, an author, a link's domain) appeared.
+type SpamModel struct {
+	SiteID     string         `json:"siteID"`
+	NrSpam     int            `json:"nrSpam"`
+	NrHam      int            `json:"nrHam"`
+	SpamTokens map[string]int `json:"spamTokens"`
+	HamTokens  map[string]int `json:"hamTokens"`
+	UpdatedAt  Timestamp      `json:"updatedAt"`
+}
+
+// NewSpamModel is a model that knows nothing yet.
+func NewSpamModel(siteID string) SpamModel {
+	return SpamModel{
+		SiteID:     siteID,
+		SpamTokens: make(map[string]int),
+		HamTokens:  make(map[string]int),
+	}
+}
+
+// SpamModelStore keeps one model per site.
+//
+//   - SpamModel reports false if the site has no model yet.
+//   - PutSpamModel replaces the site's model.
+//   - DeleteSpamModel is not an error for a site without a model.
+type SpamModelStore interface {
+	SpamModel(ctx context.Context, siteID string) (SpamModel, bool, error)
+	PutSpamModel(ctx context.Context, model SpamModel) error
+	DeleteSpamModel(ctx context.Context, siteID string) error
+}
@@ -0,0 +1,33 @@
+package conduit
+
+import "context"
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionPending SubscriptionStatus = "pending" // waiting for the email to be confirmed
+	SubscriptionActive  SubscriptionStatus = "active"
+)
+
+// Subscription is a commenter's request for emails about new approved
+// comments on a post. Unsubscribing deletes it.
+type Subscription struct {
+	SubscriptionID string             `json:"subscriptionID"`
+	SiteID         string             `json:"siteID"`
+	PostID         string             `json:"postID"`
+	Email          string             `json:"email"`
+	Status         SubscriptionStatus `json:"status"`
+	CreatedAt      Timestamp          `json:"createdAt"`
+}
+
+// SubscriptionStore persists subscriptions, which are always looked up by
+// post.
+//
+//   - PostSubscriptions lists a post's subscriptions, in no particular order.
+//   - PutSubscription inserts or replaces by SubscriptionID.
+//   - DeleteSubscription is not an error for an unknown subscription.
+type SubscriptionStore interface {
+	PostSubscriptions(ctx context.Context, siteID, postID string) ([]Subscription, error)
+	PutSubscription(ctx context.Context, sub Subscription) error
+	DeleteSubscription(ctx context.Context, siteID, postID, subscriptionID string) error
+}
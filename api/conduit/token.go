@@ -0,0 +1,17 @@
+package conduit
+
+import (
+	"context"
+	"time"
+)
+
+// UsedTokens remembers single-use tokens, such as the ones in moderation
+// links, until they expire.
+//
+// UseToken records the token and reports whether this was its first use. It
+// must be atomic, so that two requests with the same token can't both see
+// true. Backends may forget tokens once they have expired, since those fail
+// verification anyway.
+type UsedTokens interface {
+	UseToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error)
+}
@@ -1,48 +1,210 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
+type Backend string
+
+const (
+	BackendDynamoDB Backend = "dynamodb"
+	BackendS3       Backend = "s3"
+	BackendSQLite   Backend = "sqlite"
+	BackendMemory   Backend = "memory"
+)
+
+type NotifierKind string
+
+const (
+	NotifierSES     NotifierKind = "ses"
+	NotifierSMTP    NotifierKind = "smtp"
+	NotifierWebhook NotifierKind = "webhook"
+	NotifierNone    NotifierKind = "none" // only logs
+)
+
+// NotifierConfig says how moderators hear about new comments.
+type NotifierConfig struct {
+	Kind NotifierKind
+
+	From string // sender of emails
+
+	SESRegion string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string // no auth if empty
+	SMTPPassword string
+	SMTPStartTLS bool
+
+	WebhookURL    string
+	WebhookSecret string // optional, signs the body
+}
+
+// NotifyMode says how often a site's moderator hears about new comments.
+type NotifyMode string
+
+const (
+	NotifyEach   NotifyMode = "each"   // one message per comment
+	NotifyDigest NotifyMode = "digest" // a summary every DigestInterval
+)
+
+// CaptchaProvider is the captcha a site's comment form uses.
+type CaptchaProvider string
+
+const (
+	CaptchaTurnstile CaptchaProvider = "turnstile" // Cloudflare
+	CaptchaHCaptcha  CaptchaProvider = "hcaptcha"
+	CaptchaReCaptcha CaptchaProvider = "recaptcha" // Google reCAPTCHA v3
+	CaptchaDisabled  CaptchaProvider = "disabled"  // for local development
+)
+
+// CaptchaConfig says how to check a site's captcha tokens.
+type CaptchaConfig struct {
+	Provider  CaptchaProvider `json:"provider"`
+	SiteKey   string          `json:"siteKey"`
+	SecretKey string          `json:"secretKey"`
+	VerifyURL string          `json:"verifyURL"` // defaults to the provider's siteverify endpoint
+	Hostnames []string        `json:"hostnames"` // pages the captcha may be solved on; defaults to the siteID
+	Action    string          `json:"action"`    // Turnstile and reCAPTCHA: the widget's action, if it sets one
+	MinScore  float64         `json:"minScore"`  // reCAPTCHA: lowest score let through, 0.5 if unset
+}
+
+// SpamConfig tunes the checks that every new comment goes through before it
+// is stored.
+type SpamConfig struct {
+	MaxLinks        int            // more links than this counts against a comment
+	BlockedWords    []string       // whole words, ignoring case
+	BadIPs          []netip.Prefix // networks that only ever send spam
+	RejectScore     float64        // comments scoring this much are marked as spam
+	DuplicateWindow time.Duration  // how long a repeated body counts as a duplicate
+}
+
+// RateLimit lets a client make Burst requests at once, refilled at Rate
+// per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits are per client, and each class of request has its own, so that
+// using up one doesn't lock a client out of the others.
+type RateLimits struct {
+	Read   RateLimit // reading comments and feeds, links in emails, and the admin API
+	Submit RateLimit // new comments and webmentions
+	Login  RateLimit // admin login
+
+	// A client's limiters are forgotten once unused for this long.
+	Idle time.Duration
+}
+
+// DiscoveryConfig says where to look for the posts on a site that may
+// receive comments. Sitemaps, Feeds and Archives are paths on BaseURL or
+// absolute URLs.
+type DiscoveryConfig struct {
+	BaseURL     string   `json:"baseURL"`     // e.g. https://example.com
+	PathPattern string   `json:"pathPattern"` // regular expression a post's path must match
+	Sitemaps    []string `json:"sitemaps"`    // sitemap.xml or sitemap index
+	Feeds       []string `json:"feeds"`       // RSS or Atom
+	Archives    []string `json:"archives"`    // HTML pages, following rel="next" links
+	MaxPages    int      `json:"maxPages"`    // upper bound on fetches per discovery run
+}
+
+// SiteConfig holds the settings of one of the sites served. SiteID is the
+// blog's host, as sent by the frontend with each comment.
+type SiteConfig struct {
+	SiteID             string          `json:"siteID"`
+	CorsAllowedOrigins []string        `json:"corsAllowedOrigins"`
+	CfSiteKey          string          `json:"cfSiteKey"`   // legacy, for Captcha.SiteKey with Turnstile
+	CfSecretKey        string          `json:"cfSecretKey"` // legacy, for Captcha.SecretKey with Turnstile
+	MaxNrComments      int             `json:"maxNrComments"`
+	Discovery          DiscoveryConfig `json:"discovery"`
+	NotifyRecipient    string          `json:"notifyRecipient"`
+	NotifyMode         NotifyMode      `json:"notifyMode"`
+
+	// Emails whose comments skip the queue when no spam check objects and
+	// a comment from the same email and address was approved before.
+	TrustedAuthors []string `json:"trustedAuthors"`
+
+	// How the comment form keeps out bots.
+	Captcha CaptchaConfig `json:"captcha"`
+}
+
 type Config struct {
+	// Which of the storage backends below is in use.
+	Backend Backend
+
 	// sqlite config
 	SqlitePath string
 
-	SESIdentity string
+	SESIdentity string // only read with NOTIFIER=ses
 
 	// S3 config
 	S3Region     string
 	S3BucketName string
+	S3Endpoint   string // optional, for a local S3 stand-in
 
 	// DynamoDB config
 	DynamoDBRegion    string
 	DynamoDBTableName string
+	DynamoDBEndpoint  string // optional, for DynamoDB Local
+
+	// Everything that isn't a comment. Defaults to DynamoDBTableName + "Meta".
+	DynamoDBMetaTableName string
+
+	Port           string
+	HmacSecret     string
+	AdminUser      string
+	AdminPass      string
+	LogLevel       slog.Level
+	LogDirectory   string
+	AppName        string
+	HandlerTimeout time.Duration
+	MaxBodySize    int
+
+	Sites []SiteConfig
+
+	Notifier NotifierConfig
+
+	// Where this API is reachable from a moderator's mail client, e.g.
+	// https://comments.example.com. Without it, notifications have no
+	// one-click moderation links.
+	PublicBaseURL string
 
-	// CloudFlare
-	CfSiteKey   string
-	CfSecretKey string
+	// How long a moderation link stays valid.
+	ModerationLinkTTL time.Duration
 
-	Port               string
-	HmacSecret         string
-	CommentHost        string
-	AdminUser          string
-	AdminPass          string
-	LogLevel           slog.Level
-	LogDirectory       string
-	AppName            string
-	CorsAllowedOrigins []string
-	HandlerTimeout     time.Duration
-	MaxBodySize        int
+	// How often sites with NotifyDigest get a digest. Digests go out on
+	// multiples of the interval since the Unix epoch, so a day means
+	// midnight UTC.
+	DigestInterval time.Duration
 
-	MaxNrComments int
+	Spam SpamConfig
 
-	LimiterRate  int
-	LimiterBurst int
+	// How long to wait for a captcha provider to check a token.
+	CaptchaTimeout time.Duration
+
+	RateLimits RateLimits
+
+	// Load balancers and reverse proxies in front of the server. Only
+	// their forwarding headers are believed; see server/clientip.go.
+	TrustedProxies []netip.Prefix
+
+	// The header in which trusted proxies say who they are forwarding for:
+	// X-Forwarded-For, X-Real-IP or Forwarded.
+	ClientIPHeader string
+
+	// Whether trusted proxies connect with a PROXY protocol header.
+	ProxyProtocol bool
 }
 
 func setDynamoDBConfig(config *Config) int {
@@ -52,6 +214,13 @@ func setDynamoDBConfig(config *Config) int {
 	if ok0 && ok1 {
 		config.DynamoDBTableName = dynamoDBTableName
 		config.DynamoDBRegion = dynamoDBRegion
+		config.DynamoDBEndpoint = os.Getenv("DYNAMODB_ENDPOINT")
+
+		config.DynamoDBMetaTableName = dynamoDBTableName + "Meta"
+		if metaTableName, ok := os.LookupEnv("DYNAMODB_META_TABLE_NAME"); ok {
+			config.DynamoDBMetaTableName = metaTableName
+		}
+		config.Backend = BackendDynamoDB
 		return 1
 	}
 
@@ -62,6 +231,7 @@ func setSQLiteConfig(config *Config) int {
 	sqlitePath, ok := os.LookupEnv("SQLITE_PATH")
 	if ok {
 		config.SqlitePath = sqlitePath
+		config.Backend = BackendSQLite
 		return 1
 	}
 	return 0
@@ -73,30 +243,44 @@ func setS3Config(config *Config) int {
 	if ok0 && ok1 {
 		config.S3BucketName = s3BucketName
 		config.S3Region = s3Region
+		config.S3Endpoint = os.Getenv("S3_ENDPOINT")
+		config.Backend = BackendS3
 		return 1
 	}
 
 	return 0
 }
 
+// The in-memory backend has no settings of its own, so it is opted into
+// explicitly with MEMORY_BACKEND=true.
+func setMemoryConfig(config *Config) int {
+	memoryBackend, ok := os.LookupEnv("MEMORY_BACKEND")
+	if !ok {
+		return 0
+	}
+
+	useMemory, err := strconv.ParseBool(memoryBackend)
+	if err != nil || !useMemory {
+		return 0
+	}
+
+	config.Backend = BackendMemory
+	return 1
+}
+
 func GetConfig() (*Config, error) {
-	cfg := &Config{MaxNrComments: 100}
+	cfg := &Config{}
 
 	dynamodb := setDynamoDBConfig(cfg)
 	s3 := setS3Config(cfg)
 	sqlite := setSQLiteConfig(cfg)
+	memory := setMemoryConfig(cfg)
 
-	if dynamodb+s3+sqlite != 1 {
+	if dynamodb+s3+sqlite+memory != 1 {
 		return nil, fmt.Errorf("need precisely one backend to be configured")
 
 	}
 
-	sesIdentity, ok := os.LookupEnv("SES_IDENTITY")
-	if !ok {
-		return nil, fmt.Errorf("SES_IDENTITY is not set")
-	}
-	cfg.SESIdentity = sesIdentity
-
 	port, ok := os.LookupEnv("PORT")
 	if !ok {
 		return nil, fmt.Errorf("PORT is not set")
@@ -109,12 +293,6 @@ func GetConfig() (*Config, error) {
 	}
 	cfg.HmacSecret = hmacSecret
 
-	commentHost, ok := os.LookupEnv("COMMENT_HOST")
-	if !ok {
-		return nil, fmt.Errorf("COMMENT_HOST is not set")
-	}
-	cfg.CommentHost = commentHost
-
 	adminUser, ok := os.LookupEnv("ADMIN_USER")
 	if !ok {
 		return nil, fmt.Errorf("ADMIN_USER is not set")
@@ -141,12 +319,6 @@ func GetConfig() (*Config, error) {
 	}
 	cfg.AppName = appName
 
-	allowedOrigins, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS")
-	if !ok {
-		return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS is not set")
-	}
-	cfg.CorsAllowedOrigins = strings.Split(allowedOrigins, ",")
-
 	handlerTimeoutString, ok := os.LookupEnv("HANDLER_TIMEOUT")
 	if !ok {
 		return nil, fmt.Errorf("HANDLER_TIMEOUT is not set")
@@ -158,41 +330,561 @@ func GetConfig() (*Config, error) {
 	}
 	cfg.HandlerTimeout = handlerTimeoutDuration
 
-	limiterRate, ok := os.LookupEnv("LIMITER_RATE")
-	if !ok {
-		return nil, fmt.Errorf("LIMITER_RATE is not set")
+	if trustedProxies, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		cfg.TrustedProxies, err = parsePrefixes(trustedProxies)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES %v", err)
+		}
 	}
-	num, err := strconv.ParseInt(limiterRate, 10, strconv.IntSize)
+
+	cfg.ClientIPHeader = "X-Forwarded-For"
+	if header, ok := os.LookupEnv("CLIENT_IP_HEADER"); ok && header != "" {
+		cfg.ClientIPHeader = http.CanonicalHeaderKey(header)
+		switch cfg.ClientIPHeader {
+		case "X-Forwarded-For", "X-Real-Ip", "Forwarded":
+		default:
+			return nil, fmt.Errorf("CLIENT_IP_HEADER must be X-Forwarded-For, X-Real-IP or Forwarded")
+		}
+	}
+
+	// Empty is the same as unset, which is what a configmap gives for a
+	// setting left out.
+	if proxyProtocol := os.Getenv("PROXY_PROTOCOL"); proxyProtocol != "" {
+		cfg.ProxyProtocol, err = strconv.ParseBool(proxyProtocol)
+		if err != nil {
+			return nil, fmt.Errorf("PROXY_PROTOCOL bad boolean")
+		}
+		if cfg.ProxyProtocol && len(cfg.TrustedProxies) == 0 {
+			return nil, fmt.Errorf("PROXY_PROTOCOL needs TRUSTED_PROXIES")
+		}
+	}
+
+	rateLimits, err := getRateLimits()
 	if err != nil {
-		return nil, fmt.Errorf("LIMITER_RATE bad integer")
+		return nil, err
+	}
+	cfg.RateLimits = rateLimits
+
+	cfg.MaxBodySize = 4 * 8192
 
+	if publicBaseURL, ok := os.LookupEnv("PUBLIC_BASE_URL"); ok {
+		u, err := url.Parse(publicBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("PUBLIC_BASE_URL must be an absolute http(s) URL")
+		}
+		cfg.PublicBaseURL = strings.TrimSuffix(publicBaseURL, "/")
 	}
-	cfg.LimiterRate = int(num)
 
-	limiterBurst, ok := os.LookupEnv("LIMITER_BURST")
+	cfg.ModerationLinkTTL = 72 * time.Hour
+	if ttl, ok := os.LookupEnv("MODERATION_LINK_TTL"); ok {
+		cfg.ModerationLinkTTL, err = time.ParseDuration(ttl)
+		if err != nil || cfg.ModerationLinkTTL <= 0 {
+			return nil, fmt.Errorf("MODERATION_LINK_TTL bad duration")
+		}
+	}
+
+	cfg.DigestInterval = 24 * time.Hour
+	if interval, ok := os.LookupEnv("DIGEST_INTERVAL"); ok {
+		cfg.DigestInterval, err = time.ParseDuration(interval)
+		if err != nil || cfg.DigestInterval < time.Minute {
+			return nil, fmt.Errorf("DIGEST_INTERVAL bad duration, must be at least 1m")
+		}
+	}
+
+	spam, err := getSpamConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Spam = spam
+
+	cfg.CaptchaTimeout = 10 * time.Second
+	if timeout, ok := os.LookupEnv("CAPTCHA_TIMEOUT"); ok {
+		cfg.CaptchaTimeout, err = time.ParseDuration(timeout)
+		if err != nil || cfg.CaptchaTimeout <= 0 {
+			return nil, fmt.Errorf("CAPTCHA_TIMEOUT bad duration")
+		}
+	}
+
+	notifier, err := getNotifierConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Notifier = notifier
+
+	var sites []SiteConfig
+	if sitesConfig, ok := os.LookupEnv("SITES_CONFIG"); ok {
+		sites, err = readSitesConfig(sitesConfig)
+	} else {
+		sites, err = getLegacySiteConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sites {
+		if err := sites[i].setDefaults(adminUser); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Sites = sites
+
+	return cfg, nil
+}
+
+// Site returns the settings for a site, if it is one of ours.
+func (cfg *Config) Site(siteID string) (SiteConfig, bool) {
+	for _, site := range cfg.Sites {
+		if site.SiteID == siteID {
+			return site, true
+		}
+	}
+	return SiteConfig{}, false
+}
+
+// AllowedOrigins is every site's CORS origins, for the one CORS handler in
+// front of the API. Requests that create comments are checked against their
+// own site's origins.
+func (cfg *Config) AllowedOrigins() []string {
+	seen := make(map[string]bool)
+	var origins []string
+	for _, site := range cfg.Sites {
+		for _, origin := range site.CorsAllowedOrigins {
+			if !seen[origin] {
+				seen[origin] = true
+				origins = append(origins, origin)
+			}
+		}
+	}
+	return origins
+}
+
+// readSitesConfig reads a JSON array of SiteConfig, for serving several sites.
+func readSitesConfig(path string) ([]SiteConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SITES_CONFIG: %v", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var sites []SiteConfig
+	if err := dec.Decode(&sites); err != nil {
+		return nil, fmt.Errorf("failed to parse SITES_CONFIG: %v", err)
+	}
+
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("SITES_CONFIG has no sites")
+	}
+
+	seen := make(map[string]bool)
+	for _, site := range sites {
+		if site.SiteID == "" {
+			return nil, fmt.Errorf("SITES_CONFIG has a site without a siteID")
+		}
+		if seen[site.SiteID] {
+			return nil, fmt.Errorf("SITES_CONFIG has site %s twice", site.SiteID)
+		}
+		seen[site.SiteID] = true
+	}
+
+	return sites, nil
+}
+
+// getLegacySiteConfig is the single site set up by COMMENT_HOST and friends,
+// from before there could be more than one.
+func getLegacySiteConfig() ([]SiteConfig, error) {
+	commentHost, ok := os.LookupEnv("COMMENT_HOST")
 	if !ok {
-		return nil, fmt.Errorf("LIMITER_BURST is not set")
+		return nil, fmt.Errorf("COMMENT_HOST is not set")
 	}
-	num, err = strconv.ParseInt(limiterBurst, 10, strconv.IntSize)
+
+	allowedOrigins, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS")
+	if !ok {
+		return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS is not set")
+	}
+
+	// The CloudFlare keys are checked by setDefaults, and aren't needed with
+	// another captcha.
+	captcha := CaptchaConfig{
+		Provider:  CaptchaProvider(os.Getenv("CAPTCHA_PROVIDER")),
+		SiteKey:   os.Getenv("CAPTCHA_SITE_KEY"),
+		SecretKey: os.Getenv("CAPTCHA_SECRET_KEY"),
+		VerifyURL: os.Getenv("CAPTCHA_VERIFY_URL"),
+		Hostnames: splitList(os.Getenv("CAPTCHA_HOSTNAMES")),
+	}
+
+	discovery, err := getDiscoveryConfig()
 	if err != nil {
-		return nil, fmt.Errorf("LIMITER_BURST bad integer")
+		return nil, err
+	}
 
+	site := SiteConfig{
+		SiteID:             commentHost,
+		CorsAllowedOrigins: strings.Split(allowedOrigins, ","),
+		CfSiteKey:          os.Getenv("CF_SITE_KEY"),
+		CfSecretKey:        os.Getenv("CF_SECRET_KEY"),
+		Discovery:          discovery,
+		NotifyRecipient:    os.Getenv("NOTIFY_RECIPIENT"),
+		NotifyMode:         NotifyMode(os.Getenv("NOTIFY_MODE")),
+		TrustedAuthors:     splitList(os.Getenv("TRUSTED_AUTHORS")),
+		Captcha:            captcha,
 	}
-	cfg.LimiterBurst = int(num)
 
-	cfg.MaxBodySize = 4 * 8192
+	return []SiteConfig{site}, nil
+}
+
+// setDefaults fills in what a site left out. Discovery defaults to what the
+// server has always done: scrape the homepage for links that start with /2
+// (as in /2024/01/01/some-post).
+func (site *SiteConfig) setDefaults(adminUser string) error {
+	if len(site.CorsAllowedOrigins) == 0 {
+		return fmt.Errorf("site %s has no CORS allowed origins", site.SiteID)
+	}
+
+	if err := site.Captcha.setDefaults(site); err != nil {
+		return err
+	}
+
+	if site.MaxNrComments == 0 {
+		site.MaxNrComments = 100
+	}
+
+	if site.NotifyRecipient == "" {
+		site.NotifyRecipient = adminUser
+	}
+
+	switch site.NotifyMode {
+	case "":
+		site.NotifyMode = NotifyEach
+	case NotifyEach, NotifyDigest:
+	default:
+		return fmt.Errorf("site %s has unknown notifyMode %q", site.SiteID, site.NotifyMode)
+	}
+
+	discovery := &site.Discovery
+
+	if discovery.BaseURL == "" {
+		discovery.BaseURL = "https://" + site.SiteID
+	}
+
+	if discovery.PathPattern == "" {
+		discovery.PathPattern = "^/2"
+	}
+
+	if len(discovery.Sitemaps)+len(discovery.Feeds)+len(discovery.Archives) == 0 {
+		discovery.Archives = []string{"/"}
+	}
+
+	if discovery.MaxPages == 0 {
+		discovery.MaxPages = 50
+	}
+
+	return nil
+}
+
+// setDefaults makes Turnstile the captcha, as it always was, and only lets
+// the captcha be solved on the site's own pages.
+func (captcha *CaptchaConfig) setDefaults(site *SiteConfig) error {
+	if captcha.Provider == "" {
+		captcha.Provider = CaptchaTurnstile
+	}
+
+	if captcha.Provider == CaptchaTurnstile {
+		if captcha.SiteKey == "" {
+			captcha.SiteKey = site.CfSiteKey
+		}
+		if captcha.SecretKey == "" {
+			captcha.SecretKey = site.CfSecretKey
+		}
+	}
+
+	switch captcha.Provider {
+	case CaptchaDisabled:
+		return nil
+	case CaptchaTurnstile, CaptchaHCaptcha, CaptchaReCaptcha:
+	default:
+		return fmt.Errorf("site %s has unknown captcha provider %q", site.SiteID, captcha.Provider)
+	}
+
+	if captcha.SecretKey == "" {
+		return fmt.Errorf("site %s has no %s secret key", site.SiteID, captcha.Provider)
+	}
+
+	if captcha.VerifyURL != "" {
+		u, err := url.Parse(captcha.VerifyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("site %s captcha verifyURL must be an absolute http(s) URL", site.SiteID)
+		}
+	}
+
+	if len(captcha.Hostnames) == 0 {
+		captcha.Hostnames = []string{site.SiteID}
+	}
+	for i := range captcha.Hostnames {
+		captcha.Hostnames[i] = strings.ToLower(captcha.Hostnames[i])
+	}
+
+	if captcha.MinScore < 0 || captcha.MinScore > 1 {
+		return fmt.Errorf("site %s captcha minScore must be between 0 and 1", site.SiteID)
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getDiscoveryConfig reads the legacy single site's discovery settings.
+// Anything unset is filled in by setDefaults.
+func getDiscoveryConfig() (DiscoveryConfig, error) {
+	var discovery DiscoveryConfig
+
+	discovery.BaseURL = os.Getenv("DISCOVERY_BASE_URL")
+	discovery.PathPattern = os.Getenv("DISCOVERY_PATH_PATTERN")
+
+	if sitemaps, ok := os.LookupEnv("DISCOVERY_SITEMAPS"); ok {
+		discovery.Sitemaps = splitList(sitemaps)
+	}
+
+	if feeds, ok := os.LookupEnv("DISCOVERY_FEEDS"); ok {
+		discovery.Feeds = splitList(feeds)
+	}
+
+	if archives, ok := os.LookupEnv("DISCOVERY_ARCHIVES"); ok {
+		discovery.Archives = splitList(archives)
+	}
+
+	if maxPages, ok := os.LookupEnv("DISCOVERY_MAX_PAGES"); ok {
+		num, err := strconv.ParseInt(maxPages, 10, strconv.IntSize)
+		if err != nil || num < 1 {
+			return discovery, fmt.Errorf("DISCOVERY_MAX_PAGES bad integer")
+		}
+		discovery.MaxPages = int(num)
+	}
+
+	return discovery, nil
+}
 
-	cfSiteKey, ok := os.LookupEnv("CF_SITE_KEY")
+// getRateLimits reads the LIMITER_* settings. LIMITER_RATE and LIMITER_BURST
+// are the read limit, from when there was only one. The others look like
+// 5/10m: a burst of 5, refilled at 5 per 10 minutes.
+func getRateLimits() (RateLimits, error) {
+	limits := RateLimits{
+		Submit: RateLimit{Rate: 5 / (10 * time.Minute).Seconds(), Burst: 5},
+		Login:  RateLimit{Rate: 5 / (15 * time.Minute).Seconds(), Burst: 5},
+		Idle:   10 * time.Minute,
+	}
+
+	limiterRate, ok := os.LookupEnv("LIMITER_RATE")
 	if !ok {
-		return nil, fmt.Errorf("CF_SITE_KEY is not set")
+		return limits, fmt.Errorf("LIMITER_RATE is not set")
+	}
+	num, err := strconv.ParseInt(limiterRate, 10, strconv.IntSize)
+	if err != nil || num < 1 {
+		return limits, fmt.Errorf("LIMITER_RATE bad integer")
 	}
-	cfg.CfSiteKey = cfSiteKey
+	limits.Read.Rate = float64(num)
 
-	cfSecretKey, ok := os.LookupEnv("CF_SECRET_KEY")
+	limiterBurst, ok := os.LookupEnv("LIMITER_BURST")
 	if !ok {
-		return nil, fmt.Errorf("CF_SECRET_KEY is not set")
+		return limits, fmt.Errorf("LIMITER_BURST is not set")
+	}
+	num, err = strconv.ParseInt(limiterBurst, 10, strconv.IntSize)
+	if err != nil || num < 1 {
+		return limits, fmt.Errorf("LIMITER_BURST bad integer")
 	}
-	cfg.CfSecretKey = cfSecretKey
+	limits.Read.Burst = int(num)
 
-	return cfg, nil
+	if submit, ok := os.LookupEnv("LIMITER_SUBMIT"); ok {
+		if limits.Submit, err = parseRateLimit(submit); err != nil {
+			return limits, fmt.Errorf("LIMITER_SUBMIT %v", err)
+		}
+	}
+
+	if login, ok := os.LookupEnv("LIMITER_LOGIN"); ok {
+		if limits.Login, err = parseRateLimit(login); err != nil {
+			return limits, fmt.Errorf("LIMITER_LOGIN %v", err)
+		}
+	}
+
+	if idle, ok := os.LookupEnv("LIMITER_IDLE"); ok {
+		limits.Idle, err = time.ParseDuration(idle)
+		if err != nil || limits.Idle <= 0 {
+			return limits, fmt.Errorf("LIMITER_IDLE bad duration")
+		}
+	}
+
+	return limits, nil
+}
+
+// parseRateLimit reads a limit like 5/10m.
+func parseRateLimit(value string) (RateLimit, error) {
+	events, per, found := strings.Cut(value, "/")
+	if !found {
+		return RateLimit{}, fmt.Errorf("must look like 5/10m")
+	}
+
+	num, err := strconv.ParseInt(strings.TrimSpace(events), 10, strconv.IntSize)
+	if err != nil || num < 1 {
+		return RateLimit{}, fmt.Errorf("bad number of requests")
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(per))
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("bad duration")
+	}
+
+	return RateLimit{Rate: float64(num) / d.Seconds(), Burst: int(num)}, nil
+}
+
+// parsePrefixes reads a comma separated list of CIDRs, where a bare address
+// stands for itself.
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(value) {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(item)
+			if addrErr != nil {
+				return nil, fmt.Errorf("bad address or CIDR %q", item)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// getSpamConfig reads the SPAM_* settings. The defaults hold comments with
+// a few links for moderation, and mark as spam anything with a blocked word
+// and a duplicate body, or from a bad network.
+func getSpamConfig() (SpamConfig, error) {
+	spam := SpamConfig{
+		MaxLinks:        2,
+		RejectScore:     10,
+		DuplicateWindow: 24 * time.Hour,
+	}
+
+	if maxLinks, ok := os.LookupEnv("SPAM_MAX_LINKS"); ok {
+		num, err := strconv.ParseInt(maxLinks, 10, strconv.IntSize)
+		if err != nil || num < 0 {
+			return spam, fmt.Errorf("SPAM_MAX_LINKS bad integer")
+		}
+		spam.MaxLinks = int(num)
+	}
+
+	if words, ok := os.LookupEnv("SPAM_BLOCKED_WORDS"); ok {
+		spam.BlockedWords = splitList(words)
+	}
+
+	if badIPs, ok := os.LookupEnv("SPAM_BAD_IPS"); ok {
+		prefixes, err := parsePrefixes(badIPs)
+		if err != nil {
+			return spam, fmt.Errorf("SPAM_BAD_IPS %v", err)
+		}
+		spam.BadIPs = prefixes
+	}
+
+	if rejectScore, ok := os.LookupEnv("SPAM_REJECT_SCORE"); ok {
+		num, err := strconv.ParseFloat(rejectScore, 64)
+		if err != nil || num <= 0 {
+			return spam, fmt.Errorf("SPAM_REJECT_SCORE bad number")
+		}
+		spam.RejectScore = num
+	}
+
+	if window, ok := os.LookupEnv("SPAM_DUPLICATE_WINDOW"); ok {
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return spam, fmt.Errorf("SPAM_DUPLICATE_WINDOW bad duration")
+		}
+		spam.DuplicateWindow = d
+	}
+
+	return spam, nil
+}
+
+// getNotifierConfig defaults to SES, which is all there used to be. Emails
+// come from the admin user unless NOTIFY_FROM says otherwise.
+func getNotifierConfig(cfg *Config) (NotifierConfig, error) {
+	notifier := NotifierConfig{
+		Kind: NotifierSES,
+		From: cfg.AdminUser,
+	}
+
+	if kind, ok := os.LookupEnv("NOTIFIER"); ok {
+		notifier.Kind = NotifierKind(kind)
+	}
+
+	if from, ok := os.LookupEnv("NOTIFY_FROM"); ok {
+		notifier.From = from
+	}
+
+	switch notifier.Kind {
+	case NotifierSES:
+		cfg.SESIdentity = os.Getenv("SES_IDENTITY")
+		if cfg.SESIdentity == "" {
+			return notifier, fmt.Errorf("SES_IDENTITY is not set")
+		}
+
+		notifier.SESRegion = cfg.DynamoDBRegion
+		if notifier.SESRegion == "" {
+			notifier.SESRegion = cfg.S3Region
+		}
+		if region, ok := os.LookupEnv("SES_REGION"); ok {
+			notifier.SESRegion = region
+		}
+		if notifier.SESRegion == "" {
+			return notifier, fmt.Errorf("SES_REGION is not set")
+		}
+
+	case NotifierSMTP:
+		smtpHost, ok := os.LookupEnv("SMTP_HOST")
+		if !ok {
+			return notifier, fmt.Errorf("SMTP_HOST is not set")
+		}
+		notifier.SMTPHost = smtpHost
+
+		notifier.SMTPPort = "587"
+		if smtpPort, ok := os.LookupEnv("SMTP_PORT"); ok {
+			notifier.SMTPPort = smtpPort
+		}
+
+		notifier.SMTPUsername = os.Getenv("SMTP_USERNAME")
+		notifier.SMTPPassword = os.Getenv("SMTP_PASSWORD")
+
+		notifier.SMTPStartTLS = true
+		if startTLS, ok := os.LookupEnv("SMTP_STARTTLS"); ok {
+			b, err := strconv.ParseBool(startTLS)
+			if err != nil {
+				return notifier, fmt.Errorf("SMTP_STARTTLS bad boolean")
+			}
+			notifier.SMTPStartTLS = b
+		}
+
+	case NotifierWebhook:
+		webhookURL, ok := os.LookupEnv("WEBHOOK_URL")
+		if !ok {
+			return notifier, fmt.Errorf("WEBHOOK_URL is not set")
+		}
+		notifier.WebhookURL = webhookURL
+		notifier.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+
+	case NotifierNone:
+
+	default:
+		return notifier, fmt.Errorf("unknown NOTIFIER %q", notifier.Kind)
+	}
+
+	return notifier, nil
 }
@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// archive scrapes an HTML page for links to posts, then follows rel="next"
+// from page to page until there are no more or MaxPages is reached.
+func (r *run) archive(ctx context.Context, ref string) error {
+	pageURL, err := r.resolve(ref, r.base)
+	if err != nil {
+		return err
+	}
+
+	for pageURL != nil {
+		body, err := r.fetch(ctx, pageURL)
+		if err != nil {
+			return err
+		}
+		if body == nil {
+			return nil
+		}
+
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to parse page: %v", err)
+		}
+
+		var next string
+
+		var f func(*html.Node)
+		f = func(n *html.Node) {
+			if n.Type == html.ElementNode && (n.Data == "a" || n.Data == "link") {
+				href, rel := attr(n, "href"), attr(n, "rel")
+
+				if href != "" && hasRel(rel, "next") && next == "" {
+					next = href
+				}
+
+				if n.Data == "a" && href != "" {
+					r.add(ctx, href, pageURL, SourceArchive)
+				}
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				f(c)
+			}
+		}
+		f(doc)
+
+		if next == "" {
+			return nil
+		}
+
+		nextURL, err := r.resolve(next, pageURL)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(nextURL.Hostname(), r.base.Hostname()) {
+			return nil
+		}
+		pageURL = nextURL
+	}
+
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasRel(rel, want string) bool {
+	for _, r := range strings.Fields(rel) {
+		if strings.EqualFold(r, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+// Package discovery finds the posts on a blog that may receive comments, by
+// reading its sitemaps, feeds and archive pages.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/config"
+)
+
+type Source string
+
+const (
+	SourceSitemap Source = "sitemap"
+	SourceFeed    Source = "feed"
+	SourceArchive Source = "archive"
+)
+
+type Post struct {
+	PostID string
+	Source Source
+	URL    string // the page the post was found on
+}
+
+// maxPageSize stops a misbehaving site from feeding us an endless body.
+const maxPageSize = 16 << 20
+
+type Discoverer struct {
+	cfg     config.DiscoveryConfig
+	base    *url.URL
+	pattern *regexp.Regexp
+	client  *http.Client
+}
+
+// New checks the config up front. A nil client gets one with a timeout.
+func New(cfg config.DiscoveryConfig, client *http.Client) (*Discoverer, error) {
+	base, err := parseHTTPURL(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad discovery base URL: %v", err)
+	}
+
+	pattern, err := regexp.Compile(cfg.PathPattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad discovery path pattern: %v", err)
+	}
+
+	if cfg.MaxPages < 1 {
+		cfg.MaxPages = 1
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &Discoverer{cfg: cfg, base: base, pattern: pattern, client: client}, nil
+}
+
+// run is the state of one Discover call.
+type run struct {
+	*Discoverer
+	fetched map[string]bool
+	posts   map[string]Post
+}
+
+// Discover reads every configured source. A source that fails doesn't stop the
+// others: whatever was found is returned along with the errors.
+func (d *Discoverer) Discover(ctx context.Context) ([]Post, error) {
+	r := &run{
+		Discoverer: d,
+		fetched:    make(map[string]bool),
+		posts:      make(map[string]Post),
+	}
+
+	var errs []error
+
+	for _, sitemap := range d.cfg.Sitemaps {
+		if err := r.sitemap(ctx, sitemap, 0); err != nil {
+			errs = append(errs, fmt.Errorf("sitemap %s: %w", sitemap, err))
+		}
+	}
+
+	for _, feed := range d.cfg.Feeds {
+		if err := r.feed(ctx, feed); err != nil {
+			errs = append(errs, fmt.Errorf("feed %s: %w", feed, err))
+		}
+	}
+
+	for _, archive := range d.cfg.Archives {
+		if err := r.archive(ctx, archive); err != nil {
+			errs = append(errs, fmt.Errorf("archive %s: %w", archive, err))
+		}
+	}
+
+	posts := make([]Post, 0, len(r.posts))
+	for _, p := range r.posts {
+		posts = append(posts, p)
+	}
+
+	return posts, errors.Join(errs...)
+}
+
+func parseHTTPURL(rawURL string) (*url.URL, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL scheme: %s", parsedURL.Scheme)
+	}
+	return parsedURL, nil
+}
+
+// resolve turns a configured path or a link found on a page into a URL.
+func (r *run) resolve(ref string, page *url.URL) (*url.URL, error) {
+	refURL, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return nil, err
+	}
+	resolved := page.ResolveReference(refURL)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL scheme: %s", resolved.Scheme)
+	}
+	return resolved, nil
+}
+
+// fetch gets a page, at most once per run and at most MaxPages per run.
+// Returns nil for a page that was already fetched.
+func (r *run) fetch(ctx context.Context, pageURL *url.URL) ([]byte, error) {
+	logger := conduit.GetLogger(ctx)
+
+	key := pageURL.String()
+	if r.fetched[key] {
+		return nil, nil
+	}
+	if len(r.fetched) >= r.cfg.MaxPages {
+		return nil, fmt.Errorf("reached the limit of %d pages", r.cfg.MaxPages)
+	}
+	r.fetched[key] = true
+
+	// The simple thing here would be to do
+	// resp, err := http.Get(pageURL)
+	// but gosec then complains about https://cwe.mitre.org/data/definitions/88.html
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		logger.Error("failed to create request", "error", err)
+		return nil, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		logger.Error("failed to get page", "url", key, "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("unexpected status", "url", key, "status", resp.StatusCode)
+		return nil, fmt.Errorf("GET %s: %s", key, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+}
+
+// add records a link if it points at a post on this site.
+func (r *run) add(ctx context.Context, link string, page *url.URL, source Source) {
+	logger := conduit.GetLogger(ctx)
+
+	postID, ok := r.postID(link, page)
+	if !ok {
+		return
+	}
+
+	if !conduit.IsValidPostID(postID) {
+		logger.Warn("ignoring link", "page_url", page.String(), "link_url", link)
+		return
+	}
+
+	if _, seen := r.posts[postID]; !seen {
+		r.posts[postID] = Post{PostID: postID, Source: source, URL: page.String()}
+	}
+}
+
+// postID is the path of a link on this site that matches PathPattern,
+// without a trailing slash.
+func (r *run) postID(link string, page *url.URL) (string, bool) {
+	linkURL, err := r.resolve(link, page)
+	if err != nil {
+		return "", false
+	}
+
+	if !strings.EqualFold(linkURL.Hostname(), r.base.Hostname()) {
+		return "", false
+	}
+
+	postID := strings.TrimSuffix(linkURL.Path, "/")
+	if postID == "" || !r.pattern.MatchString(postID) {
+		return "", false
+	}
+
+	return postID, true
+}
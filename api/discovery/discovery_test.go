@@ -0,0 +1,148 @@
+package discovery_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/discovery"
+)
+
+// testSite is a blog whose posts live under /2.../. Its pages link to each
+// other by absolute URL where the formats need it.
+func testSite(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var site *httptest.Server
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+			<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<sitemap><loc>%s/posts.xml</loc></sitemap>
+			</sitemapindex>`, site.URL)
+	})
+	mux.HandleFunc("/posts.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<url><loc>%s/2020/01/01/sitemap-post/</loc></url>
+				<url><loc>%s/about/</loc></url>
+				<url><loc>https://elsewhere.example/2020/01/01/not-ours/</loc></url>
+			</urlset>`, site.URL, site.URL)
+	})
+	mux.HandleFunc("/rss.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<rss version="2.0"><channel>
+				<item><link>%s/2024/02/02/rss-post/</link></item>
+			</channel></rss>`, site.URL)
+	})
+	mux.HandleFunc("/atom.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<feed xmlns="http://www.w3.org/2005/Atom">
+				<entry><link href="/2024/03/03/atom-post/"/><link rel="edit" href="/2024/03/03/edit"/></entry>
+			</feed>`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<html><body><a href="/2025/01/01/front/">front</a><a rel="next" href="/page/2/">older</a></body></html>`)
+	})
+	mux.HandleFunc("/page/2/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><link rel="next" href="/page/3/"></head><body><a href="/2019/05/05/second-page">second</a></body></html>`)
+	})
+	mux.HandleFunc("/page/3/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><a href="/2018/05/05/third-page/">third</a><a rel="next" href="/">back to the start</a></body></html>`)
+	})
+
+	site = httptest.NewServer(mux)
+	t.Cleanup(site.Close)
+
+	return site
+}
+
+func discover(t *testing.T, site *httptest.Server, cfg config.DiscoveryConfig) ([]string, error) {
+	t.Helper()
+
+	cfg.BaseURL = site.URL
+	cfg.PathPattern = "^/2"
+	if cfg.MaxPages == 0 {
+		cfg.MaxPages = 50
+	}
+
+	d, err := discovery.New(cfg, site.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := d.Discover(conduittest.Context())
+
+	var found []string
+	for _, post := range posts {
+		found = append(found, string(post.Source)+" "+post.PostID)
+	}
+	sort.Strings(found)
+
+	return found, err
+}
+
+func TestDiscover(t *testing.T) {
+	site := testSite(t)
+
+	tests := []struct {
+		name    string
+		cfg     config.DiscoveryConfig
+		want    []string
+		wantErr string
+	}{
+		{"sitemap index", config.DiscoveryConfig{Sitemaps: []string{"/sitemap.xml"}},
+			[]string{"sitemap /2020/01/01/sitemap-post"}, ""},
+		{"rss and atom", config.DiscoveryConfig{Feeds: []string{"/rss.xml", "/atom.xml"}},
+			[]string{"feed /2024/02/02/rss-post", "feed /2024/03/03/atom-post"}, ""},
+		{"archive pages", config.DiscoveryConfig{Archives: []string{"/"}},
+			[]string{"archive /2018/05/05/third-page", "archive /2019/05/05/second-page", "archive /2025/01/01/front"}, ""},
+		{"archive page limit", config.DiscoveryConfig{Archives: []string{"/"}, MaxPages: 2},
+			[]string{"archive /2019/05/05/second-page", "archive /2025/01/01/front"}, "limit of 2 pages"},
+		{"a missing source keeps the rest", config.DiscoveryConfig{Feeds: []string{"/missing.xml", "/rss.xml"}},
+			[]string{"feed /2024/02/02/rss-post"}, "feed /missing.xml"},
+		{"not a feed", config.DiscoveryConfig{Feeds: []string{"/posts.xml"}},
+			nil, "not an RSS or Atom feed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := discover(t, site, tt.cfg)
+
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+
+			if strings.Join(found, ", ") != strings.Join(tt.want, ", ") {
+				t.Fatalf("got %v, want %v", found, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverFindsEachPostOnce(t *testing.T) {
+	site := testSite(t)
+
+	found, err := discover(t, site, config.DiscoveryConfig{
+		Sitemaps: []string{"/sitemap.xml", "/sitemap.xml"},
+		Feeds:    []string{"/rss.xml", "/atom.xml"},
+		Archives: []string{"/", "/page/2/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 6 {
+		t.Fatalf("got %v", found)
+	}
+}
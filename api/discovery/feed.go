@@ -0,0 +1,64 @@
+package discovery
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+)
+
+// Enough of RSS 2.0 and Atom to find the links to posts.
+type feedDoc struct {
+	XMLName xml.Name
+	Items   []rssItem   `xml:"channel>item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Link string `xml:"link"`
+}
+
+type atomEntry struct {
+	Links []atomLink `xml:"link"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+func (r *run) feed(ctx context.Context, ref string) error {
+	feedURL, err := r.resolve(ref, r.base)
+	if err != nil {
+		return err
+	}
+
+	body, err := r.fetch(ctx, feedURL)
+	if err != nil || body == nil {
+		return err
+	}
+
+	var doc feedDoc
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("failed to parse feed: %v", err)
+	}
+
+	switch doc.XMLName.Local {
+	case "rss":
+		for _, item := range doc.Items {
+			r.add(ctx, item.Link, feedURL, SourceFeed)
+		}
+	case "feed":
+		for _, entry := range doc.Entries {
+			for _, link := range entry.Links {
+				// A missing rel means alternate, the post itself.
+				if link.Rel == "" || link.Rel == "alternate" {
+					r.add(ctx, link.Href, feedURL, SourceFeed)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("not an RSS or Atom feed: <%s>", doc.XMLName.Local)
+	}
+
+	return nil
+}
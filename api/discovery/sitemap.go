@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+// maxSitemapDepth bounds how far sitemap indexes may nest. The protocol only
+// allows one level, but be lenient.
+const maxSitemapDepth = 3
+
+// A sitemap is either a urlset or a sitemapindex; both list <loc> elements.
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+func (r *run) sitemap(ctx context.Context, ref string, depth int) error {
+	sitemapURL, err := r.resolve(ref, r.base)
+	if err != nil {
+		return err
+	}
+
+	body, err := r.fetch(ctx, sitemapURL)
+	if err != nil || body == nil {
+		return err
+	}
+
+	var doc sitemapDoc
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("failed to parse sitemap: %v", err)
+	}
+
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, u := range doc.URLs {
+			r.add(ctx, u.Loc, sitemapURL, SourceSitemap)
+		}
+		return nil
+
+	case "sitemapindex":
+		if depth >= maxSitemapDepth {
+			return fmt.Errorf("sitemap indexes nested too deeply")
+		}
+		var errs []error
+		for _, s := range doc.Sitemaps {
+			if err := r.sitemap(ctx, s.Loc, depth+1); err != nil {
+				errs = append(errs, fmt.Errorf("sitemap %s: %w", s.Loc, err))
+			}
+		}
+		return errors.Join(errs...)
+
+	default:
+		return fmt.Errorf("not a sitemap: <%s>", doc.XMLName.Local)
+	}
+}
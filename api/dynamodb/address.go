@@ -0,0 +1,146 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// All address rules share one partition in the meta table, like the outbox.
+// Rules that expire carry the TTL attribute too, so DynamoDB deletes them
+// eventually.
+const addressRulePK = "address"
+
+type AddressRuleStore struct {
+	*DB
+	DynamoDBMetaTableName string
+}
+
+func NewAddressRuleStore(db *DB, dynamoDBMetaTableName string) *AddressRuleStore {
+	return &AddressRuleStore{db, dynamoDBMetaTableName}
+}
+
+type DynamoAddressRule struct {
+	PK        string `dynamodbav:"PK"` // addressRulePK
+	SK        string `dynamodbav:"SK"` // Prefix
+	List      string `dynamodbav:"List"`
+	Reason    string `dynamodbav:"Reason"`
+	CreatedBy string `dynamodbav:"CreatedBy"`
+	CreatedAt int64  `dynamodbav:"CreatedAt"`
+	ExpiresMs int64  `dynamodbav:"ExpiresMs,omitempty"` // 0 for a rule that doesn't expire
+	ExpiresAt int64  `dynamodbav:"ExpiresAt,omitempty"` // unix seconds, for the table's TTL setting
+}
+
+func (as *AddressRuleStore) AddressRules(ctx context.Context) ([]conduit.AddressRule, error) {
+	logger := conduit.GetLogger(ctx)
+
+	query := &dynamodb.QueryInput{
+		TableName:              aws.String(as.DynamoDBMetaTableName),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: addressRulePK},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rules := make([]conduit.AddressRule, 0)
+
+	for {
+		result, err := as.Client.Query(ctx, query)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "query address rules")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
+
+		var items []DynamoAddressRule
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "unmarshall")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
+
+		for _, item := range items {
+			rule := conduit.AddressRule{
+				Prefix:    item.SK,
+				List:      conduit.AddressList(item.List),
+				Reason:    item.Reason,
+				CreatedBy: item.CreatedBy,
+				CreatedAt: conduit.Timestamp(time.UnixMilli(item.CreatedAt)),
+			}
+			if item.ExpiresMs != 0 {
+				t := conduit.Timestamp(time.UnixMilli(item.ExpiresMs))
+				rule.ExpiresAt = &t
+			}
+			rules = append(rules, rule)
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		query.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	return rules, nil
+}
+
+func (as *AddressRuleStore) PutAddressRule(ctx context.Context, rule conduit.AddressRule) error {
+	logger := conduit.GetLogger(ctx)
+
+	item := DynamoAddressRule{
+		PK:        addressRulePK,
+		SK:        rule.Prefix,
+		List:      string(rule.List),
+		Reason:    rule.Reason,
+		CreatedBy: rule.CreatedBy,
+		CreatedAt: time.Time(rule.CreatedAt).UnixMilli(),
+	}
+	if rule.ExpiresAt != nil {
+		item.ExpiresMs = time.Time(*rule.ExpiresAt).UnixMilli()
+		item.ExpiresAt = time.Time(*rule.ExpiresAt).Unix()
+	}
+
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return fmt.Errorf("failed to marshal address rule: %v", err)
+	}
+
+	_, err = as.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(as.DynamoDBMetaTableName),
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "PutItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
+
+func (as *AddressRuleStore) DeleteAddressRule(ctx context.Context, prefix string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := as.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(as.DynamoDBMetaTableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: addressRulePK},
+			"SK": &types.AttributeValueMemberS{Value: prefix},
+		},
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "DeleteItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
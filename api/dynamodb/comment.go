@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -19,6 +20,22 @@ import (
 
 const PostIndex = "PostIndex"
 
+// ParentIndex is sparse: top level comments have no ParentID attribute,
+// since an index key can't be an empty string.
+const ParentIndex = "ParentIndex"
+
+// TimestampIndex keeps a site's comments in (Timestamp, CommentID) order on
+// TimeOrder, so that whole-site listings can be paged without reading all
+// of them.
+const TimestampIndex = "TimestampIndex"
+
+// timeOrder is the TimestampIndex sort key: the Timestamp in milliseconds,
+// zero padded so that it sorts as a string, then the CommentID, which breaks
+// ties the way conduit.PageRequest.SortComments does.
+func timeOrder(ms int64, commentID string) string {
+	return fmt.Sprintf("%016d#%s", ms, commentID)
+}
+
 type CommentService struct {
 	*DB
 	DynamoDBRegion    string
@@ -29,49 +46,124 @@ type DynamoComment struct {
 	SiteID        string `dynamodbav:"SiteID"`
 	CommentID     string `dynamodbav:"CommentID"`
 	PostID        string `dynamodbav:"PostID"`
+	ParentID      string `dynamodbav:"ParentID,omitempty"`
 	Timestamp     int64  `dynamodbav:"Timestamp"`
+	TimeOrder     string `dynamodbav:"TimeOrder"`
 	SourceAddress string `dynamodbav:"SourceAddress"`
 	Author        string `dynamodbav:"Author"`
 	AuthorEmail   string `dynamodbav:"AuthorEmail"`
 	IsActive      int    `dynamodbav:"IsActive"`
 	CommentBody   string `dynamodbav:"CommentBody"`
+
+	// Missing on items written before moderation statuses existed. Not
+	// called Status because that is a DynamoDB reserved word.
+	ModerationStatus string               `dynamodbav:"ModerationStatus,omitempty"`
+	History          []DynamoStatusChange `dynamodbav:"History,omitempty"`
+
+	Kind      string `dynamodbav:"Kind,omitempty"`
+	SourceURL string `dynamodbav:"SourceURL,omitempty"`
+
+	SpamScore   float64  `dynamodbav:"SpamScore,omitempty"`
+	SpamReasons []string `dynamodbav:"SpamReasons,omitempty"`
+
+	SpamProbability float64 `dynamodbav:"SpamProbability,omitempty"`
+}
+
+type DynamoStatusChange struct {
+	From string `dynamodbav:"From"`
+	To   string `dynamodbav:"To"`
+	At   int64  `dynamodbav:"At"`
+	By   string `dynamodbav:"By"`
 }
 
 // Two isomorphisms:
 // 1. Timestamp: time.Time <=> UnixMilli
 // 2. IsActive: 0, 1 <=> False, True
+//
+// IsActive is still written, derived from the status, so that ActiveIndex and
+// older items keep working. Items without a ModerationStatus are migrated as
+// they are read.
 func commentToDynamoItem(c conduit.Comment) DynamoComment {
+	c.ResolveStatus()
+
 	isActive := 0
 	if c.IsActive {
 		isActive = 1
 	}
 
+	var history []DynamoStatusChange
+	for _, h := range c.History {
+		history = append(history, DynamoStatusChange{
+			From: string(h.From),
+			To:   string(h.To),
+			At:   time.Time(h.At).UnixMilli(),
+			By:   h.By,
+		})
+	}
+
 	return DynamoComment{
 		SiteID:        c.SiteID,
 		CommentID:     c.CommentID,
 		PostID:        c.PostID,
+		ParentID:      c.ParentID,
 		Timestamp:     time.Time(c.Timestamp).UnixMilli(),
+		TimeOrder:     timeOrder(time.Time(c.Timestamp).UnixMilli(), c.CommentID),
 		SourceAddress: c.SourceAddress,
 		Author:        c.Author,
 		AuthorEmail:   c.AuthorEmail,
 		IsActive:      isActive,
 		CommentBody:   c.CommentBody,
+
+		ModerationStatus: string(c.Status),
+		History:          history,
+
+		Kind:      string(c.Kind),
+		SourceURL: c.SourceURL,
+
+		SpamScore:   c.SpamScore,
+		SpamReasons: c.SpamReasons,
+
+		SpamProbability: c.SpamProbability,
 	}
 }
 
 func dynamoItemToComment(d DynamoComment) conduit.Comment {
-	return conduit.Comment{
+	var history []conduit.StatusChange
+	for _, h := range d.History {
+		history = append(history, conduit.StatusChange{
+			From: conduit.ModerationStatus(h.From),
+			To:   conduit.ModerationStatus(h.To),
+			At:   conduit.Timestamp(time.UnixMilli(h.At)),
+			By:   h.By,
+		})
+	}
+
+	c := conduit.Comment{
 		SiteID:        d.SiteID,
 		CommentID:     d.CommentID,
 		PostID:        d.PostID,
+		ParentID:      d.ParentID,
 		Timestamp:     conduit.Timestamp(time.UnixMilli(d.Timestamp)),
 		SourceAddress: d.SourceAddress,
 		Author:        d.Author,
 		AuthorEmail:   d.AuthorEmail,
 		IsActive:      d.IsActive == 1,
 		CommentBody:   d.CommentBody,
+
+		Status:  conduit.ModerationStatus(d.ModerationStatus),
+		History: history,
+
+		Kind:      conduit.CommentKind(d.Kind),
+		SourceURL: d.SourceURL,
+
+		SpamScore:   d.SpamScore,
+		SpamReasons: d.SpamReasons,
+
+		SpamProbability: d.SpamProbability,
 	}
+	c.ResolveStatus()
 
+	return c
 }
 
 func expandAWSError(err error, operation string) (string, []any) {
@@ -100,6 +192,120 @@ func NewCommentService(db *DB, dynamodbRegion string, dynamoDBTableName string)
 	return &CommentService{db, dynamodbRegion, dynamoDBTableName}
 }
 
+// commentQuery builds the query for a filter. Which key or index it uses
+// depends on what the filter pins down; whatever is left over becomes a
+// FilterExpression. The caller must check SiteID.
+func (cs *CommentService) commentQuery(filter conduit.CommentFilter) *dynamodb.QueryInput {
+	var query *dynamodb.QueryInput
+	var conditions []string
+
+	switch {
+
+	// With a CommentID, we search on the primary key; a PostID is only a filter.
+	// This would be so much nicer as an ADT.
+	case filter.CommentID != nil:
+		query = &dynamodb.QueryInput{
+			TableName:              aws.String(cs.DynamoDBTableName),
+			KeyConditionExpression: aws.String("SiteID = :siteID AND CommentID = :commentID"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":siteID":    &types.AttributeValueMemberS{Value: *filter.SiteID},
+				":commentID": &types.AttributeValueMemberS{Value: *filter.CommentID},
+			},
+		}
+
+		if filter.PostID != nil {
+			conditions = append(conditions, "PostID = :postID")
+			query.ExpressionAttributeValues[":postID"] = &types.AttributeValueMemberS{Value: *filter.PostID}
+		}
+
+	// Replies to a comment are found through the (sparse) parent index.
+	case filter.ParentID != nil && *filter.ParentID != "":
+		query = &dynamodb.QueryInput{
+			TableName:              aws.String(cs.DynamoDBTableName),
+			IndexName:              aws.String(ParentIndex),
+			KeyConditionExpression: aws.String("SiteID = :siteID AND ParentID = :parentID"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":siteID":   &types.AttributeValueMemberS{Value: *filter.SiteID},
+				":parentID": &types.AttributeValueMemberS{Value: *filter.ParentID},
+			},
+		}
+
+		if filter.PostID != nil {
+			conditions = append(conditions, "PostID = :postID")
+			query.ExpressionAttributeValues[":postID"] = &types.AttributeValueMemberS{Value: *filter.PostID}
+		}
+
+	// With a PostID, we search for SiteID + PostID using a secondary global index.
+	case filter.PostID != nil:
+		query = &dynamodb.QueryInput{
+			TableName:              aws.String(cs.DynamoDBTableName),
+			IndexName:              aws.String(PostIndex),
+			KeyConditionExpression: aws.String("SiteID = :siteID AND PostID = :postID"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":siteID": &types.AttributeValueMemberS{Value: *filter.SiteID},
+				":postID": &types.AttributeValueMemberS{Value: *filter.PostID},
+			},
+		}
+
+	// Otherwise everything on the site, in time order.
+	default:
+		query = &dynamodb.QueryInput{
+			TableName:              aws.String(cs.DynamoDBTableName),
+			IndexName:              aws.String(TimestampIndex),
+			KeyConditionExpression: aws.String("SiteID = :siteID"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":siteID": &types.AttributeValueMemberS{Value: *filter.SiteID},
+			},
+		}
+	}
+
+	if filter.ParentID != nil {
+		switch {
+		case *filter.ParentID == "":
+			// Top level comments are the ones missing from the parent index.
+			conditions = append(conditions, "attribute_not_exists(ParentID)")
+		case query.IndexName == nil || *query.IndexName != ParentIndex:
+			conditions = append(conditions, "ParentID = :parentID")
+			query.ExpressionAttributeValues[":parentID"] = &types.AttributeValueMemberS{Value: *filter.ParentID}
+		}
+	}
+
+	// Items from before ModerationStatus existed only have IsActive, which
+	// maps to pending or approved.
+	if filter.Status != nil {
+		query.ExpressionAttributeValues[":status"] = &types.AttributeValueMemberS{Value: string(*filter.Status)}
+
+		switch *filter.Status {
+		case conduit.StatusPending:
+			conditions = append(conditions, "(ModerationStatus = :status OR (attribute_not_exists(ModerationStatus) AND IsActive = :legacyActive))")
+			query.ExpressionAttributeValues[":legacyActive"] = &types.AttributeValueMemberN{Value: "0"}
+		case conduit.StatusApproved:
+			conditions = append(conditions, "(ModerationStatus = :status OR (attribute_not_exists(ModerationStatus) AND IsActive = :legacyActive))")
+			query.ExpressionAttributeValues[":legacyActive"] = &types.AttributeValueMemberN{Value: "1"}
+		default:
+			conditions = append(conditions, "ModerationStatus = :status")
+		}
+	}
+
+	// Regardless of the query, we offer to filter on IsActive.
+	if filter.IsActive != nil {
+		a := "0"
+		if *filter.IsActive {
+			a = "1"
+		}
+		conditions = append(conditions, "IsActive = :isActive")
+		query.ExpressionAttributeValues[":isActive"] = &types.AttributeValueMemberN{Value: a}
+	}
+
+	if len(conditions) > 0 {
+		query.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+
+	return query
+}
+
+// NrComments asks DynamoDB to count rather than return the items. Count is
+// per page (1MB read), so we still follow LastEvaluatedKey.
 func (cs *CommentService) NrComments(ctx context.Context, filter conduit.CommentFilter) (int, error) {
 	logger := conduit.GetLogger(ctx)
 
@@ -110,31 +316,46 @@ func (cs *CommentService) NrComments(ctx context.Context, filter conduit.Comment
 		return -1, fmt.Errorf("need PostID for count query")
 	}
 
-	query := &dynamodb.QueryInput{
-		TableName:              aws.String(cs.DynamoDBTableName),
-		IndexName:              aws.String(PostIndex),
-		KeyConditionExpression: aws.String("SiteID = :siteID AND PostID = :postID"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":siteID": &types.AttributeValueMemberS{Value: *filter.SiteID},
-			":postID": &types.AttributeValueMemberS{Value: *filter.PostID},
-		},
+	query := cs.commentQuery(filter)
+	query.Select = types.SelectCount
+
+	nr := 0
+	for {
+		result, err := cs.Client.Query(ctx, query)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "count comments")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return -1, err
+		}
+		nr += int(result.Count)
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return nr, nil
+		}
+		query.ExclusiveStartKey = result.LastEvaluatedKey
 	}
+}
 
-	result, err := cs.Client.Query(ctx, query)
-	if err != nil {
-		msg, attrs := expandAWSError(err, "query comments")
-		logger.ErrorContext(ctx, msg, attrs...)
-		return -1, err
+// NrCommentsByPost has to run one count query per post, since PostIndex can
+// only be queried for one PostID at a time.
+func (cs *CommentService) NrCommentsByPost(ctx context.Context, filter conduit.CommentFilter, postIDs []string) (map[string]int, error) {
+	if filter.SiteID == nil {
+		return nil, fmt.Errorf("need SiteID for count query")
 	}
 
-	var comments []DynamoComment
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &comments)
-	if err != nil {
-		msg, attrs := expandAWSError(err, "unmarshall")
-		logger.ErrorContext(ctx, msg, attrs...)
+	counts := make(map[string]int)
+	for _, postID := range postIDs {
+		postID := postID
+		filter.PostID = &postID
+
+		nr, err := cs.NrComments(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		counts[postID] = nr
 	}
 
-	return len(comments), nil
+	return counts, nil
 }
 
 func (cs *CommentService) UpsertComment(ctx context.Context, c *conduit.Comment) error {
@@ -160,77 +381,120 @@ func (cs *CommentService) UpsertComment(ctx context.Context, c *conduit.Comment)
 	return nil
 }
 
-func (cs *CommentService) Comments(ctx context.Context, commentFilter conduit.CommentFilter) ([]conduit.Comment, error) {
+// queryAll follows LastEvaluatedKey until the query is exhausted. A single
+// Query call stops at 1MB of items.
+func (cs *CommentService) queryAll(ctx context.Context, query *dynamodb.QueryInput) ([]DynamoComment, error) {
 	logger := conduit.GetLogger(ctx)
-	var empty []conduit.Comment
-
-	if commentFilter.SiteID == nil {
-		return empty, fmt.Errorf("need SiteID for Comment query")
-	}
 
-	var query *dynamodb.QueryInput
+	var dynamoComments []DynamoComment
 
-	switch {
+	for {
+		result, err := cs.Client.Query(ctx, query)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "query comments")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
 
-	// With a CommentID, we search on that and ignore any PostID in the filter.
-	// This would be so much nicer as an ADT.
-	case commentFilter.CommentID != nil:
-		query = &dynamodb.QueryInput{
-			TableName:              aws.String(cs.DynamoDBTableName),
-			KeyConditionExpression: aws.String("SiteID = :siteID AND CommentID = :commentID"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":siteID":    &types.AttributeValueMemberS{Value: *commentFilter.SiteID},
-				":commentID": &types.AttributeValueMemberS{Value: *commentFilter.CommentID},
-			},
+		var items []DynamoComment
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "unmarshall")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
 		}
+		dynamoComments = append(dynamoComments, items...)
 
-	// With a PostID, we search for SiteID + PostID using a secondary global index.
-	case commentFilter.PostID != nil:
-		query = &dynamodb.QueryInput{
-			TableName:              aws.String(cs.DynamoDBTableName),
-			IndexName:              aws.String(PostIndex),
-			KeyConditionExpression: aws.String("SiteID = :siteID AND PostID = :postID"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":siteID": &types.AttributeValueMemberS{Value: *commentFilter.SiteID},
-				":postID": &types.AttributeValueMemberS{Value: *commentFilter.PostID},
-			},
+		if len(result.LastEvaluatedKey) == 0 {
+			return dynamoComments, nil
 		}
-	default:
-		logger.Error("unhandled case in DynamoDB Comments", "filter", commentFilter) // FIXME log this better
-		return empty, fmt.Errorf("unhandled case in DynamoDB Comments")
+		query.ExclusiveStartKey = result.LastEvaluatedKey
 	}
+}
 
-	// Regardless of the query, we offer to filter on IsActive.
-	if commentFilter.IsActive != nil {
-		a := "0"
-		if *commentFilter.IsActive {
-			a = "1"
-		}
-		query.FilterExpression = aws.String("IsActive = :isActive")
-		query.ExpressionAttributeValues[":isActive"] = &types.AttributeValueMemberN{Value: a}
+// Comments pages through a whole-site listing on TimestampIndex, starting
+// from the cursor. The narrower queries on a post, a parent or a comment
+// don't come back in time order, so they fetch every match and page in
+// memory; a post holds at most MaxNrComments comments.
+func (cs *CommentService) Comments(ctx context.Context, commentFilter conduit.CommentFilter, page conduit.PageRequest) (conduit.CommentPage, error) {
+	if commentFilter.SiteID == nil {
+		return conduit.CommentPage{}, fmt.Errorf("need SiteID for Comment query")
 	}
 
-	result, err := cs.Client.Query(ctx, query)
-	if err != nil {
-		msg, attrs := expandAWSError(err, "query comments")
-		logger.ErrorContext(ctx, msg, attrs...)
-		return empty, err
+	query := cs.commentQuery(commentFilter)
+	if query.IndexName != nil && *query.IndexName == TimestampIndex {
+		return cs.commentsByTime(ctx, query, *commentFilter.SiteID, page)
 	}
 
-	var dynamoComments []DynamoComment
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &dynamoComments)
+	dynamoComments, err := cs.queryAll(ctx, query)
 	if err != nil {
-		msg, attrs := expandAWSError(err, "unmarshall")
-		logger.ErrorContext(ctx, msg, attrs...)
-		return empty, err
+		return conduit.CommentPage{}, err
 	}
 
-	var comments []conduit.Comment
+	comments := make([]conduit.Comment, 0, len(dynamoComments))
 	for _, d := range dynamoComments {
 		comments = append(comments, dynamoItemToComment(d))
 	}
 
-	return comments, nil
+	return page.Paginate(comments)
+}
+
+// commentsByTime reads one page, plus one comment to tell whether there is
+// more. Limit counts the items read before the FilterExpression drops any,
+// so it may take several queries to fill a page. The cursor is the index key
+// of the last comment on the previous page.
+func (cs *CommentService) commentsByTime(ctx context.Context, query *dynamodb.QueryInput, siteID string, page conduit.PageRequest) (conduit.CommentPage, error) {
+	logger := conduit.GetLogger(ctx)
+
+	query.ScanIndexForward = aws.Bool(page.Order != conduit.NewestFirst)
+
+	if page.Cursor != "" {
+		ms, commentID, err := conduit.DecodeCursor(page.Cursor)
+		if err != nil {
+			return conduit.CommentPage{}, err
+		}
+		query.ExclusiveStartKey = map[string]types.AttributeValue{
+			"SiteID":    &types.AttributeValueMemberS{Value: siteID},
+			"CommentID": &types.AttributeValueMemberS{Value: commentID},
+			"TimeOrder": &types.AttributeValueMemberS{Value: timeOrder(ms, commentID)},
+		}
+	}
+
+	if page.Limit > 0 {
+		query.Limit = aws.Int32(int32(page.Limit + 1))
+	}
+
+	var comments []conduit.Comment
+
+	for page.Limit == 0 || len(comments) <= page.Limit {
+		result, err := cs.Client.Query(ctx, query)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "query comments by time")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return conduit.CommentPage{}, err
+		}
+
+		var items []DynamoComment
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &items); err != nil {
+			msg, attrs := expandAWSError(err, "unmarshall")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return conduit.CommentPage{}, err
+		}
+		for _, d := range items {
+			comments = append(comments, dynamoItemToComment(d))
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		query.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	if page.Limit > 0 && len(comments) > page.Limit+1 {
+		comments = comments[:page.Limit+1]
+	}
+
+	return page.NewPage(comments), nil
 }
 
 func (cs *CommentService) DeleteComment(ctx context.Context, comment *conduit.Comment) error {
@@ -244,8 +508,11 @@ func (cs *CommentService) DeleteComment(ctx context.Context, comment *conduit.Co
 		},
 	}
 	_, err := cs.Client.DeleteItem(ctx, input)
-	msg, attrs := expandAWSError(err, "DeleteItem")
-	logger.ErrorContext(ctx, msg, attrs...)
+	if err != nil {
+		msg, attrs := expandAWSError(err, "DeleteItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
 
-	return err
+	return nil
 }
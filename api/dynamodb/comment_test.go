@@ -0,0 +1,41 @@
+package dynamodb
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// TimestampIndex must hand out comments in the order the cursor assumes, or
+// a page boundary between two comments in the same millisecond repeats or
+// skips one.
+func TestTimeOrder(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var comments []conduit.Comment
+	for i, id := range []string{"b", "a", "c", "a-1", "B", "b", "0", "z"} {
+		at := start.Add(time.Duration(i%3) * time.Millisecond)
+		if i == 7 {
+			// Ten times as many milliseconds, so one more digit.
+			at = time.UnixMilli(10 * start.UnixMilli())
+		}
+		comments = append(comments, conduit.Comment{CommentID: id, Timestamp: conduit.Timestamp(at)})
+	}
+
+	want := append([]conduit.Comment{}, comments...)
+	conduit.PageRequest{}.SortComments(want)
+
+	got := append([]conduit.Comment{}, comments...)
+	sort.SliceStable(got, func(i, j int) bool {
+		return commentToDynamoItem(got[i]).TimeOrder < commentToDynamoItem(got[j]).TimeOrder
+	})
+
+	for i := range want {
+		if got[i].CommentID != want[i].CommentID || !time.Time(got[i].Timestamp).Equal(time.Time(want[i].Timestamp)) {
+			t.Fatalf("position %d: got %s at %v, want %s at %v", i,
+				got[i].CommentID, time.Time(got[i].Timestamp), want[i].CommentID, time.Time(want[i].Timestamp))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package dynamodb_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/dynamodb"
+)
+
+// TestConduit needs DynamoDB Local at DYNAMODB_ENDPOINT; "make
+// test-backends" starts one. The tables, DYNAMODB_TABLE_NAME (BlogComments by
+// default) and its Meta table, are created from the schemas in ../../dynamodb
+// if they aren't there.
+func TestConduit(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT is not set")
+	}
+
+	region := os.Getenv("DYNAMODB_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	table := os.Getenv("DYNAMODB_TABLE_NAME")
+	if table == "" {
+		table = "BlogComments"
+	}
+	metaTable := os.Getenv("DYNAMODB_META_TABLE_NAME")
+	if metaTable == "" {
+		metaTable = table + "Meta"
+	}
+
+	db, err := dynamodb.Open(conduittest.Context(), config.Config{DynamoDBRegion: region, DynamoDBEndpoint: endpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createTable(t, db, "../../dynamodb/dynamodb-schema.json", table)
+	createTable(t, db, "../../dynamodb/dynamodb-meta-schema.json", metaTable)
+
+	t.Run("CommentService", func(t *testing.T) { conduittest.TestCommentService(t, dynamodb.NewCommentService(db, region, table)) })
+	t.Run("PostRegistry", func(t *testing.T) { conduittest.TestPostRegistry(t, dynamodb.NewPostRegistry(db, metaTable)) })
+	t.Run("Outbox", func(t *testing.T) { conduittest.TestOutbox(t, dynamodb.NewOutbox(db, metaTable)) })
+	t.Run("UsedTokens", func(t *testing.T) { conduittest.TestUsedTokens(t, dynamodb.NewUsedTokens(db, metaTable)) })
+	t.Run("SubscriptionStore", func(t *testing.T) { conduittest.TestSubscriptionStore(t, dynamodb.NewSubscriptionStore(db, metaTable)) })
+	t.Run("SpamModelStore", func(t *testing.T) { conduittest.TestSpamModelStore(t, dynamodb.NewSpamModelStore(db, metaTable)) })
+	t.Run("AddressRuleStore", func(t *testing.T) { conduittest.TestAddressRuleStore(t, dynamodb.NewAddressRuleStore(db, metaTable)) })
+}
+
+// createTable creates a table as create-dynamodb.sh would, unless it exists.
+func createTable(t *testing.T, db *dynamodb.DB, schema, name string) {
+	t.Helper()
+	ctx := conduittest.Context()
+
+	data, err := os.ReadFile(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var input awsdynamodb.CreateTableInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		t.Fatalf("%s: %v", schema, err)
+	}
+	input.TableName = aws.String(name)
+
+	_, err = db.CreateTable(ctx, &input)
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		t.Fatalf("CreateTable %s: %v", name, err)
+	}
+
+	waiter := awsdynamodb.NewTableExistsWaiter(db.Client)
+	if err := waiter.Wait(ctx, &awsdynamodb.DescribeTableInput{TableName: aws.String(name)}, time.Minute); err != nil {
+		t.Fatalf("waiting for %s: %v", name, err)
+	}
+}
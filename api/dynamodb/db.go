@@ -3,6 +3,7 @@ package dynamodb
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 
@@ -23,7 +24,12 @@ func Open(ctx context.Context, carloconfig carloconfig.Config) (*DB, error) {
 		return nil, err
 	}
 
-	client := dynamodb.NewFromConfig(cfg)
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		// DynamoDB Local for development and tests.
+		if carloconfig.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(carloconfig.DynamoDBEndpoint)
+		}
+	})
 
 	return &DB{client}, nil
 }
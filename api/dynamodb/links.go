@@ -0,0 +1,37 @@
+package dynamodb
+
+import (
+	"net/url"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+func (cs *CommentService) consoleURL() string {
+	return "https://" + cs.DynamoDBRegion + ".console.aws.amazon.com/dynamodbv2/home?region=" + cs.DynamoDBRegion
+}
+
+func (cs *CommentService) CommentLink(c *conduit.Comment) string {
+	link := cs.consoleURL()
+	link += "#edit-item?itemMode=2&pk="
+	link += url.QueryEscape(c.SiteID)
+	link += "&route=ROUTE_ITEM_EXPLORER&sk="
+	link += url.QueryEscape(c.CommentID)
+	link += "&table="
+	link += cs.DynamoDBTableName
+
+	return link
+}
+
+// PendingLink scans for pending comments. Items from before ModerationStatus
+// existed are pending when IsActive is 0, which this doesn't show.
+func (cs *CommentService) PendingLink(siteID string) string {
+	link := cs.consoleURL()
+	link += "#item-explorer?filter1Comparator=EQUAL&filter1Name=ModerationStatus&filter1Type=S&filter1Value="
+	link += string(conduit.StatusPending)
+	link += "&filter2Comparator=EQUAL&filter2Name=SiteID&filter2Type=S&filter2Value="
+	link += url.QueryEscape(siteID)
+	link += "&operation=SCAN&table="
+	link += cs.DynamoDBTableName
+
+	return link
+}
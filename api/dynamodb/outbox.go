@@ -0,0 +1,156 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// All outbox entries share one partition in the meta table. There are only
+// ever a handful, so the worker can read the whole partition and filter.
+const outboxPK = "outbox"
+
+type Outbox struct {
+	*DB
+	DynamoDBMetaTableName string
+}
+
+func NewOutbox(db *DB, dynamoDBMetaTableName string) *Outbox {
+	return &Outbox{db, dynamoDBMetaTableName}
+}
+
+type DynamoOutboxEntry struct {
+	PK          string `dynamodbav:"PK"` // outboxPK
+	SK          string `dynamodbav:"SK"` // EntryID
+	Kind        string `dynamodbav:"Kind"`
+	SiteID      string `dynamodbav:"SiteID"`
+	CommentID   string `dynamodbav:"CommentID"`
+	Recipient   string `dynamodbav:"Recipient"`
+	Status      string `dynamodbav:"Status"`
+	Attempts    int    `dynamodbav:"Attempts"`
+	NextAttempt int64  `dynamodbav:"NextAttempt"`
+	LastError   string `dynamodbav:"LastError"`
+	CreatedAt   int64  `dynamodbav:"CreatedAt"`
+}
+
+func (o *Outbox) PutOutboxEntry(ctx context.Context, entry conduit.OutboxEntry) error {
+	logger := conduit.GetLogger(ctx)
+
+	item, err := attributevalue.MarshalMap(DynamoOutboxEntry{
+		PK:          outboxPK,
+		SK:          entry.EntryID,
+		Kind:        entry.Kind,
+		SiteID:      entry.SiteID,
+		CommentID:   entry.CommentID,
+		Recipient:   entry.Recipient,
+		Status:      string(entry.Status),
+		Attempts:    entry.Attempts,
+		NextAttempt: time.Time(entry.NextAttempt).UnixMilli(),
+		LastError:   entry.LastError,
+		CreatedAt:   time.Time(entry.CreatedAt).UnixMilli(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry: %v", err)
+	}
+
+	_, err = o.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(o.DynamoDBMetaTableName),
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "PutItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
+
+func (o *Outbox) OutboxEntries(ctx context.Context, filter conduit.OutboxFilter) ([]conduit.OutboxEntry, error) {
+	logger := conduit.GetLogger(ctx)
+
+	query := &dynamodb.QueryInput{
+		TableName:              aws.String(o.DynamoDBMetaTableName),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: outboxPK},
+		},
+	}
+
+	if filter.EntryID != nil {
+		query.KeyConditionExpression = aws.String("PK = :pk AND SK = :sk")
+		query.ExpressionAttributeValues[":sk"] = &types.AttributeValueMemberS{Value: *filter.EntryID}
+	}
+
+	entries := make([]conduit.OutboxEntry, 0)
+
+	for {
+		result, err := o.Client.Query(ctx, query)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "query outbox")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
+
+		var items []DynamoOutboxEntry
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "unmarshall")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
+
+		for _, item := range items {
+			entry := conduit.OutboxEntry{
+				EntryID:     item.SK,
+				Kind:        item.Kind,
+				SiteID:      item.SiteID,
+				CommentID:   item.CommentID,
+				Recipient:   item.Recipient,
+				Status:      conduit.OutboxStatus(item.Status),
+				Attempts:    item.Attempts,
+				NextAttempt: conduit.Timestamp(time.UnixMilli(item.NextAttempt)),
+				LastError:   item.LastError,
+				CreatedAt:   conduit.Timestamp(time.UnixMilli(item.CreatedAt)),
+			}
+			if filter.Matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		query.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	conduit.SortOutboxEntries(entries)
+
+	return entries, nil
+}
+
+func (o *Outbox) DeleteOutboxEntry(ctx context.Context, entryID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := o.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(o.DynamoDBMetaTableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: outboxPK},
+			"SK": &types.AttributeValueMemberS{Value: entryID},
+		},
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "DeleteItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// Everything that isn't a comment goes in a second table with a generic
+// PK/SK key (see dynamodb-meta-schema.json), so that none of it shows up in
+// queries on the comments table. The PK prefix says what kind of item it is.
+const knownPostPrefix = "post#"
+
+type PostRegistry struct {
+	*DB
+	DynamoDBMetaTableName string
+}
+
+func NewPostRegistry(db *DB, dynamoDBMetaTableName string) *PostRegistry {
+	return &PostRegistry{db, dynamoDBMetaTableName}
+}
+
+type DynamoKnownPost struct {
+	PK           string `dynamodbav:"PK"` // knownPostPrefix + SiteID
+	SK           string `dynamodbav:"SK"` // PostID
+	SiteID       string `dynamodbav:"SiteID"`
+	DiscoveredAt int64  `dynamodbav:"DiscoveredAt"`
+	Source       string `dynamodbav:"Source"`
+}
+
+func (pr *PostRegistry) KnownPosts(ctx context.Context, siteID string) ([]conduit.KnownPost, error) {
+	logger := conduit.GetLogger(ctx)
+
+	query := &dynamodb.QueryInput{
+		TableName:              aws.String(pr.DynamoDBMetaTableName),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: knownPostPrefix + siteID},
+		},
+	}
+
+	posts := make([]conduit.KnownPost, 0)
+
+	for {
+		result, err := pr.Client.Query(ctx, query)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "query known posts")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
+
+		var items []DynamoKnownPost
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "unmarshall")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
+
+		for _, item := range items {
+			posts = append(posts, conduit.KnownPost{
+				SiteID:       item.SiteID,
+				PostID:       item.SK,
+				DiscoveredAt: conduit.Timestamp(time.UnixMilli(item.DiscoveredAt)),
+				Source:       item.Source,
+			})
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return posts, nil
+		}
+		query.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+func (pr *PostRegistry) AddKnownPost(ctx context.Context, post conduit.KnownPost) (bool, error) {
+	logger := conduit.GetLogger(ctx)
+
+	item, err := attributevalue.MarshalMap(DynamoKnownPost{
+		PK:           knownPostPrefix + post.SiteID,
+		SK:           post.PostID,
+		SiteID:       post.SiteID,
+		DiscoveredAt: time.Time(post.DiscoveredAt).UnixMilli(),
+		Source:       post.Source,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal known post: %v", err)
+	}
+
+	_, err = pr.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(pr.DynamoDBMetaTableName),
+
+		// Keep the first DiscoveredAt and Source.
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return false, nil
+	}
+	if err != nil {
+		msg, attrs := expandAWSError(err, "PutItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (pr *PostRegistry) RemoveKnownPost(ctx context.Context, siteID string, postID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := pr.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(pr.DynamoDBMetaTableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: knownPostPrefix + siteID},
+			"SK": &types.AttributeValueMemberS{Value: postID},
+		},
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "DeleteItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+const spamModelPK = "spam"
+
+// SpamModelStore keeps each site's model as a JSON string in one item. The
+// classifier caps its vocabulary so that this stays well under DynamoDB's
+// item size limit.
+type SpamModelStore struct {
+	*DB
+	DynamoDBMetaTableName string
+}
+
+func NewSpamModelStore(db *DB, dynamoDBMetaTableName string) *SpamModelStore {
+	return &SpamModelStore{db, dynamoDBMetaTableName}
+}
+
+type DynamoSpamModel struct {
+	PK    string `dynamodbav:"PK"` // spamModelPK
+	SK    string `dynamodbav:"SK"` // SiteID
+	Model string `dynamodbav:"Model"`
+}
+
+func spamModelKey(siteID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: spamModelPK},
+		"SK": &types.AttributeValueMemberS{Value: siteID},
+	}
+}
+
+func (ms *SpamModelStore) SpamModel(ctx context.Context, siteID string) (conduit.SpamModel, bool, error) {
+	logger := conduit.GetLogger(ctx)
+
+	result, err := ms.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(ms.DynamoDBMetaTableName),
+		Key:            spamModelKey(siteID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "GetItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return conduit.SpamModel{}, false, err
+	}
+	if result.Item == nil {
+		return conduit.SpamModel{}, false, nil
+	}
+
+	var item DynamoSpamModel
+	if err := attributevalue.UnmarshalMap(result.Item, &item); err != nil {
+		msg, attrs := expandAWSError(err, "unmarshall")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return conduit.SpamModel{}, false, err
+	}
+
+	var model conduit.SpamModel
+	if err := json.Unmarshal([]byte(item.Model), &model); err != nil {
+		logger.Error("failed to unmarshal spam model", "error", err, "site_id", siteID)
+		return conduit.SpamModel{}, false, err
+	}
+
+	return model, true, nil
+}
+
+func (ms *SpamModelStore) PutSpamModel(ctx context.Context, model conduit.SpamModel) error {
+	logger := conduit.GetLogger(ctx)
+
+	data, err := json.Marshal(model)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spam model: %v", err)
+	}
+
+	item, err := attributevalue.MarshalMap(DynamoSpamModel{
+		PK:    spamModelPK,
+		SK:    model.SiteID,
+		Model: string(data),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal spam model: %v", err)
+	}
+
+	_, err = ms.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(ms.DynamoDBMetaTableName),
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "PutItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
+
+func (ms *SpamModelStore) DeleteSpamModel(ctx context.Context, siteID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := ms.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(ms.DynamoDBMetaTableName),
+		Key:       spamModelKey(siteID),
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "DeleteItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
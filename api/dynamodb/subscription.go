@@ -0,0 +1,134 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+const subscriptionPrefix = "sub#"
+
+type SubscriptionStore struct {
+	*DB
+	DynamoDBMetaTableName string
+}
+
+func NewSubscriptionStore(db *DB, dynamoDBMetaTableName string) *SubscriptionStore {
+	return &SubscriptionStore{db, dynamoDBMetaTableName}
+}
+
+type DynamoSubscription struct {
+	PK        string `dynamodbav:"PK"` // subscriptionPrefix + SiteID + PostID
+	SK        string `dynamodbav:"SK"` // SubscriptionID
+	SiteID    string `dynamodbav:"SiteID"`
+	PostID    string `dynamodbav:"PostID"`
+	Email     string `dynamodbav:"Email"`
+	Status    string `dynamodbav:"Status"`
+	CreatedAt int64  `dynamodbav:"CreatedAt"`
+}
+
+func subscriptionPK(siteID, postID string) string {
+	return subscriptionPrefix + siteID + postID
+}
+
+func (ss *SubscriptionStore) PostSubscriptions(ctx context.Context, siteID, postID string) ([]conduit.Subscription, error) {
+	logger := conduit.GetLogger(ctx)
+
+	query := &dynamodb.QueryInput{
+		TableName:              aws.String(ss.DynamoDBMetaTableName),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: subscriptionPK(siteID, postID)},
+		},
+	}
+
+	subs := make([]conduit.Subscription, 0)
+
+	for {
+		result, err := ss.Client.Query(ctx, query)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "query subscriptions")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
+
+		var items []DynamoSubscription
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			msg, attrs := expandAWSError(err, "unmarshall")
+			logger.ErrorContext(ctx, msg, attrs...)
+			return nil, err
+		}
+
+		for _, item := range items {
+			subs = append(subs, conduit.Subscription{
+				SubscriptionID: item.SK,
+				SiteID:         item.SiteID,
+				PostID:         item.PostID,
+				Email:          item.Email,
+				Status:         conduit.SubscriptionStatus(item.Status),
+				CreatedAt:      conduit.Timestamp(time.UnixMilli(item.CreatedAt)),
+			})
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return subs, nil
+		}
+		query.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+func (ss *SubscriptionStore) PutSubscription(ctx context.Context, sub conduit.Subscription) error {
+	logger := conduit.GetLogger(ctx)
+
+	item, err := attributevalue.MarshalMap(DynamoSubscription{
+		PK:        subscriptionPK(sub.SiteID, sub.PostID),
+		SK:        sub.SubscriptionID,
+		SiteID:    sub.SiteID,
+		PostID:    sub.PostID,
+		Email:     sub.Email,
+		Status:    string(sub.Status),
+		CreatedAt: time.Time(sub.CreatedAt).UnixMilli(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal subscription: %v", err)
+	}
+
+	_, err = ss.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(ss.DynamoDBMetaTableName),
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "PutItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
+
+func (ss *SubscriptionStore) DeleteSubscription(ctx context.Context, siteID, postID, subscriptionID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := ss.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(ss.DynamoDBMetaTableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: subscriptionPK(siteID, postID)},
+			"SK": &types.AttributeValueMemberS{Value: subscriptionID},
+		},
+	})
+	if err != nil {
+		msg, attrs := expandAWSError(err, "DeleteItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return err
+	}
+
+	return nil
+}
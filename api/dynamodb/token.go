@@ -0,0 +1,63 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+const usedTokenPK = "token"
+
+type UsedTokens struct {
+	*DB
+	DynamoDBMetaTableName string
+}
+
+func NewUsedTokens(db *DB, dynamoDBMetaTableName string) *UsedTokens {
+	return &UsedTokens{db, dynamoDBMetaTableName}
+}
+
+type DynamoUsedToken struct {
+	PK        string `dynamodbav:"PK"`        // usedTokenPK
+	SK        string `dynamodbav:"SK"`        // TokenID
+	ExpiresAt int64  `dynamodbav:"ExpiresAt"` // unix seconds, for the table's TTL setting
+}
+
+func (ut *UsedTokens) UseToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	logger := conduit.GetLogger(ctx)
+
+	item, err := attributevalue.MarshalMap(DynamoUsedToken{
+		PK:        usedTokenPK,
+		SK:        tokenID,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal used token: %v", err)
+	}
+
+	_, err = ut.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:                item,
+		TableName:           aws.String(ut.DynamoDBMetaTableName),
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return false, nil
+	}
+	if err != nil {
+		msg, attrs := expandAWSError(err, "PutItem")
+		logger.ErrorContext(ctx, msg, attrs...)
+		return false, err
+	}
+
+	return true, nil
+}
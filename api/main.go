@@ -2,14 +2,95 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/carlohamalainen/carlo-comments/conduit"
 	"github.com/carlohamalainen/carlo-comments/config"
 	"github.com/carlohamalainen/carlo-comments/dynamodb"
+	"github.com/carlohamalainen/carlo-comments/memory"
+	"github.com/carlohamalainen/carlo-comments/notify"
+	"github.com/carlohamalainen/carlo-comments/s3"
 	"github.com/carlohamalainen/carlo-comments/server"
+	"github.com/carlohamalainen/carlo-comments/sqlite"
 )
 
+// openStores opens whichever storage backend the config selected.
+func openStores(ctx context.Context, cfg config.Config) (server.Stores, error) {
+	switch cfg.Backend {
+	case config.BackendDynamoDB:
+		db, err := dynamodb.Open(ctx, cfg)
+		if err != nil {
+			return server.Stores{}, err
+		}
+		comments := dynamodb.NewCommentService(db, cfg.DynamoDBRegion, cfg.DynamoDBTableName)
+		return server.Stores{
+			Comments:      comments,
+			Posts:         dynamodb.NewPostRegistry(db, cfg.DynamoDBMetaTableName),
+			Links:         comments,
+			Outbox:        dynamodb.NewOutbox(db, cfg.DynamoDBMetaTableName),
+			Tokens:        dynamodb.NewUsedTokens(db, cfg.DynamoDBMetaTableName),
+			Subscriptions: dynamodb.NewSubscriptionStore(db, cfg.DynamoDBMetaTableName),
+			SpamModels:    dynamodb.NewSpamModelStore(db, cfg.DynamoDBMetaTableName),
+			Addresses:     dynamodb.NewAddressRuleStore(db, cfg.DynamoDBMetaTableName),
+		}, nil
+
+	case config.BackendS3:
+		db, err := s3.Open(ctx, cfg)
+		if err != nil {
+			return server.Stores{}, err
+		}
+		comments := s3.NewCommentService(db, cfg.S3Region, cfg.S3BucketName)
+		return server.Stores{
+			Comments:      comments,
+			Posts:         s3.NewPostRegistry(db, cfg.S3BucketName),
+			Links:         comments,
+			Outbox:        s3.NewOutbox(db, cfg.S3BucketName),
+			Tokens:        s3.NewUsedTokens(db, cfg.S3BucketName),
+			Subscriptions: s3.NewSubscriptionStore(db, cfg.S3BucketName),
+			SpamModels:    s3.NewSpamModelStore(db, cfg.S3BucketName),
+			Addresses:     s3.NewAddressRuleStore(db, cfg.S3BucketName),
+		}, nil
+
+	case config.BackendSQLite:
+		db, err := sqlite.Open(ctx, cfg)
+		if err != nil {
+			return server.Stores{}, err
+		}
+		comments := sqlite.NewCommentService(db)
+		return server.Stores{
+			Comments:      comments,
+			Posts:         sqlite.NewPostRegistry(db),
+			Links:         comments,
+			Outbox:        sqlite.NewOutbox(db),
+			Tokens:        sqlite.NewUsedTokens(db),
+			Subscriptions: sqlite.NewSubscriptionStore(db),
+			SpamModels:    sqlite.NewSpamModelStore(db),
+			Addresses:     sqlite.NewAddressRuleStore(db),
+		}, nil
+
+	case config.BackendMemory:
+		db, err := memory.Open(ctx, cfg)
+		if err != nil {
+			return server.Stores{}, err
+		}
+		comments := memory.NewCommentService(db)
+		return server.Stores{
+			Comments:      comments,
+			Posts:         memory.NewPostRegistry(db),
+			Links:         comments,
+			Outbox:        memory.NewOutbox(db),
+			Tokens:        memory.NewUsedTokens(db),
+			Subscriptions: memory.NewSubscriptionStore(db),
+			SpamModels:    memory.NewSpamModelStore(db),
+			Addresses:     memory.NewAddressRuleStore(db),
+		}, nil
+
+	default:
+		return server.Stores{}, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
+
 func main() {
 	cfg, err := config.GetConfig()
 	if err != nil {
@@ -21,19 +102,40 @@ func main() {
 
 	ctx := conduit.WithLogger(context.Background(), logger)
 
-	db, err := dynamodb.Open(ctx, *cfg)
+	stores, err := openStores(ctx, *cfg)
 	if err != nil {
-		logger.Error("failed to open database", "error", err)
+		logger.Error("failed to open database", "backend", cfg.Backend, "error", err)
 		panic(err)
 	}
 
-	srv := server.NewServer(ctx, db, *cfg)
+	notifier, err := notify.New(ctx, cfg.Notifier)
+	if err != nil {
+		logger.Error("failed to set up notifications", "notifier", cfg.Notifier.Kind, "error", err)
+		panic(err)
+	}
+
+	srv := server.NewServer(ctx, stores, notifier, *cfg)
+
+	// Sends whatever was queued before a restart, as well as new comments.
+	go srv.RunOutbox(ctx)
+
+	// Discovery below may fail if a site is down, but the posts it found
+	// before are still good.
+	if err := srv.LoadKnown(ctx); err != nil {
+		logger.Error("failed to load known posts", "error", err)
+	}
+
+	if err := srv.LoadSpamIPs(ctx); err != nil {
+		logger.Error("failed to load spam addresses", "error", err)
+	}
 
 	updater := func() {
-		logger.Info("updating known hosts", "hosts", srv.Config.CommentHost)
-		err := srv.InitHost(ctx, srv.Config.CommentHost)
-		if err != nil {
-			logger.Error("updater failed", "error", err)
+		for _, site := range srv.Config.Sites {
+			logger.Info("updating known hosts", "host", site.SiteID)
+			err := srv.InitHost(ctx, site.SiteID)
+			if err != nil {
+				logger.Error("updater failed", "host", site.SiteID, "error", err)
+			}
 		}
 	}
 
@@ -46,6 +148,17 @@ func main() {
 		}
 	}()
 
+	// The first call starts the digest clock; later ones send a digest
+	// once each period has ended.
+	srv.SendDueDigests(ctx)
+
+	digestTicker := time.NewTicker(time.Minute)
+	go func() {
+		for range digestTicker.C {
+			srv.SendDueDigests(ctx)
+		}
+	}()
+
 	err = srv.Run(ctx, cfg.Port)
 	if err != nil {
 		logger.Error("server exited", "error", err)
@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type AddressRuleStore struct {
+	*DB
+}
+
+func NewAddressRuleStore(db *DB) *AddressRuleStore {
+	return &AddressRuleStore{db}
+}
+
+func (as *AddressRuleStore) AddressRules(ctx context.Context) ([]conduit.AddressRule, error) {
+	as.mtx.Lock()
+	defer as.mtx.Unlock()
+
+	rules := make([]conduit.AddressRule, 0, len(as.addressRules))
+	for _, rule := range as.addressRules {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (as *AddressRuleStore) PutAddressRule(ctx context.Context, rule conduit.AddressRule) error {
+	as.mtx.Lock()
+	defer as.mtx.Unlock()
+
+	as.addressRules[rule.Prefix] = rule
+	return nil
+}
+
+func (as *AddressRuleStore) DeleteAddressRule(ctx context.Context, prefix string) error {
+	as.mtx.Lock()
+	defer as.mtx.Unlock()
+
+	delete(as.addressRules, prefix)
+	return nil
+}
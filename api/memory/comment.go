@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type CommentService struct {
+	*DB
+}
+
+func NewCommentService(db *DB) *CommentService {
+	return &CommentService{db}
+}
+
+func (cs *CommentService) NrComments(ctx context.Context, filter conduit.CommentFilter) (int, error) {
+	if filter.SiteID == nil {
+		return -1, fmt.Errorf("need SiteID for count query")
+	}
+	if filter.PostID == nil {
+		return -1, fmt.Errorf("need PostID for count query")
+	}
+
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	nr := 0
+	for _, c := range cs.comments[*filter.SiteID] {
+		if filter.Matches(c) {
+			nr = nr + 1
+		}
+	}
+
+	return nr, nil
+}
+
+func (cs *CommentService) NrCommentsByPost(ctx context.Context, filter conduit.CommentFilter, postIDs []string) (map[string]int, error) {
+	if filter.SiteID == nil {
+		return nil, fmt.Errorf("need SiteID for count query")
+	}
+
+	counts := make(map[string]int)
+	for _, postID := range postIDs {
+		counts[postID] = 0
+	}
+
+	filter.PostID = nil
+
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	for _, c := range cs.comments[*filter.SiteID] {
+		if _, wanted := counts[c.PostID]; wanted && filter.Matches(c) {
+			counts[c.PostID]++
+		}
+	}
+
+	return counts, nil
+}
+
+func (cs *CommentService) UpsertComment(ctx context.Context, c *conduit.Comment) error {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	_, ok := cs.comments[c.SiteID]
+	if !ok {
+		cs.comments[c.SiteID] = make(map[string]conduit.Comment)
+	}
+
+	stored := *c
+	stored.History = append([]conduit.StatusChange(nil), c.History...)
+	stored.ResolveStatus()
+
+	cs.comments[c.SiteID][c.CommentID] = stored
+	return nil
+}
+
+func (cs *CommentService) Comments(ctx context.Context, commentFilter conduit.CommentFilter, page conduit.PageRequest) (conduit.CommentPage, error) {
+	if commentFilter.SiteID == nil {
+		return conduit.CommentPage{}, fmt.Errorf("need SiteID for Comment query")
+	}
+
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	comments := make([]conduit.Comment, 0)
+
+	for _, c := range cs.comments[*commentFilter.SiteID] {
+		if commentFilter.Matches(c) {
+			c.History = append([]conduit.StatusChange(nil), c.History...)
+			comments = append(comments, c)
+		}
+	}
+
+	return page.Paginate(comments)
+}
+
+func (cs *CommentService) DeleteComment(ctx context.Context, comment *conduit.Comment) error {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	delete(cs.comments[comment.SiteID], comment.CommentID)
+	return nil
+}
@@ -0,0 +1,24 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/memory"
+)
+
+func TestConduit(t *testing.T) {
+	db, err := memory.Open(conduittest.Context(), config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("CommentService", func(t *testing.T) { conduittest.TestCommentService(t, memory.NewCommentService(db)) })
+	t.Run("PostRegistry", func(t *testing.T) { conduittest.TestPostRegistry(t, memory.NewPostRegistry(db)) })
+	t.Run("Outbox", func(t *testing.T) { conduittest.TestOutbox(t, memory.NewOutbox(db)) })
+	t.Run("UsedTokens", func(t *testing.T) { conduittest.TestUsedTokens(t, memory.NewUsedTokens(db)) })
+	t.Run("SubscriptionStore", func(t *testing.T) { conduittest.TestSubscriptionStore(t, memory.NewSubscriptionStore(db)) })
+	t.Run("SpamModelStore", func(t *testing.T) { conduittest.TestSpamModelStore(t, memory.NewSpamModelStore(db)) })
+	t.Run("AddressRuleStore", func(t *testing.T) { conduittest.TestAddressRuleStore(t, memory.NewAddressRuleStore(db)) })
+}
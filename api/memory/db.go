@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/config"
+)
+
+// DB keeps everything in process. Nothing survives a restart, so it is only
+// useful for tests and demos.
+type DB struct {
+	mtx sync.Mutex
+
+	// SiteID -> CommentID -> Comment
+	comments map[string]map[string]conduit.Comment
+
+	// SiteID -> PostID -> KnownPost
+	posts map[string]map[string]conduit.KnownPost
+
+	// EntryID -> OutboxEntry
+	outbox map[string]conduit.OutboxEntry
+
+	// TokenID -> expiry
+	tokens map[string]time.Time
+
+	// SiteID + PostID -> SubscriptionID -> Subscription
+	subscriptions map[string]map[string]conduit.Subscription
+
+	// SiteID -> SpamModel
+	spamModels map[string]conduit.SpamModel
+
+	// Prefix -> AddressRule
+	addressRules map[string]conduit.AddressRule
+}
+
+func Open(ctx context.Context, cfg config.Config) (*DB, error) {
+	logger := conduit.GetLogger(ctx)
+
+	logger.Warn("using in-memory backend, comments will not be persisted")
+
+	return &DB{
+		comments: make(map[string]map[string]conduit.Comment),
+		posts:    make(map[string]map[string]conduit.KnownPost),
+		outbox:   make(map[string]conduit.OutboxEntry),
+		tokens:   make(map[string]time.Time),
+
+		subscriptions: make(map[string]map[string]conduit.Subscription),
+		spamModels:    make(map[string]conduit.SpamModel),
+		addressRules:  make(map[string]conduit.AddressRule),
+	}, nil
+}
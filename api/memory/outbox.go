@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type Outbox struct {
+	*DB
+}
+
+func NewOutbox(db *DB) *Outbox {
+	return &Outbox{db}
+}
+
+func (o *Outbox) PutOutboxEntry(ctx context.Context, entry conduit.OutboxEntry) error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	o.outbox[entry.EntryID] = entry
+	return nil
+}
+
+func (o *Outbox) OutboxEntries(ctx context.Context, filter conduit.OutboxFilter) ([]conduit.OutboxEntry, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	entries := make([]conduit.OutboxEntry, 0)
+	for _, e := range o.outbox {
+		if filter.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	conduit.SortOutboxEntries(entries)
+
+	return entries, nil
+}
+
+func (o *Outbox) DeleteOutboxEntry(ctx context.Context, entryID string) error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	delete(o.outbox, entryID)
+	return nil
+}
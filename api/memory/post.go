@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type PostRegistry struct {
+	*DB
+}
+
+func NewPostRegistry(db *DB) *PostRegistry {
+	return &PostRegistry{db}
+}
+
+func (pr *PostRegistry) KnownPosts(ctx context.Context, siteID string) ([]conduit.KnownPost, error) {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+
+	posts := make([]conduit.KnownPost, 0, len(pr.posts[siteID]))
+	for _, p := range pr.posts[siteID] {
+		posts = append(posts, p)
+	}
+
+	return posts, nil
+}
+
+func (pr *PostRegistry) AddKnownPost(ctx context.Context, post conduit.KnownPost) (bool, error) {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+
+	site, ok := pr.posts[post.SiteID]
+	if !ok {
+		site = make(map[string]conduit.KnownPost)
+		pr.posts[post.SiteID] = site
+	}
+
+	if _, ok := site[post.PostID]; ok {
+		return false, nil
+	}
+
+	site[post.PostID] = post
+	return true, nil
+}
+
+func (pr *PostRegistry) RemoveKnownPost(ctx context.Context, siteID string, postID string) error {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+
+	delete(pr.posts[siteID], postID)
+	return nil
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"context"
+	"maps"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type SpamModelStore struct {
+	*DB
+}
+
+func NewSpamModelStore(db *DB) *SpamModelStore {
+	return &SpamModelStore{db}
+}
+
+// The token maps are copied both ways, so that the caller can't change the
+// stored model other than through PutSpamModel.
+func copySpamModel(model conduit.SpamModel) conduit.SpamModel {
+	model.SpamTokens = maps.Clone(model.SpamTokens)
+	model.HamTokens = maps.Clone(model.HamTokens)
+	return model
+}
+
+func (ms *SpamModelStore) SpamModel(ctx context.Context, siteID string) (conduit.SpamModel, bool, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	model, ok := ms.spamModels[siteID]
+	if !ok {
+		return conduit.SpamModel{}, false, nil
+	}
+	return copySpamModel(model), true, nil
+}
+
+func (ms *SpamModelStore) PutSpamModel(ctx context.Context, model conduit.SpamModel) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ms.spamModels[model.SiteID] = copySpamModel(model)
+	return nil
+}
+
+func (ms *SpamModelStore) DeleteSpamModel(ctx context.Context, siteID string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.spamModels, siteID)
+	return nil
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type SubscriptionStore struct {
+	*DB
+}
+
+func NewSubscriptionStore(db *DB) *SubscriptionStore {
+	return &SubscriptionStore{db}
+}
+
+func (ss *SubscriptionStore) PostSubscriptions(ctx context.Context, siteID, postID string) ([]conduit.Subscription, error) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	subs := make([]conduit.Subscription, 0)
+	for _, sub := range ss.subscriptions[siteID+postID] {
+		subs = append(subs, sub)
+	}
+
+	return subs, nil
+}
+
+func (ss *SubscriptionStore) PutSubscription(ctx context.Context, sub conduit.Subscription) error {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	key := sub.SiteID + sub.PostID
+	if _, ok := ss.subscriptions[key]; !ok {
+		ss.subscriptions[key] = make(map[string]conduit.Subscription)
+	}
+	ss.subscriptions[key][sub.SubscriptionID] = sub
+
+	return nil
+}
+
+func (ss *SubscriptionStore) DeleteSubscription(ctx context.Context, siteID, postID, subscriptionID string) error {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	delete(ss.subscriptions[siteID+postID], subscriptionID)
+	return nil
+}
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+type UsedTokens struct {
+	*DB
+}
+
+func NewUsedTokens(db *DB) *UsedTokens {
+	return &UsedTokens{db}
+}
+
+func (ut *UsedTokens) UseToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	ut.mtx.Lock()
+	defer ut.mtx.Unlock()
+
+	now := time.Now()
+	for id, expiry := range ut.tokens {
+		if expiry.Before(now) {
+			delete(ut.tokens, id)
+		}
+	}
+
+	if _, ok := ut.tokens[tokenID]; ok {
+		return false, nil
+	}
+	ut.tokens[tokenID] = expiresAt
+
+	return true, nil
+}
@@ -0,0 +1,160 @@
+// Package modlink signs the links in emails: one-click moderation for
+// moderators, and confirm and unsubscribe links for subscribers.
+//
+// A token is the base64url JSON claims, a dot, and the base64url
+// HMAC-SHA256 of the first part. Each kind of link has its own key derived
+// from the server's HmacSecret, so a token can't pass as another kind, or as
+// a login token.
+package modlink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalid = errors.New("invalid link token")
+	ErrExpired = errors.New("expired link token")
+)
+
+// Claims say what a link does. TokenID lets the server refuse a second use.
+type Claims struct {
+	TokenID   string `json:"jti"`
+	SiteID    string `json:"site"`
+	CommentID string `json:"cid"`
+	Action    string `json:"act"`
+	Moderator string `json:"mod"` // who the link was sent to
+	ExpiresAt int64  `json:"exp"` // unix seconds
+}
+
+func (c Claims) Expires() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// SubscriptionClaims are for a subscriber's links. Unsubscribe links don't
+// expire, since they are in every email and must keep working.
+type SubscriptionClaims struct {
+	SubscriptionID string `json:"sid"`
+	SiteID         string `json:"site"`
+	PostID         string `json:"post"`
+	Action         string `json:"act"`
+	ExpiresAt      int64  `json:"exp,omitempty"` // unix seconds, 0 for never
+}
+
+type Signer struct {
+	moderationKey   []byte
+	subscriptionKey []byte
+}
+
+func NewSigner(hmacSecret string) *Signer {
+	return &Signer{
+		moderationKey:   deriveKey(hmacSecret, "carlo-comments moderation link"),
+		subscriptionKey: deriveKey(hmacSecret, "carlo-comments subscription link"),
+	}
+}
+
+func deriveKey(hmacSecret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(hmacSecret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Sign makes a token for one action on one comment, valid for ttl.
+func (s *Signer) Sign(siteID, commentID, action, moderator string, ttl time.Duration, now time.Time) (string, error) {
+	return encode(s.moderationKey, Claims{
+		TokenID:   uuid.NewString(),
+		SiteID:    siteID,
+		CommentID: commentID,
+		Action:    action,
+		Moderator: moderator,
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+}
+
+// Verify checks the signature and expiry and returns the claims.
+func (s *Signer) Verify(token string, now time.Time) (Claims, error) {
+	var claims Claims
+	if err := decode(s.moderationKey, token, &claims); err != nil {
+		return Claims{}, err
+	}
+	if claims.TokenID == "" || claims.SiteID == "" || claims.CommentID == "" || claims.Action == "" {
+		return Claims{}, ErrInvalid
+	}
+
+	if !now.Before(claims.Expires()) {
+		return Claims{}, ErrExpired
+	}
+
+	return claims, nil
+}
+
+func (s *Signer) SignSubscription(claims SubscriptionClaims) (string, error) {
+	return encode(s.subscriptionKey, claims)
+}
+
+// VerifySubscription is Verify for subscription links.
+func (s *Signer) VerifySubscription(token string, now time.Time) (SubscriptionClaims, error) {
+	var claims SubscriptionClaims
+	if err := decode(s.subscriptionKey, token, &claims); err != nil {
+		return SubscriptionClaims{}, err
+	}
+	if claims.SubscriptionID == "" || claims.SiteID == "" || claims.PostID == "" || claims.Action == "" {
+		return SubscriptionClaims{}, ErrInvalid
+	}
+
+	if claims.ExpiresAt != 0 && !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return SubscriptionClaims{}, ErrExpired
+	}
+
+	return claims, nil
+}
+
+func encode(key []byte, claims any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac(key, encoded)), nil
+}
+
+func decode(key []byte, token string, claims any) error {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalid
+	}
+
+	gotMAC, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotMAC, mac(key, encoded)) {
+		return ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalid
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(claims); err != nil {
+		return ErrInvalid
+	}
+
+	return nil
+}
+
+func mac(key []byte, encoded string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(encoded))
+	return m.Sum(nil)
+}
@@ -0,0 +1,149 @@
+package modlink_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/modlink"
+)
+
+var now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSignVerify(t *testing.T) {
+	signer := modlink.NewSigner("secret")
+
+	token, err := signer.Sign("example.com", "c1", "approve", "admin@example.com", time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := signer.Verify(token, now.Add(59*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.SiteID != "example.com" || claims.CommentID != "c1" || claims.Action != "approve" ||
+		claims.Moderator != "admin@example.com" || claims.TokenID == "" || !claims.Expires().Equal(now.Add(time.Hour)) {
+		t.Fatalf("got %+v", claims)
+	}
+
+	// Each link can be told apart, so that it can only be used once.
+	other, err := signer.Sign("example.com", "c1", "approve", "admin@example.com", time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherClaims, _ := signer.Verify(other, now); otherClaims.TokenID == claims.TokenID {
+		t.Fatal("two links share a TokenID")
+	}
+
+	if _, err := signer.Verify(token, now.Add(time.Hour)); !errors.Is(err, modlink.ErrExpired) {
+		t.Fatalf("at expiry: got %v", err)
+	}
+	if _, err := signer.Verify(token, now.Add(48*time.Hour)); !errors.Is(err, modlink.ErrExpired) {
+		t.Fatalf("after expiry: got %v", err)
+	}
+}
+
+// tamper changes the first character of the part of a token before or after
+// the dot.
+func tamper(token string, part int) string {
+	parts := strings.SplitN(token, ".", 2)
+	b := []byte(parts[part])
+	if b[0] == 'A' {
+		b[0] = 'B'
+	} else {
+		b[0] = 'A'
+	}
+	parts[part] = string(b)
+	return strings.Join(parts, ".")
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer := modlink.NewSigner("secret")
+
+	token, err := signer.Sign("example.com", "c1", "approve", "admin@example.com", time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe, err := signer.SignSubscription(modlink.SubscriptionClaims{SubscriptionID: "s1", SiteID: "example.com", PostID: "/post/", Action: "unsubscribe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret, err := modlink.NewSigner("other secret").Sign("example.com", "c1", "approve", "admin@example.com", time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spam := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(decoded), `"act":"approve"`, `"act":"spam"`, 1)))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"no signature", payload},
+		{"tampered payload", tamper(token, 0)},
+		{"tampered signature", tamper(token, 1)},
+		{"another action with the same signature", spam + "." + sig},
+		{"truncated signature", payload + "." + sig[:len(sig)-2]},
+		{"signature not base64", payload + ".!!!"},
+		{"signed with another secret", otherSecret},
+		{"a subscription link", unsubscribe},
+	}
+
+	for _, tt := range tests {
+		if _, err := signer.Verify(tt.token, now); !errors.Is(err, modlink.ErrInvalid) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+
+	// Nor does a moderation link pass as a subscription link.
+	if _, err := signer.VerifySubscription(token, now); !errors.Is(err, modlink.ErrInvalid) {
+		t.Errorf("moderation link as a subscription link: got %v", err)
+	}
+}
+
+func TestSubscriptionLinks(t *testing.T) {
+	signer := modlink.NewSigner("secret")
+
+	confirm := modlink.SubscriptionClaims{SubscriptionID: "s1", SiteID: "example.com", PostID: "/post/", Action: "confirm", ExpiresAt: now.Add(time.Hour).Unix()}
+	token, err := signer.SignSubscription(confirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := signer.VerifySubscription(token, now); err != nil || claims != confirm {
+		t.Fatalf("got %+v, %v", claims, err)
+	}
+	if _, err := signer.VerifySubscription(token, now.Add(time.Hour)); !errors.Is(err, modlink.ErrExpired) {
+		t.Fatalf("expired confirmation: got %v", err)
+	}
+	if _, err := signer.VerifySubscription(tamper(token, 0), now); !errors.Is(err, modlink.ErrInvalid) {
+		t.Fatalf("tampered confirmation: got %v", err)
+	}
+
+	// Unsubscribe links work forever.
+	unsubscribe := modlink.SubscriptionClaims{SubscriptionID: "s1", SiteID: "example.com", PostID: "/post/", Action: "unsubscribe"}
+	token, err = signer.SignSubscription(unsubscribe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := signer.VerifySubscription(token, now.AddDate(10, 0, 0)); err != nil || claims != unsubscribe {
+		t.Fatalf("got %+v, %v", claims, err)
+	}
+
+	// Claims missing a field aren't valid, even when signed.
+	token, err = signer.SignSubscription(modlink.SubscriptionClaims{SiteID: "example.com", PostID: "/post/", Action: "unsubscribe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.VerifySubscription(token, now); !errors.Is(err, modlink.ErrInvalid) {
+		t.Fatalf("no SubscriptionID: got %v", err)
+	}
+}
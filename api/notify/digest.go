@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// DigestComment is a comment awaiting moderation, with the same links as a
+// Notification.
+type DigestComment struct {
+	Comment     conduit.Comment `json:"comment"`
+	CommentLink string          `json:"commentLink,omitempty"`
+	ApproveLink string          `json:"approveLink,omitempty"`
+	RejectLink  string          `json:"rejectLink,omitempty"`
+	SpamLink    string          `json:"spamLink,omitempty"`
+}
+
+type DigestPost struct {
+	PostID   string          `json:"postID"`
+	PostURL  string          `json:"postURL"`
+	Comments []DigestComment `json:"comments"`
+}
+
+// Digest summarises a site's moderation queue for one moderator. NrSpam
+// counts the comments marked as spam between Since and Until, which never
+// reach the queue.
+type Digest struct {
+	Recipient   string       `json:"-"`
+	SiteID      string       `json:"siteID"`
+	Since       time.Time    `json:"since"`
+	Until       time.Time    `json:"until"`
+	Posts       []DigestPost `json:"posts"`
+	NrPending   int          `json:"nrPending"`
+	NrSpam      int          `json:"nrSpam"`
+	PendingLink string       `json:"pendingLink,omitempty"`
+}
+
+var digestTemplate = template.Must(template.New("digestTemplate").Parse(`
+<!DOCTYPE html>
+<html>
+<body>
+	<p>{{.NrPending}} comments awaiting moderation on {{.SiteID}}, {{.NrSpam}} marked as spam since {{.Since.Format "2006-01-02 15:04 MST"}}.</p>
+	{{range .Posts}}
+	<h2><a href="{{.PostURL}}">{{.PostID}}</a></h2>
+	{{range .Comments}}
+	<p>{{.Comment.Author}} &lt;{{.Comment.AuthorEmail}}&gt;</p>
+	<blockquote>{{.Comment.CommentBody}}</blockquote>
+	{{if .ApproveLink}}<p><a href="{{.ApproveLink}}">Approve</a> | <a href="{{.RejectLink}}">Reject</a> | <a href="{{.SpamLink}}">Spam</a></p>{{end}}
+	{{if .CommentLink}}<p><a href="{{.CommentLink}}">{{.CommentLink}}</a></p>{{end}}
+	{{end}}
+	{{end}}
+	{{if .PendingLink}}<p><a href="{{.PendingLink}}">{{.PendingLink}}</a></p>{{end}}
+</body>
+</html>
+`))
+
+func (d Digest) Email() (Email, error) {
+	var buf bytes.Buffer
+	if err := digestTemplate.Execute(&buf, d); err != nil {
+		return Email{}, fmt.Errorf("failed to execute digest template: %v", err)
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d comments awaiting moderation on %s, %d marked as spam since %s.\n",
+		d.NrPending, d.SiteID, d.NrSpam, d.Since.Format("2006-01-02 15:04 MST"))
+	for _, post := range d.Posts {
+		fmt.Fprintf(&text, "\n%s\n", post.PostURL)
+		for _, c := range post.Comments {
+			fmt.Fprintf(&text, "\n%s <%s>\n%s\n", c.Comment.Author, c.Comment.AuthorEmail, c.Comment.CommentBody)
+			if c.ApproveLink != "" {
+				fmt.Fprintf(&text, "Approve: %s\nReject: %s\nSpam: %s\n", c.ApproveLink, c.RejectLink, c.SpamLink)
+			}
+			if c.CommentLink != "" {
+				fmt.Fprintf(&text, "%s\n", c.CommentLink)
+			}
+		}
+	}
+	if d.PendingLink != "" {
+		fmt.Fprintf(&text, "\n%s\n", d.PendingLink)
+	}
+
+	return Email{
+		To:      d.Recipient,
+		Subject: fmt.Sprintf("%d comments awaiting moderation on %s", d.NrPending, d.SiteID),
+		Text:    text.String(),
+		HTML:    buf.String(),
+	}, nil
+}
+
+// digestByEmail is NotifyDigest for the email notifiers.
+func digestByEmail(ctx context.Context, m Mailer, d Digest) error {
+	logger := conduit.GetLogger(ctx)
+
+	email, err := d.Email()
+	if err != nil {
+		logger.Error("failed to build email", "error", err.Error())
+		return err
+	}
+
+	return m.Send(ctx, email)
+}
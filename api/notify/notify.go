@@ -0,0 +1,150 @@
+// Package notify tells moderators about new comments, by email through SES
+// or SMTP, or by posting to a webhook. The email notifiers also write to
+// commenters who have subscribed to a post.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/config"
+)
+
+// Notification is one new comment for one moderator. CommentLink and
+// PendingLink point into the backend's console and are empty if it hasn't
+// got one. The action links moderate the comment in one click; they are
+// empty if the server doesn't know its public URL.
+type Notification struct {
+	Recipient   string
+	Comment     conduit.Comment
+	CommentLink string
+	PendingLink string
+
+	ApproveLink string
+	RejectLink  string
+	SpamLink    string
+}
+
+// Notifier tells a moderator about one comment, or about the whole queue
+// for sites that get digests.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+	NotifyDigest(ctx context.Context, d Digest) error
+}
+
+// Email is a message with the same content as text and as HTML.
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer is implemented by the notifiers that can send email to anyone,
+// not just to moderators. Commenters' subscriptions need one.
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// notifyByEmail is Notify for the email notifiers.
+func notifyByEmail(ctx context.Context, m Mailer, n Notification) error {
+	logger := conduit.GetLogger(ctx)
+
+	htmlBody, err := htmlBody(n)
+	if err != nil {
+		logger.Error("failed to build email", "error", err.Error())
+		return err
+	}
+
+	return m.Send(ctx, Email{
+		To:      n.Recipient,
+		Subject: subject(n),
+		Text:    textBody(n),
+		HTML:    htmlBody,
+	})
+}
+
+// New builds the notifier selected by the config.
+func New(ctx context.Context, cfg config.NotifierConfig) (Notifier, error) {
+	switch cfg.Kind {
+	case config.NotifierSES:
+		return NewSES(ctx, cfg.SESRegion, cfg.From)
+	case config.NotifierSMTP:
+		return NewSMTP(cfg), nil
+	case config.NotifierWebhook:
+		return NewWebhook(cfg.WebhookURL, cfg.WebhookSecret), nil
+	case config.NotifierNone:
+		return Log{}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", cfg.Kind)
+	}
+}
+
+// Log only logs, for development or when moderators check by hand.
+type Log struct{}
+
+func (Log) Notify(ctx context.Context, n Notification) error {
+	logger := conduit.GetLogger(ctx)
+	logger.Info("new comment", "site_id", n.Comment.SiteID, "post_id", n.Comment.PostID, "comment_id", n.Comment.CommentID)
+	return nil
+}
+
+func (Log) NotifyDigest(ctx context.Context, d Digest) error {
+	logger := conduit.GetLogger(ctx)
+	logger.Info("digest", "site_id", d.SiteID, "nr_pending", d.NrPending, "nr_spam", d.NrSpam)
+	return nil
+}
+
+func (Log) Send(ctx context.Context, email Email) error {
+	logger := conduit.GetLogger(ctx)
+	logger.Info("email", "to", email.To, "subject", email.Subject, "text", email.Text)
+	return nil
+}
+
+func subject(n Notification) string {
+	return "New comment " + n.Comment.CommentID + " " + n.Comment.PostID
+}
+
+func textBody(n Notification) string {
+	body := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
+		n.Comment.CommentID,
+		n.Comment.PostID,
+		n.Comment.Author,
+		n.Comment.AuthorEmail,
+		n.Comment.CommentBody,
+		n.CommentLink,
+		n.PendingLink)
+
+	if n.ApproveLink != "" {
+		body += fmt.Sprintf("\nApprove: %s\nReject: %s\nSpam: %s\n", n.ApproveLink, n.RejectLink, n.SpamLink)
+	}
+
+	return body
+}
+
+var emailTemplate = template.Must(template.New("emailTemplate").Parse(`
+<!DOCTYPE html>
+<html>
+<body>
+	<p>{{.Comment.CommentID}}</p>
+	<p>{{.Comment.PostID}}</p>
+	<p>{{.Comment.Author}}</p>
+	<p>{{.Comment.AuthorEmail}}</p>
+	<p>{{.Comment.CommentBody}}</p>
+	{{if .CommentLink}}<p><a href="{{.CommentLink}}">{{.CommentLink}}</a></p>{{end}}
+	{{if .PendingLink}}<p><a href="{{.PendingLink}}">{{.PendingLink}}</a></p>{{end}}
+	{{if .ApproveLink}}<p><a href="{{.ApproveLink}}">Approve</a> | <a href="{{.RejectLink}}">Reject</a> | <a href="{{.SpamLink}}">Spam</a></p>{{end}}
+</body>
+</html>
+`))
+
+func htmlBody(n Notification) (string, error) {
+	var buf bytes.Buffer
+	if err := emailTemplate.Execute(&buf, n); err != nil {
+		return "", fmt.Errorf("failed to execute email template: %v", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type SES struct {
+	client *ses.Client
+	from   string
+}
+
+// NewSES loads the AWS config once, rather than for every email.
+func NewSES(ctx context.Context, region string, from string) (*SES, error) {
+	awscfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SES{client: ses.NewFromConfig(awscfg), from: from}, nil
+}
+
+func (n *SES) Notify(ctx context.Context, notification Notification) error {
+	return notifyByEmail(ctx, n, notification)
+}
+
+func (n *SES) NotifyDigest(ctx context.Context, digest Digest) error {
+	return digestByEmail(ctx, n, digest)
+}
+
+func (n *SES) Send(ctx context.Context, email Email) error {
+	logger := conduit.GetLogger(ctx)
+
+	input := &ses.SendEmailInput{
+		Destination: &types.Destination{
+			ToAddresses: []string{email.To},
+		},
+		Message: &types.Message{
+			Body: &types.Body{
+				Html: &types.Content{
+					Charset: aws.String("UTF-8"),
+					Data:    aws.String(email.HTML),
+				},
+				Text: &types.Content{
+					Charset: aws.String("UTF-8"),
+					Data:    aws.String(email.Text),
+				},
+			},
+			Subject: &types.Content{
+				Charset: aws.String("UTF-8"),
+				Data:    aws.String(email.Subject),
+			},
+		},
+		Source: aws.String(n.from),
+	}
+
+	result, err := n.client.SendEmail(ctx, input)
+	if err != nil {
+		logger.Error("failed to send email", "error", err.Error())
+		return err
+	}
+
+	logger.Info("sent email", "recipient", email.To, "message_id", result.MessageId)
+
+	return nil
+}
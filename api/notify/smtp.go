@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/config"
+)
+
+// DefaultSMTPTimeout bounds a whole conversation with the mail server, from
+// connecting to QUIT, so that a server that stops answering can't hold up
+// the outbox.
+const DefaultSMTPTimeout = 30 * time.Second
+
+// SMTP sends through any mail server. With StartTLS set (the default) it
+// refuses to send, or to authenticate, over an unencrypted connection.
+type SMTP struct {
+	cfg config.NotifierConfig
+
+	// TLSConfig is used for STARTTLS; nil means the system roots and the
+	// configured host name.
+	TLSConfig *tls.Config
+
+	// Timeout is how long sending one email may take; zero means
+	// DefaultSMTPTimeout.
+	Timeout time.Duration
+}
+
+func NewSMTP(cfg config.NotifierConfig) *SMTP {
+	return &SMTP{cfg: cfg}
+}
+
+func (n *SMTP) Notify(ctx context.Context, notification Notification) error {
+	return notifyByEmail(ctx, n, notification)
+}
+
+func (n *SMTP) NotifyDigest(ctx context.Context, digest Digest) error {
+	return digestByEmail(ctx, n, digest)
+}
+
+func (n *SMTP) Send(ctx context.Context, email Email) error {
+	logger := conduit.GetLogger(ctx)
+
+	msg, err := n.message(email)
+	if err != nil {
+		logger.Error("failed to build email", "error", err.Error())
+		return err
+	}
+
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = DefaultSMTPTimeout
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := n.send(sendCtx, email.To, msg); err != nil {
+		logger.Error("failed to send email", "error", err.Error(), "host", n.cfg.SMTPHost)
+		return err
+	}
+
+	logger.Info("sent email", "recipient", email.To)
+
+	return nil
+}
+
+func (n *SMTP) send(ctx context.Context, recipient string, msg []byte) error {
+	addr := net.JoinHostPort(n.cfg.SMTPHost, n.cfg.SMTPPort)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// net/smtp doesn't take a context, so the deadline is on the connection.
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	c, err := smtp.NewClient(conn, n.cfg.SMTPHost)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if n.cfg.SMTPStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+
+		tlsConfig := n.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: n.cfg.SMTPHost}
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if n.cfg.SMTPUsername != "" {
+		// PlainAuth itself refuses to send the password in the clear,
+		// except to localhost.
+		auth := smtp.PlainAuth("", n.cfg.SMTPUsername, n.cfg.SMTPPassword, n.cfg.SMTPHost)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(n.cfg.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(recipient); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// message is a multipart/alternative email with the same text and HTML
+// bodies as SES sends.
+func (n *SMTP) message(email Email) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", email.Text},
+		{"text/html; charset=UTF-8", email.HTML},
+	}
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", (&mail.Address{Address: n.cfg.From}).String())
+	fmt.Fprintf(&msg, "To: %s\r\n", (&mail.Address{Address: email.To}).String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", email.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n", mw.Boundary())
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
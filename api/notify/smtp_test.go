@@ -0,0 +1,226 @@
+package notify_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/notify"
+)
+
+// smtpStandIn is enough of a mail server to talk to net/smtp. It serves one
+// connection and passes on the DATA of any message it accepts.
+type smtpStandIn struct {
+	offerTLS  bool        // advertise STARTTLS
+	refuseTLS bool        // but answer it with an error
+	tlsConfig *tls.Config // for the upgrade
+	password  string      // advertise AUTH PLAIN and accept this password
+	stall     bool        // greet, then say nothing more
+
+	messages chan string
+}
+
+func (ss *smtpStandIn) start(t *testing.T) (host, port string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ss.messages = make(chan string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ss.serve(conn)
+	}()
+
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port
+}
+
+func (ss *smtpStandIn) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 stand-in ESMTP")
+	if ss.stall {
+		r.ReadString('\n')
+		time.Sleep(time.Second)
+		return
+	}
+
+	encrypted := false
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimSpace(line)
+		verb, arg, _ := strings.Cut(command, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			extensions := []string{"stand-in"}
+			if ss.offerTLS && !encrypted {
+				extensions = append(extensions, "STARTTLS")
+			}
+			if ss.password != "" {
+				extensions = append(extensions, "AUTH PLAIN")
+			}
+			for i, extension := range extensions {
+				if i == len(extensions)-1 {
+					reply("250 " + extension)
+				} else {
+					reply("250-" + extension)
+				}
+			}
+		case "STARTTLS":
+			if ss.refuseTLS {
+				reply("454 TLS not available")
+				continue
+			}
+			reply("220 go ahead")
+			tlsConn := tls.Server(conn, ss.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r, encrypted = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			_, initial, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(initial)
+			parts := strings.Split(string(decoded), "\x00")
+			if len(parts) == 3 && parts[2] == ss.password {
+				reply("235 authenticated")
+			} else {
+				reply("535 authentication failed")
+			}
+		case "MAIL", "RCPT", "NOOP", "RSET":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			reply("250 queued")
+			ss.messages <- data.String()
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// testTLS is a certificate for 127.0.0.1 and a client config that trusts it.
+func testTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+
+	https := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(https.Close)
+
+	roots := https.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	return &tls.Config{Certificates: https.TLS.Certificates},
+		&tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+func testEmail() notify.Email {
+	return notify.Email{
+		To:      "moderator@example.com",
+		Subject: "Nouveau commentaire",
+		Text:    "héllo",
+		HTML:    "<p>héllo</p>",
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	serverTLS, clientTLS := testTLS(t)
+
+	tests := []struct {
+		name     string
+		standIn  smtpStandIn
+		startTLS bool
+		username string
+		password string
+		wantErr  string
+	}{
+		{name: "plain", standIn: smtpStandIn{}},
+		{name: "STARTTLS", standIn: smtpStandIn{offerTLS: true, tlsConfig: serverTLS}, startTLS: true},
+		{name: "STARTTLS with auth", standIn: smtpStandIn{offerTLS: true, tlsConfig: serverTLS, password: "secret"},
+			startTLS: true, username: "user", password: "secret"},
+		{name: "STARTTLS required but not offered", standIn: smtpStandIn{},
+			startTLS: true, wantErr: "does not support STARTTLS"},
+		{name: "STARTTLS refused", standIn: smtpStandIn{offerTLS: true, refuseTLS: true},
+			startTLS: true, wantErr: "454"},
+		{name: "auth failure", standIn: smtpStandIn{password: "secret"},
+			username: "user", password: "wrong", wantErr: "535"},
+		{name: "server stops answering", standIn: smtpStandIn{stall: true},
+			wantErr: "timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := tt.standIn.start(t)
+
+			n := notify.NewSMTP(config.NotifierConfig{
+				From:         "comments@example.com",
+				SMTPHost:     host,
+				SMTPPort:     port,
+				SMTPUsername: tt.username,
+				SMTPPassword: tt.password,
+				SMTPStartTLS: tt.startTLS,
+			})
+			n.TLSConfig = clientTLS
+			n.Timeout = 200 * time.Millisecond
+
+			err := n.Send(conduittest.Context(), testEmail())
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				select {
+				case msg := <-tt.standIn.messages:
+					t.Fatalf("sent %q", msg)
+				default:
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg := <-tt.standIn.messages
+			for _, want := range []string{"To: <moderator@example.com>", "multipart/alternative", "h=C3=A9llo"} {
+				if !strings.Contains(msg, want) {
+					t.Fatalf("message has no %q:\n%s", want, msg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// SubscriptionConfirmation asks a commenter to confirm that they want
+// emails about a post. Nothing else is sent until they do.
+type SubscriptionConfirmation struct {
+	Recipient   string
+	PostURL     string
+	ConfirmLink string
+}
+
+var confirmationTemplate = template.Must(template.New("confirmationTemplate").Parse(`
+<!DOCTYPE html>
+<html>
+<body>
+	<p>Someone, hopefully you, asked to be told about new comments on <a href="{{.PostURL}}">{{.PostURL}}</a>.</p>
+	<p><a href="{{.ConfirmLink}}">Confirm</a></p>
+	<p>If it wasn't you, ignore this email and you won't hear from us again.</p>
+</body>
+</html>
+`))
+
+func (c SubscriptionConfirmation) Email() (Email, error) {
+	var buf bytes.Buffer
+	if err := confirmationTemplate.Execute(&buf, c); err != nil {
+		return Email{}, fmt.Errorf("failed to execute confirmation template: %v", err)
+	}
+
+	return Email{
+		To:      c.Recipient,
+		Subject: "Confirm comment notifications for " + c.PostURL,
+		Text: fmt.Sprintf("Someone, hopefully you, asked to be told about new comments on %s.\n\nConfirm: %s\n\nIf it wasn't you, ignore this email and you won't hear from us again.\n",
+			c.PostURL, c.ConfirmLink),
+		HTML: buf.String(),
+	}, nil
+}
+
+// CommentOnPost tells a subscriber about an approved comment. Like the
+// feeds, it leaves out the author's email.
+type CommentOnPost struct {
+	Recipient       string
+	PostURL         string
+	Comment         conduit.Comment
+	UnsubscribeLink string
+}
+
+var commentOnPostTemplate = template.Must(template.New("commentOnPostTemplate").Parse(`
+<!DOCTYPE html>
+<html>
+<body>
+	<p>{{.Comment.Author}} commented on <a href="{{.PostURL}}">{{.PostURL}}</a>:</p>
+	<blockquote>{{.Comment.CommentBody}}</blockquote>
+	<p><a href="{{.UnsubscribeLink}}">Unsubscribe</a></p>
+</body>
+</html>
+`))
+
+func (c CommentOnPost) Email() (Email, error) {
+	var buf bytes.Buffer
+	if err := commentOnPostTemplate.Execute(&buf, c); err != nil {
+		return Email{}, fmt.Errorf("failed to execute comment template: %v", err)
+	}
+
+	return Email{
+		To:      c.Recipient,
+		Subject: "New comment on " + c.PostURL,
+		Text: fmt.Sprintf("%s commented on %s:\n\n%s\n\nUnsubscribe: %s\n",
+			c.Comment.Author, c.PostURL, c.Comment.CommentBody, c.UnsubscribeLink),
+		HTML: buf.String(),
+	}, nil
+}
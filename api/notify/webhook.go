@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body, keyed with the
+// webhook secret, so the receiver can check where the request came from.
+const SignatureHeader = "X-Comments-Signature"
+
+// Webhook posts a JSON description of the comment to a URL, for chat
+// integrations and the like.
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhook(url string, secret string) *Webhook {
+	return &Webhook{url: url, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// WebhookPayload is what the receiver gets. Like the emails, it includes the
+// author's email but not their IP address.
+type WebhookPayload struct {
+	Event       string          `json:"event"`
+	Recipient   string          `json:"recipient"`
+	Comment     conduit.Comment `json:"comment"`
+	CommentLink string          `json:"commentLink,omitempty"`
+	PendingLink string          `json:"pendingLink,omitempty"`
+	ApproveLink string          `json:"approveLink,omitempty"`
+	RejectLink  string          `json:"rejectLink,omitempty"`
+	SpamLink    string          `json:"spamLink,omitempty"`
+}
+
+func (n *Webhook) Notify(ctx context.Context, notification Notification) error {
+	logger := conduit.GetLogger(ctx)
+
+	comment := notification.Comment
+	comment.SourceAddress = ""
+
+	body, err := json.Marshal(WebhookPayload{
+		Event:       "comment.created",
+		Recipient:   notification.Recipient,
+		Comment:     comment,
+		CommentLink: notification.CommentLink,
+		PendingLink: notification.PendingLink,
+		ApproveLink: notification.ApproveLink,
+		RejectLink:  notification.RejectLink,
+		SpamLink:    notification.SpamLink,
+	})
+	if err != nil {
+		logger.Error("json marshalling failure", "error", err)
+		return err
+	}
+
+	if err := n.post(ctx, body); err != nil {
+		return err
+	}
+
+	logger.Info("sent webhook notification", "comment_id", comment.CommentID)
+
+	return nil
+}
+
+// WebhookDigestPayload is sent instead of WebhookPayload for sites that
+// get digests.
+type WebhookDigestPayload struct {
+	Event     string `json:"event"`
+	Recipient string `json:"recipient"`
+	Digest
+}
+
+func (n *Webhook) NotifyDigest(ctx context.Context, digest Digest) error {
+	logger := conduit.GetLogger(ctx)
+
+	digest.Posts = append([]DigestPost(nil), digest.Posts...)
+	for i := range digest.Posts {
+		comments := append([]DigestComment(nil), digest.Posts[i].Comments...)
+		for j := range comments {
+			comments[j].Comment.SourceAddress = ""
+		}
+		digest.Posts[i].Comments = comments
+	}
+
+	body, err := json.Marshal(WebhookDigestPayload{
+		Event:     "comments.digest",
+		Recipient: digest.Recipient,
+		Digest:    digest,
+	})
+	if err != nil {
+		logger.Error("json marshalling failure", "error", err)
+		return err
+	}
+
+	if err := n.post(ctx, body); err != nil {
+		return err
+	}
+
+	logger.Info("sent webhook digest", "site_id", digest.SiteID)
+
+	return nil
+}
+
+func (n *Webhook) post(ctx context.Context, body []byte) error {
+	logger := conduit.GetLogger(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("failed to create request", "error", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if n.secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		logger.Error("failed to call webhook", "error", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Error("webhook failed", "status", resp.StatusCode)
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
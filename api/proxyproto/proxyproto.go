@@ -0,0 +1,248 @@
+// Package proxyproto reads the PROXY protocol header that load balancers
+// such as HAProxy, ingress-nginx and DigitalOcean's put at the start of a
+// connection, so that the server sees the client's address instead of the
+// load balancer's. Both the text (v1) and binary (v2) forms are understood.
+//
+// Only connections from trusted addresses may carry a header; anyone else
+// could use one to claim any address they like. Trusted connections without
+// a header are passed through as they are, which suits health checks that
+// go straight to the server.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerTimeout bounds how long a trusted peer has to send its header.
+var headerTimeout = 5 * time.Second
+
+// The longest v1 header, including the CRLF.
+const maxV1Length = 107
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Listener wraps accepted connections in Conn.
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+func NewListener(inner net.Listener, trusted []netip.Prefix) *Listener {
+	return &Listener{Listener: inner, trusted: trusted}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, trusted: l.isTrusted(conn.RemoteAddr()), reader: bufio.NewReader(conn)}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn reads the header on first use, in the goroutine that serves the
+// connection, so that a slow peer can't hold up Accept.
+type Conn struct {
+	net.Conn
+
+	trusted bool
+	reader  *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+		if !c.trusted {
+			return
+		}
+
+		c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		remote, err := readHeader(c.reader)
+		if err != nil {
+			c.err = err
+			return
+		}
+		if remote != nil {
+			c.remote = remote
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr is the client's address from the header, if there was one.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readHeader reads a header if the connection starts with one. It returns a
+// nil address if there was no header, or if the header didn't name a TCP
+// client, as for a load balancer's own health checks.
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(v1Prefix))
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(start, v1Prefix) {
+		return readV1(r)
+	}
+
+	// Don't wait for more bytes than a plain request may have sent.
+	if start[0] != v2Signature[0] {
+		return nil, nil
+	}
+
+	start, err = r.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(start, v2Signature) {
+		return readV2(r)
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// readV1 reads "PROXY TCP4 <src> <dst> <sport> <dport>\r\n", or
+// "PROXY UNKNOWN ...\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header too long or not terminated", ErrInvalidHeader)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || (fields[1] == "TCP4") != ip.Is4() {
+		return nil, fmt.Errorf("%w: bad source address %q", ErrInvalidHeader, fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad source port %q", ErrInvalidHeader, fields[4])
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readV2 reads the binary header: the signature, a version and command
+// byte, an address family byte, the length of what follows, and then the
+// addresses.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(v2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	versionCommand, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("%w: v2 header with version %d", ErrInvalidHeader, versionCommand>>4)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch versionCommand & 0x0f {
+	case 0x0: // LOCAL: the proxy's own connection
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: v2 header with command %d", ErrInvalidHeader, versionCommand&0x0f)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if length < 12 {
+			return nil, fmt.Errorf("%w: short v2 IPv4 addresses", ErrInvalidHeader)
+		}
+		ip := netip.AddrFrom4([4]byte(body[0:4]))
+		port := binary.BigEndian.Uint16(body[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, fmt.Errorf("%w: short v2 IPv6 addresses", ErrInvalidHeader)
+		}
+		ip := netip.AddrFrom16([16]byte(body[0:16]))
+		port := binary.BigEndian.Uint16(body[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+
+	default: // UDP, unix sockets, unspecified: nothing we can use
+		return nil, nil
+	}
+}
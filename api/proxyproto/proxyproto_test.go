@@ -0,0 +1,286 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var trusted = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+// pipeConn is the server's end of a pipe, claiming to come from a TCP peer.
+type pipeConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+// pipeListener accepts a single connection.
+type pipeListener struct {
+	conn net.Conn
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	if l.conn == nil {
+		return nil, net.ErrClosed
+	}
+	conn := l.conn
+	l.conn = nil
+	return conn, nil
+}
+
+func (l *pipeListener) Close() error   { return nil }
+func (l *pipeListener) Addr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080} }
+
+// accept is the server's side of a connection from peer, and the client's.
+func accept(t *testing.T, peer string) (server, client net.Conn) {
+	t.Helper()
+
+	s, c := net.Pipe()
+	t.Cleanup(func() {
+		s.Close()
+		c.Close()
+	})
+
+	remote := net.TCPAddrFromAddrPort(netip.MustParseAddrPort(peer))
+	l := NewListener(&pipeListener{conn: pipeConn{Conn: s, remote: remote}}, trusted)
+
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, c
+}
+
+// send writes data and hangs up, without waiting for the server to read it.
+func send(client net.Conn, data string) {
+	go func() {
+		client.Write([]byte(data))
+		client.Close()
+	}()
+}
+
+func v2(command, family byte, addresses []byte) string {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addresses)))
+	return string(append(header, addresses...))
+}
+
+// withLength gives a v2 header a length that doesn't match what follows.
+func withLength(header string, length uint16) string {
+	b := []byte(header)
+	binary.BigEndian.PutUint16(b[14:], length)
+	return string(b)
+}
+
+func inet() []byte {
+	b := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(b[8:], 56324)
+	binary.BigEndian.PutUint16(b[10:], 443)
+	return b
+}
+
+func inet6() []byte {
+	src := netip.MustParseAddr("2001:db8::1").As16()
+	dst := netip.MustParseAddr("2001:db8::2").As16()
+	b := append(src[:], dst[:]...)
+	b = binary.BigEndian.AppendUint16(b, 56324)
+	return binary.BigEndian.AppendUint16(b, 443)
+}
+
+func TestConn(t *testing.T) {
+	const request = "GET / HTTP/1.1\r\n\r\n"
+
+	tests := []struct {
+		name       string
+		peer       string
+		data       string
+		wantRemote string
+		wantData   string // what the server reads after the header
+		wantErr    bool
+	}{
+		{name: "v1 TCP4", peer: "10.0.0.1:1234",
+			data:       "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" + request,
+			wantRemote: "192.0.2.1:56324", wantData: request},
+		{name: "v1 TCP6", peer: "10.0.0.1:1234",
+			data:       "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n" + request,
+			wantRemote: "[2001:db8::1]:56324", wantData: request},
+		{name: "v1 UNKNOWN", peer: "10.0.0.1:1234",
+			data:       "PROXY UNKNOWN\r\n" + request,
+			wantRemote: "10.0.0.1:1234", wantData: request},
+		{name: "v1 from an IPv4-mapped trusted peer", peer: "[::ffff:10.0.0.1]:1234",
+			data:       "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" + request,
+			wantRemote: "192.0.2.1:56324", wantData: request},
+		{name: "v1 TCP4 with an IPv6 address", peer: "10.0.0.1:1234",
+			data: "PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n" + request, wantErr: true},
+		{name: "v1 bad port", peer: "10.0.0.1:1234",
+			data: "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n" + request, wantErr: true},
+		{name: "v1 bad protocol", peer: "10.0.0.1:1234",
+			data: "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n" + request, wantErr: true},
+		{name: "v1 missing fields", peer: "10.0.0.1:1234",
+			data: "PROXY TCP4 192.0.2.1 198.51.100.1\r\n" + request, wantErr: true},
+		{name: "v1 over 107 bytes", peer: "10.0.0.1:1234",
+			data: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443" + strings.Repeat(" ", 70) + "\r\n" + request, wantErr: true},
+		{name: "v1 without CRLF", peer: "10.0.0.1:1234",
+			data: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n" + request, wantErr: true},
+		{name: "v1 truncated", peer: "10.0.0.1:1234",
+			data: "PROXY TCP4 192.0.2.1", wantErr: true},
+
+		{name: "v2 PROXY INET", peer: "10.0.0.1:1234",
+			data:       v2(0x1, 0x11, inet()) + request,
+			wantRemote: "192.0.2.1:56324", wantData: request},
+		{name: "v2 PROXY INET6", peer: "10.0.0.1:1234",
+			data:       v2(0x1, 0x21, inet6()) + request,
+			wantRemote: "[2001:db8::1]:56324", wantData: request},
+		{name: "v2 PROXY UNSPEC", peer: "10.0.0.1:1234",
+			data:       v2(0x1, 0x00, nil) + request,
+			wantRemote: "10.0.0.1:1234", wantData: request},
+		{name: "v2 PROXY with TLVs after the addresses", peer: "10.0.0.1:1234",
+			data:       v2(0x1, 0x11, append(inet(), 0x04, 0, 1, 0)) + request,
+			wantRemote: "192.0.2.1:56324", wantData: request},
+		{name: "v2 LOCAL INET", peer: "10.0.0.1:1234",
+			data:       v2(0x0, 0x11, inet()) + request,
+			wantRemote: "10.0.0.1:1234", wantData: request},
+		{name: "v2 LOCAL INET6", peer: "10.0.0.1:1234",
+			data:       v2(0x0, 0x21, inet6()) + request,
+			wantRemote: "10.0.0.1:1234", wantData: request},
+		{name: "v2 LOCAL UNSPEC", peer: "10.0.0.1:1234",
+			data:       v2(0x0, 0x00, nil) + request,
+			wantRemote: "10.0.0.1:1234", wantData: request},
+		{name: "v2 version 1", peer: "10.0.0.1:1234",
+			data: strings.Replace(v2(0x1, 0x11, inet()), "\x21", "\x11", 1) + request, wantErr: true},
+		{name: "v2 unknown command", peer: "10.0.0.1:1234",
+			data: v2(0x2, 0x11, inet()) + request, wantErr: true},
+		{name: "v2 INET addresses cut short", peer: "10.0.0.1:1234",
+			data: v2(0x1, 0x11, inet()[:4]) + request, wantErr: true},
+		{name: "v2 INET6 addresses cut short", peer: "10.0.0.1:1234",
+			data: v2(0x1, 0x21, inet6()[:16]) + request, wantErr: true},
+		{name: "v2 length overruns the header", peer: "10.0.0.1:1234",
+			data: withLength(v2(0x1, 0x11, inet()), 100), wantErr: true},
+		{name: "v2 length with nothing after it", peer: "10.0.0.1:1234",
+			data: withLength(v2(0x1, 0x11, nil), 12), wantErr: true},
+		{name: "v2 truncated", peer: "10.0.0.1:1234",
+			data: v2(0x1, 0x11, inet())[:14], wantErr: true},
+
+		// Anything that isn't a header is the start of the request.
+		{name: "no header", peer: "10.0.0.1:1234",
+			data: request, wantRemote: "10.0.0.1:1234", wantData: request},
+		{name: "bad v2 signature", peer: "10.0.0.1:1234",
+			data:       "\r\n\r\n\x00\r\nQUIX\n" + request,
+			wantRemote: "10.0.0.1:1234", wantData: "\r\n\r\n\x00\r\nQUIX\n" + request},
+		{name: "bad v1 prefix", peer: "10.0.0.1:1234",
+			data:       "PROXYTCP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+			wantRemote: "10.0.0.1:1234", wantData: "PROXYTCP4 192.0.2.1 198.51.100.1 56324 443\r\n"},
+		{name: "empty connection", peer: "10.0.0.1:1234",
+			data: "", wantRemote: "10.0.0.1:1234", wantData: ""},
+
+		// Only trusted peers may say where a connection comes from.
+		{name: "untrusted v1", peer: "192.0.2.9:1000",
+			data:       "PROXY TCP4 10.0.0.5 198.51.100.1 56324 443\r\n" + request,
+			wantRemote: "192.0.2.9:1000", wantData: "PROXY TCP4 10.0.0.5 198.51.100.1 56324 443\r\n" + request},
+		{name: "untrusted v2", peer: "[2001:db8::9]:1000",
+			data:       v2(0x1, 0x11, inet()) + request,
+			wantRemote: "[2001:db8::9]:1000", wantData: v2(0x1, 0x11, inet()) + request},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := accept(t, tt.peer)
+			send(client, tt.data)
+
+			data, err := io.ReadAll(conn)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read %q with no error", data)
+				}
+				if !errors.Is(err, ErrInvalidHeader) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("got error %v", err)
+				}
+				if len(data) != 0 {
+					t.Fatalf("read %q after a bad header", data)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := conn.RemoteAddr().String(); got != tt.wantRemote {
+				t.Errorf("RemoteAddr %s, want %s", got, tt.wantRemote)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("read %q, want %q", data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestConnHeaderTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { headerTimeout = timeout }(headerTimeout)
+	headerTimeout = 50 * time.Millisecond
+
+	// A trusted peer that says nothing is cut off rather than waited for.
+	conn, _ := accept(t, "10.0.0.1:1234")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("got error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the header deadline didn't fire")
+	}
+
+	if got := conn.RemoteAddr().String(); got != "10.0.0.1:1234" {
+		t.Errorf("RemoteAddr %s", got)
+	}
+
+	// An untrusted peer's silence is the server's business, not the
+	// listener's.
+	conn, client := accept(t, "192.0.2.9:1000")
+	if got := conn.RemoteAddr().String(); got != "192.0.2.9:1000" {
+		t.Errorf("RemoteAddr %s", got)
+	}
+	send(client, "late")
+	if data, err := io.ReadAll(conn); err != nil || string(data) != "late" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+}
+
+func TestIsTrusted(t *testing.T) {
+	l := NewListener(nil, trusted)
+
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("11.1.2.3"), Port: 1}, false},
+		{&net.TCPAddr{IP: net.ParseIP("fe00::1"), Port: 1}, false},
+		{&net.TCPAddr{}, false},
+		{&net.UnixAddr{Name: "/tmp/socket", Net: "unix"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := l.isTrusted(tt.addr); got != tt.want {
+			t.Errorf("isTrusted(%v) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// All the rules are one object, like each post's subscriptions, and the
+// read-modify-write is likewise only serialised within this process.
+type AddressRuleStore struct {
+	*DB
+	S3BucketName string
+
+	mtx sync.Mutex
+}
+
+func NewAddressRuleStore(db *DB, s3BucketName string) *AddressRuleStore {
+	return &AddressRuleStore{DB: db, S3BucketName: s3BucketName}
+}
+
+const addressRulesKey = "_addresses.json"
+
+// Prefix -> AddressRule
+type addressRules map[string]conduit.AddressRule
+
+func (as *AddressRuleStore) load(ctx context.Context) (addressRules, error) {
+	logger := conduit.GetLogger(ctx)
+
+	resp, err := as.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(as.S3BucketName),
+		Key:    aws.String(addressRulesKey),
+	})
+	if isNoSuchKey(err) {
+		return make(addressRules), nil
+	}
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "GetObject", "key", addressRulesKey)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rules := make(addressRules)
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+		logger.Error("failed json decode", "error", err, "key", addressRulesKey)
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (as *AddressRuleStore) save(ctx context.Context, rules addressRules) error {
+	logger := conduit.GetLogger(ctx)
+
+	jsonBytes, err := json.Marshal(rules)
+	if err != nil {
+		logger.Error("json marshalling failure", "error", err)
+		return err
+	}
+
+	_, err = as.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(as.S3BucketName),
+		Key:    aws.String(addressRulesKey),
+		Body:   bytes.NewReader(jsonBytes),
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "PutObject", "key", addressRulesKey)
+		return err
+	}
+
+	return nil
+}
+
+func (as *AddressRuleStore) AddressRules(ctx context.Context) ([]conduit.AddressRule, error) {
+	as.mtx.Lock()
+	defer as.mtx.Unlock()
+
+	rules, err := as.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]conduit.AddressRule, 0, len(rules))
+	for _, rule := range rules {
+		list = append(list, rule)
+	}
+
+	return list, nil
+}
+
+func (as *AddressRuleStore) PutAddressRule(ctx context.Context, rule conduit.AddressRule) error {
+	as.mtx.Lock()
+	defer as.mtx.Unlock()
+
+	rules, err := as.load(ctx)
+	if err != nil {
+		return err
+	}
+	rules[rule.Prefix] = rule
+
+	return as.save(ctx, rules)
+}
+
+func (as *AddressRuleStore) DeleteAddressRule(ctx context.Context, prefix string) error {
+	as.mtx.Lock()
+	defer as.mtx.Unlock()
+
+	rules, err := as.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := rules[prefix]; !ok {
+		return nil
+	}
+	delete(rules, prefix)
+
+	return as.save(ctx, rules)
+}
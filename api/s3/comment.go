@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -18,17 +19,39 @@ type CommentService struct {
 	*DB
 	S3Region     string
 	S3BucketName string
+
+	// Guards the per-post counters, see counter.go.
+	counterMtx sync.Mutex
 }
 
 func NewCommentService(db *DB, s3Region string, s3BucketName string) *CommentService {
-	return &CommentService{db, s3Region, s3BucketName}
+	return &CommentService{DB: db, S3Region: s3Region, S3BucketName: s3BucketName}
 }
 
-func (cs *CommentService) NrComments(ctx context.Context, filter conduit.CommentFilter) (int, error) {
-	logger := conduit.GetLogger(ctx)
+// Comments are stored at SiteID + PostID + "/" + CommentID. Since PostIDs
+// start with a slash this gives e.g. example.com/2024/01/01/foo/<uuid>.
+func commentKey(c *conduit.Comment) string {
+	return c.SiteID + c.PostID + "/" + c.CommentID // FIXME sanity check the path?
+}
+
+// filterPrefix is the narrowest key prefix that covers every comment that
+// can match the filter. The caller must check SiteID.
+func filterPrefix(filter conduit.CommentFilter) string {
+	prefix := *filter.SiteID
+
+	if filter.PostID != nil {
+		prefix += *filter.PostID
+	}
 
-	var prefix = ""
+	// Messy. When we search with just a SiteID we need to add a trailing slash.
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
 
+	return prefix
+}
+
+func (cs *CommentService) NrComments(ctx context.Context, filter conduit.CommentFilter) (int, error) {
 	if filter.SiteID == nil {
 		return -1, fmt.Errorf("need SiteID for count query")
 	}
@@ -36,36 +59,75 @@ func (cs *CommentService) NrComments(ctx context.Context, filter conduit.Comment
 		return -1, fmt.Errorf("need PostID for count query")
 	}
 
-	prefix += *filter.SiteID
-	prefix += *filter.PostID
-	prefix += "/"
+	if !countable(filter) {
+		comments, err := cs.matchingComments(ctx, filter)
+		if err != nil {
+			return -1, err
+		}
+		return len(comments), nil
+	}
 
-	fmt.Println(*filter.SiteID)
-	fmt.Println(prefix)
-	resp, err := cs.ListObjects(&s3.ListObjectsInput{
-		Bucket: aws.String(cs.S3BucketName),
-		Prefix: aws.String(prefix),
-	})
+	cs.counterMtx.Lock()
+	defer cs.counterMtx.Unlock()
+
+	pc, err := cs.loadCounter(ctx, *filter.SiteID, *filter.PostID)
 	if err != nil {
-		logger.Error("failed S3", "error", err, "action", "ListObjects", "prefix", prefix)
 		return -1, err
 	}
 
-	return len(resp.Contents) - 1, nil
+	return pc.count(filter), nil
+}
+
+func (cs *CommentService) NrCommentsByPost(ctx context.Context, filter conduit.CommentFilter, postIDs []string) (map[string]int, error) {
+	if filter.SiteID == nil {
+		return nil, fmt.Errorf("need SiteID for count query")
+	}
+
+	counts := make(map[string]int)
+	for _, postID := range postIDs {
+		postID := postID
+		filter.PostID = &postID
+
+		nr, err := cs.NrComments(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		counts[postID] = nr
+	}
+
+	return counts, nil
 }
 
 func (cs *CommentService) UpsertComment(ctx context.Context, c *conduit.Comment) error {
 	logger := conduit.GetLogger(ctx)
 
-	objectKey := c.SiteID + c.PostID + "/" + c.CommentID // FIXME sanity check the path?
+	objectKey := commentKey(c)
+
+	stored := *c
+	stored.ResolveStatus()
 
-	jsonBytes, err := json.Marshal(c)
+	jsonBytes, err := json.Marshal(stored)
 	if err != nil {
 		logger.Error("json marshalling failure", "error", err)
 		return err
 	}
 
-	_, err = cs.PutObject(&s3.PutObjectInput{
+	cs.counterMtx.Lock()
+	defer cs.counterMtx.Unlock()
+
+	// Load the counter first: if it has to be rebuilt, that must happen
+	// before this comment is written or it would be counted twice.
+	pc, err := cs.loadCounter(ctx, stored.SiteID, stored.PostID)
+	if err != nil {
+		return err
+	}
+
+	previous, err := cs.getComment(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = cs.PutObjectWithContext(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(cs.S3BucketName),
 		Key:    aws.String(objectKey),
 		Body:   bytes.NewReader(jsonBytes),
@@ -75,66 +137,81 @@ func (cs *CommentService) UpsertComment(ctx context.Context, c *conduit.Comment)
 		return err
 	}
 
-	return nil
-}
-
-func (cs *CommentService) Comments(ctx context.Context, commentFilter conduit.CommentFilter) ([]conduit.Comment, error) {
-	logger := conduit.GetLogger(ctx)
+	if previous != nil {
+		pc.Statuses[previous.Status]--
+	}
+	pc.Statuses[stored.Status]++
 
-	var empty []conduit.Comment
-	var comments []conduit.Comment
+	return cs.saveCounter(ctx, stored.SiteID, stored.PostID, pc)
+}
 
-	var prefix = ""
+// listKeys pages through every object under the prefix; a single
+// ListObjects call stops at 1000 keys.
+func (cs *CommentService) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
 
-	// TODO this isn't consistent, need to have SiteID or SiteID+PostID
+	err := cs.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(cs.S3BucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+		return true
+	})
 
-	if commentFilter.SiteID != nil && *commentFilter.SiteID != "" {
-		prefix += *commentFilter.SiteID
-	}
+	return keys, err
+}
 
-	if commentFilter.PostID != nil && *commentFilter.PostID != "" {
-		prefix += *commentFilter.PostID
-	}
+// matchingComments fetches every comment that matches the filter. Keys are
+// not in Timestamp order, so there is no way to only fetch one page.
+func (cs *CommentService) matchingComments(ctx context.Context, commentFilter conduit.CommentFilter) ([]conduit.Comment, error) {
+	logger := conduit.GetLogger(ctx)
 
-	// Messy. When we search with just a SiteID we need to add a trailing slash.
-	if !strings.HasSuffix(prefix, "/") {
-		prefix += "/"
-	}
+	comments := make([]conduit.Comment, 0)
 
-	onlyActive := commentFilter.IsActive != nil && *commentFilter.IsActive
+	prefix := filterPrefix(commentFilter)
 
-	resp, err := cs.ListObjects(&s3.ListObjectsInput{
-		Bucket: aws.String(cs.S3BucketName),
-		Prefix: aws.String(prefix),
-	})
+	keys, err := cs.listKeys(ctx, prefix)
 	if err != nil {
-		logger.Error("failed S3", "error", err, "action", "ListObjects", "prefix", prefix)
-		return empty, err
+		logger.Error("failed S3", "error", err, "action", "ListObjectsV2", "prefix", prefix)
+		return nil, err
 	}
 
-	for _, object := range resp.Contents {
-		if *object.Key == prefix {
+	for _, key := range keys {
+		// Skip the folder marker that the console creates.
+		if key == prefix {
 			continue
 		}
-		getResp, err := cs.GetObject(&s3.GetObjectInput{
+
+		// Don't fetch objects that can't be the comment we are after.
+		if commentFilter.CommentID != nil && !strings.HasSuffix(key, "/"+*commentFilter.CommentID) {
+			continue
+		}
+
+		getResp, err := cs.GetObjectWithContext(ctx, &s3.GetObjectInput{
 			Bucket: aws.String(cs.S3BucketName),
-			Key:    object.Key,
+			Key:    aws.String(key),
 		})
 		if err != nil {
-			logger.Error("failed S3", "error", err, "action", "GetObject", "key", *object.Key)
-			return empty, err
+			logger.Error("failed S3", "error", err, "action", "GetObject", "key", key)
+			return nil, err
 		}
 
 		var comment conduit.Comment
 		err = json.NewDecoder(getResp.Body).Decode(&comment)
+		getResp.Body.Close()
 		if err != nil && err == io.EOF {
 			continue
 		} else if err != nil {
-			logger.Error("failed json decode", "error", err, "key", *object.Key)
-			return empty, err
+			logger.Error("failed json decode", "error", err, "key", key)
+			return nil, err
 		}
 
-		if !onlyActive || (onlyActive && comment.IsActive) {
+		// Objects written before Status existed.
+		comment.ResolveStatus()
+
+		if commentFilter.Matches(comment) {
 			comments = append(comments, comment)
 		}
 	}
@@ -142,12 +219,61 @@ func (cs *CommentService) Comments(ctx context.Context, commentFilter conduit.Co
 	return comments, nil
 }
 
+// Comments reads every matching comment and pages in memory. This is a known
+// limitation of this backend: a page of a whole-site listing costs a
+// ListObjects call per 1000 comments on the site and a GetObject for each of
+// them, however small the page. Listings of one post are bounded by
+// MaxNrComments. Sites that outgrow this should move to DynamoDB, which
+// pages on an index.
+func (cs *CommentService) Comments(ctx context.Context, commentFilter conduit.CommentFilter, page conduit.PageRequest) (conduit.CommentPage, error) {
+	if commentFilter.SiteID == nil {
+		return conduit.CommentPage{}, fmt.Errorf("need SiteID for Comment query")
+	}
+
+	comments, err := cs.matchingComments(ctx, commentFilter)
+	if err != nil {
+		return conduit.CommentPage{}, err
+	}
+
+	return page.Paginate(comments)
+}
+
 func (cs *CommentService) DeleteComment(ctx context.Context, comment *conduit.Comment) error {
 	logger := conduit.GetLogger(ctx)
 
-	key := comment.SiteID + comment.PostID + "/" + comment.CommentID
+	// The key includes the PostID, so find it if the caller only knows the
+	// SiteID and CommentID.
+	if comment.PostID == "" {
+		found, err := cs.matchingComments(ctx, conduit.CommentFilter{SiteID: &comment.SiteID, CommentID: &comment.CommentID})
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			return nil
+		}
+		comment = &found[0]
+	}
+
+	key := commentKey(comment)
 
-	_, err := cs.DeleteObject(&s3.DeleteObjectInput{
+	cs.counterMtx.Lock()
+	defer cs.counterMtx.Unlock()
+
+	pc, err := cs.loadCounter(ctx, comment.SiteID, comment.PostID)
+	if err != nil {
+		return err
+	}
+
+	// The stored copy has the status that was counted.
+	previous, err := cs.getComment(ctx, key)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return nil
+	}
+
+	_, err = cs.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(cs.S3BucketName),
 		Key:    aws.String(key),
 	})
@@ -157,5 +283,7 @@ func (cs *CommentService) DeleteComment(ctx context.Context, comment *conduit.Co
 		return err
 	}
 
-	return nil
+	pc.Statuses[previous.Status]--
+
+	return cs.saveCounter(ctx, comment.SiteID, comment.PostID, pc)
 }
@@ -0,0 +1,52 @@
+package s3_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/s3"
+)
+
+// TestConduit needs a local S3 stand-in such as minio at S3_ENDPOINT. The
+// bucket, S3_BUCKET or "conduittest", is created if it isn't there.
+func TestConduit(t *testing.T) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_ENDPOINT is not set")
+	}
+
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		bucket = "conduittest"
+	}
+
+	db, err := s3.Open(conduittest.Context(), config.Config{S3Region: region, S3Endpoint: endpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String(bucket)})
+	var aerr awserr.Error
+	if err != nil && !(errors.As(err, &aerr) && (aerr.Code() == awss3.ErrCodeBucketAlreadyOwnedByYou || aerr.Code() == awss3.ErrCodeBucketAlreadyExists)) {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+
+	t.Run("CommentService", func(t *testing.T) { conduittest.TestCommentService(t, s3.NewCommentService(db, region, bucket)) })
+	t.Run("PostRegistry", func(t *testing.T) { conduittest.TestPostRegistry(t, s3.NewPostRegistry(db, bucket)) })
+	t.Run("Outbox", func(t *testing.T) { conduittest.TestOutbox(t, s3.NewOutbox(db, bucket)) })
+	t.Run("UsedTokens", func(t *testing.T) { conduittest.TestUsedTokens(t, s3.NewUsedTokens(db, bucket)) })
+	t.Run("SubscriptionStore", func(t *testing.T) { conduittest.TestSubscriptionStore(t, s3.NewSubscriptionStore(db, bucket)) })
+	t.Run("SpamModelStore", func(t *testing.T) { conduittest.TestSpamModelStore(t, s3.NewSpamModelStore(db, bucket)) })
+	t.Run("AddressRuleStore", func(t *testing.T) { conduittest.TestAddressRuleStore(t, s3.NewAddressRuleStore(db, bucket)) })
+}
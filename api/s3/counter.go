@@ -0,0 +1,154 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// Counting by listing every key on a post gets slower as the post gathers
+// comments, and createComment counts on every submission. So each post has a
+// small object with the number of comments in each status, kept up to date by
+// UpsertComment and DeleteComment.
+//
+// The read-modify-write is only serialised within this process, which is
+// fine for the single replica we run. A missing counter is rebuilt from the
+// comments themselves, so deleting counters is a safe way to repair them.
+type postCounter struct {
+	Statuses map[conduit.ModerationStatus]int `json:"statuses"`
+}
+
+// Counters live outside the SiteID/ prefixes so that listings never see them.
+const counterPrefix = "_counters/"
+
+func counterKey(siteID, postID string) string {
+	return counterPrefix + siteID + postID + ".json"
+}
+
+func isNoSuchKey(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey
+}
+
+func (pc postCounter) count(filter conduit.CommentFilter) int {
+	nr := 0
+	for status, n := range pc.Statuses {
+		c := conduit.Comment{Status: status, IsActive: status == conduit.StatusApproved}
+		if filter.Status != nil && c.Status != *filter.Status {
+			continue
+		}
+		if filter.IsActive != nil && c.IsActive != *filter.IsActive {
+			continue
+		}
+		nr += n
+	}
+	return nr
+}
+
+// countable is true when the counter has everything needed for the filter.
+func countable(filter conduit.CommentFilter) bool {
+	return filter.CommentID == nil && filter.ParentID == nil
+}
+
+// loadCounter must be called with counterMtx held.
+func (cs *CommentService) loadCounter(ctx context.Context, siteID, postID string) (postCounter, error) {
+	logger := conduit.GetLogger(ctx)
+
+	key := counterKey(siteID, postID)
+
+	resp, err := cs.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cs.S3BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNoSuchKey(err) {
+		logger.Error("failed S3", "error", err, "action", "GetObject", "key", key)
+		return postCounter{}, err
+	}
+
+	if err == nil {
+		defer resp.Body.Close()
+
+		var pc postCounter
+		if err := json.NewDecoder(resp.Body).Decode(&pc); err == nil {
+			if pc.Statuses == nil {
+				pc.Statuses = make(map[conduit.ModerationStatus]int)
+			}
+			return pc, nil
+		}
+		logger.Warn("rebuilding unreadable comment counter", "key", key)
+	}
+
+	comments, err := cs.matchingComments(ctx, conduit.CommentFilter{SiteID: &siteID, PostID: &postID})
+	if err != nil {
+		return postCounter{}, err
+	}
+
+	pc := postCounter{Statuses: make(map[conduit.ModerationStatus]int)}
+	for _, c := range comments {
+		pc.Statuses[c.Status]++
+	}
+
+	if err := cs.saveCounter(ctx, siteID, postID, pc); err != nil {
+		return postCounter{}, err
+	}
+
+	return pc, nil
+}
+
+func (cs *CommentService) saveCounter(ctx context.Context, siteID, postID string, pc postCounter) error {
+	logger := conduit.GetLogger(ctx)
+
+	key := counterKey(siteID, postID)
+
+	jsonBytes, err := json.Marshal(pc)
+	if err != nil {
+		logger.Error("json marshalling failure", "error", err)
+		return err
+	}
+
+	_, err = cs.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(cs.S3BucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(jsonBytes),
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "PutObject", "key", key)
+		return err
+	}
+
+	return nil
+}
+
+// getComment reads one comment object, or returns nil if there isn't one.
+func (cs *CommentService) getComment(ctx context.Context, key string) (*conduit.Comment, error) {
+	logger := conduit.GetLogger(ctx)
+
+	resp, err := cs.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cs.S3BucketName),
+		Key:    aws.String(key),
+	})
+	if isNoSuchKey(err) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "GetObject", "key", key)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var comment conduit.Comment
+	if err := json.NewDecoder(resp.Body).Decode(&comment); err != nil {
+		logger.Error("failed json decode", "error", err, "key", key)
+		return nil, err
+	}
+	comment.ResolveStatus()
+
+	return &comment, nil
+}
@@ -2,7 +2,6 @@ package s3
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -19,21 +18,26 @@ type DB struct {
 func Open(ctx context.Context, cfg config.Config) (*DB, error) {
 	logger := conduit.GetLogger(ctx)
 
-	sess, err := session.NewSession(&aws.Config{
+	awsCfg := &aws.Config{
 		Region: aws.String(cfg.S3Region),
-	})
+	}
+
+	// A local S3 stand-in (minio, localstack, ...) for development and tests.
+	if cfg.S3Endpoint != "" {
+		awsCfg.Endpoint = aws.String(cfg.S3Endpoint)
+		awsCfg.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	sess, err := session.NewSession(awsCfg)
 	if err != nil {
 		logger.Error("failed to create AWS session", "error", err)
 		return nil, err
 	}
 
-	awsCfg := sess.Config
-	creds, err := awsCfg.Credentials.Get()
-	if err != nil {
+	if _, err := sess.Config.Credentials.Get(); err != nil {
 		// Authentication failed or credentials not found
-		fmt.Println("Authentication failed:", err)
+		logger.Warn("no AWS credentials found", "error", err)
 	}
-	fmt.Println(creds)
 
 	svc := s3.New(sess)
 
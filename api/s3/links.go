@@ -0,0 +1,19 @@
+package s3
+
+import (
+	"net/url"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+func (cs *CommentService) CommentLink(c *conduit.Comment) string {
+	return "https://s3.console.aws.amazon.com/s3/object/" + cs.S3BucketName +
+		"?region=" + cs.S3Region + "&prefix=" + url.QueryEscape(commentKey(c))
+}
+
+// PendingLink can only list the site's comments; S3 has no way to filter on
+// what is inside an object.
+func (cs *CommentService) PendingLink(siteID string) string {
+	return "https://s3.console.aws.amazon.com/s3/buckets/" + cs.S3BucketName +
+		"?region=" + cs.S3Region + "&prefix=" + url.QueryEscape(siteID+"/")
+}
@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// One object per entry. The outbox is expected to be nearly empty, so
+// listing everything and filtering here is fine.
+type Outbox struct {
+	*DB
+	S3BucketName string
+}
+
+func NewOutbox(db *DB, s3BucketName string) *Outbox {
+	return &Outbox{db, s3BucketName}
+}
+
+const outboxPrefix = "_outbox/"
+
+func outboxKey(entryID string) string {
+	return outboxPrefix + entryID + ".json"
+}
+
+func (o *Outbox) PutOutboxEntry(ctx context.Context, entry conduit.OutboxEntry) error {
+	logger := conduit.GetLogger(ctx)
+
+	key := outboxKey(entry.EntryID)
+
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("json marshalling failure", "error", err)
+		return err
+	}
+
+	_, err = o.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(o.S3BucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(jsonBytes),
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "PutObject", "key", key)
+		return err
+	}
+
+	return nil
+}
+
+func (o *Outbox) OutboxEntries(ctx context.Context, filter conduit.OutboxFilter) ([]conduit.OutboxEntry, error) {
+	logger := conduit.GetLogger(ctx)
+
+	var keys []string
+
+	err := o.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(o.S3BucketName),
+		Prefix: aws.String(outboxPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+		return true
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "ListObjectsV2", "prefix", outboxPrefix)
+		return nil, err
+	}
+
+	entries := make([]conduit.OutboxEntry, 0)
+
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		if filter.EntryID != nil && key != outboxKey(*filter.EntryID) {
+			continue
+		}
+
+		resp, err := o.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(o.S3BucketName),
+			Key:    aws.String(key),
+		})
+		if isNoSuchKey(err) {
+			// Delivered since we listed it.
+			continue
+		}
+		if err != nil {
+			logger.Error("failed S3", "error", err, "action", "GetObject", "key", key)
+			return nil, err
+		}
+
+		var entry conduit.OutboxEntry
+		err = json.NewDecoder(resp.Body).Decode(&entry)
+		resp.Body.Close()
+		if err != nil {
+			logger.Error("failed json decode", "error", err, "key", key)
+			return nil, err
+		}
+
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	conduit.SortOutboxEntries(entries)
+
+	return entries, nil
+}
+
+func (o *Outbox) DeleteOutboxEntry(ctx context.Context, entryID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	key := outboxKey(entryID)
+
+	_, err := o.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(o.S3BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "DeleteObject", "key", key)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// Each site's known posts are one object, so that loading them at startup is
+// a single GET rather than one per post. As with the counters, the
+// read-modify-write is only serialised within this process.
+type PostRegistry struct {
+	*DB
+	S3BucketName string
+
+	mtx sync.Mutex
+}
+
+func NewPostRegistry(db *DB, s3BucketName string) *PostRegistry {
+	return &PostRegistry{DB: db, S3BucketName: s3BucketName}
+}
+
+// Like the counters, known posts live outside the SiteID/ prefixes.
+const postsPrefix = "_posts/"
+
+func postsKey(siteID string) string {
+	return postsPrefix + siteID + ".json"
+}
+
+// PostID -> KnownPost
+type sitePosts map[string]conduit.KnownPost
+
+func (pr *PostRegistry) load(ctx context.Context, siteID string) (sitePosts, error) {
+	logger := conduit.GetLogger(ctx)
+
+	key := postsKey(siteID)
+
+	resp, err := pr.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(pr.S3BucketName),
+		Key:    aws.String(key),
+	})
+	if isNoSuchKey(err) {
+		return make(sitePosts), nil
+	}
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "GetObject", "key", key)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	posts := make(sitePosts)
+	if err := json.NewDecoder(resp.Body).Decode(&posts); err != nil {
+		logger.Error("failed json decode", "error", err, "key", key)
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+func (pr *PostRegistry) save(ctx context.Context, siteID string, posts sitePosts) error {
+	logger := conduit.GetLogger(ctx)
+
+	key := postsKey(siteID)
+
+	jsonBytes, err := json.Marshal(posts)
+	if err != nil {
+		logger.Error("json marshalling failure", "error", err)
+		return err
+	}
+
+	_, err = pr.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(pr.S3BucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(jsonBytes),
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "PutObject", "key", key)
+		return err
+	}
+
+	return nil
+}
+
+func (pr *PostRegistry) KnownPosts(ctx context.Context, siteID string) ([]conduit.KnownPost, error) {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+
+	posts, err := pr.load(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make([]conduit.KnownPost, 0, len(posts))
+	for _, p := range posts {
+		known = append(known, p)
+	}
+
+	return known, nil
+}
+
+func (pr *PostRegistry) AddKnownPost(ctx context.Context, post conduit.KnownPost) (bool, error) {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+
+	posts, err := pr.load(ctx, post.SiteID)
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := posts[post.PostID]; ok {
+		return false, nil
+	}
+	posts[post.PostID] = post
+
+	if err := pr.save(ctx, post.SiteID, posts); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (pr *PostRegistry) RemoveKnownPost(ctx context.Context, siteID string, postID string) error {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+
+	posts, err := pr.load(ctx, siteID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := posts[postID]; !ok {
+		return nil
+	}
+	delete(posts, postID)
+
+	return pr.save(ctx, siteID, posts)
+}
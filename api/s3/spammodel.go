@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// SpamModelStore keeps each site's model as one object.
+type SpamModelStore struct {
+	*DB
+	S3BucketName string
+}
+
+func NewSpamModelStore(db *DB, s3BucketName string) *SpamModelStore {
+	return &SpamModelStore{DB: db, S3BucketName: s3BucketName}
+}
+
+const spamModelsPrefix = "_spam/"
+
+func spamModelKey(siteID string) string {
+	return spamModelsPrefix + siteID + ".json"
+}
+
+func (ms *SpamModelStore) SpamModel(ctx context.Context, siteID string) (conduit.SpamModel, bool, error) {
+	logger := conduit.GetLogger(ctx)
+
+	key := spamModelKey(siteID)
+
+	resp, err := ms.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ms.S3BucketName),
+		Key:    aws.String(key),
+	})
+	if isNoSuchKey(err) {
+		return conduit.SpamModel{}, false, nil
+	}
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "GetObject", "key", key)
+		return conduit.SpamModel{}, false, err
+	}
+	defer resp.Body.Close()
+
+	var model conduit.SpamModel
+	if err := json.NewDecoder(resp.Body).Decode(&model); err != nil {
+		logger.Error("failed json decode", "error", err, "key", key)
+		return conduit.SpamModel{}, false, err
+	}
+
+	return model, true, nil
+}
+
+func (ms *SpamModelStore) PutSpamModel(ctx context.Context, model conduit.SpamModel) error {
+	logger := conduit.GetLogger(ctx)
+
+	key := spamModelKey(model.SiteID)
+
+	jsonBytes, err := json.Marshal(model)
+	if err != nil {
+		logger.Error("json marshalling failure", "error", err)
+		return err
+	}
+
+	_, err = ms.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ms.S3BucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(jsonBytes),
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "PutObject", "key", key)
+		return err
+	}
+
+	return nil
+}
+
+func (ms *SpamModelStore) DeleteSpamModel(ctx context.Context, siteID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	key := spamModelKey(siteID)
+
+	_, err := ms.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(ms.S3BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "DeleteObject", "key", key)
+		return err
+	}
+
+	return nil
+}
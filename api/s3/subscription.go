@@ -0,0 +1,133 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// Each post's subscriptions are one object, like the known posts, and the
+// read-modify-write is likewise only serialised within this process.
+type SubscriptionStore struct {
+	*DB
+	S3BucketName string
+
+	mtx sync.Mutex
+}
+
+func NewSubscriptionStore(db *DB, s3BucketName string) *SubscriptionStore {
+	return &SubscriptionStore{DB: db, S3BucketName: s3BucketName}
+}
+
+const subscriptionsPrefix = "_subscriptions/"
+
+func subscriptionsKey(siteID, postID string) string {
+	return subscriptionsPrefix + siteID + postID + ".json"
+}
+
+// SubscriptionID -> Subscription
+type postSubscriptions map[string]conduit.Subscription
+
+func (ss *SubscriptionStore) load(ctx context.Context, siteID, postID string) (postSubscriptions, error) {
+	logger := conduit.GetLogger(ctx)
+
+	key := subscriptionsKey(siteID, postID)
+
+	resp, err := ss.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ss.S3BucketName),
+		Key:    aws.String(key),
+	})
+	if isNoSuchKey(err) {
+		return make(postSubscriptions), nil
+	}
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "GetObject", "key", key)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	subs := make(postSubscriptions)
+	if err := json.NewDecoder(resp.Body).Decode(&subs); err != nil {
+		logger.Error("failed json decode", "error", err, "key", key)
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+func (ss *SubscriptionStore) save(ctx context.Context, siteID, postID string, subs postSubscriptions) error {
+	logger := conduit.GetLogger(ctx)
+
+	key := subscriptionsKey(siteID, postID)
+
+	jsonBytes, err := json.Marshal(subs)
+	if err != nil {
+		logger.Error("json marshalling failure", "error", err)
+		return err
+	}
+
+	_, err = ss.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ss.S3BucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(jsonBytes),
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "PutObject", "key", key)
+		return err
+	}
+
+	return nil
+}
+
+func (ss *SubscriptionStore) PostSubscriptions(ctx context.Context, siteID, postID string) ([]conduit.Subscription, error) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	subs, err := ss.load(ctx, siteID, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]conduit.Subscription, 0, len(subs))
+	for _, sub := range subs {
+		list = append(list, sub)
+	}
+
+	return list, nil
+}
+
+func (ss *SubscriptionStore) PutSubscription(ctx context.Context, sub conduit.Subscription) error {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	subs, err := ss.load(ctx, sub.SiteID, sub.PostID)
+	if err != nil {
+		return err
+	}
+	subs[sub.SubscriptionID] = sub
+
+	return ss.save(ctx, sub.SiteID, sub.PostID, subs)
+}
+
+func (ss *SubscriptionStore) DeleteSubscription(ctx context.Context, siteID, postID, subscriptionID string) error {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	subs, err := ss.load(ctx, siteID, postID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := subs[subscriptionID]; !ok {
+		return nil
+	}
+	delete(subs, subscriptionID)
+
+	return ss.save(ctx, siteID, postID, subs)
+}
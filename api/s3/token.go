@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// Used tokens are empty objects. As with the counters, the check and the
+// write are only serialised within this process. Expired tokens are left
+// for a bucket lifecycle rule on the prefix to clean up.
+type UsedTokens struct {
+	*DB
+	S3BucketName string
+
+	mtx sync.Mutex
+}
+
+func NewUsedTokens(db *DB, s3BucketName string) *UsedTokens {
+	return &UsedTokens{DB: db, S3BucketName: s3BucketName}
+}
+
+const tokensPrefix = "_tokens/"
+
+// isNotFound is isNoSuchKey for HeadObject, which has no body to carry the
+// error code.
+func isNotFound(err error) bool {
+	var rerr awserr.RequestFailure
+	return errors.As(err, &rerr) && rerr.StatusCode() == http.StatusNotFound
+}
+
+func (ut *UsedTokens) UseToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	logger := conduit.GetLogger(ctx)
+
+	ut.mtx.Lock()
+	defer ut.mtx.Unlock()
+
+	key := tokensPrefix + tokenID
+
+	_, err := ut.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(ut.S3BucketName),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return false, nil
+	}
+	if !isNotFound(err) {
+		logger.Error("failed S3", "error", err, "action", "HeadObject", "key", key)
+		return false, err
+	}
+
+	_, err = ut.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ut.S3BucketName),
+		Key:    aws.String(key),
+		Metadata: map[string]*string{
+			"expires-at": aws.String(strconv.FormatInt(expiresAt.Unix(), 10)),
+		},
+	})
+	if err != nil {
+		logger.Error("failed S3", "error", err, "action", "PutObject", "key", key)
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,385 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+
+	"github.com/google/uuid"
+)
+
+// How stale the cached address rules may be. Changes made through this
+// server apply at once; other servers sharing the store see them this much
+// later.
+const addressRulesTTL = time.Minute
+
+// A ban of an IPv6 commenter covers their /64, which is what the rate limits
+// treat as one client; see clientKey.
+const bannedIPv6Bits = 64
+
+// addressRules caches the rules in the store, since every submission is
+// checked against them.
+type addressRules struct {
+	store conduit.AddressRuleStore
+
+	mtx      sync.Mutex
+	rules    []conduit.AddressRule
+	prefixes []netip.Prefix
+	loadedAt time.Time
+}
+
+func newAddressRules(store conduit.AddressRuleStore) *addressRules {
+	return &addressRules{store: store}
+}
+
+// load must be called with mtx held. Expired rules are deleted from the store
+// as they are found, since only the DynamoDB backend forgets them by itself.
+func (ar *addressRules) load(ctx context.Context, now time.Time) error {
+	logger := conduit.GetLogger(ctx)
+
+	if !ar.loadedAt.IsZero() && now.Sub(ar.loadedAt) < addressRulesTTL {
+		return nil
+	}
+
+	all, err := ar.store.AddressRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	ar.rules, ar.prefixes = nil, nil
+	for _, rule := range all {
+		if rule.Expired(now) {
+			if err := ar.store.DeleteAddressRule(ctx, rule.Prefix); err != nil {
+				logger.Warn("failed to delete expired address rule", "prefix", rule.Prefix, "error", err)
+			}
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(rule.Prefix)
+		if err != nil {
+			logger.Error("ignoring address rule with a bad prefix", "prefix", rule.Prefix, "error", err)
+			continue
+		}
+
+		ar.rules = append(ar.rules, rule)
+		ar.prefixes = append(ar.prefixes, prefix)
+	}
+	ar.loadedAt = now
+
+	return nil
+}
+
+// invalidate makes the next lookup read the store again.
+func (ar *addressRules) invalidate() {
+	ar.mtx.Lock()
+	defer ar.mtx.Unlock()
+
+	ar.loadedAt = time.Time{}
+}
+
+// match finds the most specific rule that covers an address.
+func (ar *addressRules) match(ctx context.Context, addr netip.Addr, now time.Time) (conduit.AddressRule, bool, error) {
+	ar.mtx.Lock()
+	defer ar.mtx.Unlock()
+
+	if err := ar.load(ctx, now); err != nil {
+		return conduit.AddressRule{}, false, err
+	}
+
+	best := -1
+	for i, prefix := range ar.prefixes {
+		if ar.rules[i].Expired(now) || !prefix.Contains(addr) {
+			continue
+		}
+		if best < 0 || prefix.Bits() > ar.prefixes[best].Bits() {
+			best = i
+		}
+	}
+	if best < 0 {
+		return conduit.AddressRule{}, false, nil
+	}
+	return ar.rules[best], true, nil
+}
+
+// list returns the rules that haven't expired, newest first.
+func (ar *addressRules) list(ctx context.Context, now time.Time) ([]conduit.AddressRule, error) {
+	ar.mtx.Lock()
+	defer ar.mtx.Unlock()
+
+	ar.loadedAt = time.Time{}
+	if err := ar.load(ctx, now); err != nil {
+		return nil, err
+	}
+
+	rules := make([]conduit.AddressRule, len(ar.rules))
+	copy(rules, ar.rules)
+	sort.Slice(rules, func(i, j int) bool {
+		ti, tj := time.Time(rules[i].CreatedAt), time.Time(rules[j].CreatedAt)
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return rules[i].Prefix < rules[j].Prefix
+	})
+
+	return rules, nil
+}
+
+// addressBlocked reports the rule that blocks a client, if there is one.
+// Like the spam checks, it lets the client through if the rules can't be
+// read.
+func (s *Server) addressBlocked(ctx context.Context, ip string) (conduit.AddressRule, bool) {
+	logger := conduit.GetLogger(ctx)
+
+	addr, ok := parseHop(ip)
+	if !ok {
+		return conduit.AddressRule{}, false
+	}
+
+	rule, found, err := s.addressRules.match(ctx, addr, time.Now())
+	if err != nil {
+		logger.Error("failed to read address rules", "error", err)
+		return conduit.AddressRule{}, false
+	}
+
+	return rule, found && rule.List == conduit.AddressBlock
+}
+
+// parsePrefix reads a CIDR or a single address.
+func parsePrefix(value string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Masked(), nil
+	}
+	if addr, ok := parseHop(value); ok {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.Prefix{}, fmt.Errorf("bad address or CIDR %q", value)
+}
+
+// expiry reads an optional duration such as "720h"; empty means never.
+func expiry(expiresIn string, now time.Time) (*conduit.Timestamp, error) {
+	if expiresIn == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(expiresIn)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("bad expiresIn %q", expiresIn)
+	}
+	at := conduit.Timestamp(now.Add(d))
+	return &at, nil
+}
+
+func (s *Server) putAddressRule(ctx context.Context, rule conduit.AddressRule) error {
+	defer s.addressRules.invalidate()
+	return s.addressRules.store.PutAddressRule(ctx, rule)
+}
+
+func (s *Server) listAddressRules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "listAddressRules")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		rules, err := s.addressRules.list(ctx, time.Now())
+		if err != nil {
+			logger.Error("failed to list address rules", "error", err)
+			// TODO add to conduit/errors.go
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(ctx, w, http.StatusOK, M{"rules": rules})
+	}
+}
+
+func (s *Server) addAddressRule() http.HandlerFunc {
+	type Input struct {
+		Prefix    string              `json:"prefix"`
+		List      conduit.AddressList `json:"list"`
+		Reason    string              `json:"reason"`
+		ExpiresIn string              `json:"expiresIn"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "addAddressRule")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		prefix, err := parsePrefix(input.Prefix)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if input.List != conduit.AddressBlock && input.List != conduit.AddressAllow {
+			// TODO add to conduit/errors.go
+			http.Error(w, "list must be block or allow", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		expiresAt, err := expiry(input.ExpiresIn, now)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rule := conduit.AddressRule{
+			Prefix:    prefix.String(),
+			List:      input.List,
+			Reason:    input.Reason,
+			CreatedBy: contextUser(r),
+			CreatedAt: conduit.Timestamp(now),
+			ExpiresAt: expiresAt,
+		}
+
+		if err := s.putAddressRule(ctx, rule); err != nil {
+			logger.Error("failed to put address rule", "error", err)
+			// TODO add to conduit/errors.go
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("added address rule", "prefix", rule.Prefix, "list", rule.List, "reason", rule.Reason, "by", rule.CreatedBy)
+
+		writeJSON(ctx, w, http.StatusCreated, rule)
+	}
+}
+
+func (s *Server) removeAddressRule() http.HandlerFunc {
+	type Input struct {
+		Prefix string `json:"prefix"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "removeAddressRule")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		prefix, err := parsePrefix(input.Prefix)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		defer s.addressRules.invalidate()
+		if err := s.addressRules.store.DeleteAddressRule(ctx, prefix.String()); err != nil {
+			logger.Error("failed to delete address rule", "error", err)
+			// TODO add to conduit/errors.go
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("removed address rule", "prefix", prefix.String(), "by", contextUser(r))
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// banCommenter blocks the address a comment was sent from. The comment
+// itself is left alone; moderate it as spam separately if it is.
+func (s *Server) banCommenter() http.HandlerFunc {
+	type Input struct {
+		SiteID    string `json:"siteID"`
+		CommentID string `json:"commentID"`
+		Reason    string `json:"reason"`
+		ExpiresIn string `json:"expiresIn"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "banCommenter")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if input.SiteID == "" || input.CommentID == "" {
+			// TODO add to conduit/errors.go
+			http.Error(w, "need siteID and commentID", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		expiresAt, err := expiry(input.ExpiresIn, now)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &input.SiteID, CommentID: &input.CommentID}, conduit.PageRequest{})
+		if err != nil {
+			logger.Error("failed to look up comment", "error", err)
+			// TODO add to conduit/errors.go
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if len(found.Comments) == 0 {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Unknown comment", http.StatusNotFound)
+			return
+		}
+		comment := found.Comments[0]
+
+		addr, ok := parseHop(comment.SourceAddress)
+		if !ok {
+			logger.Error("comment has no usable source address", "comment_id", comment.CommentID, "source_address", comment.SourceAddress)
+			// TODO add to conduit/errors.go
+			http.Error(w, "Comment has no source address", http.StatusUnprocessableEntity)
+			return
+		}
+
+		prefix := netip.PrefixFrom(addr, addr.BitLen())
+		if addr.Is6() {
+			prefix, _ = addr.Prefix(bannedIPv6Bits)
+		}
+
+		reason := input.Reason
+		if reason == "" {
+			reason = "banned for comment " + comment.CommentID
+		}
+
+		rule := conduit.AddressRule{
+			Prefix:    prefix.String(),
+			List:      conduit.AddressBlock,
+			Reason:    reason,
+			CreatedBy: contextUser(r),
+			CreatedAt: conduit.Timestamp(now),
+			ExpiresAt: expiresAt,
+		}
+
+		if err := s.putAddressRule(ctx, rule); err != nil {
+			logger.Error("failed to put address rule", "error", err)
+			// TODO add to conduit/errors.go
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("banned commenter", "site_id", comment.SiteID, "comment_id", comment.CommentID, "prefix", rule.Prefix, "by", rule.CreatedBy)
+
+		writeJSON(ctx, w, http.StatusCreated, rule)
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+func TestAddressRulesMatch(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+	now := time.Now()
+
+	at := func(d time.Duration) *conduit.Timestamp {
+		ts := conduit.Timestamp(now.Add(d))
+		return &ts
+	}
+
+	for _, rule := range []conduit.AddressRule{
+		{Prefix: "203.0.113.0/24", List: conduit.AddressBlock},
+		{Prefix: "203.0.113.7/32", List: conduit.AddressAllow},
+		{Prefix: "203.0.0.0/16", List: conduit.AddressAllow},
+		{Prefix: "2001:db8::/32", List: conduit.AddressBlock},
+		{Prefix: "2001:db8:1::/48", List: conduit.AddressAllow},
+		{Prefix: "198.51.100.0/24", List: conduit.AddressBlock, ExpiresAt: at(-time.Minute)},
+		{Prefix: "192.0.2.0/24", List: conduit.AddressBlock, ExpiresAt: at(30 * time.Second)},
+		{Prefix: "not a prefix", List: conduit.AddressBlock},
+	} {
+		if err := s.addressRules.store.PutAddressRule(ctx, rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		addr  string
+		after time.Duration
+		want  string // the matching prefix, if any
+	}{
+		{"203.0.113.1", 0, "203.0.113.0/24"},
+		{"203.0.113.7", 0, "203.0.113.7/32"},
+		{"203.0.1.1", 0, "203.0.0.0/16"},
+		{"2001:db8:2::1", 0, "2001:db8::/32"},
+		{"2001:db8:1::1", 0, "2001:db8:1::/48"},
+		{"198.51.100.1", 0, ""},
+		{"192.0.2.1", 0, "192.0.2.0/24"},
+		{"10.0.0.1", 0, ""},
+
+		// Still cached, but expired since.
+		{"192.0.2.1", 40 * time.Second, ""},
+		{"203.0.113.1", 40 * time.Second, "203.0.113.0/24"},
+	}
+
+	for _, tt := range tests {
+		rule, found, err := s.addressRules.match(ctx, netip.MustParseAddr(tt.addr), now.Add(tt.after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != (tt.want != "") || rule.Prefix != tt.want {
+			t.Errorf("%s after %v: got %+v, %v, want %q", tt.addr, tt.after, rule, found, tt.want)
+		}
+	}
+
+	// The rule that had expired when they were loaded is gone from the
+	// store; the one that expired later goes at the next load. A bad prefix
+	// is only skipped.
+	s.addressRules.invalidate()
+	if _, _, err := s.addressRules.match(ctx, netip.MustParseAddr("10.0.0.1"), now.Add(40*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.addressRules.store.AddressRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prefixes []string
+	for _, rule := range stored {
+		prefixes = append(prefixes, rule.Prefix)
+	}
+	if got := strings.Join(prefixes, " "); strings.Contains(got, "198.51.100.0/24") || strings.Contains(got, "192.0.2.0/24") ||
+		!strings.Contains(got, "not a prefix") || len(prefixes) != 6 {
+		t.Fatalf("stored rules: %s", got)
+	}
+}
+
+// adminPost calls an admin handler with a JSON body.
+func adminPost(h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r = setContextUser(r, "admin@example.com")
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestAddressRulesInvalidate(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	blocked := func(ip string) bool {
+		t.Helper()
+		_, found := s.addressBlocked(ctx, ip)
+		return found
+	}
+
+	if blocked("192.0.2.1") {
+		t.Fatal("blocked with no rules")
+	}
+
+	// A rule put in the store by another server is seen once the cache is
+	// stale.
+	if err := s.addressRules.store.PutAddressRule(ctx, conduit.AddressRule{Prefix: "192.0.2.1/32", List: conduit.AddressBlock}); err != nil {
+		t.Fatal(err)
+	}
+	if blocked("192.0.2.1") {
+		t.Fatal("the cache was read again before its time")
+	}
+
+	// Changes made here apply at once, along with anything else in the store.
+	if w := adminPost(s.addAddressRule(), `{"prefix": "198.51.100.9", "list": "block"}`); w.Code != http.StatusCreated {
+		t.Fatalf("add: %d %s", w.Code, w.Body)
+	}
+	if !blocked("198.51.100.9") || !blocked("192.0.2.1") {
+		t.Fatal("an added rule didn't apply at once")
+	}
+
+	if w := adminPost(s.addAddressRule(), `{"prefix": "198.51.100.9/32", "list": "allow"}`); w.Code != http.StatusCreated {
+		t.Fatalf("replace: %d %s", w.Code, w.Body)
+	}
+	if blocked("198.51.100.9") {
+		t.Fatal("a replaced rule still applies")
+	}
+
+	if w := adminPost(s.removeAddressRule(), `{"prefix": "192.0.2.1"}`); w.Code != http.StatusOK {
+		t.Fatalf("remove: %d %s", w.Code, w.Body)
+	}
+	if blocked("192.0.2.1") {
+		t.Fatal("a removed rule still applies")
+	}
+
+	for _, body := range []string{`{"prefix": "nonsense", "list": "block"}`, `{"prefix": "192.0.2.0/24", "list": "deny"}`, `{"prefix": "192.0.2.0/24", "list": "block", "expiresIn": "-1h"}`} {
+		if w := adminPost(s.addAddressRule(), body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", body, w.Code)
+		}
+	}
+}
+
+func TestBanCommenter(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	for id, address := range map[string]string{"v4": "192.0.2.9", "v6": "2001:db8:1:2::5", "none": ""} {
+		comment := conduit.Comment{SiteID: "example.com", PostID: "/post/", CommentID: id, SourceAddress: address, Timestamp: conduit.Timestamp(time.Now()), Status: conduit.StatusPending}
+		if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The ban is on where the comment came from, not on the admin.
+	ban := func(body string) (*httptest.ResponseRecorder, conduit.AddressRule) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/v1/admin/addresses/ban", strings.NewReader(body))
+		r.RemoteAddr = "10.0.0.1:1234"
+		r = setContextUser(r, "admin@example.com")
+		w := httptest.NewRecorder()
+		s.banCommenter()(w, r)
+
+		var rule conduit.AddressRule
+		if w.Code == http.StatusCreated {
+			if err := json.Unmarshal(w.Body.Bytes(), &rule); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, rule
+	}
+
+	w, rule := ban(`{"siteID": "example.com", "commentID": "v4", "expiresIn": "24h"}`)
+	if w.Code != http.StatusCreated || rule.Prefix != "192.0.2.9/32" || rule.List != conduit.AddressBlock ||
+		rule.CreatedBy != "admin@example.com" || rule.Reason != "banned for comment v4" || rule.ExpiresAt == nil {
+		t.Fatalf("v4: %d %+v", w.Code, rule)
+	}
+
+	w, rule = ban(`{"siteID": "example.com", "commentID": "v6", "reason": "spam"}`)
+	if w.Code != http.StatusCreated || rule.Prefix != "2001:db8:1:2::/64" || rule.Reason != "spam" || rule.ExpiresAt != nil {
+		t.Fatalf("v6: %d %+v", w.Code, rule)
+	}
+
+	for _, ip := range []string{"192.0.2.9", "2001:db8:1:2::99"} {
+		if _, found := s.addressBlocked(ctx, ip); !found {
+			t.Errorf("%s isn't blocked", ip)
+		}
+	}
+	for _, ip := range []string{"10.0.0.1", "192.0.2.10", "2001:db8:1:3::5"} {
+		if _, found := s.addressBlocked(ctx, ip); found {
+			t.Errorf("%s is blocked", ip)
+		}
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"siteID": "example.com", "commentID": "none"}`, http.StatusUnprocessableEntity},
+		{`{"siteID": "example.com", "commentID": "missing"}`, http.StatusNotFound},
+		{`{"siteID": "example.com"}`, http.StatusBadRequest},
+		{`{"siteID": "example.com", "commentID": "v4", "expiresIn": "soon"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w, _ := ban(tt.body); w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.body, w.Code, tt.want)
+		}
+	}
+}
+
+func TestCreateCommentFromBlockedAddress(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+	captchas := countCaptchas(s)
+
+	s.mtx.Lock()
+	s.cacheKnown("example.com", "/post/")
+	s.mtx.Unlock()
+
+	if err := s.putAddressRule(ctx, conduit.AddressRule{Prefix: "192.0.2.0/24", List: conduit.AddressBlock}); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(remote string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(conduit.NewComment{SiteID: "example.com", PostID: "/post/", Author: "Someone", CommentBody: "Hello", CaptchaToken: "token"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/v1/comments/new", bytes.NewReader(body))
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		s.resolveClientIP(s.createComment()).ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post("192.0.2.1:1234"); w.Code != http.StatusForbidden {
+		t.Fatalf("blocked: %d %s", w.Code, w.Body)
+	}
+	if captchas.checks != 0 {
+		t.Fatal("checked the captcha of a blocked address")
+	}
+
+	if w := post("198.51.100.1:1234"); w.Code != http.StatusCreated {
+		t.Fatalf("not blocked: %d %s", w.Code, w.Body)
+	}
+	if captchas.checks != 1 {
+		t.Fatalf("%d captcha checks", captchas.checks)
+	}
+
+	siteID, postID := "example.com", "/post/"
+	nr, err := s.commentService.NrComments(ctx, conduit.CommentFilter{SiteID: &siteID, PostID: &postID})
+	if err != nil || nr != 1 {
+		t.Fatalf("stored %d comments, %v", nr, err)
+	}
+}
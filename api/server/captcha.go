@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/carlohamalainen/carlo-comments/captcha"
+	"github.com/carlohamalainen/carlo-comments/config"
+)
+
+// newVerifier checks tokens from a site's comment form, as its settings say.
+func newVerifier(cfg config.CaptchaConfig, client *http.Client) captcha.Verifier {
+	switch cfg.Provider {
+	case config.CaptchaDisabled:
+		return captcha.Disabled{}
+
+	case config.CaptchaHCaptcha:
+		return &captcha.HCaptcha{
+			Secret:    cfg.SecretKey,
+			SiteKey:   cfg.SiteKey,
+			VerifyURL: cfg.VerifyURL,
+			Hostnames: cfg.Hostnames,
+			Client:    client,
+		}
+
+	case config.CaptchaReCaptcha:
+		return &captcha.ReCaptcha{
+			Secret:    cfg.SecretKey,
+			VerifyURL: cfg.VerifyURL,
+			Hostnames: cfg.Hostnames,
+			Action:    cfg.Action,
+			MinScore:  cfg.MinScore,
+			Client:    client,
+		}
+
+	default:
+		return &captcha.Turnstile{
+			Secret:    cfg.SecretKey,
+			VerifyURL: cfg.VerifyURL,
+			Hostnames: cfg.Hostnames,
+			Action:    cfg.Action,
+			Client:    client,
+		}
+	}
+}
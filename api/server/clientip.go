@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPKey contextKey = "carlo-comments-client-ip"
+
+// clientIP works out who sent a request. The peer of the connection is the
+// client, unless it is a trusted proxy, in which case the header the proxies
+// write says who the proxy was talking to. No other forwarding header is
+// read, since any other arrives as the client sent it.
+//
+// The hops in the header are walked from the right, which is where the
+// trusted proxies appended them. The first one that isn't a trusted proxy is
+// the client; anything to its left could have been made up by the client. A
+// hop that isn't an address, such as "unknown", stops the walk at the proxy
+// that added it.
+func clientIP(r *http.Request, trusted []netip.Prefix, header string) string {
+	peer, ok := parseHop(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+
+	if !isTrusted(peer, trusted) {
+		return peer.String()
+	}
+
+	var hops []string
+	if http.CanonicalHeaderKey(header) == "Forwarded" {
+		hops = forwardedFor(r)
+	} else {
+		hops = headerList(r, header)
+	}
+
+	if len(hops) > 0 {
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseHop(hops[i])
+			if !ok {
+				break
+			}
+			client = addr
+			if !isTrusted(addr, trusted) {
+				break
+			}
+		}
+		return client.String()
+	}
+
+	return peer.String()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor lists the for= parameters of the Forwarded headers (RFC 7239),
+// in order.
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	for _, header := range r.Header.Values("Forwarded") {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hops = append(hops, value)
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// headerList lists the comma separated values of a header such as
+// X-Forwarded-For, across all its lines, in order. X-Real-IP is a list of one.
+func headerList(r *http.Request, name string) []string {
+	var hops []string
+	for _, header := range r.Header.Values(name) {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop reads an address as proxies write it: bare, with a port, in
+// brackets for IPv6, or quoted as in Forwarded.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// resolveClientIP works out the client's address once per request, for
+// getClientIP.
+func (s *Server) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey, clientIP(r, s.Config.TrustedProxies, s.Config.ClientIPHeader))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getClientIP is the address a request came from, as far as we can trust
+// the proxies in front of us.
+func getClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return r.RemoteAddr
+}
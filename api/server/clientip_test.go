@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+	tests := []struct {
+		name    string
+		remote  string
+		header  string
+		headers map[string][]string
+		want    string
+	}{
+		{"untrusted peer", "1.2.3.4:5", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"9.9.9.9"}, "X-Real-Ip": {"8.8.8.8"}, "Forwarded": {"for=7.7.7.7"}}, "1.2.3.4"},
+		{"first untrusted hop from the right", "10.0.0.1:5", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"9.9.9.9, 5.5.5.5, 10.0.0.2"}}, "5.5.5.5"},
+		{"several header lines", "10.0.0.1:5", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"9.9.9.9", "10.1.1.1"}}, "9.9.9.9"},
+		{"all hops trusted", "10.0.0.1:5", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"}}, "10.2.2.2"},
+		{"unparseable hop", "10.0.0.1:5", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"unknown, 10.1.1.1"}}, "10.1.1.1"},
+		{"IPv4 mapped", "[fd00::1]:5", "X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"::ffff:1.2.3.4"}}, "1.2.3.4"},
+		{"no header", "10.0.0.1:5", "X-Forwarded-For", nil, "10.0.0.1"},
+
+		// Headers other than the configured one are the client's own.
+		{"spoofed Forwarded", "10.0.0.1:5", "X-Forwarded-For",
+			map[string][]string{"Forwarded": {"for=1.2.3.4"}, "X-Forwarded-For": {"5.5.5.5"}}, "5.5.5.5"},
+		{"spoofed Forwarded only", "10.0.0.1:5", "X-Forwarded-For",
+			map[string][]string{"Forwarded": {"for=1.2.3.4"}}, "10.0.0.1"},
+		{"spoofed X-Real-IP", "10.0.0.1:5", "X-Forwarded-For",
+			map[string][]string{"X-Real-Ip": {"1.2.3.4"}}, "10.0.0.1"},
+		{"spoofed X-Forwarded-For", "10.0.0.1:5", "Forwarded",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, "10.0.0.1"},
+
+		{"Forwarded", "10.0.0.1:5", "Forwarded",
+			map[string][]string{"Forwarded": {`for=192.0.2.60;proto=http, For="[2001:db8::1]:4711"`}}, "2001:db8::1"},
+		{"X-Real-IP", "10.0.0.1:5", "X-Real-Ip",
+			map[string][]string{"X-Real-Ip": {"8.8.8.8"}}, "8.8.8.8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for name, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+			if got := clientIP(r, trusted, tt.header); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
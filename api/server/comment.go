@@ -1,31 +1,57 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/carlohamalainen/carlo-comments/captcha"
 	"github.com/carlohamalainen/carlo-comments/conduit"
 	"github.com/google/uuid"
 )
 
-func getClientIP(r *http.Request) string {
-	ip := r.Header.Get("X-Real-IP")
-	if ip != "" {
-		return ip
+// Listings are paged by the client with a cursor from this header.
+const nextCursorHeader = "X-Next-Cursor"
+
+// maxPageSize caps the limit a client can ask for. No limit at all is still
+// allowed, since a post can't hold more than MaxNrComments.
+const maxPageSize = 500
+
+// originAllowed matches an Origin header the way the CORS handler does: an
+// exact match, "*", or a pattern with one wildcard like https://*.example.com.
+func originAllowed(allowed []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if prefix, suffix, found := strings.Cut(pattern, "*"); found {
+			if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	ips := r.Header.Get("X-Forwarded-For")
-	if ips != "" {
-		ipList := strings.Split(ips, ",")
-		if len(ipList) > 0 {
-			return strings.TrimSpace(ipList[0])
-		}
+// requestOrigin is the page a request came from. Browsers that leave out
+// Origin, as some privacy settings do, still send a Referer, whose scheme and
+// host are the same thing.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
 	}
 
-	return r.RemoteAddr
+	referer, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || referer.Scheme == "" || referer.Host == "" {
+		return ""
+	}
+	return referer.Scheme + "://" + referer.Host
 }
 
 func logRequestHeaders(logger *slog.Logger, r *http.Request) {
@@ -70,18 +96,47 @@ func (s *Server) createComment() http.HandlerFunc {
 			return
 		}
 
-		if true {
-			if newComment.TurnstileToken == "" {
-				logger.Error("turnstile token empty")
-				http.Error(w, "Internal server error", http.StatusBadRequest)
-				return
-			}
+		// Everything after this is per site: origins, captcha keys, capacity.
+		site, ok := s.Config.Site(newComment.SiteID)
+		if !ok {
+			// TODO add to conduit/errors.go
+			logger.Error("unknown siteID", "site_id", newComment.SiteID)
+			http.Error(w, "Unknown host", http.StatusBadRequest)
+			return
+		}
+
+		// The CORS handler lets in any site's origins, so a page on one
+		// site could otherwise post comments to another.
+		if origin := requestOrigin(r); origin != "" && !originAllowed(site.CorsAllowedOrigins, origin) {
+			// TODO add to conduit/errors.go
+			logger.Error("origin not allowed for site", "site_id", site.SiteID, "origin", origin)
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		if rule, blocked := s.addressBlocked(ctx, getClientIP(r)); blocked {
+			// TODO add to conduit/errors.go
+			logger.Info("refusing comment from blocked address", "prefix", rule.Prefix, "reason", rule.Reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		token := newComment.CaptchaToken
+		if token == "" {
+			token = newComment.TurnstileToken
+		}
 
-			if _, err := VerifyTurnstileToken(newComment.TurnstileToken, s.Config.CfSecretKey); err != nil {
-				logger.Error("turnstile token rejected", "error", err.Error(), "turnstile_token", newComment.TurnstileToken)
-				http.Error(w, "Internal server error", http.StatusBadRequest)
+		if err := s.captchas[site.SiteID].Verify(ctx, token, getClientIP(r)); err != nil {
+			if errors.Is(err, captcha.ErrRejected) {
+				// TODO add to conduit/errors.go
+				logger.Info("captcha rejected", "provider", site.Captcha.Provider, "error", err)
+				http.Error(w, "Captcha failed", http.StatusBadRequest)
 				return
 			}
+
+			logger.Error("failed to verify captcha", "provider", site.Captcha.Provider, "error", err)
+			http.Error(w, "Internal server error", http.StatusServiceUnavailable)
+			return
 		}
 
 		nr, err := s.commentService.NrComments(ctx, conduit.CommentFilter{SiteID: &newComment.SiteID, PostID: &newComment.PostID})
@@ -91,7 +146,7 @@ func (s *Server) createComment() http.HandlerFunc {
 			return
 		}
 
-		if nr >= s.Config.MaxNrComments {
+		if nr >= site.MaxNrComments {
 			logger.Info("discarding comment due to over capcity", "site_id", newComment.SiteID, "post_id", newComment.PostID)
 			http.Error(w, "Internal server error", http.StatusForbidden)
 			return
@@ -100,6 +155,7 @@ func (s *Server) createComment() http.HandlerFunc {
 		comment := conduit.Comment{
 			CommentID: uuid.NewString(),
 			Timestamp: conduit.Timestamp(time.Now()),
+			Status:    conduit.StatusPending,
 			IsActive:  false,
 		}
 
@@ -118,6 +174,25 @@ func (s *Server) createComment() http.HandlerFunc {
 		}
 		comment.SiteID = newComment.SiteID
 
+		if newComment.ParentID != "" {
+			found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &newComment.SiteID, CommentID: &newComment.ParentID}, conduit.PageRequest{})
+			if err != nil {
+				logger.Error("failed to look up parent comment", "error", err.Error())
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			// Readers can only reply to comments they can see.
+			parents := found.Comments
+			if len(parents) == 0 || parents[0].PostID != newComment.PostID || !parents[0].IsActive {
+				// TODO add to conduit/errors.go
+				logger.Error("unknown parent comment", "site_id", newComment.SiteID, "post_id", newComment.PostID, "parent_id", newComment.ParentID)
+				http.Error(w, "Unknown parent comment", http.StatusBadRequest)
+				return
+			}
+			comment.ParentID = newComment.ParentID
+		}
+
 		comment.SourceAddress = getClientIP(r)
 		comment.Author = Sanitize(newComment.Author)
 		comment.CommentBody = Sanitize(newComment.CommentBody)
@@ -128,6 +203,12 @@ func (s *Server) createComment() http.HandlerFunc {
 		// }
 		comment.AuthorEmail = newComment.AuthorEmail
 
+		if err := s.screenComment(ctx, &comment); err != nil {
+			logger.Error("failed to screen comment", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
 		err = s.commentService.UpsertComment(ctx, &comment)
 		if err != nil {
 			// TODO add to conduit/errors.go
@@ -135,15 +216,13 @@ func (s *Server) createComment() http.HandlerFunc {
 			return
 		}
 
-		go func() {
-			err := Notify(logger, &s.Config, &comment)
-			if err != nil {
-				logger.Error("failed to send notification email", "error", err.Error())
-			} else {
-				logger.Info("sent notification email", "to", s.Config.AdminUser)
+		// Spam gets the same response as anything else, so that spammers
+		// learn nothing from it.
+		s.afterScreening(ctx, &comment)
 
-			}
-		}()
+		if newComment.Subscribe && comment.Status != conduit.StatusSpam {
+			s.subscribe(ctx, &comment)
+		}
 
 		w.WriteHeader(http.StatusCreated)
 	}
@@ -165,12 +244,30 @@ func (s *Server) getComments(redact bool, filterMode FilterMode) http.HandlerFun
 			maxLength = 0
 		}
 
-		var commentFilter conduit.CommentFilter
-		if err := readJSON(ctx, r.Body, &commentFilter, maxLength); err != nil {
+		// The filter fields sit at the top level of the request, next to
+		// the options for how to lay out the result.
+		var query struct {
+			conduit.CommentFilter
+			conduit.PageRequest
+			Thread ThreadLayout `json:"thread"`
+		}
+		if err := readJSON(ctx, r.Body, &query, maxLength); err != nil {
 			logger.Error("failed to decode", "error", err)
 			badRequestError(ctx, w)
 			return
 		}
+		commentFilter := query.CommentFilter
+
+		page := query.PageRequest
+		if !page.IsValid() {
+			// TODO add to conduit/errors.go
+			logger.Error("invalid page request", "limit", page.Limit, "order", page.Order, "cursor", page.Cursor)
+			http.Error(w, "invalid limit, order or cursor", http.StatusBadRequest)
+			return
+		}
+		if page.Limit > maxPageSize {
+			page.Limit = maxPageSize
+		}
 
 		switch filterMode {
 		case ActiveOnly:
@@ -200,20 +297,100 @@ func (s *Server) getComments(redact bool, filterMode FilterMode) http.HandlerFun
 			return
 		}
 
-		comments, err := s.commentService.Comments(ctx, commentFilter)
+		result, err := s.commentService.Comments(ctx, commentFilter, page)
 		if err != nil {
 			// TODO add to conduit/errors.go
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		comments := result.Comments
+
+		// The body stays a plain list for older clients. Threading only
+		// arranges the comments on this page.
+		if result.NextCursor != "" {
+			w.Header().Set(nextCursorHeader, result.NextCursor)
+		}
 
 		if redact {
 			for i := range comments {
 				comments[i].AuthorEmail = ""
 				comments[i].SourceAddress = ""
+				comments[i].History = nil
+				comments[i].SpamScore = 0
+				comments[i].SpamReasons = nil
+				comments[i].SpamProbability = 0
 			}
 		}
-		writeJSON(ctx, w, http.StatusOK, comments)
+
+		switch query.Thread {
+		case NoThreading:
+			writeJSON(ctx, w, http.StatusOK, comments)
+		case ThreadFlat:
+			writeJSON(ctx, w, http.StatusOK, flattenThreads(buildThreads(comments)))
+		case ThreadTree:
+			writeJSON(ctx, w, http.StatusOK, buildThreads(comments))
+		default:
+			// TODO add to conduit/errors.go
+			logger.Error("unknown thread layout", "thread", query.Thread)
+			http.Error(w, "unknown thread layout", http.StatusBadRequest)
+		}
+	}
+}
+
+// maxCountPosts bounds the number of posts in one count request, which is
+// about what an index or archive page shows.
+const maxCountPosts = 100
+
+// getCommentCounts returns the number of visible comments on each of a list of
+// posts, for showing "N comments" on index pages.
+func (s *Server) getCommentCounts() http.HandlerFunc {
+	type Input struct {
+		SiteID  string   `json:"siteID"`
+		PostIDs []string `json:"postIDs"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "getCommentCounts")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		if r.Method != http.MethodPost {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if input.SiteID == "" || len(input.PostIDs) == 0 || len(input.PostIDs) > maxCountPosts {
+			// TODO add to conduit/errors.go
+			http.Error(w, fmt.Sprintf("need siteID and 1 to %d postIDs", maxCountPosts), http.StatusBadRequest)
+			return
+		}
+
+		for _, postID := range input.PostIDs {
+			if !conduit.IsValidPostID(postID) || !s.IsKnown(input.SiteID, postID) {
+				// TODO add to conduit/errors.go
+				logger.Error("unknown siteID and postID", "site_id", input.SiteID, "post_id", postID)
+				http.Error(w, "Unknown host", http.StatusBadRequest)
+				return
+			}
+		}
+
+		t := true
+		counts, err := s.commentService.NrCommentsByPost(ctx, conduit.CommentFilter{SiteID: &input.SiteID, IsActive: &t}, input.PostIDs)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to count comments", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(ctx, w, http.StatusOK, M{"counts": counts})
 	}
 }
 
@@ -255,6 +432,32 @@ func (s *Server) upsertComment() http.HandlerFunc {
 		comment.AuthorEmail = Sanitize(comment.AuthorEmail)
 		comment.CommentBody = Sanitize(comment.CommentBody)
 
+		existing, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &comment.SiteID, CommentID: &comment.CommentID}, conduit.PageRequest{})
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to look up comment", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var previous *conduit.Comment
+		if len(existing.Comments) > 0 {
+			previous = &existing.Comments[0]
+		}
+
+		now := time.Now()
+
+		if err := reconcileStatus(&comment, previous, contextUser(r), now); err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("invalid moderation status", "error", err, "comment_id", comment.CommentID)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// As in moderate, subscribers hear about a comment once.
+		firstApproval := comment.Status == conduit.StatusApproved &&
+			(previous == nil || (previous.Status != conduit.StatusApproved && !wasApproved(previous)))
+
 		// if !conduit.IsValidEmail(comment.AuthorEmail) {
 		// 	// TODO add to conduit/errors.go
 		// 	logger.Error("invalid author email", "email", comment.AuthorEmail)
@@ -262,7 +465,7 @@ func (s *Server) upsertComment() http.HandlerFunc {
 		// 	return
 		// }
 
-		err := s.commentService.UpsertComment(ctx, &comment)
+		err = s.commentService.UpsertComment(ctx, &comment)
 		if err != nil {
 			// TODO add to conduit/errors.go
 			logger.Error("upsert comment failed", "error", err)
@@ -270,6 +473,51 @@ func (s *Server) upsertComment() http.HandlerFunc {
 			return
 		}
 
+		if err := s.learn(ctx, previous, &comment, now); err != nil {
+			logger.Error("failed to train spam classifier", "comment_id", comment.CommentID, "error", err)
+		}
+
+		if firstApproval {
+			s.notifySubscribers(ctx, &comment)
+		}
+
 		writeJSON(ctx, w, http.StatusCreated, comment)
 	}
 }
+
+// reconcileStatus works out the status of a comment an admin has uploaded.
+// The history always comes from the stored copy, and a change of status has
+// to be an allowed transition. Clients that only know about IsActive leave
+// Status empty, in which case IsActive is only taken as a change if it
+// disagrees with what is stored.
+func reconcileStatus(comment *conduit.Comment, previous *conduit.Comment, moderator string, now time.Time) error {
+	target := comment.Status
+	if target == "" {
+		if previous != nil && previous.IsActive == comment.IsActive {
+			target = previous.Status
+		} else {
+			target = conduit.StatusFromIsActive(comment.IsActive)
+		}
+	}
+
+	if !target.IsValid() {
+		return fmt.Errorf("unknown moderation status %q", target)
+	}
+
+	if previous == nil {
+		comment.History = nil
+		comment.Status = target
+		comment.ResolveStatus()
+		return nil
+	}
+
+	comment.History = previous.History
+	comment.Status = previous.Status
+	comment.ResolveStatus()
+
+	if target == comment.Status {
+		return nil
+	}
+
+	return comment.Moderate(target, moderator, now)
+}
@@ -0,0 +1,457 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+func TestUpsertCommentNotifiesSubscribers(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	s.mtx.Lock()
+	s.cacheKnown("example.com", "/post/")
+	s.mtx.Unlock()
+
+	err := s.subscriptions.PutSubscription(ctx, conduit.Subscription{
+		SubscriptionID: "sub",
+		SiteID:         "example.com",
+		PostID:         "/post/",
+		Email:          "reader@example.net",
+		Status:         conduit.SubscriptionActive,
+		CreatedAt:      conduit.Timestamp(time.Now()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comment := conduit.Comment{
+		SiteID:      "example.com",
+		PostID:      "/post/",
+		CommentID:   "1",
+		Author:      "Someone",
+		AuthorEmail: "someone@example.org",
+		CommentBody: "Hello",
+		Timestamp:   conduit.Timestamp(time.Now()),
+		Status:      conduit.StatusPending,
+	}
+	if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+		t.Fatal(err)
+	}
+
+	upsert := func(status conduit.ModerationStatus) {
+		t.Helper()
+
+		comment.Status = status
+		body, err := json.Marshal(comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/admin/comments/new", bytes.NewReader(body))
+		r = setContextUser(r, "admin@example.com")
+		w := httptest.NewRecorder()
+		s.upsertComment()(w, r)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("upserting %s: got %d %s", status, w.Code, w.Body)
+		}
+	}
+
+	notified := func(want int) {
+		t.Helper()
+		if got := len(outboxEntries(t, s, conduit.OutboxCommentOnPost)); got != want {
+			t.Fatalf("got %d subscriber emails, want %d", got, want)
+		}
+	}
+
+	upsert(conduit.StatusPending)
+	notified(0)
+
+	upsert(conduit.StatusApproved)
+	notified(1)
+
+	// Saving it again, or approving it a second time, sends nothing more.
+	upsert(conduit.StatusApproved)
+	notified(1)
+
+	upsert(conduit.StatusRejected)
+	upsert(conduit.StatusApproved)
+	notified(1)
+}
+
+func TestGetCommentsCursor(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	start := time.Now()
+	for i, id := range []string{"a", "b", "c"} {
+		comment := conduit.Comment{
+			SiteID:      "example.com",
+			PostID:      "/post/",
+			CommentID:   id,
+			Author:      "Someone",
+			CommentBody: "Hello",
+			Timestamp:   conduit.Timestamp(start.Add(time.Duration(i) * time.Minute)),
+			Status:      conduit.StatusApproved,
+		}
+		if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(cursor string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, err := json.Marshal(map[string]any{"SiteID": "example.com", "PostID": "/post/", "limit": 2, "cursor": cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.getComments(true, ActiveOnly)(w, httptest.NewRequest(http.MethodPost, "/v1/comments", bytes.NewReader(body)))
+		return w
+	}
+
+	first := get("")
+	next := first.Header().Get(nextCursorHeader)
+	if first.Code != http.StatusOK || next == "" {
+		t.Fatalf("got %d, cursor %q: %s", first.Code, next, first.Body)
+	}
+
+	second := get(next)
+	var comments []conduit.Comment
+	if err := json.Unmarshal(second.Body.Bytes(), &comments); err != nil {
+		t.Fatal(err)
+	}
+	if second.Code != http.StatusOK || len(comments) != 1 || comments[0].CommentID != "c" {
+		t.Fatalf("got %d: %s", second.Code, second.Body)
+	}
+
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24", "e30="} {
+		if w := get(cursor); w.Code != http.StatusBadRequest {
+			t.Errorf("cursor %q: got %d, want 400", cursor, w.Code)
+		}
+	}
+}
+
+func TestGetComments(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	start := time.Now()
+	for i, c := range []struct {
+		postID, commentID string
+		status            conduit.ModerationStatus
+	}{
+		{"/post/", "approved", conduit.StatusApproved},
+		{"/post/", "pending", conduit.StatusPending},
+		{"/post/", "spam", conduit.StatusSpam},
+		{"/other/", "elsewhere", conduit.StatusApproved},
+	} {
+		comment := conduit.Comment{
+			SiteID:        "example.com",
+			PostID:        c.postID,
+			CommentID:     c.commentID,
+			Author:        "Someone",
+			AuthorEmail:   "someone@example.org",
+			CommentBody:   "Hello",
+			SourceAddress: "192.0.2.1",
+			SpamScore:     1,
+			SpamReasons:   []string{"a reason"},
+			Timestamp:     conduit.Timestamp(start.Add(time.Duration(i) * time.Minute)),
+			Status:        conduit.StatusPending,
+		}
+		if c.status != conduit.StatusPending {
+			if err := comment.Moderate(c.status, "admin@example.com", start); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(h http.HandlerFunc, method, body string) (*httptest.ResponseRecorder, []conduit.Comment) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, "/v1/comments", strings.NewReader(body)))
+
+		var comments []conduit.Comment
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &comments); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, comments
+	}
+
+	ids := func(comments []conduit.Comment) string {
+		var ids []string
+		for _, c := range comments {
+			ids = append(ids, c.CommentID)
+		}
+		return strings.Join(ids, " ")
+	}
+
+	// Readers see the approved comments on one post, without anything
+	// private.
+	public := s.getComments(true, ActiveOnly)
+	w, comments := get(public, http.MethodPost, `{"SiteID": "example.com", "PostID": "/post/"}`)
+	if w.Code != http.StatusOK || ids(comments) != "approved" {
+		t.Fatalf("public: %d %s", w.Code, w.Body)
+	}
+	if c := comments[0]; c.AuthorEmail != "" || c.SourceAddress != "" || c.History != nil || c.SpamScore != 0 || c.SpamReasons != nil ||
+		c.Author != "Someone" || c.CommentBody != "Hello" || !c.IsActive {
+		t.Fatalf("public comment: %+v", c)
+	}
+
+	// Asking for inactive comments doesn't get them.
+	if _, comments := get(public, http.MethodPost, `{"SiteID": "example.com", "PostID": "/post/", "IsActive": false}`); ids(comments) != "approved" {
+		t.Fatalf("public, inactive: %s", ids(comments))
+	}
+
+	tests := []struct {
+		method, body string
+		want         int
+	}{
+		{http.MethodPost, `{"PostID": "/post/"}`, http.StatusBadRequest},
+		{http.MethodPost, `{"SiteID": "example.com"}`, http.StatusBadRequest},
+		{http.MethodPost, `{"SiteID": "example.com", "PostID": "/post/", "limit": -1}`, http.StatusBadRequest},
+		{http.MethodPost, `{"SiteID": "example.com", "PostID": "/post/", "order": "sideways"}`, http.StatusBadRequest},
+		{http.MethodPost, `{"SiteID": "example.com", "PostID": "/post/", "thread": "spiral"}`, http.StatusBadRequest},
+		{http.MethodPost, `not json`, http.StatusUnprocessableEntity},
+		{http.MethodGet, ``, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if w, _ := get(public, tt.method, tt.body); w.Code != tt.want {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.body, w.Code, tt.want)
+		}
+	}
+
+	// The admin interface filters as asked, and sees everything.
+	admin := s.getComments(false, FreeRange)
+	if _, comments := get(admin, http.MethodPost, `{"SiteID": "example.com"}`); ids(comments) != "approved pending spam elsewhere" {
+		t.Fatalf("admin, site: %s", ids(comments))
+	}
+	if _, comments := get(admin, http.MethodPost, `{"SiteID": "example.com", "PostID": "/post/", "IsActive": false}`); ids(comments) != "pending spam" {
+		t.Fatalf("admin, inactive: %s", ids(comments))
+	}
+	w, comments = get(admin, http.MethodPost, `{"SiteID": "example.com", "CommentID": "spam"}`)
+	if w.Code != http.StatusOK || ids(comments) != "spam" {
+		t.Fatalf("admin, one comment: %d %s", w.Code, w.Body)
+	}
+	if c := comments[0]; c.AuthorEmail != "someone@example.org" || c.SourceAddress != "192.0.2.1" || len(c.History) != 1 || c.SpamScore != 1 {
+		t.Fatalf("admin comment: %+v", c)
+	}
+}
+
+func TestCreateComment(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+	s.Config.Sites[0].CorsAllowedOrigins = []string{"https://example.com"}
+	captchas := countCaptchas(s)
+
+	s.mtx.Lock()
+	s.cacheKnown("example.com", "/post/")
+	s.cacheKnown("example.com", "/other/")
+	s.mtx.Unlock()
+
+	for _, c := range []struct {
+		postID, commentID string
+		status            conduit.ModerationStatus
+	}{
+		{"/post/", "visible", conduit.StatusApproved},
+		{"/post/", "hidden", conduit.StatusPending},
+		{"/other/", "elsewhere", conduit.StatusApproved},
+	} {
+		comment := conduit.Comment{SiteID: "example.com", PostID: c.postID, CommentID: c.commentID, Timestamp: conduit.Timestamp(time.Now()), Status: c.status}
+		if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post := func(method, origin string, newComment conduit.NewComment) *httptest.ResponseRecorder {
+		t.Helper()
+		body, err := json.Marshal(newComment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(method, "/v1/comments/new", bytes.NewReader(body))
+		r.RemoteAddr = "198.51.100.7:5555"
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		s.resolveClientIP(s.createComment()).ServeHTTP(w, r)
+		return w
+	}
+
+	stored := func(postID string) []conduit.Comment {
+		t.Helper()
+		siteID := "example.com"
+		isActive := false
+		found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &siteID, PostID: &postID, IsActive: &isActive}, conduit.PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var comments []conduit.Comment
+		for _, c := range found.Comments {
+			if c.CommentID != "hidden" {
+				comments = append(comments, c)
+			}
+		}
+		return comments
+	}
+
+	newComment := conduit.NewComment{
+		SiteID:       "example.com",
+		PostID:       "/post/",
+		ParentID:     "visible",
+		Author:       "Some <b>one</b>",
+		AuthorEmail:  "someone@example.org",
+		CommentBody:  `Hello <script>alert(1)</script><a href="javascript:alert(1)">there</a>`,
+		CaptchaToken: "token",
+	}
+
+	w := post(http.MethodPost, "https://example.com", newComment)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	comments := stored("/post/")
+	if len(comments) != 1 {
+		t.Fatalf("stored %+v", comments)
+	}
+	c := comments[0]
+	if c.CommentID == "" || c.ParentID != "visible" || c.Status != conduit.StatusPending || c.IsActive ||
+		c.SourceAddress != "198.51.100.7" || c.AuthorEmail != "someone@example.org" || time.Since(time.Time(c.Timestamp)) > time.Minute {
+		t.Fatalf("stored %+v", c)
+	}
+	if strings.Contains(c.CommentBody, "script") || strings.Contains(c.CommentBody, "javascript") || !strings.HasPrefix(c.CommentBody, "Hello") ||
+		c.Author != "Some <b>one</b>" {
+		t.Fatalf("not sanitized: %q by %q", c.CommentBody, c.Author)
+	}
+	if captchas.checks != 1 {
+		t.Fatalf("%d captcha checks", captchas.checks)
+	}
+
+	with := func(change func(*conduit.NewComment)) conduit.NewComment {
+		nc := newComment
+		change(&nc)
+		return nc
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		newComment conduit.NewComment
+		want       int
+	}{
+		{"GET", http.MethodGet, "", newComment, http.StatusMethodNotAllowed},
+		{"unknown site", http.MethodPost, "", with(func(nc *conduit.NewComment) { nc.SiteID = "other.example" }), http.StatusBadRequest},
+		{"another site's page", http.MethodPost, "https://other.example", newComment, http.StatusForbidden},
+		{"bad post ID", http.MethodPost, "", with(func(nc *conduit.NewComment) { nc.PostID = "no slashes" }), http.StatusBadRequest},
+		{"unknown post", http.MethodPost, "", with(func(nc *conduit.NewComment) { nc.PostID = "/unknown/" }), http.StatusBadRequest},
+		{"unknown parent", http.MethodPost, "", with(func(nc *conduit.NewComment) { nc.ParentID = "missing" }), http.StatusBadRequest},
+		{"parent not visible", http.MethodPost, "", with(func(nc *conduit.NewComment) { nc.ParentID = "hidden" }), http.StatusBadRequest},
+		{"parent on another post", http.MethodPost, "", with(func(nc *conduit.NewComment) { nc.ParentID = "elsewhere" }), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := post(tt.method, tt.origin, tt.newComment); w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+	if comments := stored("/post/"); len(comments) != 1 {
+		t.Fatalf("refused comments were stored: %+v", comments)
+	}
+
+	// Once a post is full, nothing more goes on it.
+	s.Config.Sites[0].MaxNrComments = 2
+	if w := post(http.MethodPost, "", newComment); w.Code != http.StatusForbidden {
+		t.Fatalf("full post: got %d", w.Code)
+	}
+	if w := post(http.MethodPost, "", with(func(nc *conduit.NewComment) { nc.PostID = "/other/"; nc.ParentID = "" })); w.Code != http.StatusCreated {
+		t.Fatalf("another post: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://example.com", " HTTPS://WWW.Example.com ", "https://*.preview.example.com", "http://localhost:1313"}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://example.com", true},
+		{"https://www.example.com", true},
+		{"https://pr-1.preview.example.com", true},
+		{"http://localhost:1313", true},
+		{"https://other.example", false},
+		{"https://example.com.other.example", false},
+		{"https://preview.example.com", false},
+		{"http://example.com", false},
+		{"https://example.com:8443", false},
+		{"http://localhost:1314", false},
+		{"https://localhost:1313", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := originAllowed(allowed, tt.origin); got != tt.want {
+			t.Errorf("originAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !originAllowed([]string{"*"}, "https://anywhere.example") {
+		t.Error("* didn't allow everything")
+	}
+}
+
+func TestCreateCommentOrigin(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Config.Sites[0].CorsAllowedOrigins = []string{"https://example.com"}
+	countCaptchas(s)
+
+	s.mtx.Lock()
+	s.cacheKnown("example.com", "/post/")
+	s.mtx.Unlock()
+
+	tests := []struct {
+		name, origin, referer string
+		want                  int
+	}{
+		{"allowed origin", "https://example.com", "", http.StatusCreated},
+		{"disallowed origin", "https://other.example", "", http.StatusForbidden},
+		{"origin wins over referer", "https://other.example", "https://example.com/post/", http.StatusForbidden},
+		{"allowed referer", "", "https://example.com/post/?page=2#comments", http.StatusCreated},
+		{"disallowed referer", "", "https://other.example/post/", http.StatusForbidden},
+		{"scheme mismatch", "http://example.com", "", http.StatusForbidden},
+		{"port mismatch", "https://example.com:8443", "", http.StatusForbidden},
+		{"referer port mismatch", "", "https://example.com:8443/post/", http.StatusForbidden},
+		{"neither, as from a script", "", "", http.StatusCreated},
+		{"unusable referer", "", "/post/", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		body := `{"siteID": "example.com", "postID": "/post/", "author": "Someone", "commentBody": "Hello", "captchaToken": "token"}`
+		r := httptest.NewRequest(http.MethodPost, "/v1/comments/new", strings.NewReader(body))
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.referer != "" {
+			r.Header.Set("Referer", tt.referer)
+		}
+		w := httptest.NewRecorder()
+		s.createComment()(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
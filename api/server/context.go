@@ -11,9 +11,22 @@ type contextKey string
 
 const (
 	tokenKey contextKey = "carlo-comments-token"
+	userKey  contextKey = "carlo-comments-user"
 )
 
 func setContextUserToken(r *http.Request, token string) *http.Request {
 	ctx := context.WithValue(r.Context(), tokenKey, token)
 	return r.WithContext(ctx)
 }
+
+// setContextUser records who the token was issued to, so that moderation
+// decisions can say who made them.
+func setContextUser(r *http.Request, user string) *http.Request {
+	ctx := context.WithValue(r.Context(), userKey, user)
+	return r.WithContext(ctx)
+}
+
+func contextUser(r *http.Request) string {
+	user, _ := r.Context().Value(userKey).(string)
+	return user
+}
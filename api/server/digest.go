@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/notify"
+)
+
+// maxDigestComments bounds the comments listed in one digest. NrPending
+// still counts them all.
+const maxDigestComments = 100
+
+// SendDueDigests queues a digest for each site with NotifyDigest once a
+// period of DigestInterval has ended. It is meant to be called from a
+// ticker loop more often than the interval.
+//
+// The first call only starts the clock: a period that ended before the
+// server started is not sent, but nothing is lost, since the next digest
+// lists everything still pending.
+func (s *Server) SendDueDigests(ctx context.Context) {
+	logger := conduit.GetLogger(ctx)
+
+	now := s.Clock()
+	period := now.Truncate(s.Config.DigestInterval)
+
+	if s.lastDigest.IsZero() {
+		s.lastDigest = period
+		return
+	}
+	if !period.After(s.lastDigest) {
+		return
+	}
+	s.lastDigest = period
+
+	for _, site := range s.Config.Sites {
+		if site.NotifyMode != config.NotifyDigest {
+			continue
+		}
+
+		entry := conduit.OutboxEntry{
+			EntryID:     "digest-" + site.SiteID + "-" + period.UTC().Format("20060102T150405Z"),
+			Kind:        conduit.OutboxDigest,
+			SiteID:      site.SiteID,
+			Recipient:   site.NotifyRecipient,
+			Status:      conduit.OutboxPending,
+			NextAttempt: conduit.Timestamp(now),
+			CreatedAt:   conduit.Timestamp(period),
+		}
+
+		if err := s.outbox.PutOutboxEntry(ctx, entry); err != nil {
+			logger.Error("failed to queue digest", "site_id", site.SiteID, "error", err)
+			continue
+		}
+
+		logger.Info("queued digest", "entry_id", entry.EntryID, "to", entry.Recipient)
+	}
+
+	s.wakeOutbox()
+}
+
+// markedSpamBetween reports whether the comment's latest status change was
+// to spam, at a time in [since, until).
+func markedSpamBetween(comment *conduit.Comment, since, until time.Time) bool {
+	if len(comment.History) == 0 {
+		return false
+	}
+	last := comment.History[len(comment.History)-1]
+	at := time.Time(last.At)
+	return last.To == conduit.StatusSpam && !at.Before(since) && at.Before(until)
+}
+
+// deliverDigest builds the digest from the queue as it is now. An empty
+// digest is not sent.
+func (s *Server) deliverDigest(ctx context.Context, entry conduit.OutboxEntry) error {
+	logger := conduit.GetLogger(ctx)
+
+	site, ok := s.Config.Site(entry.SiteID)
+	if !ok {
+		logger.Info("dropping digest for unknown site", "site_id", entry.SiteID)
+		return nil
+	}
+
+	until := time.Time(entry.CreatedAt)
+	since := until.Add(-s.Config.DigestInterval)
+
+	pending := conduit.StatusPending
+	queue, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &site.SiteID, Status: &pending}, conduit.PageRequest{})
+	if err != nil {
+		return err
+	}
+
+	spam := conduit.StatusSpam
+	spammed, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &site.SiteID, Status: &spam}, conduit.PageRequest{})
+	if err != nil {
+		return err
+	}
+
+	nrSpam := 0
+	for i := range spammed.Comments {
+		if markedSpamBetween(&spammed.Comments[i], since, until) {
+			nrSpam++
+		}
+	}
+
+	if len(queue.Comments) == 0 && nrSpam == 0 {
+		logger.Info("nothing for the digest", "site_id", site.SiteID)
+		return nil
+	}
+
+	comments := queue.Comments
+	sort.Slice(comments, func(i, j int) bool {
+		if comments[i].PostID != comments[j].PostID {
+			return comments[i].PostID < comments[j].PostID
+		}
+		return time.Time(comments[i].Timestamp).Before(time.Time(comments[j].Timestamp))
+	})
+	if len(comments) > maxDigestComments {
+		comments = comments[:maxDigestComments]
+	}
+
+	baseURL := strings.TrimSuffix(site.Discovery.BaseURL, "/")
+
+	digest := notify.Digest{
+		Recipient:   entry.Recipient,
+		SiteID:      site.SiteID,
+		Since:       since,
+		Until:       until,
+		NrPending:   len(queue.Comments),
+		NrSpam:      nrSpam,
+		PendingLink: s.adminLinks.PendingLink(site.SiteID),
+	}
+
+	for i := range comments {
+		comment := &comments[i]
+
+		if n := len(digest.Posts); n == 0 || digest.Posts[n-1].PostID != comment.PostID {
+			digest.Posts = append(digest.Posts, notify.DigestPost{
+				PostID:  comment.PostID,
+				PostURL: baseURL + comment.PostID,
+			})
+		}
+		post := &digest.Posts[len(digest.Posts)-1]
+
+		dc := notify.DigestComment{
+			Comment:     *comment,
+			CommentLink: s.adminLinks.CommentLink(comment),
+		}
+		if s.Config.PublicBaseURL != "" {
+			links, err := s.moderationLinks(comment, entry.Recipient, s.Clock())
+			if err != nil {
+				return err
+			}
+			dc.ApproveLink = links[ActionApprove]
+			dc.RejectLink = links[ActionReject]
+			dc.SpamLink = links[ActionSpam]
+		}
+		post.Comments = append(post.Comments, dc)
+	}
+
+	if err := s.notifier.NotifyDigest(ctx, digest); err != nil {
+		return err
+	}
+
+	logger.Info("sent digest", "site_id", site.SiteID, "nr_pending", digest.NrPending, "nr_spam", nrSpam, "to", entry.Recipient)
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+)
+
+func TestSendDueDigests(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"daily", 24 * time.Hour},
+		{"weekly", 7 * 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := conduittest.Context()
+			s, notifier := newTestServer(t)
+
+			s.Config.DigestInterval = tt.interval
+			s.Config.Sites[0].NotifyMode = config.NotifyDigest
+
+			// An hour into a period, which for a week starts on a Monday.
+			start := time.Date(2026, 10, 5, 1, 0, 0, 0, time.UTC)
+			if !start.Truncate(tt.interval).Equal(start.Add(-time.Hour)) {
+				t.Fatalf("%v is not an hour into a period", start)
+			}
+
+			now := start
+			s.Clock = func() time.Time { return now }
+
+			// tick moves the clock on in eighths of the interval, as the
+			// ticker loop would, and delivers whatever is queued.
+			tick := func(periods int) {
+				t.Helper()
+				for i := 0; i < 8*periods; i++ {
+					now = now.Add(tt.interval / 8)
+					s.SendDueDigests(ctx)
+					s.processOutbox(ctx, now)
+				}
+			}
+
+			digests := func(want int) {
+				t.Helper()
+				if got := len(notifier.digests); got != want {
+					t.Fatalf("got %d digests, want %d", got, want)
+				}
+			}
+
+			// The first call only starts the clock.
+			s.SendDueDigests(ctx)
+			s.processOutbox(ctx, now)
+			digests(0)
+
+			// Nothing pending, nothing sent, however many periods go by.
+			tick(2)
+			digests(0)
+
+			comment := conduit.Comment{
+				SiteID:      "example.com",
+				PostID:      "/post/",
+				CommentID:   "1",
+				Author:      "Someone",
+				CommentBody: "Hello",
+				Timestamp:   conduit.Timestamp(now),
+				Status:      conduit.StatusPending,
+			}
+			if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+				t.Fatal(err)
+			}
+
+			// One digest per period while the comment waits.
+			tick(1)
+			digests(1)
+			tick(2)
+			digests(3)
+
+			for i, digest := range notifier.digests {
+				if digest.NrPending != 1 || digest.Until.Sub(digest.Since) != tt.interval {
+					t.Fatalf("digest %d: %+v", i, digest)
+				}
+				if !digest.Until.Equal(digest.Until.Truncate(tt.interval)) {
+					t.Fatalf("digest %d ends at %v, not a period boundary", i, digest.Until)
+				}
+				if i > 0 && !digest.Since.Equal(notifier.digests[i-1].Until) {
+					t.Fatalf("digest %d starts at %v, previous ended at %v", i, digest.Since, notifier.digests[i-1].Until)
+				}
+			}
+
+			// Calling again within the period sends nothing more.
+			s.SendDueDigests(ctx)
+			s.processOutbox(ctx, now)
+			digests(3)
+
+			// Once the queue is empty, the digests stop.
+			if _, err := s.moderate(ctx, "example.com", "1", ActionApprove, "admin@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			tick(2)
+			digests(3)
+
+			if entries := outboxEntries(t, s, conduit.OutboxDigest); len(entries) != 0 {
+				t.Fatalf("%d digests left in the outbox", len(entries))
+			}
+		})
+	}
+}
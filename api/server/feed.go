@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/google/uuid"
+)
+
+type FeedFormat string
+
+const (
+	FeedAtom FeedFormat = "atom"
+	FeedRSS  FeedFormat = "rss"
+)
+
+// maxFeedEntries is how many of the newest comments a feed shows.
+const maxFeedEntries = 50
+
+// How long feed readers may cache a feed before asking again. They can
+// always ask cheaply with If-None-Match or If-Modified-Since.
+const feedMaxAge = 5 * time.Minute
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Link      atomLink    `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Creator     string  `xml:"dc:creator"` // RSS's own author element is an email address
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+// feedModTime is the last time anything in the feed changed: a comment
+// arriving or being approved, or leaving again when it is rejected, marked as
+// spam or sent back to the queue. That is why it is given every comment, not
+// just the ones in the feed.
+func feedModTime(comments []conduit.Comment) time.Time {
+	var modTime time.Time
+	for i := range comments {
+		c := &comments[i]
+		if c.Status != conduit.StatusApproved && !wasApproved(c) {
+			continue
+		}
+		if t := time.Time(c.Timestamp); c.Status == conduit.StatusApproved && t.After(modTime) {
+			modTime = t
+		}
+		if change, ok := c.LastChange(); ok && time.Time(change.At).After(modTime) {
+			modTime = time.Time(change.At)
+		}
+	}
+	return modTime
+}
+
+// feedCreated stands in for the modification time of a feed that has never
+// had a comment: when the post, or the site's first post, was discovered.
+// Unlike the time now, it is the same on every replica.
+func (s *Server) feedCreated(ctx context.Context, siteID, postID string) time.Time {
+	logger := conduit.GetLogger(ctx)
+
+	posts, err := s.postRegistry.KnownPosts(ctx, siteID)
+	if err != nil {
+		logger.Error("failed to read known posts", "site_id", siteID, "error", err)
+	}
+
+	var created time.Time
+	for _, post := range posts {
+		if postID != "" && post.PostID != postID {
+			continue
+		}
+		if discovered := time.Time(post.DiscoveredAt); created.IsZero() || discovered.Before(created) {
+			created = discovered
+		}
+	}
+
+	if created.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return created
+}
+
+func commentTitle(c conduit.Comment) string {
+	return "Comment by " + c.Author + " on " + c.PostID
+}
+
+func commentLink(baseURL string, c conduit.Comment) string {
+	return baseURL + c.PostID + "#comment-" + c.CommentID
+}
+
+func atomBody(title, siteURL string, modTime time.Time, comments []conduit.Comment, baseURL string) ([]byte, error) {
+	feed := atomFeed{
+		ID:      siteURL + "#comments",
+		Title:   title,
+		Updated: modTime.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: siteURL, Rel: "alternate", Type: "text/html"},
+		},
+	}
+
+	for _, c := range comments {
+		updated := time.Time(c.Timestamp)
+		if change, ok := c.LastChange(); ok {
+			updated = time.Time(change.At)
+		}
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        "urn:uuid:" + c.CommentID,
+			Title:     commentTitle(c),
+			Published: time.Time(c.Timestamp).UTC().Format(time.RFC3339),
+			Updated:   updated.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: c.Author},
+			Link:      atomLink{Href: commentLink(baseURL, c), Rel: "alternate", Type: "text/html"},
+			Content:   atomContent{Type: "html", Body: Sanitize(c.CommentBody)},
+		})
+	}
+
+	return marshalFeed(feed)
+}
+
+func rssBody(title, siteURL string, modTime time.Time, comments []conduit.Comment, baseURL string) ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          siteURL,
+			Description:   title,
+			LastBuildDate: modTime.UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	for _, c := range comments {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       commentTitle(c),
+			Link:        commentLink(baseURL, c),
+			GUID:        rssGUID{IsPermaLink: "false", ID: c.CommentID},
+			PubDate:     time.Time(c.Timestamp).UTC().Format(time.RFC1123Z),
+			Creator:     c.Author,
+			Description: Sanitize(c.CommentBody),
+		})
+	}
+
+	return marshalFeed(feed)
+}
+
+func marshalFeed(feed any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// getFeed serves the approved comments on a site, or on one post if postID is
+// given, as ?siteID=...&postID=...
+func (s *Server) getFeed(format FeedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "getFeed", "format", format)
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		query := r.URL.Query()
+		siteID := query.Get("siteID")
+		postID := query.Get("postID")
+
+		site, ok := s.Config.Site(siteID)
+		if !ok {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Unknown host", http.StatusNotFound)
+			return
+		}
+
+		filter := conduit.CommentFilter{SiteID: &siteID}
+
+		baseURL := strings.TrimSuffix(site.Discovery.BaseURL, "/")
+		siteURL := baseURL + "/"
+		title := "Comments on " + siteID
+
+		if postID != "" {
+			if !conduit.IsValidPostID(postID) || !s.IsKnown(siteID, postID) {
+				// TODO add to conduit/errors.go
+				logger.Error("unknown siteID and postID", "site_id", siteID, "post_id", postID)
+				http.Error(w, "Unknown host", http.StatusNotFound)
+				return
+			}
+			filter.PostID = &postID
+			siteURL = baseURL + postID
+			title = "Comments on " + siteID + postID
+		}
+
+		// Every comment, so that feedModTime sees the ones that have left
+		// the feed as well.
+		page, err := s.commentService.Comments(ctx, filter, conduit.PageRequest{Order: conduit.NewestFirst})
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to get comments", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var comments []conduit.Comment
+		for _, c := range page.Comments {
+			if c.Status == conduit.StatusApproved && len(comments) < maxFeedEntries {
+				comments = append(comments, c)
+			}
+		}
+
+		modTime := feedModTime(page.Comments)
+		if modTime.IsZero() {
+			modTime = s.feedCreated(ctx, siteID, postID)
+		}
+
+		var body []byte
+		switch format {
+		case FeedAtom:
+			w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+			body, err = atomBody(title, siteURL, modTime, comments, baseURL)
+		case FeedRSS:
+			w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+			body, err = rssBody(title, siteURL, modTime, comments, baseURL)
+		default:
+			err = fmt.Errorf("unknown feed format %q", format)
+		}
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to render feed", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// The ETag covers what Last-Modified can't see, like an approved
+		// comment being deleted outright.
+		sum := sha256.Sum256(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedMaxAge.Seconds())))
+
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+	}
+}
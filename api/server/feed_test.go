@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+func TestFeedModTime(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	discovered := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.SetKnown(ctx, conduit.KnownPost{SiteID: "example.com", PostID: "/post", DiscoveredAt: conduit.Timestamp(discovered)}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(format FeedFormat, ifModifiedSince time.Time) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/v1/feed?siteID=example.com&postID=/post", nil)
+		if !ifModifiedSince.IsZero() {
+			r.Header.Set("If-Modified-Since", ifModifiedSince.UTC().Format(http.TimeFormat))
+		}
+		w := httptest.NewRecorder()
+		s.getFeed(format)(w, r)
+		return w
+	}
+
+	lastModified := func(w *httptest.ResponseRecorder) time.Time {
+		t.Helper()
+		modTime, err := http.ParseTime(w.Header().Get("Last-Modified"))
+		if err != nil {
+			t.Fatalf("Last-Modified %q: %v", w.Header().Get("Last-Modified"), err)
+		}
+		return modTime
+	}
+
+	// A feed without comments dates from when the post was found.
+	for _, format := range []FeedFormat{FeedRSS, FeedAtom} {
+		w := get(format, time.Time{})
+		if w.Code != http.StatusOK || !lastModified(w).Equal(discovered) {
+			t.Fatalf("%s: got %d, Last-Modified %v", format, w.Code, lastModified(w))
+		}
+		if body := w.Body.String(); strings.Contains(body, "0001") || !strings.Contains(body, "2026") {
+			t.Fatalf("%s: empty feed is not dated from discovery:\n%s", format, body)
+		}
+	}
+
+	posted := discovered.Add(time.Hour)
+	approved := posted.Add(time.Hour)
+	rejected := approved.Add(time.Hour)
+
+	comment := conduit.Comment{
+		SiteID:      "example.com",
+		PostID:      "/post",
+		CommentID:   "1",
+		Author:      "Someone",
+		CommentBody: "Hello",
+		Timestamp:   conduit.Timestamp(posted),
+		Status:      conduit.StatusPending,
+	}
+
+	// Comments waiting for moderation aren't in the feed and don't change it.
+	if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(FeedRSS, time.Time{}); !lastModified(w).Equal(discovered) {
+		t.Fatalf("pending comment moved Last-Modified to %v", lastModified(w))
+	}
+
+	if err := comment.Moderate(conduit.StatusApproved, "admin@example.com", approved); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+		t.Fatal(err)
+	}
+	w := get(FeedRSS, time.Time{})
+	if !lastModified(w).Equal(approved) || !strings.Contains(w.Body.String(), "Hello") {
+		t.Fatalf("after approval: Last-Modified %v\n%s", lastModified(w), w.Body)
+	}
+
+	// Rejecting it takes it out of the feed, which is a change too.
+	if err := comment.Moderate(conduit.StatusRejected, "admin@example.com", rejected); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+		t.Fatal(err)
+	}
+	w = get(FeedRSS, approved)
+	if w.Code != http.StatusOK || !lastModified(w).Equal(rejected) || strings.Contains(w.Body.String(), "Hello") {
+		t.Fatalf("after rejection: got %d, Last-Modified %v\n%s", w.Code, lastModified(w), w.Body)
+	}
+
+	if w := get(FeedRSS, rejected); w.Code != http.StatusNotModified {
+		t.Fatalf("got %d, want 304", w.Code)
+	}
+}
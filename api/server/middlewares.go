@@ -66,6 +66,7 @@ func (s *Server) authenticate() func(http.Handler) http.Handler {
 			}
 
 			r = setContextUserToken(r, token.Raw)
+			r = setContextUser(r, claims.Subject)
 			h.ServeHTTP(w, r)
 		})
 	}
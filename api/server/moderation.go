@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type ModerationAction string
+
+const (
+	ActionApprove ModerationAction = "approve"
+	ActionReject  ModerationAction = "reject"
+	ActionSpam    ModerationAction = "spam"
+	ActionDelete  ModerationAction = "delete" // permanent, unlike conduit.StatusDeleted
+)
+
+// maxBulkModeration bounds the number of comments in one bulk request.
+const maxBulkModeration = 100
+
+var errCommentNotFound = errors.New("comment not found")
+
+func (a ModerationAction) status() (conduit.ModerationStatus, bool) {
+	switch a {
+	case ActionApprove:
+		return conduit.StatusApproved, true
+	case ActionReject:
+		return conduit.StatusRejected, true
+	case ActionSpam:
+		return conduit.StatusSpam, true
+	default:
+		return "", false
+	}
+}
+
+// moderate applies an action to a stored comment. Asking for the status the
+// comment already has is not an error, so that retries are harmless.
+func (s *Server) moderate(ctx context.Context, siteID, commentID string, action ModerationAction, moderator string) (*conduit.Comment, error) {
+	logger := conduit.GetLogger(ctx)
+
+	found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &siteID, CommentID: &commentID}, conduit.PageRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if len(found.Comments) == 0 {
+		return nil, errCommentNotFound
+	}
+	comment := found.Comments[0]
+
+	if action == ActionDelete {
+		if err := s.commentService.DeleteComment(ctx, &comment); err != nil {
+			return nil, err
+		}
+		logger.Info("deleted comment", "site_id", siteID, "comment_id", commentID, "moderator", moderator)
+		return &comment, nil
+	}
+
+	status, ok := action.status()
+	if !ok {
+		return nil, fmt.Errorf("unknown moderation action %q", action)
+	}
+
+	comment.ResolveStatus()
+	if comment.Status == status {
+		return &comment, nil
+	}
+
+	// Subscribers hear about a comment once, even if it is approved again.
+	firstApproval := status == conduit.StatusApproved && !wasApproved(&comment)
+
+	previous := comment
+	now := time.Now()
+
+	if err := comment.Moderate(status, moderator, now); err != nil {
+		return nil, err
+	}
+
+	if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+		return nil, err
+	}
+
+	logger.Info("moderated comment", "site_id", siteID, "comment_id", commentID, "status", status, "moderator", moderator)
+
+	if status == conduit.StatusSpam {
+		s.badIPs.Add(comment.SourceAddress)
+	}
+
+	// The comment is stored either way; the model just misses an example.
+	if err := s.learn(ctx, &previous, &comment, now); err != nil {
+		logger.Error("failed to train spam classifier", "comment_id", commentID, "error", err)
+	}
+
+	if firstApproval {
+		s.notifySubscribers(ctx, &comment)
+	}
+
+	return &comment, nil
+}
+
+func wasApproved(comment *conduit.Comment) bool {
+	for _, change := range comment.History {
+		if change.To == conduit.StatusApproved {
+			return true
+		}
+	}
+	return false
+}
+
+func moderationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errCommentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, conduit.ErrInvalidTransition):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s *Server) moderateComment(action ModerationAction) http.HandlerFunc {
+	type Input struct {
+		SiteID    string `json:"siteID"`
+		CommentID string `json:"commentID"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "moderateComment", "action", action)
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if input.SiteID == "" || input.CommentID == "" {
+			// TODO add to conduit/errors.go
+			http.Error(w, "need siteID and commentID", http.StatusBadRequest)
+			return
+		}
+
+		comment, err := s.moderate(ctx, input.SiteID, input.CommentID, action, contextUser(r))
+		if err != nil {
+			logger.Error("moderation failed", "error", err, "site_id", input.SiteID, "comment_id", input.CommentID)
+			errorResponse(ctx, w, moderationErrorStatus(err), err.Error())
+			return
+		}
+
+		writeJSON(ctx, w, http.StatusOK, comment)
+	}
+}
+
+func (s *Server) bulkModerate() http.HandlerFunc {
+	type Input struct {
+		SiteID     string           `json:"siteID"`
+		CommentIDs []string         `json:"commentIDs"`
+		Action     ModerationAction `json:"action"`
+	}
+
+	type Result struct {
+		CommentID string                   `json:"commentID"`
+		Status    conduit.ModerationStatus `json:"status,omitempty"`
+		Error     string                   `json:"error,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "bulkModerate")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if _, ok := input.Action.status(); !ok && input.Action != ActionDelete {
+			// TODO add to conduit/errors.go
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+
+		if input.SiteID == "" || len(input.CommentIDs) == 0 || len(input.CommentIDs) > maxBulkModeration {
+			// TODO add to conduit/errors.go
+			http.Error(w, fmt.Sprintf("need siteID and 1 to %d commentIDs", maxBulkModeration), http.StatusBadRequest)
+			return
+		}
+
+		// One bad comment shouldn't stop the rest, so report per comment.
+		results := make([]Result, 0, len(input.CommentIDs))
+		for _, commentID := range input.CommentIDs {
+			comment, err := s.moderate(ctx, input.SiteID, commentID, input.Action, contextUser(r))
+			if err != nil {
+				logger.Error("moderation failed", "error", err, "site_id", input.SiteID, "comment_id", commentID)
+				results = append(results, Result{CommentID: commentID, Error: err.Error()})
+				continue
+			}
+
+			result := Result{CommentID: commentID}
+			if input.Action != ActionDelete {
+				result.Status = comment.Status
+			}
+			results = append(results, result)
+		}
+
+		writeJSON(ctx, w, http.StatusOK, M{"results": results})
+	}
+}
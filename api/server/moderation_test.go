@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+// storeComment puts a comment with the given status on /post/.
+func storeComment(t *testing.T, s *Server, commentID string, status conduit.ModerationStatus) {
+	t.Helper()
+
+	comment := conduit.Comment{
+		SiteID:      "example.com",
+		PostID:      "/post/",
+		CommentID:   commentID,
+		Author:      "Someone",
+		AuthorEmail: "someone@example.org",
+		CommentBody: "Hello",
+		Timestamp:   conduit.Timestamp(time.Now()),
+		Status:      status,
+	}
+	if err := s.commentService.UpsertComment(conduittest.Context(), &comment); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedComment reads a comment back, or fails if it has gone.
+func storedComment(t *testing.T, s *Server, commentID string) conduit.Comment {
+	t.Helper()
+
+	siteID := "example.com"
+	found, err := s.commentService.Comments(conduittest.Context(), conduit.CommentFilter{SiteID: &siteID, CommentID: &commentID}, conduit.PageRequest{})
+	if err != nil || len(found.Comments) != 1 {
+		t.Fatal(found, err)
+	}
+	return found.Comments[0]
+}
+
+func TestModerateComment(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		from   conduit.ModerationStatus
+		action ModerationAction
+		want   int
+		to     conduit.ModerationStatus
+	}{
+		{conduit.StatusPending, ActionApprove, http.StatusOK, conduit.StatusApproved},
+		{conduit.StatusPending, ActionReject, http.StatusOK, conduit.StatusRejected},
+		{conduit.StatusPending, ActionSpam, http.StatusOK, conduit.StatusSpam},
+		{conduit.StatusApproved, ActionReject, http.StatusOK, conduit.StatusRejected},
+		{conduit.StatusApproved, ActionSpam, http.StatusOK, conduit.StatusSpam},
+		{conduit.StatusRejected, ActionApprove, http.StatusOK, conduit.StatusApproved},
+		{conduit.StatusSpam, ActionApprove, http.StatusOK, conduit.StatusApproved},
+		{conduit.StatusSpam, ActionReject, http.StatusOK, conduit.StatusRejected},
+		{conduit.StatusDeleted, ActionApprove, http.StatusConflict, conduit.StatusDeleted},
+		{conduit.StatusDeleted, ActionSpam, http.StatusConflict, conduit.StatusDeleted},
+	}
+
+	for i, tt := range tests {
+		commentID := fmt.Sprint(i)
+		storeComment(t, s, commentID, tt.from)
+
+		w := adminPost(s.moderateComment(tt.action), `{"siteID": "example.com", "commentID": "`+commentID+`"}`)
+		if w.Code != tt.want {
+			t.Errorf("%s to %s: got %d %s", tt.from, tt.action, w.Code, w.Body)
+			continue
+		}
+
+		comment := storedComment(t, s, commentID)
+		if comment.Status != tt.to || comment.IsActive != (tt.to == conduit.StatusApproved) {
+			t.Errorf("%s to %s: stored %+v", tt.from, tt.action, comment)
+		}
+
+		if tt.want != http.StatusOK {
+			if len(comment.History) != 0 {
+				t.Errorf("%s to %s: a refused change went in the history: %+v", tt.from, tt.action, comment.History)
+			}
+			continue
+		}
+		change, ok := comment.LastChange()
+		if !ok || len(comment.History) != 1 || change.From != tt.from || change.To != tt.to || change.By != "admin@example.com" ||
+			time.Since(time.Time(change.At)) > time.Minute {
+			t.Errorf("%s to %s: history %+v", tt.from, tt.action, comment.History)
+		}
+	}
+
+	// Asking again for the status a comment has changes nothing.
+	storeComment(t, s, "again", conduit.StatusPending)
+	for i := 0; i < 2; i++ {
+		if w := adminPost(s.moderateComment(ActionApprove), `{"siteID": "example.com", "commentID": "again"}`); w.Code != http.StatusOK {
+			t.Fatalf("approve %d: got %d", i, w.Code)
+		}
+	}
+	if comment := storedComment(t, s, "again"); len(comment.History) != 1 {
+		t.Fatalf("history after approving twice: %+v", comment.History)
+	}
+
+	// Delete is for good.
+	storeComment(t, s, "delete", conduit.StatusSpam)
+	if w := adminPost(s.moderateComment(ActionDelete), `{"siteID": "example.com", "commentID": "delete"}`); w.Code != http.StatusOK {
+		t.Fatalf("delete: got %d", w.Code)
+	}
+	siteID, commentID := "example.com", "delete"
+	if found, err := s.commentService.Comments(conduittest.Context(), conduit.CommentFilter{SiteID: &siteID, CommentID: &commentID}, conduit.PageRequest{}); err != nil || len(found.Comments) != 0 {
+		t.Fatalf("after delete: %+v, %v", found, err)
+	}
+
+	for body, want := range map[string]int{
+		`{"siteID": "example.com", "commentID": "missing"}`: http.StatusNotFound,
+		`{"siteID": "example.com"}`:                         http.StatusBadRequest,
+		`not json`:                                          http.StatusUnprocessableEntity,
+	} {
+		if w := adminPost(s.moderateComment(ActionApprove), body); w.Code != want {
+			t.Errorf("%s: got %d, want %d", body, w.Code, want)
+		}
+	}
+}
+
+func TestModerationErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errCommentNotFound, http.StatusNotFound},
+		{fmt.Errorf("moderating: %w", errCommentNotFound), http.StatusNotFound},
+		{conduit.ErrInvalidTransition, http.StatusConflict},
+		{fmt.Errorf("%w: can't move comment from deleted to spam", conduit.ErrInvalidTransition), http.StatusConflict},
+		{errors.New("store unavailable"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := moderationErrorStatus(tt.err); got != tt.want {
+			t.Errorf("moderationErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBulkModerate(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	storeComment(t, s, "pending", conduit.StatusPending)
+	storeComment(t, s, "deleted", conduit.StatusDeleted)
+
+	w := adminPost(s.bulkModerate(), `{"siteID": "example.com", "commentIDs": ["pending", "deleted", "missing"], "action": "spam"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+
+	var response struct {
+		Results []struct {
+			CommentID string                   `json:"commentID"`
+			Status    conduit.ModerationStatus `json:"status"`
+			Error     string                   `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	results := response.Results
+	if len(results) != 3 ||
+		results[0].CommentID != "pending" || results[0].Status != conduit.StatusSpam || results[0].Error != "" ||
+		results[1].CommentID != "deleted" || results[1].Status != "" || !strings.Contains(results[1].Error, "deleted to spam") ||
+		results[2].CommentID != "missing" || results[2].Error != errCommentNotFound.Error() {
+		t.Fatalf("results %+v", results)
+	}
+	if comment := storedComment(t, s, "pending"); comment.Status != conduit.StatusSpam {
+		t.Fatalf("stored %+v", comment)
+	}
+
+	ids := func(n int) string {
+		quoted := make([]string, n)
+		for i := range quoted {
+			quoted[i] = fmt.Sprintf(`"c%d"`, i)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"siteID": "example.com", "commentIDs": ` + ids(maxBulkModeration) + `, "action": "reject"}`, http.StatusOK},
+		{`{"siteID": "example.com", "commentIDs": ` + ids(maxBulkModeration+1) + `, "action": "reject"}`, http.StatusBadRequest},
+		{`{"siteID": "example.com", "commentIDs": [], "action": "reject"}`, http.StatusBadRequest},
+		{`{"commentIDs": ["pending"], "action": "reject"}`, http.StatusBadRequest},
+		{`{"siteID": "example.com", "commentIDs": ["pending"], "action": "pending"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		if w := adminPost(s.bulkModerate(), tt.body); w.Code != tt.want {
+			t.Errorf("%.80s: got %d, want %d", tt.body, w.Code, tt.want)
+		}
+	}
+}
+
+func TestModerateNotifiesOnFirstApproval(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	err := s.subscriptions.PutSubscription(ctx, conduit.Subscription{
+		SubscriptionID: "sub",
+		SiteID:         "example.com",
+		PostID:         "/post/",
+		Email:          "reader@example.net",
+		Status:         conduit.SubscriptionActive,
+		CreatedAt:      conduit.Timestamp(time.Now()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storeComment(t, s, "1", conduit.StatusPending)
+
+	notified := func(want int) {
+		t.Helper()
+		if got := len(outboxEntries(t, s, conduit.OutboxCommentOnPost)); got != want {
+			t.Fatalf("got %d subscriber emails, want %d", got, want)
+		}
+	}
+
+	for _, step := range []struct {
+		action ModerationAction
+		want   int
+	}{
+		{ActionReject, 0},
+		{ActionApprove, 1},
+		{ActionApprove, 1},
+		{ActionSpam, 1},
+		{ActionApprove, 1},
+	} {
+		if _, err := s.moderate(ctx, "example.com", "1", step.action, "admin@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		notified(step.want)
+	}
+
+	tests := []struct {
+		history []conduit.StatusChange
+		want    bool
+	}{
+		{nil, false},
+		{[]conduit.StatusChange{{From: conduit.StatusPending, To: conduit.StatusRejected}}, false},
+		{[]conduit.StatusChange{{From: conduit.StatusPending, To: conduit.StatusApproved}}, true},
+		{[]conduit.StatusChange{{From: conduit.StatusPending, To: conduit.StatusApproved}, {From: conduit.StatusApproved, To: conduit.StatusSpam}}, true},
+	}
+	for _, tt := range tests {
+		if got := wasApproved(&conduit.Comment{History: tt.history}); got != tt.want {
+			t.Errorf("wasApproved(%+v) = %v", tt.history, got)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/modlink"
+	"github.com/google/uuid"
+)
+
+// The actions a notification links to.
+var linkActions = []ModerationAction{ActionApprove, ActionReject, ActionSpam}
+
+// moderationLinks signs one link per action for the notification sent to
+// recipient, who is recorded as the moderator if the link is used.
+func (s *Server) moderationLinks(comment *conduit.Comment, recipient string, now time.Time) (map[ModerationAction]string, error) {
+	links := make(map[ModerationAction]string)
+
+	for _, action := range linkActions {
+		token, err := s.modLinks.Sign(comment.SiteID, comment.CommentID, string(action), recipient, s.Config.ModerationLinkTTL, now)
+		if err != nil {
+			return nil, err
+		}
+		links[action] = s.Config.PublicBaseURL + "/v1/moderate?token=" + url.QueryEscape(token)
+	}
+
+	return links, nil
+}
+
+// linkPage is what the links in emails show: a confirmation button, since
+// following a link must change nothing, or the outcome.
+type linkPage struct {
+	Title   string
+	Message string
+	Token   string // set on the confirmation page only
+	Button  string
+	Comment *conduit.Comment
+}
+
+var linkPageTemplate = template.Must(template.New("linkPageTemplate").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{.Title}}</title>
+</head>
+<body>
+	<h1>{{.Title}}</h1>
+	{{if .Message}}<p>{{.Message}}</p>{{end}}
+	{{with .Comment}}
+	<p>{{.SiteID}}{{.PostID}}</p>
+	<p>{{.Author}} ({{.Status}})</p>
+	<blockquote>{{.CommentBody}}</blockquote>
+	{{end}}
+	{{if .Token}}
+	<form method="post">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<button type="submit">{{.Button}}</button>
+	</form>
+	{{end}}
+</body>
+</html>
+`))
+
+func writeLinkPage(ctx context.Context, w http.ResponseWriter, code int, page linkPage) {
+	logger := conduit.GetLogger(ctx)
+
+	// The token is in the URL, so keep it out of caches and Referer headers,
+	// and don't let another site frame the button.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+	w.WriteHeader(code)
+
+	if err := linkPageTemplate.Execute(w, page); err != nil {
+		logger.Error("failed to execute moderation template", "error", err)
+	}
+}
+
+// verifyModerationLink checks a token and finds its comment. On failure it
+// has already written the error page.
+func (s *Server) verifyModerationLink(ctx context.Context, w http.ResponseWriter, token string) (modlink.Claims, ModerationAction, *conduit.Comment, bool) {
+	logger := conduit.GetLogger(ctx)
+
+	claims, err := s.modLinks.Verify(token, time.Now())
+	if errors.Is(err, modlink.ErrExpired) {
+		writeLinkPage(ctx, w, http.StatusGone, linkPage{Title: "Link expired", Message: "Moderate this comment from the admin interface instead."})
+		return claims, "", nil, false
+	}
+	if err != nil {
+		logger.Info("bad moderation token", "error", err)
+		writeLinkPage(ctx, w, http.StatusBadRequest, linkPage{Title: "Invalid link"})
+		return claims, "", nil, false
+	}
+
+	action := ModerationAction(claims.Action)
+	if _, ok := action.status(); !ok {
+		logger.Error("signed moderation token has unknown action", "action", claims.Action)
+		writeLinkPage(ctx, w, http.StatusBadRequest, linkPage{Title: "Invalid link"})
+		return claims, "", nil, false
+	}
+
+	found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &claims.SiteID, CommentID: &claims.CommentID}, conduit.PageRequest{})
+	if err != nil {
+		logger.Error("failed to look up comment", "error", err)
+		writeLinkPage(ctx, w, http.StatusInternalServerError, linkPage{Title: "Internal server error"})
+		return claims, "", nil, false
+	}
+	if len(found.Comments) == 0 {
+		writeLinkPage(ctx, w, http.StatusNotFound, linkPage{Title: "Comment not found", Message: "It may have been deleted."})
+		return claims, "", nil, false
+	}
+	comment := found.Comments[0]
+	comment.ResolveStatus()
+
+	return claims, action, &comment, true
+}
+
+// confirmModerationLink shows what the link would do. Following the link
+// changes nothing, since mail scanners fetch links too.
+func (s *Server) confirmModerationLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "confirmModerationLink")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		token := r.URL.Query().Get("token")
+
+		_, action, comment, ok := s.verifyModerationLink(ctx, w, token)
+		if !ok {
+			return
+		}
+
+		writeLinkPage(ctx, w, http.StatusOK, linkPage{
+			Title:   "Confirm " + string(action),
+			Token:   token,
+			Button:  string(action),
+			Comment: comment,
+		})
+	}
+}
+
+func (s *Server) applyModerationLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "applyModerationLink")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.Config.MaxBodySize))
+		token := r.PostFormValue("token")
+
+		claims, action, _, ok := s.verifyModerationLink(ctx, w, token)
+		if !ok {
+			return
+		}
+
+		// Spend the token first, so that a double submit can't apply the
+		// action twice.
+		first, err := s.usedTokens.UseToken(ctx, claims.TokenID, claims.Expires())
+		if err != nil {
+			logger.Error("failed to record used token", "error", err)
+			writeLinkPage(ctx, w, http.StatusInternalServerError, linkPage{Title: "Internal server error"})
+			return
+		}
+		if !first {
+			writeLinkPage(ctx, w, http.StatusConflict, linkPage{Title: "Link already used"})
+			return
+		}
+
+		comment, err := s.moderate(ctx, claims.SiteID, claims.CommentID, action, claims.Moderator)
+		if err != nil {
+			logger.Error("moderation failed", "error", err, "site_id", claims.SiteID, "comment_id", claims.CommentID)
+			writeLinkPage(ctx, w, moderationErrorStatus(err), linkPage{Title: "Moderation failed", Message: err.Error()})
+			return
+		}
+
+		writeLinkPage(ctx, w, http.StatusOK, linkPage{
+			Title:   "Comment " + string(comment.Status),
+			Comment: comment,
+		})
+	}
+}
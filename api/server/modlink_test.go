@@ -0,0 +1,198 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+// linkToken is the token in a link from an email.
+func linkToken(t *testing.T, link string) string {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("token")
+}
+
+// followLink is a GET of a link, as a mail scanner would do.
+func followLink(h http.HandlerFunc, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/?token="+url.QueryEscape(token), nil)
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// submitLink is the POST of the confirmation page's form.
+func submitLink(h http.HandlerFunc, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestModerationLink(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+	s.Config.ModerationLinkTTL = time.Hour
+
+	comment := conduit.Comment{
+		SiteID:      "example.com",
+		PostID:      "/post/",
+		CommentID:   "1",
+		Author:      "Someone",
+		CommentBody: "Hello",
+		Timestamp:   conduit.Timestamp(time.Now()),
+		Status:      conduit.StatusPending,
+	}
+	if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+		t.Fatal(err)
+	}
+
+	status := func() conduit.Comment {
+		t.Helper()
+		found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &comment.SiteID, CommentID: &comment.CommentID}, conduit.PageRequest{})
+		if err != nil || len(found.Comments) != 1 {
+			t.Fatal(found, err)
+		}
+		return found.Comments[0]
+	}
+
+	links, err := s.moderationLinks(&comment, "admin@example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != len(linkActions) || !strings.HasPrefix(links[ActionApprove], "https://comments.example.com/v1/moderate?token=") {
+		t.Fatalf("links %v", links)
+	}
+	token := linkToken(t, links[ActionApprove])
+
+	// Following the link only asks for confirmation.
+	w := followLink(s.confirmModerationLink(), token)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post">`) ||
+		!strings.Contains(w.Body.String(), `value="`+token+`"`) || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("GET: %d %s", w.Code, w.Body)
+	}
+	if got := status(); got.Status != conduit.StatusPending {
+		t.Fatalf("GET moderated the comment: %+v", got)
+	}
+
+	// Submitting the form does the moderation, as the recipient.
+	w = submitLink(s.applyModerationLink(), token)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "<form") {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	got := status()
+	change, ok := got.LastChange()
+	if got.Status != conduit.StatusApproved || !ok || change.From != conduit.StatusPending || change.By != "admin@example.com" {
+		t.Fatalf("after the POST: %+v", got)
+	}
+
+	// A link works once.
+	if w := submitLink(s.applyModerationLink(), token); w.Code != http.StatusConflict {
+		t.Fatalf("second POST: %d", w.Code)
+	}
+
+	// Another action from the same email is a separate link.
+	if w := submitLink(s.applyModerationLink(), linkToken(t, links[ActionSpam])); w.Code != http.StatusOK || status().Status != conduit.StatusSpam {
+		t.Fatalf("spam: %d %+v", w.Code, status())
+	}
+
+	expired, err := s.moderationLinks(&comment, "admin@example.com", time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := followLink(s.confirmModerationLink(), linkToken(t, expired[ActionApprove])); w.Code != http.StatusGone {
+		t.Fatalf("expired GET: %d", w.Code)
+	}
+	if w := submitLink(s.applyModerationLink(), linkToken(t, expired[ActionApprove])); w.Code != http.StatusGone {
+		t.Fatalf("expired POST: %d", w.Code)
+	}
+
+	for _, bad := range []string{"", "garbage", token + "x"} {
+		if w := followLink(s.confirmModerationLink(), bad); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %q: %d", bad, w.Code)
+		}
+		if w := submitLink(s.applyModerationLink(), bad); w.Code != http.StatusBadRequest {
+			t.Errorf("POST %q: %d", bad, w.Code)
+		}
+	}
+
+	// A link for a comment that has gone.
+	missing := comment
+	missing.CommentID = "2"
+	gone, err := s.moderationLinks(&missing, "admin@example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := followLink(s.confirmModerationLink(), linkToken(t, gone[ActionApprove])); w.Code != http.StatusNotFound {
+		t.Fatalf("missing comment: %d", w.Code)
+	}
+}
+
+func TestUnsubscribeLink(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	sub := conduit.Subscription{
+		SubscriptionID: "s1",
+		SiteID:         "example.com",
+		PostID:         "/post/",
+		Email:          "reader@example.net",
+		Status:         conduit.SubscriptionActive,
+		CreatedAt:      conduit.Timestamp(time.Now()),
+	}
+	if err := s.subscriptions.PutSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+
+	subscribed := func() bool {
+		t.Helper()
+		found, err := s.findSubscription(ctx, sub.SiteID, sub.PostID, sub.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found != nil
+	}
+
+	link, err := s.subscriptionLink(sub, subscriptionUnsubscribe, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := linkToken(t, link)
+
+	// An unsubscribe token doesn't confirm, nor a moderation link unsubscribe.
+	if w := submitLink(s.applySubscriptionLink(subscriptionConfirm), token); w.Code != http.StatusBadRequest {
+		t.Fatalf("unsubscribe token to confirm: %d", w.Code)
+	}
+	links, err := s.moderationLinks(&conduit.Comment{SiteID: "example.com", CommentID: "1"}, "admin@example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := submitLink(s.applySubscriptionLink(subscriptionUnsubscribe), linkToken(t, links[ActionApprove])); w.Code != http.StatusBadRequest {
+		t.Fatalf("moderation token to unsubscribe: %d", w.Code)
+	}
+
+	w := followLink(s.confirmSubscriptionLink(subscriptionUnsubscribe), token)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post">`) || !subscribed() {
+		t.Fatalf("GET: %d %s", w.Code, w.Body)
+	}
+
+	w = submitLink(s.applySubscriptionLink(subscriptionUnsubscribe), token)
+	if w.Code != http.StatusOK || subscribed() {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+
+	// Unsubscribing again is harmless.
+	if w := submitLink(s.applySubscriptionLink(subscriptionUnsubscribe), token); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Not subscribed") {
+		t.Fatalf("second POST: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/notify"
+	"github.com/google/uuid"
+)
+
+// notifyNewComment queues a message to the site's moderator; the outbox
+// worker sends it. Failures are only logged: the comment is stored either
+// way.
+func (s *Server) notifyNewComment(ctx context.Context, comment *conduit.Comment) {
+	logger := conduit.GetLogger(ctx)
+
+	recipient := s.Config.AdminUser
+	if site, ok := s.Config.Site(comment.SiteID); ok {
+		if site.NotifyMode == config.NotifyDigest {
+			logger.Info("leaving comment for the digest", "comment_id", comment.CommentID)
+			return
+		}
+		recipient = site.NotifyRecipient
+	}
+
+	if err := s.enqueue(ctx, conduit.OutboxNewComment, comment, recipient); err != nil {
+		logger.Error("failed to queue notification", "comment_id", comment.CommentID, "error", err)
+	}
+}
+
+// enqueue adds a message about a comment to the outbox.
+func (s *Server) enqueue(ctx context.Context, kind string, comment *conduit.Comment, recipient string) error {
+	logger := conduit.GetLogger(ctx)
+
+	now := time.Now()
+
+	entry := conduit.OutboxEntry{
+		EntryID:     uuid.NewString(),
+		Kind:        kind,
+		SiteID:      comment.SiteID,
+		CommentID:   comment.CommentID,
+		Recipient:   recipient,
+		Status:      conduit.OutboxPending,
+		NextAttempt: conduit.Timestamp(now),
+		CreatedAt:   conduit.Timestamp(now),
+	}
+
+	if err := s.outbox.PutOutboxEntry(ctx, entry); err != nil {
+		return err
+	}
+
+	logger.Info("queued notification", "entry_id", entry.EntryID, "kind", kind, "to", recipient)
+
+	s.wakeOutbox()
+
+	return nil
+}
+
+// deliverNewComment sends an OutboxNewComment entry. A comment that is gone
+// or already moderated needs no message, so that counts as delivered.
+func (s *Server) deliverNewComment(ctx context.Context, entry conduit.OutboxEntry) error {
+	logger := conduit.GetLogger(ctx)
+
+	found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &entry.SiteID, CommentID: &entry.CommentID}, conduit.PageRequest{})
+	if err != nil {
+		return err
+	}
+	if len(found.Comments) == 0 {
+		logger.Info("dropping notification for missing comment", "entry_id", entry.EntryID, "comment_id", entry.CommentID)
+		return nil
+	}
+	comment := found.Comments[0]
+
+	comment.ResolveStatus()
+	if comment.Status != conduit.StatusPending {
+		logger.Info("dropping notification for moderated comment", "entry_id", entry.EntryID, "comment_id", entry.CommentID, "status", comment.Status)
+		return nil
+	}
+
+	notification := notify.Notification{
+		Recipient:   entry.Recipient,
+		Comment:     comment,
+		CommentLink: s.adminLinks.CommentLink(&comment),
+		PendingLink: s.adminLinks.PendingLink(comment.SiteID),
+	}
+
+	if s.Config.PublicBaseURL != "" {
+		links, err := s.moderationLinks(&comment, entry.Recipient, time.Now())
+		if err != nil {
+			return err
+		}
+		notification.ApproveLink = links[ActionApprove]
+		notification.RejectLink = links[ActionReject]
+		notification.SpamLink = links[ActionSpam]
+	}
+
+	if err := s.notifier.Notify(ctx, notification); err != nil {
+		return err
+	}
+
+	logger.Info("sent notification", "entry_id", entry.EntryID, "to", entry.Recipient)
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/google/uuid"
+)
+
+const (
+	// How often the worker looks for due entries when nothing wakes it.
+	outboxPollInterval = 15 * time.Second
+
+	// Retries back off from outboxBaseDelay, doubling each time up to
+	// outboxMaxDelay. After outboxMaxAttempts the entry is dead and stays
+	// in the outbox until an admin retries it.
+	outboxBaseDelay   = 30 * time.Second
+	outboxMaxDelay    = 6 * time.Hour
+	outboxMaxAttempts = 8
+)
+
+// outboxBackoff is the delay after the given number of failed attempts.
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= outboxMaxDelay {
+			return outboxMaxDelay
+		}
+	}
+	return delay
+}
+
+// wakeOutbox asks the worker to look at the outbox now rather than at the
+// next poll.
+func (s *Server) wakeOutbox() {
+	select {
+	case s.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutbox delivers queued notifications until ctx is done. Only one worker
+// should run per outbox; a second process would send some messages twice.
+func (s *Server) RunOutbox(ctx context.Context) {
+	logger := conduit.GetLogger(ctx).With("worker", "outbox")
+	ctx = conduit.WithLogger(ctx, logger)
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.processOutbox(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.outboxWake:
+		}
+	}
+}
+
+// processOutbox makes one attempt at every pending entry that is due.
+func (s *Server) processOutbox(ctx context.Context, now time.Time) {
+	logger := conduit.GetLogger(ctx)
+
+	status := conduit.OutboxPending
+	entries, err := s.outbox.OutboxEntries(ctx, conduit.OutboxFilter{Status: &status, DueBy: &now})
+	if err != nil {
+		logger.Error("failed to read outbox", "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		s.attemptOutboxEntry(ctx, entry, now)
+	}
+}
+
+func (s *Server) attemptOutboxEntry(ctx context.Context, entry conduit.OutboxEntry, now time.Time) {
+	logger := conduit.GetLogger(ctx).With("entry_id", entry.EntryID, "kind", entry.Kind)
+	ctx = conduit.WithLogger(ctx, logger)
+
+	err := s.deliver(ctx, entry)
+	if err == nil {
+		if err := s.outbox.DeleteOutboxEntry(ctx, entry.EntryID); err != nil {
+			// It will be sent again, which is better than not at all.
+			logger.Error("failed to remove delivered entry", "error", err)
+		}
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+
+	if entry.Attempts >= outboxMaxAttempts {
+		entry.Status = conduit.OutboxDead
+		logger.Error("giving up on notification", "attempts", entry.Attempts, "error", err)
+	} else {
+		entry.NextAttempt = conduit.Timestamp(now.Add(outboxBackoff(entry.Attempts)))
+		logger.Warn("notification failed, will retry", "attempts", entry.Attempts, "next_attempt", time.Time(entry.NextAttempt), "error", err)
+	}
+
+	if err := s.outbox.PutOutboxEntry(ctx, entry); err != nil {
+		logger.Error("failed to update outbox entry", "error", err)
+	}
+}
+
+func (s *Server) deliver(ctx context.Context, entry conduit.OutboxEntry) error {
+	switch entry.Kind {
+	case conduit.OutboxNewComment:
+		return s.deliverNewComment(ctx, entry)
+	case conduit.OutboxConfirmSubscription:
+		return s.deliverSubscriptionConfirmation(ctx, entry)
+	case conduit.OutboxCommentOnPost:
+		return s.deliverCommentOnPost(ctx, entry)
+	case conduit.OutboxDigest:
+		return s.deliverDigest(ctx, entry)
+	default:
+		return fmt.Errorf("unknown outbox entry kind %q", entry.Kind)
+	}
+}
+
+func (s *Server) listOutbox() http.HandlerFunc {
+	type Input struct {
+		Status conduit.OutboxStatus `json:"status"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "listOutbox")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		var filter conduit.OutboxFilter
+		switch input.Status {
+		case "":
+		case conduit.OutboxPending, conduit.OutboxDead:
+			filter.Status = &input.Status
+		default:
+			// TODO add to conduit/errors.go
+			http.Error(w, "unknown status", http.StatusBadRequest)
+			return
+		}
+
+		entries, err := s.outbox.OutboxEntries(ctx, filter)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to list outbox", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(ctx, w, http.StatusOK, M{"entries": entries})
+	}
+}
+
+func (s *Server) retryOutboxEntry() http.HandlerFunc {
+	type Input struct {
+		EntryID string `json:"entryID"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "retryOutboxEntry")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if input.EntryID == "" {
+			// TODO add to conduit/errors.go
+			http.Error(w, "need entryID", http.StatusBadRequest)
+			return
+		}
+
+		entries, err := s.outbox.OutboxEntries(ctx, conduit.OutboxFilter{EntryID: &input.EntryID})
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to read outbox", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Unknown entry", http.StatusNotFound)
+			return
+		}
+		entry := entries[0]
+
+		// A fresh start, including the full number of attempts.
+		entry.Status = conduit.OutboxPending
+		entry.Attempts = 0
+		entry.NextAttempt = conduit.Timestamp(time.Now())
+
+		if err := s.outbox.PutOutboxEntry(ctx, entry); err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to update outbox entry", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("retrying outbox entry", "entry_id", entry.EntryID, "moderator", contextUser(r))
+
+		s.wakeOutbox()
+
+		writeJSON(ctx, w, http.StatusOK, entry)
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/notify"
+)
+
+// flakyNotifier fails its first fails sends.
+type flakyNotifier struct {
+	mtx      sync.Mutex
+	fails    int
+	attempts int
+	sent     []notify.Notification
+}
+
+func (fn *flakyNotifier) Notify(ctx context.Context, n notify.Notification) error {
+	fn.mtx.Lock()
+	defer fn.mtx.Unlock()
+
+	fn.attempts++
+	if fn.fails > 0 {
+		fn.fails--
+		return errors.New("mail server unavailable")
+	}
+	fn.sent = append(fn.sent, n)
+	return nil
+}
+
+func (fn *flakyNotifier) NotifyDigest(ctx context.Context, d notify.Digest) error {
+	return errors.New("no digests here")
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{8, 64 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// queueNewComment stores a pending comment and queues the moderator's
+// notification about it.
+func queueNewComment(t *testing.T, s *Server) conduit.OutboxEntry {
+	t.Helper()
+	ctx := conduittest.Context()
+
+	comment := conduit.Comment{
+		SiteID:      "example.com",
+		PostID:      "/post/",
+		CommentID:   "1",
+		Author:      "Someone",
+		CommentBody: "Hello",
+		Timestamp:   conduit.Timestamp(time.Now()),
+		Status:      conduit.StatusPending,
+	}
+	if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+		t.Fatal(err)
+	}
+	s.notifyNewComment(ctx, &comment)
+
+	entries := outboxEntries(t, s, conduit.OutboxNewComment)
+	if len(entries) != 1 {
+		t.Fatalf("queued %+v", entries)
+	}
+	return entries[0]
+}
+
+func TestOutboxRetriesUntilSent(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+	notifier := &flakyNotifier{fails: 2}
+	s.notifier = notifier
+
+	entry := queueNewComment(t, s)
+	now := time.Time(entry.NextAttempt)
+
+	s.processOutbox(ctx, now)
+	entry = outboxEntries(t, s, conduit.OutboxNewComment)[0]
+	if entry.Attempts != 1 || entry.Status != conduit.OutboxPending || !strings.Contains(entry.LastError, "unavailable") ||
+		!time.Time(entry.NextAttempt).Equal(now.Add(30*time.Second)) {
+		t.Fatalf("after one failure: %+v", entry)
+	}
+
+	// Nothing is tried before it's due.
+	s.processOutbox(ctx, now.Add(29*time.Second))
+	if notifier.attempts != 1 {
+		t.Fatalf("tried %d times before the backoff was up", notifier.attempts)
+	}
+
+	s.processOutbox(ctx, now.Add(30*time.Second))
+	entry = outboxEntries(t, s, conduit.OutboxNewComment)[0]
+	if entry.Attempts != 2 || !time.Time(entry.NextAttempt).Equal(now.Add(90*time.Second)) {
+		t.Fatalf("after two failures: %+v", entry)
+	}
+
+	// The third attempt gets through, and the entry goes.
+	s.processOutbox(ctx, now.Add(90*time.Second))
+	if len(notifier.sent) != 1 || notifier.sent[0].Comment.CommentID != "1" || notifier.sent[0].Recipient != "admin@example.com" {
+		t.Fatalf("sent %+v", notifier.sent)
+	}
+	if entries := outboxEntries(t, s, conduit.OutboxNewComment); len(entries) != 0 {
+		t.Fatalf("delivered entry left in the outbox: %+v", entries)
+	}
+}
+
+func TestOutboxDeadLetter(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+	notifier := &flakyNotifier{fails: outboxMaxAttempts}
+	s.notifier = notifier
+
+	entry := queueNewComment(t, s)
+	now := time.Time(entry.NextAttempt)
+
+	for attempt := 1; attempt <= outboxMaxAttempts; attempt++ {
+		s.processOutbox(ctx, now)
+		entry = outboxEntries(t, s, conduit.OutboxNewComment)[0]
+		if entry.Attempts != attempt {
+			t.Fatalf("attempt %d: %+v", attempt, entry)
+		}
+		if attempt < outboxMaxAttempts {
+			if entry.Status != conduit.OutboxPending || !time.Time(entry.NextAttempt).Equal(now.Add(outboxBackoff(attempt))) {
+				t.Fatalf("attempt %d: %+v", attempt, entry)
+			}
+			now = time.Time(entry.NextAttempt)
+		}
+	}
+
+	if entry.Status != conduit.OutboxDead {
+		t.Fatalf("after %d attempts: %+v", outboxMaxAttempts, entry)
+	}
+
+	// A dead entry is left alone.
+	s.processOutbox(ctx, now.Add(24*time.Hour))
+	if notifier.attempts != outboxMaxAttempts {
+		t.Fatalf("tried a dead entry: %d attempts", notifier.attempts)
+	}
+
+	retry := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/admin/outbox/retry", strings.NewReader(body))
+		r = setContextUser(r, "admin@example.com")
+		w := httptest.NewRecorder()
+		s.retryOutboxEntry()(w, r)
+		return w
+	}
+
+	if w := retry(`{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("no entryID: got %d", w.Code)
+	}
+	if w := retry(`{"entryID": "missing"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown entry: got %d", w.Code)
+	}
+
+	// An admin's retry starts it over, and wakes the worker.
+	if w := retry(`{"entryID": "` + entry.EntryID + `"}`); w.Code != http.StatusOK {
+		t.Fatalf("retry: got %d %s", w.Code, w.Body)
+	}
+	entry = outboxEntries(t, s, conduit.OutboxNewComment)[0]
+	if entry.Status != conduit.OutboxPending || entry.Attempts != 0 || time.Since(time.Time(entry.NextAttempt)) > time.Minute {
+		t.Fatalf("after the retry: %+v", entry)
+	}
+	select {
+	case <-s.outboxWake:
+	default:
+		t.Fatal("the worker wasn't woken")
+	}
+
+	s.processOutbox(ctx, time.Now())
+	if len(notifier.sent) != 1 {
+		t.Fatalf("sent %d after the retry", len(notifier.sent))
+	}
+	if entries := outboxEntries(t, s, conduit.OutboxNewComment); len(entries) != 0 {
+		t.Fatalf("delivered entry left in the outbox: %+v", entries)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/google/uuid"
+)
+
+// Admin endpoints for the known posts. All take a JSON body with the siteID,
+// and postID where it applies.
+type postInput struct {
+	SiteID string `json:"siteID"`
+	PostID string `json:"postID"`
+}
+
+func (s *Server) listKnownPosts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "listKnownPosts")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input postInput
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if input.SiteID == "" {
+			// TODO add to conduit/errors.go
+			http.Error(w, "need siteID", http.StatusBadRequest)
+			return
+		}
+
+		posts, err := s.postRegistry.KnownPosts(ctx, input.SiteID)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to list known posts", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		sort.Slice(posts, func(i, j int) bool {
+			return posts[i].PostID < posts[j].PostID
+		})
+
+		writeJSON(ctx, w, http.StatusOK, M{"posts": posts})
+	}
+}
+
+func (s *Server) addKnownPost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "addKnownPost")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input postInput
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if _, ok := s.Config.Site(input.SiteID); !ok {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Unknown host", http.StatusBadRequest)
+			return
+		}
+
+		if !conduit.IsValidPostID(input.PostID) {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Invalid key", http.StatusBadRequest)
+			return
+		}
+
+		post := conduit.KnownPost{
+			SiteID:       input.SiteID,
+			PostID:       input.PostID,
+			DiscoveredAt: conduit.Timestamp(time.Now()),
+			Source:       conduit.PostSourceAdmin,
+		}
+
+		if err := s.SetKnown(ctx, post); err != nil {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (s *Server) removeKnownPost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "removeKnownPost")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input postInput
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if input.SiteID == "" || input.PostID == "" {
+			// TODO add to conduit/errors.go
+			http.Error(w, "need siteID and postID", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.RemoveKnown(ctx, input.SiteID, input.PostID); err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to remove known post", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// rescanKnownPosts runs discovery now rather than waiting for the updater.
+func (s *Server) rescanKnownPosts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "rescanKnownPosts")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input postInput
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if _, ok := s.Config.Site(input.SiteID); !ok {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Unknown host", http.StatusBadRequest)
+			return
+		}
+
+		// Whatever was found is kept even if some sources failed.
+		err := s.InitHost(ctx, input.SiteID)
+
+		posts, listErr := s.postRegistry.KnownPosts(ctx, input.SiteID)
+		if listErr != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to list known posts", "error", listErr)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		resp := M{"count": len(posts)}
+		if err != nil {
+			resp["error"] = err.Error()
+		}
+
+		writeJSON(ctx, w, http.StatusOK, resp)
+	}
+}
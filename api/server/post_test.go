@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAddKnownPost(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"siteID": "example.com", "postID": "/2024/01/01/a-post"}`, http.StatusCreated},
+		{`{"siteID": "example.com", "postID": ""}`, http.StatusBadRequest},
+		{`{"siteID": "example.com"}`, http.StatusBadRequest},
+		{`{"siteID": "example.com", "postID": "/a post"}`, http.StatusBadRequest},
+		{`{"siteID": "elsewhere.example", "postID": "/2024/01/01/a-post"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.addKnownPost()(w, httptest.NewRequest(http.MethodPost, "/v1/admin/posts/new", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.body, w.Code, tt.want)
+		}
+	}
+
+	if s.IsKnown("example.com", "") {
+		t.Error("the empty post ID is known")
+	}
+	if !s.IsKnown("example.com", "/2024/01/01/a-post") {
+		t.Error("the post isn't known")
+	}
+}
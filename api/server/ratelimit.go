@@ -0,0 +1,139 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/config"
+
+	"golang.org/x/time/rate"
+)
+
+// limitClass is a kind of request with a rate limit of its own; see
+// config.RateLimits.
+type limitClass string
+
+const (
+	limitRead   limitClass = "read"
+	limitSubmit limitClass = "submit"
+	limitLogin  limitClass = "login"
+)
+
+// keyedLimiter keeps a token bucket per client. Buckets that haven't been
+// used for idle are dropped, which is the same as refilling them, so the
+// map only holds recent clients.
+type keyedLimiter struct {
+	limit config.RateLimit
+	idle  time.Duration
+
+	mtx       sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(limit config.RateLimit, idle time.Duration) *keyedLimiter {
+	return &keyedLimiter{
+		limit:   limit,
+		idle:    idle,
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+// limitResult is what a client is told about its limit.
+type limitResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the next request is allowed, if this one wasn't
+}
+
+func (kl *keyedLimiter) allow(key string, now time.Time) limitResult {
+	kl.mtx.Lock()
+	defer kl.mtx.Unlock()
+
+	if now.Sub(kl.lastSweep) > kl.idle {
+		for k, client := range kl.clients {
+			if now.Sub(client.lastSeen) > kl.idle {
+				delete(kl.clients, k)
+			}
+		}
+		kl.lastSweep = now
+	}
+
+	client, ok := kl.clients[key]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(kl.limit.Rate), kl.limit.Burst)}
+		kl.clients[key] = client
+	}
+	client.lastSeen = now
+
+	var result limitResult
+
+	reservation := client.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		result.retryAfter = delay
+	} else {
+		result.allowed = true
+	}
+
+	tokens := client.limiter.TokensAt(now)
+	result.remaining = max(int(tokens), 0)
+	result.reset = time.Duration((float64(kl.limit.Burst) - tokens) / kl.limit.Rate * float64(time.Second))
+
+	return result
+}
+
+// clientKey is who a request counts against: its client address, with
+// IPv6 clients grouped by /64, since that is what one subscriber usually
+// gets.
+func clientKey(r *http.Request) string {
+	ip := getClientIP(r)
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() {
+		return ip
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.String()
+}
+
+// seconds rounds up, so that a client that waits as long as it's told
+// isn't refused again.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimit limits each client to the class's rate. Responses say how much
+// of the limit is left in the RateLimit-* headers of the IETF draft, and
+// refusals say when to try again in Retry-After.
+func (s *Server) rateLimit(class limitClass) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			kl := s.limiters[class]
+			result := kl.allow(clientKey(r), time.Now())
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(kl.limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+			w.Header().Set("RateLimit-Reset", seconds(result.reset))
+
+			if !result.allowed {
+				s.Logger.Info("rate limited", "class", class, "client", clientKey(r), "path", r.URL.Path)
+				w.Header().Set("Retry-After", seconds(result.retryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/config"
+)
+
+func TestKeyedLimiterAllow(t *testing.T) {
+	kl := newKeyedLimiter(config.RateLimit{Rate: 1, Burst: 2}, 10*time.Minute)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		key       string
+		after     time.Duration
+		allowed   bool
+		remaining int
+		reset     time.Duration
+		retry     time.Duration
+	}{
+		{"first", "a", 0, true, 1, time.Second, 0},
+		{"burst", "a", 0, true, 0, 2 * time.Second, 0},
+		{"over", "a", 0, false, 0, 2 * time.Second, time.Second},
+		{"another key has its own bucket", "b", 0, true, 1, time.Second, 0},
+		{"still over", "a", 500 * time.Millisecond, false, 0, 1500 * time.Millisecond, 500 * time.Millisecond},
+		{"refilled by one", "a", time.Second, true, 0, 2 * time.Second, 0},
+		{"refilled completely", "a", time.Minute, true, 1, time.Second, 0},
+	}
+
+	for _, tt := range tests {
+		result := kl.allow(tt.key, start.Add(tt.after))
+		if result.allowed != tt.allowed || result.remaining != tt.remaining ||
+			result.reset.Round(time.Millisecond) != tt.reset || result.retryAfter.Round(time.Millisecond) != tt.retry {
+			t.Errorf("%s: got %+v", tt.name, result)
+		}
+	}
+}
+
+func TestKeyedLimiterEvictsIdleClients(t *testing.T) {
+	idle := 10 * time.Minute
+	kl := newKeyedLimiter(config.RateLimit{Rate: 0.001, Burst: 1}, idle)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	kl.allow("a", start)
+	if result := kl.allow("a", start.Add(idle/2)); result.allowed {
+		t.Fatal("a was allowed twice in a row")
+	}
+	kl.allow("b", start.Add(idle))
+
+	// a was last seen at idle/2, so a sweep just after 1.5 idle drops it but
+	// keeps b, seen later.
+	kl.allow("c", start.Add(3*idle/2+time.Second))
+	kl.mtx.Lock()
+	_, hasA := kl.clients["a"]
+	_, hasB := kl.clients["b"]
+	nr := len(kl.clients)
+	kl.mtx.Unlock()
+	if hasA || !hasB || nr != 2 {
+		t.Fatalf("after the sweep: a %v, b %v, %d clients", hasA, hasB, nr)
+	}
+
+	// Forgetting a client is the same as refilling its bucket.
+	if result := kl.allow("a", start.Add(3*idle/2+time.Second)); !result.allowed {
+		t.Fatal("a forgotten client was refused")
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	key := func(remote string) string {
+		var got string
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		s.resolveClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = clientKey(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		return got
+	}
+
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"192.0.2.1:1234", "192.0.2.1"},
+		{"[::ffff:192.0.2.1]:1234", "192.0.2.1"},
+		{"[2001:db8:1:2::1]:1234", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:ffff:eeee:dddd:cccc]:1234", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:3::1]:1234", "2001:db8:1:3::/64"},
+	}
+
+	for _, tt := range tests {
+		if got := key(tt.remote); got != tt.want {
+			t.Errorf("clientKey(%s) = %s, want %s", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.limiters = map[limitClass]*keyedLimiter{
+		limitRead:   newKeyedLimiter(config.RateLimit{Rate: 1.0 / 60, Burst: 1}, time.Hour),
+		limitSubmit: newKeyedLimiter(config.RateLimit{Rate: 1.0 / 60, Burst: 1}, time.Hour),
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	get := func(class limitClass, remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		s.resolveClientIP(s.rateLimit(class)(ok)).ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(limitSubmit, "[2001:db8::1]:1000")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "1" ||
+		w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Reset") != "60" || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("first: %d %v", w.Code, w.Header())
+	}
+
+	// The same /64 shares the bucket.
+	w = get(limitSubmit, "[2001:db8::2]:2000")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" || w.Header().Get("RateLimit-Limit") != "1" ||
+		w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Reset") != "60" {
+		t.Fatalf("second: %d %v", w.Code, w.Header())
+	}
+
+	// Other classes and other clients have buckets of their own.
+	if w := get(limitRead, "[2001:db8::1]:1000"); w.Code != http.StatusOK {
+		t.Fatalf("read: %d", w.Code)
+	}
+	if w := get(limitSubmit, "[2001:db8:0:1::1]:1000"); w.Code != http.StatusOK {
+		t.Fatalf("another /64: %d", w.Code)
+	}
+	if w := get(limitSubmit, "192.0.2.1:1000"); w.Code != http.StatusOK {
+		t.Fatalf("IPv4 client: %d", w.Code)
+	}
+}
@@ -25,36 +25,98 @@ func (l *printfLogger) Printf(format string, args ...interface{}) {
 func (s *Server) routes() {
 	cors := cors.New(cors.Options{
 		AllowCredentials: true,
-		AllowedOrigins:   s.Config.CorsAllowedOrigins,
+		AllowedOrigins:   s.Config.AllowedOrigins(),
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		// AllowedHeaders: []string{"Content-Type", "Authorization"},
 		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{nextCursorHeader, "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
 		Logger:         &printfLogger{slog: s.Logger},
 	})
 	s.router.Use(cors.Handler)
-	s.router.Use(s.limitMiddleware)
+	s.router.Use(s.resolveClientIP)
 	s.router.Use(Logger(s.Logger))
 
 	v1 := s.router.PathPrefix("/v1").Subrouter()
 
+	// Each group of routes below has its own rate limit; see ratelimit.go.
+	submissions := v1.PathPrefix("").Subrouter()
+	submissions.Use(s.rateLimit(limitSubmit))
+	{
+		// Need OPTIONS here otherwise the cors handler won't match anything!
+		submissions.Handle("/comments/new", s.createComment()).Methods("POST", "OPTIONS")
+		submissions.Handle("/webmention", s.receiveWebmention()).Methods("POST", "OPTIONS")
+	}
+
 	noAuth := v1.PathPrefix("").Subrouter()
+	noAuth.Use(s.rateLimit(limitRead))
 	{
 		noAuth.Handle("/health", s.healthCheck()).Methods("GET") // FIXME healthz as per convention; kubernetes config change too
 
-		// Need OPTIONS here otherwise the cors handler won't match anything!
-		noAuth.Handle("/comments/new", s.createComment()).Methods("POST", "OPTIONS")
 		noAuth.Handle("/comments", s.getComments(true, ActiveOnly)).Methods("POST", "OPTIONS")
+		noAuth.Handle("/comments/counts", s.getCommentCounts()).Methods("POST", "OPTIONS")
+
+		noAuth.Handle("/feeds/atom", s.getFeed(FeedAtom)).Methods("GET", "HEAD")
+		noAuth.Handle("/feeds/rss", s.getFeed(FeedRSS)).Methods("GET", "HEAD")
+
+		// The token is the authentication; see modlink.go.
+		noAuth.Handle("/moderate", s.confirmModerationLink()).Methods("GET")
+		noAuth.Handle("/moderate", s.applyModerationLink()).Methods("POST")
+		noAuth.Handle("/subscriptions/confirm", s.confirmSubscriptionLink(subscriptionConfirm)).Methods("GET")
+		noAuth.Handle("/subscriptions/confirm", s.applySubscriptionLink(subscriptionConfirm)).Methods("POST")
+		noAuth.Handle("/subscriptions/unsubscribe", s.confirmSubscriptionLink(subscriptionUnsubscribe)).Methods("GET")
+		noAuth.Handle("/subscriptions/unsubscribe", s.applySubscriptionLink(subscriptionUnsubscribe)).Methods("POST")
 	}
 
-	admin := v1.PathPrefix("/admin").Subrouter()
+	login := v1.PathPrefix("/admin/login").Subrouter()
+	login.Use(s.rateLimit(limitLogin))
 	{
-		admin.Handle("/login", s.loginUser()).Methods("POST", "OPTIONS")
+		login.Handle("", s.loginUser()).Methods("POST", "OPTIONS")
 	}
 
+	admin := v1.PathPrefix("/admin").Subrouter()
+	admin.Use(s.rateLimit(limitRead))
+
 	comments := admin.PathPrefix("/comments").Subrouter()
 	comments.Use(s.authenticate())
 	{
 		comments.Handle("/new", s.upsertComment()).Methods("POST", "OPTIONS")
+		comments.Handle("/approve", s.moderateComment(ActionApprove)).Methods("POST", "OPTIONS")
+		comments.Handle("/reject", s.moderateComment(ActionReject)).Methods("POST", "OPTIONS")
+		comments.Handle("/spam", s.moderateComment(ActionSpam)).Methods("POST", "OPTIONS")
+		comments.Handle("/delete", s.moderateComment(ActionDelete)).Methods("POST", "OPTIONS")
+		comments.Handle("/bulk", s.bulkModerate()).Methods("POST", "OPTIONS")
 		comments.Handle("", s.getComments(false, FreeRange)).Methods("POST", "OPTIONS")
 	}
+
+	posts := admin.PathPrefix("/posts").Subrouter()
+	posts.Use(s.authenticate())
+	{
+		posts.Handle("", s.listKnownPosts()).Methods("POST", "OPTIONS")
+		posts.Handle("/new", s.addKnownPost()).Methods("POST", "OPTIONS")
+		posts.Handle("/delete", s.removeKnownPost()).Methods("POST", "OPTIONS")
+		posts.Handle("/rescan", s.rescanKnownPosts()).Methods("POST", "OPTIONS")
+	}
+
+	outbox := admin.PathPrefix("/outbox").Subrouter()
+	outbox.Use(s.authenticate())
+	{
+		outbox.Handle("", s.listOutbox()).Methods("POST", "OPTIONS")
+		outbox.Handle("/retry", s.retryOutboxEntry()).Methods("POST", "OPTIONS")
+	}
+
+	spamModel := admin.PathPrefix("/spam/model").Subrouter()
+	spamModel.Use(s.authenticate())
+	{
+		spamModel.Handle("", s.getSpamModel()).Methods("POST", "OPTIONS")
+		spamModel.Handle("/reset", s.resetSpamModel()).Methods("POST", "OPTIONS")
+	}
+
+	addresses := admin.PathPrefix("/addresses").Subrouter()
+	addresses.Use(s.authenticate())
+	{
+		addresses.Handle("", s.listAddressRules()).Methods("POST", "OPTIONS")
+		addresses.Handle("/new", s.addAddressRule()).Methods("POST", "OPTIONS")
+		addresses.Handle("/delete", s.removeAddressRule()).Methods("POST", "OPTIONS")
+		addresses.Handle("/ban", s.banCommenter()).Methods("POST", "OPTIONS")
+	}
 }
@@ -3,51 +3,104 @@ package server
 import (
 	"context"
 	"log/slog"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/carlohamalainen/carlo-comments/captcha"
 	"github.com/carlohamalainen/carlo-comments/conduit"
 	"github.com/carlohamalainen/carlo-comments/config"
-	"github.com/carlohamalainen/carlo-comments/dynamodb"
+	"github.com/carlohamalainen/carlo-comments/modlink"
+	"github.com/carlohamalainen/carlo-comments/notify"
+	"github.com/carlohamalainen/carlo-comments/proxyproto"
 	"github.com/carlohamalainen/carlo-comments/simple"
+	"github.com/carlohamalainen/carlo-comments/spam"
+	"github.com/carlohamalainen/carlo-comments/webmention"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"golang.org/x/time/rate"
 )
 
 // TODO import/export for admin
 
-func (s *Server) limitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !s.limiter.Allow() {
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 type Server struct {
 	server *http.Server
 
-	router  *mux.Router
-	limiter *rate.Limiter
+	router *mux.Router
+
+	// Per client, by class of request; see ratelimit.go.
+	limiters map[limitClass]*keyedLimiter
 
 	Config config.Config
 
 	UserService    conduit.UserService
 	commentService conduit.CommentService
+	postRegistry   conduit.PostRegistry
+	adminLinks     conduit.AdminLinks
+	outbox         conduit.Outbox
+	usedTokens     conduit.UsedTokens
+	subscriptions  conduit.SubscriptionStore
+
+	notifier notify.Notifier
+
+	// Writes to subscribers; nil if the notifier can't send email.
+	mailer notify.Mailer
+
+	modLinks *modlink.Signer
+
+	// SpamChecks score every new comment; see spam.go. More checks can be
+	// appended before the server starts.
+	SpamChecks spam.Chain
+
+	// One of SpamChecks, told about addresses that send spam.
+	badIPs *spam.BadIP
+
+	// One of SpamChecks, taught by every moderation decision.
+	classifier *spam.Classifier
+
+	// SiteID -> the site's captcha; see captcha.go.
+	captchas map[string]captcha.Verifier
+
+	// Blocked and allowed networks; see address.go.
+	addressRules *addressRules
+
+	// Clock is what the digests go by; see digest.go.
+	Clock func() time.Time
+
+	// The end of the last digest period, only touched by SendDueDigests.
+	lastDigest time.Time
+
+	// Signals RunOutbox that there is something new; see outbox.go.
+	outboxWake chan struct{}
 
 	logLevel slog.Level
 
 	Logger *slog.Logger
 
+	// MentionClient fetches webmention sources in the background; see
+	// webmention.go. The default refuses to connect to private addresses, so
+	// it can be replaced before the server starts to reach a local source.
+	MentionClient *http.Client
+	mentionSlots  chan struct{}
+
+	// A cache of postRegistry, checked on every new comment.
 	mtx        sync.Mutex
 	knownPosts map[string](map[string]bool)
 }
 
+// Stores are the parts of a storage backend that the server uses.
+type Stores struct {
+	Comments      conduit.CommentService
+	Posts         conduit.PostRegistry
+	Links         conduit.AdminLinks
+	Outbox        conduit.Outbox
+	Tokens        conduit.UsedTokens
+	Subscriptions conduit.SubscriptionStore
+	SpamModels    conduit.SpamModelStore
+	Addresses     conduit.AddressRuleStore
+}
+
 func (s *Server) InitState() {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -82,22 +135,79 @@ func (s *Server) IsKnown(site_id string, post_id string) bool {
 	return ok
 }
 
-func (s *Server) SetKnown(ctx context.Context, site_id string, post_id string) {
+// cacheKnown must be called with mtx held.
+func (s *Server) cacheKnown(site_id string, post_id string) {
+	_, ok := s.knownPosts[site_id]
+	if !ok {
+		s.knownPosts[site_id] = make(map[string]bool)
+	}
+	s.knownPosts[site_id][post_id] = true
+}
+
+// SetKnown records a post in the registry, unless it is already known.
+func (s *Server) SetKnown(ctx context.Context, post conduit.KnownPost) error {
 	logger := conduit.GetLogger(ctx)
 
+	if s.IsKnown(post.SiteID, post.PostID) {
+		return nil
+	}
+
+	added, err := s.postRegistry.AddKnownPost(ctx, post)
+	if err != nil {
+		logger.Error("failed to add known post", "site_id", post.SiteID, "post_id", post.PostID, "error", err)
+		return err
+	}
+
+	if added {
+		logger.Info("setting known post", "site_id", post.SiteID, "post_id", post.PostID, "source", post.Source)
+	}
+
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
+	s.cacheKnown(post.SiteID, post.PostID)
 
-	_, ok := s.knownPosts[site_id]
-	if !ok {
-		s.knownPosts[site_id] = make(map[string]bool)
+	return nil
+}
+
+// RemoveKnown stops a post from receiving comments. Discovery adds it back if
+// it is still listed on the site.
+func (s *Server) RemoveKnown(ctx context.Context, site_id string, post_id string) error {
+	if err := s.postRegistry.RemoveKnownPost(ctx, site_id, post_id); err != nil {
+		return err
 	}
 
-	logger.Info("setting known host", "site_id", site_id, "post_id", post_id)
-	s.knownPosts[site_id][post_id] = true
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.knownPosts[site_id], post_id)
+
+	return nil
+}
+
+// LoadKnown fills the cache from the registry, so that comments are accepted
+// straight after a restart without waiting for discovery.
+func (s *Server) LoadKnown(ctx context.Context) error {
+	logger := conduit.GetLogger(ctx)
+
+	for _, site := range s.Config.Sites {
+		posts, err := s.postRegistry.KnownPosts(ctx, site.SiteID)
+		if err != nil {
+			logger.Error("failed to load known posts", "site_id", site.SiteID, "error", err)
+			return err
+		}
+
+		s.mtx.Lock()
+		for _, post := range posts {
+			s.cacheKnown(post.SiteID, post.PostID)
+		}
+		s.mtx.Unlock()
+
+		logger.Info("loaded known posts", "site_id", site.SiteID, "count", len(posts))
+	}
+
+	return nil
 }
 
-func NewServer(ctx context.Context, db *dynamodb.DB, cfg config.Config) *Server {
+func NewServer(ctx context.Context, stores Stores, notifier notify.Notifier, cfg config.Config) *Server {
 	logger := conduit.GetLogger(ctx)
 
 	s := Server{
@@ -109,12 +219,23 @@ func NewServer(ctx context.Context, db *dynamodb.DB, cfg config.Config) *Server
 
 		router: mux.NewRouter().StrictSlash(true),
 
-		limiter:  rate.NewLimiter(rate.Limit(cfg.LimiterRate), cfg.LimiterBurst),
+		limiters: map[limitClass]*keyedLimiter{
+			limitRead:   newKeyedLimiter(cfg.RateLimits.Read, cfg.RateLimits.Idle),
+			limitSubmit: newKeyedLimiter(cfg.RateLimits.Submit, cfg.RateLimits.Idle),
+			limitLogin:  newKeyedLimiter(cfg.RateLimits.Login, cfg.RateLimits.Idle),
+		},
 		logLevel: cfg.LogLevel,
 
 		Logger: logger,
 
 		Config: cfg,
+
+		MentionClient: webmention.NewClient(mentionTimeout),
+		mentionSlots:  make(chan struct{}, maxMentionWorkers),
+
+		outboxWake: make(chan struct{}, 1),
+
+		Clock: time.Now,
 	}
 
 	s.routes()
@@ -137,7 +258,35 @@ func NewServer(ctx context.Context, db *dynamodb.DB, cfg config.Config) *Server
 	}
 
 	s.UserService = simple.NewUserService(s.Config.HmacSecret)
-	s.commentService = dynamodb.NewCommentService(db, cfg.DynamoDBRegion, cfg.DynamoDBTableName)
+	s.commentService = stores.Comments
+	s.postRegistry = stores.Posts
+	s.adminLinks = stores.Links
+	s.outbox = stores.Outbox
+	s.usedTokens = stores.Tokens
+	s.modLinks = modlink.NewSigner(cfg.HmacSecret)
+	s.notifier = notifier
+	s.subscriptions = stores.Subscriptions
+	s.addressRules = newAddressRules(stores.Addresses)
+
+	captchaClient := captcha.NewClient(cfg.CaptchaTimeout)
+	s.captchas = make(map[string]captcha.Verifier)
+	for _, site := range cfg.Sites {
+		s.captchas[site.SiteID] = newVerifier(site.Captcha, captchaClient)
+	}
+
+	s.badIPs = spam.NewBadIP(cfg.Spam.BadIPs)
+	s.classifier = spam.NewClassifier(stores.SpamModels)
+	s.SpamChecks = spam.Chain{
+		spam.LinkCount{Max: cfg.Spam.MaxLinks},
+		spam.NewBlockedWords(cfg.Spam.BlockedWords),
+		spam.NewDuplicateBody(cfg.Spam.DuplicateWindow),
+		s.badIPs,
+		s.classifier,
+	}
+
+	if mailer, ok := notifier.(notify.Mailer); ok {
+		s.mailer = mailer
+	}
 
 	// Maybe State should be a conduit as well, with an in-memory thing...
 	s.InitState()
@@ -152,9 +301,19 @@ func (s *Server) Run(ctx context.Context, port string) error {
 
 	s.server.Addr = port
 
-	logger.Info("starting server", "addr", s.server.Addr)
+	listener, err := net.Listen("tcp", port)
+	if err != nil {
+		logger.Error("failed to listen", "addr", port, "error", err)
+		return err
+	}
+
+	if s.Config.ProxyProtocol {
+		listener = proxyproto.NewListener(listener, s.Config.TrustedProxies)
+	}
+
+	logger.Info("starting server", "addr", s.server.Addr, "proxy_protocol", s.Config.ProxyProtocol, "trusted_proxies", s.Config.TrustedProxies)
 
-	return s.server.ListenAndServe()
+	return s.server.Serve(listener)
 }
 
 func (s *Server) healthCheck() http.Handler {
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/memory"
+	"github.com/carlohamalainen/carlo-comments/notify"
+)
+
+// recorder is a notifier and mailer that keeps what it is asked to send.
+type recorder struct {
+	mtx           sync.Mutex
+	notifications []notify.Notification
+	digests       []notify.Digest
+	emails        []notify.Email
+}
+
+func (r *recorder) Notify(ctx context.Context, n notify.Notification) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+func (r *recorder) NotifyDigest(ctx context.Context, d notify.Digest) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.digests = append(r.digests, d)
+	return nil
+}
+
+func (r *recorder) Send(ctx context.Context, email notify.Email) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.emails = append(r.emails, email)
+	return nil
+}
+
+// newTestServer is a server on the in-memory backend for one site,
+// example.com, that emails subscribers.
+func newTestServer(t *testing.T) (*Server, *recorder) {
+	t.Helper()
+
+	ctx := conduittest.Context()
+
+	cfg := config.Config{
+		Backend:       config.BackendMemory,
+		HmacSecret:    "secret",
+		AdminUser:     "admin@example.com",
+		PublicBaseURL: "https://comments.example.com",
+		MaxBodySize:   4 * 8192,
+		Spam:          config.SpamConfig{MaxLinks: 2, RejectScore: 10, DuplicateWindow: 24 * time.Hour},
+		Sites: []config.SiteConfig{{
+			SiteID:          "example.com",
+			MaxNrComments:   100,
+			NotifyRecipient: "admin@example.com",
+			Discovery:       config.DiscoveryConfig{BaseURL: "https://example.com"},
+		}},
+	}
+
+	db, err := memory.Open(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments := memory.NewCommentService(db)
+	stores := Stores{
+		Comments:      comments,
+		Posts:         memory.NewPostRegistry(db),
+		Links:         comments,
+		Outbox:        memory.NewOutbox(db),
+		Tokens:        memory.NewUsedTokens(db),
+		Subscriptions: memory.NewSubscriptionStore(db),
+		SpamModels:    memory.NewSpamModelStore(db),
+		Addresses:     memory.NewAddressRuleStore(db),
+	}
+
+	notifier := &recorder{}
+	return NewServer(ctx, stores, notifier, cfg), notifier
+}
+
+// outboxEntries lists the queued messages of a kind.
+func outboxEntries(t *testing.T, s *Server, kind string) []conduit.OutboxEntry {
+	t.Helper()
+
+	entries, err := s.outbox.OutboxEntries(conduittest.Context(), conduit.OutboxFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found []conduit.OutboxEntry
+	for _, entry := range entries {
+		if entry.Kind == kind {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// captchaCounter accepts every token and counts the checks.
+type captchaCounter struct {
+	mtx    sync.Mutex
+	checks int
+}
+
+func (cc *captchaCounter) Verify(ctx context.Context, token, remoteIP string) error {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	cc.checks++
+	return nil
+}
+
+// countCaptchas replaces the sites' captchas with counters.
+func countCaptchas(s *Server) *captchaCounter {
+	cc := &captchaCounter{}
+	for siteID := range s.captchas {
+		s.captchas[siteID] = cc
+	}
+	return cc
+}
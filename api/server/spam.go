@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/spam"
+)
+
+// spamModerator is who the history says made the spam checks' decisions.
+const spamModerator = "spamcheck"
+
+// screenComment runs the spam checks on a new, pending comment before it is
+// stored. The score and reasons are kept for the moderator, and a clear
+// enough result approves or marks the comment as spam.
+func (s *Server) screenComment(ctx context.Context, comment *conduit.Comment) error {
+	logger := conduit.GetLogger(ctx)
+
+	finding := s.SpamChecks.Run(ctx, comment)
+	comment.SpamScore = finding.Score
+	comment.SpamReasons = finding.Reasons
+
+	site, _ := s.Config.Site(comment.SiteID)
+	policy := spam.Policy{
+		RejectScore:    s.Config.Spam.RejectScore,
+		TrustedAuthors: site.TrustedAuthors,
+		Known:          s.knownAuthor,
+	}
+
+	status := policy.Decide(ctx, comment, finding)
+
+	logger.Info("screened comment", "comment_id", comment.CommentID, "spam_score", finding.Score, "spam_reasons", finding.Reasons, "status", status)
+
+	if status == conduit.StatusPending {
+		return nil
+	}
+
+	if status == conduit.StatusSpam {
+		s.badIPs.Add(comment.SourceAddress)
+	}
+
+	return comment.Moderate(status, spamModerator, time.Time(comment.Timestamp))
+}
+
+// knownAuthor looks for an approved comment on the site from the same email
+// and address as a new one. It reads every approved comment, which is only
+// done for the trusted authors' comments that pass every check.
+func (s *Server) knownAuthor(ctx context.Context, comment *conduit.Comment) (bool, error) {
+	status := conduit.StatusApproved
+
+	approved, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &comment.SiteID, Status: &status}, conduit.PageRequest{})
+	if err != nil {
+		return false, err
+	}
+
+	for _, earlier := range approved.Comments {
+		if earlier.CommentID != comment.CommentID &&
+			strings.EqualFold(strings.TrimSpace(earlier.AuthorEmail), strings.TrimSpace(comment.AuthorEmail)) &&
+			spam.SameAddress(earlier.SourceAddress, comment.SourceAddress) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// afterScreening sends whatever a stored comment calls for: the moderator
+// hears about the queue, subscribers about approvals, and nobody about
+// spam.
+func (s *Server) afterScreening(ctx context.Context, comment *conduit.Comment) {
+	switch comment.Status {
+	case conduit.StatusPending:
+		s.notifyNewComment(ctx, comment)
+	case conduit.StatusApproved:
+		s.notifySubscribers(ctx, comment)
+	}
+}
+
+// LoadSpamIPs tells the bad IP check about the addresses of stored spam, so
+// that it doesn't forget them on a restart.
+func (s *Server) LoadSpamIPs(ctx context.Context) error {
+	logger := conduit.GetLogger(ctx)
+
+	status := conduit.StatusSpam
+
+	for _, site := range s.Config.Sites {
+		found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &site.SiteID, Status: &status}, conduit.PageRequest{})
+		if err != nil {
+			logger.Error("failed to load spam comments", "site_id", site.SiteID, "error", err)
+			return err
+		}
+
+		for _, comment := range found.Comments {
+			s.badIPs.Add(comment.SourceAddress)
+		}
+
+		logger.Info("loaded spam addresses", "site_id", site.SiteID, "count", len(found.Comments))
+	}
+
+	return nil
+}
+
+// labelledByModerator picks the comments whose status a person decided,
+// leaving out what the spam checks and webmention senders did on their own.
+// Comments from before the history was kept were moderated by hand.
+func labelledByModerator(comments []conduit.Comment) []conduit.Comment {
+	var labelled []conduit.Comment
+	for _, comment := range comments {
+		if decidedByModerator(&comment) {
+			labelled = append(labelled, comment)
+		}
+	}
+	return labelled
+}
+
+func decidedByModerator(comment *conduit.Comment) bool {
+	last, ok := comment.LastChange()
+	return !ok || (last.By != spamModerator && last.By != webmentionModerator)
+}
+
+// learn trains the classifier on a moderator's decision. Like Rebuild, it
+// only counts the statuses a moderator chose: the previous version is
+// forgotten only if the model learnt it, and an edit that leaves the spam
+// checks' decision in place teaches nothing.
+func (s *Server) learn(ctx context.Context, previous, updated *conduit.Comment, at time.Time) error {
+	if !decidedByModerator(updated) {
+		return nil
+	}
+	if previous != nil && !decidedByModerator(previous) {
+		previous = nil
+	}
+	return s.classifier.Learn(ctx, previous, updated, at)
+}
+
+// writeSpamModel describes a model without its whole vocabulary.
+func writeSpamModel(ctx context.Context, w http.ResponseWriter, model conduit.SpamModel) {
+	spammy, hammy := spam.Strongest(model)
+
+	writeJSON(ctx, w, http.StatusOK, M{
+		"siteID":       model.SiteID,
+		"nrSpam":       model.NrSpam,
+		"nrHam":        model.NrHam,
+		"vocabulary":   spam.Vocabulary(model),
+		"updatedAt":    model.UpdatedAt,
+		"spamFeatures": spammy,
+		"hamFeatures":  hammy,
+	})
+}
+
+func (s *Server) getSpamModel() http.HandlerFunc {
+	type Input struct {
+		SiteID string `json:"siteID"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "getSpamModel")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if _, ok := s.Config.Site(input.SiteID); !ok {
+			// TODO add to conduit/errors.go
+			logger.Error("unknown siteID", "site_id", input.SiteID)
+			http.Error(w, "Unknown host", http.StatusBadRequest)
+			return
+		}
+
+		model, err := s.classifier.Model(ctx, input.SiteID)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to load spam model", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeSpamModel(ctx, w, model)
+	}
+}
+
+// resetSpamModel throws a site's model away. With retrain, the new model
+// learns from every comment a moderator has already decided on, which
+// undoes drift from edits and pruning without losing what was learnt.
+func (s *Server) resetSpamModel() http.HandlerFunc {
+	type Input struct {
+		SiteID  string `json:"siteID"`
+		Retrain bool   `json:"retrain"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "resetSpamModel")
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		var input Input
+		if err := readJSON(ctx, r.Body, &input, s.Config.MaxBodySize); err != nil {
+			logger.Error("failed to decode json", "error", err)
+			badRequestError(ctx, w)
+			return
+		}
+
+		if _, ok := s.Config.Site(input.SiteID); !ok {
+			// TODO add to conduit/errors.go
+			logger.Error("unknown siteID", "site_id", input.SiteID)
+			http.Error(w, "Unknown host", http.StatusBadRequest)
+			return
+		}
+
+		if !input.Retrain {
+			if err := s.classifier.Reset(ctx, input.SiteID); err != nil {
+				// TODO add to conduit/errors.go
+				logger.Error("failed to reset spam model", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("reset spam model", "site_id", input.SiteID, "by", contextUser(r))
+
+			writeSpamModel(ctx, w, conduit.NewSpamModel(input.SiteID))
+			return
+		}
+
+		found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &input.SiteID}, conduit.PageRequest{})
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to load comments", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		model, err := s.classifier.Rebuild(ctx, input.SiteID, labelledByModerator(found.Comments), time.Now())
+		if err != nil {
+			// TODO add to conduit/errors.go
+			logger.Error("failed to rebuild spam model", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("retrained spam model", "site_id", input.SiteID, "by", contextUser(r), "nr_spam", model.NrSpam, "nr_ham", model.NrHam)
+
+		writeSpamModel(ctx, w, model)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/spam"
+)
+
+type spamModels map[string]conduit.SpamModel
+
+func (m spamModels) SpamModel(ctx context.Context, siteID string) (conduit.SpamModel, bool, error) {
+	model, ok := m[siteID]
+	return model, ok, nil
+}
+
+func (m spamModels) PutSpamModel(ctx context.Context, model conduit.SpamModel) error {
+	m[model.SiteID] = model
+	return nil
+}
+
+func (m spamModels) DeleteSpamModel(ctx context.Context, siteID string) error {
+	delete(m, siteID)
+	return nil
+}
+
+func TestLearnSkipsAutomaticDecisions(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	models := spamModels{}
+	s := &Server{classifier: spam.NewClassifier(models)}
+
+	moderate := func(comment conduit.Comment, to conduit.ModerationStatus, by string) conduit.Comment {
+		t.Helper()
+		if err := comment.Moderate(to, by, now); err != nil {
+			t.Fatal(err)
+		}
+		return comment
+	}
+
+	newComment := func(id string) conduit.Comment {
+		return conduit.Comment{SiteID: "example.com", CommentID: id, Author: "someone", CommentBody: "cheap pills here", Status: conduit.StatusPending}
+	}
+
+	// A moderator's spam is learnt.
+	first := newComment("1")
+	labelled := moderate(first, conduit.StatusSpam, "admin@example.com")
+	if err := s.learn(ctx, &first, &labelled, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The spam checks' decision on the second was never learnt, so approving
+	// it mustn't forget the first.
+	automatic := moderate(newComment("2"), conduit.StatusSpam, spamModerator)
+	approved := moderate(automatic, conduit.StatusApproved, "admin@example.com")
+	if err := s.learn(ctx, &automatic, &approved, now); err != nil {
+		t.Fatal(err)
+	}
+
+	model := models["example.com"]
+	if model.NrSpam != 1 || model.NrHam != 1 {
+		t.Fatalf("got %d spam and %d ham, want 1 and 1", model.NrSpam, model.NrHam)
+	}
+
+	// Editing a comment the spam checks decided on teaches nothing.
+	edited := moderate(newComment("3"), conduit.StatusSpam, spamModerator)
+	edited.CommentBody = "cheaper pills"
+	if err := s.learn(ctx, &automatic, &edited, now); err != nil {
+		t.Fatal(err)
+	}
+
+	model = models["example.com"]
+	if model.NrSpam != 1 || model.NrHam != 1 {
+		t.Fatalf("got %d spam and %d ham after the edit, want 1 and 1", model.NrSpam, model.NrHam)
+	}
+}
+
+func TestScreenCommentTrustedAuthor(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+	s.Config.Sites[0].TrustedAuthors = []string{"owner@example.com"}
+
+	nr := 0
+	screen := func(email, address string) conduit.ModerationStatus {
+		t.Helper()
+		nr++
+		comment := conduit.Comment{
+			SiteID:        "example.com",
+			PostID:        "/post/",
+			CommentID:     fmt.Sprint(nr),
+			Author:        "Owner",
+			AuthorEmail:   email,
+			SourceAddress: address,
+			CommentBody:   fmt.Sprintf("Reply number %d, with nothing wrong in it", nr),
+			Timestamp:     conduit.Timestamp(time.Now()),
+			Status:        conduit.StatusPending,
+		}
+		if err := s.screenComment(ctx, &comment); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+			t.Fatal(err)
+		}
+		return comment.Status
+	}
+
+	// Typing the owner's email isn't enough on its own.
+	if status := screen("owner@example.com", "192.0.2.1"); status != conduit.StatusPending {
+		t.Fatalf("first comment is %s", status)
+	}
+
+	// Once a moderator has approved one, the owner's comments from the same
+	// address skip the queue.
+	siteID, commentID := "example.com", "1"
+	first, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &siteID, CommentID: &commentID}, conduit.PageRequest{})
+	if err != nil || len(first.Comments) != 1 {
+		t.Fatal(first, err)
+	}
+	approved := first.Comments[0]
+	if err := approved.Moderate(conduit.StatusApproved, "admin@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.commentService.UpsertComment(ctx, &approved); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		email, address string
+		want           conduit.ModerationStatus
+	}{
+		{"owner@example.com", "192.0.2.1", conduit.StatusApproved},
+		{" Owner@Example.com", "192.0.2.1:4321", conduit.StatusApproved},
+		{"owner@example.com", "198.51.100.7", conduit.StatusPending},
+		{"someone@example.com", "192.0.2.1", conduit.StatusPending},
+		{"owner@example.com", "", conduit.StatusPending},
+	}
+	for _, tt := range tests {
+		if status := screen(tt.email, tt.address); status != tt.want {
+			t.Errorf("%q from %q: got %s, want %s", tt.email, tt.address, status, tt.want)
+		}
+	}
+}
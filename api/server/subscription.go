@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/modlink"
+	"github.com/carlohamalainen/carlo-comments/notify"
+	"github.com/google/uuid"
+)
+
+// Subscription link actions.
+const (
+	subscriptionConfirm     = "confirm"
+	subscriptionUnsubscribe = "unsubscribe"
+)
+
+// How long a subscriber has to confirm.
+const subscriptionConfirmTTL = 7 * 24 * time.Hour
+
+// subscriptionsEnabled is false if there is no way to email commenters, or
+// no public URL for the links in the emails.
+func (s *Server) subscriptionsEnabled() bool {
+	return s.mailer != nil && s.Config.PublicBaseURL != ""
+}
+
+func (s *Server) postURL(siteID, postID string) string {
+	site, _ := s.Config.Site(siteID)
+	return strings.TrimSuffix(site.Discovery.BaseURL, "/") + postID
+}
+
+func (s *Server) subscriptionLink(sub conduit.Subscription, action string, expiresAt time.Time) (string, error) {
+	claims := modlink.SubscriptionClaims{
+		SubscriptionID: sub.SubscriptionID,
+		SiteID:         sub.SiteID,
+		PostID:         sub.PostID,
+		Action:         action,
+	}
+	if !expiresAt.IsZero() {
+		claims.ExpiresAt = expiresAt.Unix()
+	}
+
+	token, err := s.modLinks.SignSubscription(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return s.Config.PublicBaseURL + "/v1/subscriptions/" + action + "?token=" + url.QueryEscape(token), nil
+}
+
+// findSubscription looks for an address among a post's subscriptions.
+func (s *Server) findSubscription(ctx context.Context, siteID, postID, email string) (*conduit.Subscription, error) {
+	subs, err := s.subscriptions.PostSubscriptions(ctx, siteID, postID)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		if strings.EqualFold(sub.Email, email) {
+			return &sub, nil
+		}
+	}
+	return nil, nil
+}
+
+// subscribe records a pending subscription for the comment's author and
+// queues the confirmation email. An address gets at most one confirmation
+// per post until its link has expired, so this can't be used to flood
+// someone's inbox.
+func (s *Server) subscribe(ctx context.Context, comment *conduit.Comment) {
+	logger := conduit.GetLogger(ctx)
+
+	if !s.subscriptionsEnabled() {
+		logger.Info("ignoring subscription, no way to email subscribers")
+		return
+	}
+	if !conduit.IsValidEmail(comment.AuthorEmail) {
+		logger.Info("ignoring subscription, invalid email")
+		return
+	}
+
+	existing, err := s.findSubscription(ctx, comment.SiteID, comment.PostID, comment.AuthorEmail)
+	if err != nil {
+		logger.Error("failed to look up subscriptions", "error", err)
+		return
+	}
+
+	now := time.Now()
+
+	var sub conduit.Subscription
+	switch {
+	case existing == nil:
+		sub = conduit.Subscription{
+			SubscriptionID: uuid.NewString(),
+			SiteID:         comment.SiteID,
+			PostID:         comment.PostID,
+			Email:          comment.AuthorEmail,
+			Status:         conduit.SubscriptionPending,
+			CreatedAt:      conduit.Timestamp(now),
+		}
+	case existing.Status == conduit.SubscriptionPending && !now.Before(time.Time(existing.CreatedAt).Add(subscriptionConfirmTTL)):
+		// The confirmation link has expired, so it's sent again.
+		logger.Info("renewing unconfirmed subscription", "subscription_id", existing.SubscriptionID)
+		sub = *existing
+		sub.CreatedAt = conduit.Timestamp(now)
+	default:
+		logger.Info("already subscribed", "subscription_id", existing.SubscriptionID, "status", existing.Status)
+		return
+	}
+
+	if err := s.subscriptions.PutSubscription(ctx, sub); err != nil {
+		logger.Error("failed to store subscription", "error", err)
+		return
+	}
+
+	if err := s.enqueue(ctx, conduit.OutboxConfirmSubscription, comment, sub.Email); err != nil {
+		logger.Error("failed to queue confirmation", "subscription_id", sub.SubscriptionID, "error", err)
+	}
+}
+
+// notifySubscribers queues an email to each confirmed subscriber of the
+// comment's post, except its author.
+func (s *Server) notifySubscribers(ctx context.Context, comment *conduit.Comment) {
+	logger := conduit.GetLogger(ctx)
+
+	if !s.subscriptionsEnabled() {
+		return
+	}
+
+	subs, err := s.subscriptions.PostSubscriptions(ctx, comment.SiteID, comment.PostID)
+	if err != nil {
+		logger.Error("failed to look up subscriptions", "error", err)
+		return
+	}
+
+	for _, sub := range subs {
+		if sub.Status != conduit.SubscriptionActive || strings.EqualFold(sub.Email, comment.AuthorEmail) {
+			continue
+		}
+		if err := s.enqueue(ctx, conduit.OutboxCommentOnPost, comment, sub.Email); err != nil {
+			logger.Error("failed to queue subscriber email", "subscription_id", sub.SubscriptionID, "error", err)
+		}
+	}
+}
+
+// outboxComment reads an entry's comment again. A nil comment means it has
+// gone, and the entry can be dropped.
+func (s *Server) outboxComment(ctx context.Context, entry conduit.OutboxEntry) (*conduit.Comment, error) {
+	found, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &entry.SiteID, CommentID: &entry.CommentID}, conduit.PageRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if len(found.Comments) == 0 {
+		return nil, nil
+	}
+	comment := found.Comments[0]
+	comment.ResolveStatus()
+	return &comment, nil
+}
+
+func (s *Server) deliverSubscriptionConfirmation(ctx context.Context, entry conduit.OutboxEntry) error {
+	logger := conduit.GetLogger(ctx)
+
+	if !s.subscriptionsEnabled() {
+		return fmt.Errorf("no way to email subscribers")
+	}
+
+	comment, err := s.outboxComment(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	// Don't send mail on behalf of spammers.
+	if comment == nil || comment.Status == conduit.StatusSpam || comment.Status == conduit.StatusDeleted {
+		logger.Info("dropping confirmation for missing or spam comment", "comment_id", entry.CommentID)
+		return nil
+	}
+
+	sub, err := s.findSubscription(ctx, comment.SiteID, comment.PostID, entry.Recipient)
+	if err != nil {
+		return err
+	}
+	if sub == nil || sub.Status != conduit.SubscriptionPending {
+		logger.Info("dropping confirmation, nothing to confirm", "comment_id", entry.CommentID)
+		return nil
+	}
+
+	confirmLink, err := s.subscriptionLink(*sub, subscriptionConfirm, time.Now().Add(subscriptionConfirmTTL))
+	if err != nil {
+		return err
+	}
+
+	email, err := notify.SubscriptionConfirmation{
+		Recipient:   sub.Email,
+		PostURL:     s.postURL(sub.SiteID, sub.PostID),
+		ConfirmLink: confirmLink,
+	}.Email()
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, email)
+}
+
+func (s *Server) deliverCommentOnPost(ctx context.Context, entry conduit.OutboxEntry) error {
+	logger := conduit.GetLogger(ctx)
+
+	if !s.subscriptionsEnabled() {
+		return fmt.Errorf("no way to email subscribers")
+	}
+
+	comment, err := s.outboxComment(ctx, entry)
+	if err != nil {
+		return err
+	}
+	if comment == nil || comment.Status != conduit.StatusApproved {
+		logger.Info("dropping subscriber email for missing or unapproved comment", "comment_id", entry.CommentID)
+		return nil
+	}
+
+	sub, err := s.findSubscription(ctx, comment.SiteID, comment.PostID, entry.Recipient)
+	if err != nil {
+		return err
+	}
+	if sub == nil || sub.Status != conduit.SubscriptionActive {
+		logger.Info("dropping subscriber email, unsubscribed", "comment_id", entry.CommentID)
+		return nil
+	}
+
+	unsubscribeLink, err := s.subscriptionLink(*sub, subscriptionUnsubscribe, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	email, err := notify.CommentOnPost{
+		Recipient:       sub.Email,
+		PostURL:         s.postURL(sub.SiteID, sub.PostID),
+		Comment:         *comment,
+		UnsubscribeLink: unsubscribeLink,
+	}.Email()
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, email)
+}
+
+// verifySubscriptionLink checks a token for the given action and finds its
+// subscription, which is nil if it has gone. On failure it has already
+// written the error page.
+func (s *Server) verifySubscriptionLink(ctx context.Context, w http.ResponseWriter, token string, action string) (*conduit.Subscription, bool) {
+	logger := conduit.GetLogger(ctx)
+
+	claims, err := s.modLinks.VerifySubscription(token, time.Now())
+	if errors.Is(err, modlink.ErrExpired) {
+		writeLinkPage(ctx, w, http.StatusGone, linkPage{Title: "Link expired", Message: "Leave another comment to subscribe again."})
+		return nil, false
+	}
+	if err != nil || claims.Action != action {
+		logger.Info("bad subscription token", "error", err, "action", claims.Action)
+		writeLinkPage(ctx, w, http.StatusBadRequest, linkPage{Title: "Invalid link"})
+		return nil, false
+	}
+
+	subs, err := s.subscriptions.PostSubscriptions(ctx, claims.SiteID, claims.PostID)
+	if err != nil {
+		logger.Error("failed to look up subscriptions", "error", err)
+		writeLinkPage(ctx, w, http.StatusInternalServerError, linkPage{Title: "Internal server error"})
+		return nil, false
+	}
+	for _, sub := range subs {
+		if sub.SubscriptionID == claims.SubscriptionID {
+			return &sub, true
+		}
+	}
+
+	return nil, true
+}
+
+// confirmSubscriptionLink shows the button for a confirm or unsubscribe
+// link. As with moderation links, only the POST changes anything.
+func (s *Server) confirmSubscriptionLink(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "confirmSubscriptionLink", "action", action)
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		token := r.URL.Query().Get("token")
+
+		sub, ok := s.verifySubscriptionLink(ctx, w, token, action)
+		if !ok {
+			return
+		}
+		if sub == nil {
+			writeLinkPage(ctx, w, http.StatusOK, linkPage{Title: "Not subscribed"})
+			return
+		}
+
+		title := "Confirm your subscription"
+		if action == subscriptionUnsubscribe {
+			title = "Unsubscribe"
+		}
+
+		writeLinkPage(ctx, w, http.StatusOK, linkPage{
+			Title:   title,
+			Message: "New comments on " + s.postURL(sub.SiteID, sub.PostID),
+			Token:   token,
+			Button:  action,
+		})
+	}
+}
+
+// applySubscriptionLink also takes the token in the query string, so that
+// mail clients can unsubscribe in one click (RFC 8058).
+func (s *Server) applySubscriptionLink(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "applySubscriptionLink", "action", action)
+		ctx := conduit.WithLogger(r.Context(), logger)
+
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.Config.MaxBodySize))
+		token := r.FormValue("token")
+
+		sub, ok := s.verifySubscriptionLink(ctx, w, token, action)
+		if !ok {
+			return
+		}
+		if sub == nil {
+			writeLinkPage(ctx, w, http.StatusOK, linkPage{Title: "Not subscribed"})
+			return
+		}
+
+		switch action {
+		case subscriptionConfirm:
+			if sub.Status != conduit.SubscriptionActive {
+				sub.Status = conduit.SubscriptionActive
+				if err := s.subscriptions.PutSubscription(ctx, *sub); err != nil {
+					logger.Error("failed to confirm subscription", "error", err)
+					writeLinkPage(ctx, w, http.StatusInternalServerError, linkPage{Title: "Internal server error"})
+					return
+				}
+				logger.Info("confirmed subscription", "subscription_id", sub.SubscriptionID)
+			}
+			writeLinkPage(ctx, w, http.StatusOK, linkPage{Title: "Subscribed", Message: "New comments on " + s.postURL(sub.SiteID, sub.PostID)})
+
+		case subscriptionUnsubscribe:
+			if err := s.subscriptions.DeleteSubscription(ctx, sub.SiteID, sub.PostID, sub.SubscriptionID); err != nil {
+				logger.Error("failed to unsubscribe", "error", err)
+				writeLinkPage(ctx, w, http.StatusInternalServerError, linkPage{Title: "Internal server error"})
+				return
+			}
+			logger.Info("unsubscribed", "subscription_id", sub.SubscriptionID)
+			writeLinkPage(ctx, w, http.StatusOK, linkPage{Title: "Unsubscribed"})
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+func TestSubscribe(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	comment := &conduit.Comment{SiteID: "example.com", PostID: "/post/", CommentID: "1", AuthorEmail: "reader@example.net"}
+
+	subscription := func() conduit.Subscription {
+		t.Helper()
+		sub, err := s.findSubscription(ctx, comment.SiteID, comment.PostID, comment.AuthorEmail)
+		if err != nil || sub == nil {
+			t.Fatal(sub, err)
+		}
+		return *sub
+	}
+
+	confirmations := func(want int) {
+		t.Helper()
+		if got := len(outboxEntries(t, s, conduit.OutboxConfirmSubscription)); got != want {
+			t.Fatalf("got %d confirmations, want %d", got, want)
+		}
+	}
+
+	s.subscribe(ctx, comment)
+	confirmations(1)
+
+	// A pending subscription whose link still works isn't sent again.
+	s.subscribe(ctx, comment)
+	confirmations(1)
+
+	// Once the link has expired, the same subscription is confirmed afresh.
+	stale := subscription()
+	stale.CreatedAt = conduit.Timestamp(time.Now().Add(-subscriptionConfirmTTL - time.Hour))
+	if err := s.subscriptions.PutSubscription(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	s.subscribe(ctx, comment)
+	confirmations(2)
+
+	renewed := subscription()
+	if renewed.SubscriptionID != stale.SubscriptionID || renewed.Status != conduit.SubscriptionPending {
+		t.Fatalf("got %+v, want %s renewed", renewed, stale.SubscriptionID)
+	}
+	if time.Since(time.Time(renewed.CreatedAt)) > time.Minute {
+		t.Fatalf("renewed subscription created at %v", time.Time(renewed.CreatedAt))
+	}
+
+	// A confirmed subscription is left alone, however old.
+	active := renewed
+	active.Status = conduit.SubscriptionActive
+	active.CreatedAt = stale.CreatedAt
+	if err := s.subscriptions.PutSubscription(ctx, active); err != nil {
+		t.Fatal(err)
+	}
+
+	s.subscribe(ctx, comment)
+	confirmations(2)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type ThreadLayout string
+
+const (
+	NoThreading ThreadLayout = ""     // comments in whatever order the backend returned them
+	ThreadFlat  ThreadLayout = "flat" // depth first, each comment followed by its replies
+	ThreadTree  ThreadLayout = "tree" // top level comments with nested replies
+)
+
+type ThreadedComment struct {
+	conduit.Comment
+	Depth   int                `json:"depth"`
+	Replies []*ThreadedComment `json:"replies,omitempty"`
+}
+
+// buildThreads arranges comments into trees ordered oldest first at every
+// level. A reply whose parent isn't in the list (still pending, say) is shown
+// as a top level comment rather than dropped.
+func buildThreads(comments []conduit.Comment) []*ThreadedComment {
+	present := make(map[string]bool)
+	for _, c := range comments {
+		present[c.CommentID] = true
+	}
+
+	children := make(map[string][]conduit.Comment)
+	var roots []conduit.Comment
+
+	for _, c := range comments {
+		if c.ParentID == "" || !present[c.ParentID] {
+			roots = append(roots, c)
+		} else {
+			children[c.ParentID] = append(children[c.ParentID], c)
+		}
+	}
+
+	visited := make(map[string]bool)
+
+	var build func(c conduit.Comment, depth int) *ThreadedComment
+	build = func(c conduit.Comment, depth int) *ThreadedComment {
+		visited[c.CommentID] = true
+
+		node := &ThreadedComment{Comment: c, Depth: depth}
+
+		replies := children[c.CommentID]
+		sortOldestFirst(replies)
+		for _, r := range replies {
+			if !visited[r.CommentID] {
+				node.Replies = append(node.Replies, build(r, depth+1))
+			}
+		}
+		return node
+	}
+
+	threads := make([]*ThreadedComment, 0)
+
+	sortOldestFirst(roots)
+	for _, c := range roots {
+		threads = append(threads, build(c, 0))
+	}
+
+	// Anything left over is in a cycle of ParentIDs, which only an admin
+	// edit can produce. Show it rather than lose it.
+	var leftovers []conduit.Comment
+	for _, c := range comments {
+		if !visited[c.CommentID] {
+			leftovers = append(leftovers, c)
+		}
+	}
+	sortOldestFirst(leftovers)
+	for _, c := range leftovers {
+		if !visited[c.CommentID] {
+			threads = append(threads, build(c, 0))
+		}
+	}
+
+	return threads
+}
+
+// flattenThreads lists the comments depth first, so that a client can render
+// them in order and indent by Depth.
+func flattenThreads(threads []*ThreadedComment) []ThreadedComment {
+	flat := make([]ThreadedComment, 0)
+
+	var walk func(node *ThreadedComment)
+	walk = func(node *ThreadedComment) {
+		flat = append(flat, ThreadedComment{Comment: node.Comment, Depth: node.Depth})
+		for _, r := range node.Replies {
+			walk(r)
+		}
+	}
+
+	for _, t := range threads {
+		walk(t)
+	}
+
+	return flat
+}
+
+func sortOldestFirst(comments []conduit.Comment) {
+	sort.SliceStable(comments, func(i, j int) bool {
+		ti, tj := time.Time(comments[i].Timestamp), time.Time(comments[j].Timestamp)
+		if ti.Equal(tj) {
+			return comments[i].CommentID < comments[j].CommentID
+		}
+		return ti.Before(tj)
+	})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+func TestThreads(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	start := time.Now()
+	for _, c := range []struct {
+		postID, commentID, parentID string
+		minute                      int
+		status                      conduit.ModerationStatus
+	}{
+		{"/post/", "a", "", 0, conduit.StatusApproved},
+		{"/post/", "b", "a", 2, conduit.StatusApproved},
+		{"/post/", "c", "a", 1, conduit.StatusApproved},
+		{"/post/", "c2", "a", 1, conduit.StatusApproved}, // same time as c
+		{"/post/", "d", "b", 3, conduit.StatusApproved},
+		{"/post/", "e", "", 4, conduit.StatusApproved},
+		{"/post/", "pending", "", 0, conduit.StatusPending},
+		{"/post/", "f", "pending", 5, conduit.StatusApproved}, // orphaned: its parent isn't shown
+		{"/post/", "g", "x", 6, conduit.StatusApproved},       // its parent is on another post
+		{"/post/", "h", "i", 7, conduit.StatusApproved},       // a cycle, from an admin's edits
+		{"/post/", "i", "h", 8, conduit.StatusApproved},
+		{"/other/", "x", "", 0, conduit.StatusApproved},
+		{"/other/", "y", "a", 1, conduit.StatusApproved}, // a reply on another post
+	} {
+		comment := conduit.Comment{
+			SiteID:    "example.com",
+			PostID:    c.postID,
+			CommentID: c.commentID,
+			ParentID:  c.parentID,
+			Timestamp: conduit.Timestamp(start.Add(time.Duration(c.minute) * time.Minute)),
+			Status:    c.status,
+		}
+		if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(layout ThreadLayout, response any) {
+		t.Helper()
+		body := fmt.Sprintf(`{"SiteID": "example.com", "PostID": "/post/", "thread": %q}`, layout)
+		w := httptest.NewRecorder()
+		s.getComments(true, ActiveOnly)(w, httptest.NewRequest(http.MethodPost, "/v1/comments", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: got %d %s", layout, w.Code, w.Body)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// show writes a tree as id[replies], checking each depth on the way.
+	var show func(nodes []*ThreadedComment, depth int) string
+	show = func(nodes []*ThreadedComment, depth int) string {
+		var parts []string
+		for _, node := range nodes {
+			if node.Depth != depth {
+				t.Errorf("%s is at depth %d, want %d", node.CommentID, node.Depth, depth)
+			}
+			part := node.CommentID
+			if len(node.Replies) > 0 {
+				part += "[" + show(node.Replies, depth+1) + "]"
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	var tree []*ThreadedComment
+	get(ThreadTree, &tree)
+	if got, want := show(tree, 0), "a[c c2 b[d]] e f g h[i]"; got != want {
+		t.Fatalf("tree: got %s, want %s", got, want)
+	}
+	// Replies are redacted like everything else.
+	if reply := tree[0].Replies[2].Replies[0]; reply.CommentID != "d" || reply.ParentID != "b" || reply.History != nil {
+		t.Fatalf("reply: %+v", reply)
+	}
+
+	var flat []ThreadedComment
+	get(ThreadFlat, &flat)
+	var got []string
+	for _, c := range flat {
+		if len(c.Replies) != 0 {
+			t.Errorf("%s has nested replies in the flat layout", c.CommentID)
+		}
+		got = append(got, fmt.Sprintf("%s:%d", c.CommentID, c.Depth))
+	}
+	if got, want := strings.Join(got, " "), "a:0 c:1 c2:1 b:1 d:2 e:0 f:0 g:0 h:0 i:1"; got != want {
+		t.Fatalf("flat: got %s, want %s", got, want)
+	}
+
+	// Without threading, the body is still the plain list.
+	var plain []conduit.Comment
+	get(NoThreading, &plain)
+	if len(plain) != 10 {
+		t.Fatalf("plain: got %d comments", len(plain))
+	}
+}
@@ -1,47 +0,0 @@
-package server
-
-import (
-	"encoding/json"
-	"errors"
-	"io"
-	"net/http"
-	"net/url"
-	"time"
-)
-
-const verifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
-
-type TurnstileResponse struct {
-	Success     bool      `json:"success"`
-	ChallengeTS time.Time `json:"challenge_ts"`
-	Hostname    string    `json:"hostname"`
-	ErrorCodes  []string  `json:"error-codes"`
-}
-
-func VerifyTurnstileToken(token, secret string) (*TurnstileResponse, error) {
-	data := url.Values{}
-	data.Set("secret", secret)
-	data.Set("response", token)
-
-	resp, err := http.PostForm(verifyURL, data)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var result TurnstileResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
-	}
-
-	if !result.Success {
-		return &result, errors.New("turnstile verification failed")
-	}
-
-	return &result, nil
-}
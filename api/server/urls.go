@@ -2,99 +2,60 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log/slog"
 	"net/http"
-	"net/url"
-	"strings"
-
-	"golang.org/x/net/html"
+	"time"
 
 	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/discovery"
 )
 
+// InitHost discovers the posts on a site and adds them to the known posts.
+// Posts are never forgotten, so a source that is down for a while doesn't stop
+// comments on posts it had already listed.
 func (s *Server) InitHost(ctx context.Context, host string) error {
 	logger := conduit.GetLogger(ctx)
 
 	logger.Info("initialising host", "host", host)
 
-	urls, err := scrape(logger, "https://"+host)
-	if err != nil {
-		logger.Error("failed to scrape", "error", err)
-		return err
+	site, ok := s.Config.Site(host)
+	if !ok {
+		return fmt.Errorf("unknown host %s", host)
 	}
-	for _, url := range urls {
-		logger.Info("setting known blog post", "host", host, "url", url)
-		s.SetKnown(ctx, host, url)
-	}
-	return nil
-}
 
-func scrape(logger *slog.Logger, pageURL string) ([]string, error) {
-	// The simple thing here would be to do
-	// resp, err := http.Get(pageURL)
-	// but gosec then complains about https://cwe.mitre.org/data/definitions/88.html
-
-	// Intead, parse the URL:
-	parsedURL, err := url.Parse(pageURL)
-	if err != nil {
-		logger.Error("failed to parse URL", "error", err)
-		return nil, fmt.Errorf("invalid URL: %v", err)
-	}
-	// Ensure that it's http or https:
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		logger.Error("invalid URL scheme", "scheme", parsedURL.Scheme)
-		return nil, fmt.Errorf("invalid URL scheme: %s", parsedURL.Scheme)
-	}
-	// Construct a GET request using the parsed URL:
-	req, err := http.NewRequest("GET", parsedURL.String(), nil)
-	if err != nil {
-		logger.Error("failed to create request", "error", err)
-		return nil, err
-	}
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	d, err := discovery.New(site.Discovery, &http.Client{Timeout: s.Config.HandlerTimeout})
 	if err != nil {
-		logger.Error("failed to get page", "error", err)
-		return nil, err
+		logger.Error("failed to set up discovery", "error", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	doc, err := html.Parse(resp.Body)
+	posts, err := d.Discover(ctx)
 	if err != nil {
-		logger.Error("failed to parse body", "error", err)
-		return make([]string, 0), err
-	}
-
-	if _, err = url.Parse(pageURL); err != nil {
-		logger.Error("failed to parse url", "url", pageURL, "error", err)
-		return make([]string, 0), err
-	}
-
-	var links []string
-
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "a" {
-			for _, a := range n.Attr {
-				if a.Key == "href" {
-					if strings.HasPrefix(a.Val, "/2") {
-
-						if !conduit.IsValidPostID(a.Val) {
-							logger.Warn("ignoring link", "page_url", pageURL, "link_url", a.Val)
-							continue
-						}
-
-						links = append(links, strings.TrimSuffix(a.Val, "/"))
-					}
-				}
-			}
+		// Partial results are still worth keeping.
+		logger.Error("failed to discover some posts", "host", host, "error", err)
+	}
+	errs := []error{err}
+
+	now := conduit.Timestamp(time.Now())
+
+	// Likewise, one post that can't be recorded doesn't stop the rest.
+	// SetKnown logs each failure.
+	nrFailed := 0
+	for _, post := range posts {
+		known := conduit.KnownPost{
+			SiteID:       host,
+			PostID:       post.PostID,
+			DiscoveredAt: now,
+			Source:       string(post.Source),
 		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+		if err := s.SetKnown(ctx, known); err != nil {
+			errs = append(errs, fmt.Errorf("recording %s: %w", post.PostID, err))
+			nrFailed++
 		}
 	}
-	f(doc)
 
-	return links, nil
+	logger.Info("discovered posts", "host", host, "count", len(posts), "failed", nrFailed)
+
+	return errors.Join(errs...)
 }
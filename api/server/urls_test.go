@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+)
+
+func TestInitHost(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>/2020/01/01/sitemap-post/</loc></url></urlset>`)
+	})
+	mux.HandleFunc("/rss.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<rss><channel><item><link>/2024/02/02/rss-post/</link></item></channel></rss>`)
+	})
+	mux.HandleFunc("/archive/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><a href="/2025/01/01/archived/">a post</a><a href="/about/">about</a></body></html>`)
+	})
+	site := httptest.NewServer(mux)
+	defer site.Close()
+
+	s.Config.Sites[0].Discovery = config.DiscoveryConfig{
+		BaseURL:     site.URL,
+		PathPattern: "^/2",
+		Sitemaps:    []string{"/sitemap.xml"},
+		Feeds:       []string{"/rss.xml"},
+		Archives:    []string{"/archive/"},
+		MaxPages:    10,
+	}
+
+	if err := s.InitHost(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, postID := range []string{"/2020/01/01/sitemap-post", "/2024/02/02/rss-post", "/2025/01/01/archived"} {
+		if !s.IsKnown("example.com", postID) {
+			t.Errorf("%s is not known", postID)
+		}
+	}
+	if s.IsKnown("example.com", "/about") {
+		t.Error("/about is known")
+	}
+
+	posts, err := s.postRegistry.KnownPosts(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 3 {
+		t.Fatalf("registered %+v", posts)
+	}
+
+	// A restarted server finds them in the registry without discovering
+	// again.
+	s.InitState()
+	if err := s.LoadKnown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsKnown("example.com", "/2024/02/02/rss-post") {
+		t.Error("post not loaded from the registry")
+	}
+}
+
+// failingRegistry refuses to record the posts in fail.
+type failingRegistry struct {
+	conduit.PostRegistry
+	fail map[string]bool
+}
+
+func (fr failingRegistry) AddKnownPost(ctx context.Context, post conduit.KnownPost) (bool, error) {
+	if fr.fail[post.PostID] {
+		return false, errors.New("registry unavailable")
+	}
+	return fr.PostRegistry.AddKnownPost(ctx, post)
+}
+
+func TestInitHostKeepsGoing(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+
+			`<url><loc>/2020/01/01/first/</loc></url><url><loc>/2020/01/02/second/</loc></url>`+
+			`<url><loc>/2020/01/03/third/</loc></url><url><loc>/2020/01/04/fourth/</loc></url></urlset>`)
+	}))
+	defer site.Close()
+
+	s.Config.Sites[0].Discovery = config.DiscoveryConfig{
+		BaseURL:  site.URL,
+		Sitemaps: []string{"/sitemap.xml"},
+	}
+	fail := map[string]bool{"/2020/01/01/first": true, "/2020/01/03/third": true}
+	s.postRegistry = failingRegistry{PostRegistry: s.postRegistry, fail: fail}
+
+	err := s.InitHost(ctx, "example.com")
+	if err == nil {
+		t.Fatal("the failures weren't reported")
+	}
+	for _, postID := range []string{"/2020/01/01/first", "/2020/01/03/third"} {
+		if !strings.Contains(err.Error(), postID) {
+			t.Errorf("%s is missing from %q", postID, err)
+		}
+		if s.IsKnown("example.com", postID) {
+			t.Errorf("%s is known", postID)
+		}
+	}
+
+	// The posts after the first failure are still recorded.
+	for _, postID := range []string{"/2020/01/02/second", "/2020/01/04/fourth"} {
+		if !s.IsKnown("example.com", postID) {
+			t.Errorf("%s is not known", postID)
+		}
+	}
+
+	// And the next run picks up the rest.
+	clear(fail)
+	if err := s.InitHost(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsKnown("example.com", "/2020/01/01/first") || !s.IsKnown("example.com", "/2020/01/03/third") {
+		t.Fatal("the second run didn't record the rest")
+	}
+}
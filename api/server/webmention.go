@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/webmention"
+)
+
+// maxMentionWorkers bounds how many sources are fetched at once. Senders are
+// told to retry later when all are busy.
+const maxMentionWorkers = 4
+
+// mentionTimeout covers fetching the source and storing the result.
+const mentionTimeout = 30 * time.Second
+
+// webmentionModerator is recorded in the history of mentions that are
+// withdrawn or updated by their sender.
+const webmentionModerator = "webmention"
+
+// siteForURL finds which of our sites a URL is on, by the host of its base
+// URL.
+func (s *Server) siteForURL(u *url.URL) (config.SiteConfig, bool) {
+	for _, site := range s.Config.Sites {
+		base, err := url.Parse(site.Discovery.BaseURL)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(base.Host, u.Host) {
+			return site, true
+		}
+	}
+	return config.SiteConfig{}, false
+}
+
+// receiveWebmention implements the receiving side of
+// https://www.w3.org/TR/webmention/. The request is checked here and the
+// source is fetched in the background.
+func (s *Server) receiveWebmention() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger.With("request_id", uuid.NewString(), "handler", "receiveWebmention", "client_ip", getClientIP(r))
+
+		if rule, blocked := s.addressBlocked(conduit.WithLogger(r.Context(), logger), getClientIP(r)); blocked {
+			// TODO add to conduit/errors.go
+			logger.Info("refusing webmention from blocked address", "prefix", rule.Prefix, "reason", rule.Reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.Config.MaxBodySize))
+		if err := r.ParseForm(); err != nil {
+			logger.Error("failed to parse form", "error", err)
+			// TODO add to conduit/errors.go
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		source := r.PostForm.Get("source")
+		target := r.PostForm.Get("target")
+
+		sourceURL, err := webmention.ParseURL(source)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Invalid source", http.StatusBadRequest)
+			return
+		}
+
+		targetURL, err := webmention.ParseURL(target)
+		if err != nil {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Invalid target", http.StatusBadRequest)
+			return
+		}
+
+		if sourceURL.String() == targetURL.String() {
+			// TODO add to conduit/errors.go
+			http.Error(w, "Source and target are the same", http.StatusBadRequest)
+			return
+		}
+
+		site, ok := s.siteForURL(targetURL)
+		postID := strings.TrimSuffix(targetURL.Path, "/")
+		if !ok || !conduit.IsValidPostID(postID) || !s.IsKnown(site.SiteID, postID) {
+			// TODO add to conduit/errors.go
+			logger.Error("unknown target", "target", target)
+			http.Error(w, "Unknown target", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case s.mentionSlots <- struct{}{}:
+		default:
+			logger.Warn("too many webmentions in progress", "source", source, "target", target)
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		sourceAddress := getClientIP(r)
+
+		go func() {
+			defer func() { <-s.mentionSlots }()
+
+			ctx, cancel := context.WithTimeout(conduit.WithLogger(context.Background(), logger), mentionTimeout)
+			defer cancel()
+
+			s.processWebmention(ctx, site, postID, source, target, sourceAddress)
+		}()
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// findMention looks for an earlier mention from the same source.
+func (s *Server) findMention(ctx context.Context, siteID, postID, source string) (*conduit.Comment, error) {
+	page, err := s.commentService.Comments(ctx, conduit.CommentFilter{SiteID: &siteID, PostID: &postID}, conduit.PageRequest{})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range page.Comments {
+		if c.Kind == conduit.KindMention && c.SourceURL == source {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// processWebmention verifies a mention and stores it as a pending comment. A
+// repeated mention updates the earlier one, and a source that no longer links
+// to the target withdraws it.
+func (s *Server) processWebmention(ctx context.Context, site config.SiteConfig, postID, source, target, sourceAddress string) {
+	logger := conduit.GetLogger(ctx).With("source", source, "target", target)
+
+	mention, verifyErr := webmention.Verify(ctx, s.MentionClient, source, target)
+	if verifyErr != nil && !errors.Is(verifyErr, webmention.ErrNoLink) {
+		logger.Error("failed to verify webmention", "error", verifyErr)
+		return
+	}
+
+	existing, err := s.findMention(ctx, site.SiteID, postID, source)
+	if err != nil {
+		logger.Error("failed to look up earlier mention", "error", err)
+		return
+	}
+
+	now := time.Now()
+
+	if verifyErr != nil {
+		logger.Info("webmention has no link to target", "error", verifyErr)
+		if existing != nil && existing.Status != conduit.StatusDeleted {
+			if err := existing.Moderate(conduit.StatusDeleted, webmentionModerator, now); err != nil {
+				logger.Error("failed to withdraw mention", "comment_id", existing.CommentID, "error", err)
+				return
+			}
+			if err := s.commentService.UpsertComment(ctx, existing); err != nil {
+				logger.Error("failed to withdraw mention", "comment_id", existing.CommentID, "error", err)
+				return
+			}
+			logger.Info("withdrew mention", "comment_id", existing.CommentID)
+		}
+		return
+	}
+
+	author := Sanitize(mention.Author)
+	body := Sanitize(mention.Content)
+
+	if existing != nil {
+		changed := existing.Author != author || existing.CommentBody != body
+		existing.Author = author
+		existing.CommentBody = body
+
+		// Changed words need another look, and a restored link brings a
+		// withdrawn mention back. Rejected and spam mentions stay that way.
+		if (changed && existing.Status == conduit.StatusApproved) || existing.Status == conduit.StatusDeleted {
+			if err := existing.Moderate(conduit.StatusPending, webmentionModerator, now); err != nil {
+				logger.Error("failed to requeue mention", "comment_id", existing.CommentID, "error", err)
+				return
+			}
+		}
+
+		if err := s.commentService.UpsertComment(ctx, existing); err != nil {
+			logger.Error("failed to update mention", "comment_id", existing.CommentID, "error", err)
+			return
+		}
+		logger.Info("updated mention", "comment_id", existing.CommentID, "status", existing.Status)
+		return
+	}
+
+	nr, err := s.commentService.NrComments(ctx, conduit.CommentFilter{SiteID: &site.SiteID, PostID: &postID})
+	if err != nil {
+		logger.Error("failed to count nr comments on post", "error", err.Error())
+		return
+	}
+	if nr >= site.MaxNrComments {
+		logger.Info("discarding mention due to over capacity", "site_id", site.SiteID, "post_id", postID)
+		return
+	}
+
+	comment := conduit.Comment{
+		CommentID:     uuid.NewString(),
+		SiteID:        site.SiteID,
+		PostID:        postID,
+		Timestamp:     conduit.Timestamp(now),
+		SourceAddress: sourceAddress,
+		Author:        author,
+		CommentBody:   body,
+		Status:        conduit.StatusPending,
+		Kind:          conduit.KindMention,
+		SourceURL:     source,
+	}
+
+	if err := s.screenComment(ctx, &comment); err != nil {
+		logger.Error("failed to screen mention", "error", err)
+		return
+	}
+
+	if err := s.commentService.UpsertComment(ctx, &comment); err != nil {
+		logger.Error("failed to store mention", "error", err)
+		return
+	}
+	logger.Info("stored mention", "comment_id", comment.CommentID, "status", comment.Status)
+
+	s.afterScreening(ctx, &comment)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+func TestProcessWebmention(t *testing.T) {
+	ctx := conduittest.Context()
+	s, _ := newTestServer(t)
+
+	const target = "https://example.com/post/"
+
+	var linked atomic.Bool
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if !linked.Load() {
+			w.Write([]byte(`<html><body><p>Nothing to see.</p></body></html>`))
+			return
+		}
+		w.Write([]byte(`<html><body><div class="h-entry">
+			<span class="p-author">Some One</span>
+			<div class="e-content">Replying to <a href="` + target + `">the post</a>.</div>
+		</div></body></html>`))
+	}))
+	defer source.Close()
+
+	s.MentionClient = source.Client()
+
+	site, _ := s.Config.Site("example.com")
+
+	mention := func() *conduit.Comment {
+		t.Helper()
+		found, err := s.findMention(ctx, "example.com", "/post", source.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+
+	// A source without the link isn't stored.
+	s.processWebmention(ctx, site, "/post", source.URL, target, "192.0.2.1")
+	if found := mention(); found != nil {
+		t.Fatalf("stored %+v", found)
+	}
+
+	linked.Store(true)
+	s.processWebmention(ctx, site, "/post", source.URL, target, "192.0.2.1")
+	found := mention()
+	if found == nil {
+		t.Fatal("mention not stored")
+	}
+	if found.Author != "Some One" || found.Status != conduit.StatusPending || found.Kind != conduit.KindMention {
+		t.Fatalf("got %+v", found)
+	}
+
+	// Taking the link away withdraws the mention.
+	linked.Store(false)
+	s.processWebmention(ctx, site, "/post", source.URL, target, "192.0.2.1")
+	if found := mention(); found == nil || found.Status != conduit.StatusDeleted {
+		t.Fatalf("got %+v, want it withdrawn", found)
+	}
+}
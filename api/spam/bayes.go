@@ -0,0 +1,407 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"maps"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+const (
+	// The classifier says nothing until it has seen this many examples of
+	// each class.
+	minExamples = 5
+
+	// A model with more features than this forgets the rarest ones, which
+	// keeps it small enough to store as one item or object.
+	maxVocabulary = 5000
+
+	// How many features Strongest lists each way.
+	maxStrongest = 20
+
+	minWordLength = 2
+	maxWordLength = 40
+
+	authorPrefix = "author:"
+	domainPrefix = "domain:"
+)
+
+// What the classifier's probability counts towards Policy.RejectScore.
+const (
+	likelySpam  = 0.9
+	likelyScore = 4
+
+	almostSurelySpam  = 0.99
+	almostSurelyScore = 8
+)
+
+var (
+	tagPattern  = regexp.MustCompile(`<[^>]*>`)
+	wordPattern = regexp.MustCompile(`[\p{L}\p{N}][\p{L}\p{N}'_-]*`)
+)
+
+// Features are what the classifier looks at: the words of the body, the
+// author's name, and the domains the body links to. Each appears once, however
+// often the comment repeats it.
+func Features(comment *conduit.Comment) []string {
+	seen := make(map[string]bool)
+	var features []string
+	add := func(feature string) {
+		if !seen[feature] {
+			seen[feature] = true
+			features = append(features, feature)
+		}
+	}
+
+	text := html.UnescapeString(tagPattern.ReplaceAllString(comment.CommentBody, " "))
+	for _, word := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		if len(word) >= minWordLength && len(word) <= maxWordLength {
+			add(word)
+		}
+	}
+
+	if author := strings.ToLower(strings.TrimSpace(comment.Author)); author != "" {
+		add(authorPrefix + author)
+	}
+
+	for _, link := range Links(comment.CommentBody) {
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+		u, err := url.Parse(link)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		add(domainPrefix + strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."))
+	}
+
+	return features
+}
+
+// class is how a moderation status counts as an example: approved comments
+// are ham, rejected ones and spam are spam, and the rest aren't examples.
+func class(status conduit.ModerationStatus) (isSpam bool, ok bool) {
+	switch status {
+	case conduit.StatusApproved:
+		return false, true
+	case conduit.StatusRejected, conduit.StatusSpam:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// train adds (delta 1) or removes (delta -1) a comment's features as an
+// example of a class. Counts stop at zero, since a pruned or retrained model
+// may not have the features it is asked to forget.
+func train(model *conduit.SpamModel, features []string, isSpam bool, delta int) {
+	nr, tokens := &model.NrHam, model.HamTokens
+	if isSpam {
+		nr, tokens = &model.NrSpam, model.SpamTokens
+	}
+
+	*nr = max(*nr+delta, 0)
+	for _, feature := range features {
+		if n := tokens[feature] + delta; n > 0 {
+			tokens[feature] = n
+		} else {
+			delete(tokens, feature)
+		}
+	}
+}
+
+// Vocabulary is the number of distinct features in a model.
+func Vocabulary(model conduit.SpamModel) int {
+	nr := len(model.SpamTokens)
+	for feature := range model.HamTokens {
+		if _, ok := model.SpamTokens[feature]; !ok {
+			nr++
+		}
+	}
+	return nr
+}
+
+// prune forgets the rarest features once there are too many, down to 90% of
+// the limit so that it doesn't happen on every update.
+func prune(model *conduit.SpamModel) {
+	totals := make(map[string]int)
+	for feature, n := range model.SpamTokens {
+		totals[feature] += n
+	}
+	for feature, n := range model.HamTokens {
+		totals[feature] += n
+	}
+	if len(totals) <= maxVocabulary {
+		return
+	}
+
+	features := make([]string, 0, len(totals))
+	for feature := range totals {
+		features = append(features, feature)
+	}
+	sort.Slice(features, func(i, j int) bool {
+		if totals[features[i]] != totals[features[j]] {
+			return totals[features[i]] < totals[features[j]]
+		}
+		return features[i] < features[j]
+	})
+
+	for _, feature := range features[:len(features)-maxVocabulary*9/10] {
+		delete(model.SpamTokens, feature)
+		delete(model.HamTokens, feature)
+	}
+}
+
+// probability is naive Bayes over the features the model has seen, with
+// add-one smoothing. It is false until the model has enough examples.
+func probability(model *conduit.SpamModel, features []string) (float64, bool) {
+	if model.NrSpam < minExamples || model.NrHam < minExamples {
+		return 0, false
+	}
+
+	logOdds := math.Log(float64(model.NrSpam) / float64(model.NrHam))
+	for _, feature := range features {
+		s, h := model.SpamTokens[feature], model.HamTokens[feature]
+		if s == 0 && h == 0 {
+			continue
+		}
+		pSpam := float64(s+1) / float64(model.NrSpam+2)
+		pHam := float64(h+1) / float64(model.NrHam+2)
+		logOdds += math.Log(pSpam / pHam)
+	}
+
+	return 1 / (1 + math.Exp(-logOdds)), true
+}
+
+// FeatureWeight is how strongly one feature points at spam.
+type FeatureWeight struct {
+	Feature     string  `json:"feature"`
+	Spam        int     `json:"spam"`
+	Ham         int     `json:"ham"`
+	Probability float64 `json:"probability"` // of spam, given only this feature
+}
+
+// Strongest lists the features that most point at spam and at ham, for
+// an admin to see what the model has picked up. Features seen only once are
+// left out as noise.
+func Strongest(model conduit.SpamModel) (spam, ham []FeatureWeight) {
+	features := make(map[string]bool)
+	for feature := range model.SpamTokens {
+		features[feature] = true
+	}
+	for feature := range model.HamTokens {
+		features[feature] = true
+	}
+
+	var weights []FeatureWeight
+	for feature := range features {
+		s, h := model.SpamTokens[feature], model.HamTokens[feature]
+		if s+h < 2 {
+			continue
+		}
+		pSpam := float64(s+1) / float64(model.NrSpam+2)
+		pHam := float64(h+1) / float64(model.NrHam+2)
+		weights = append(weights, FeatureWeight{Feature: feature, Spam: s, Ham: h, Probability: pSpam / (pSpam + pHam)})
+	}
+
+	sort.Slice(weights, func(i, j int) bool {
+		if weights[i].Probability != weights[j].Probability {
+			return weights[i].Probability > weights[j].Probability
+		}
+		return weights[i].Feature < weights[j].Feature
+	})
+
+	for i := 0; i < len(weights) && i < maxStrongest && weights[i].Probability > 0.5; i++ {
+		spam = append(spam, weights[i])
+	}
+	for i := len(weights) - 1; i >= 0 && len(ham) < maxStrongest && weights[i].Probability < 0.5; i-- {
+		ham = append(ham, weights[i])
+	}
+
+	return spam, ham
+}
+
+// Classifier learns from moderators which comments are spam. It keeps each
+// site's model in memory and writes it back to the store on every change;
+// with several servers sharing a store, the last write wins.
+type Classifier struct {
+	store conduit.SpamModelStore
+
+	mtx    sync.Mutex
+	models map[string]*conduit.SpamModel
+}
+
+func NewClassifier(store conduit.SpamModelStore) *Classifier {
+	return &Classifier{store: store, models: make(map[string]*conduit.SpamModel)}
+}
+
+// model must be called with mtx held.
+func (c *Classifier) model(ctx context.Context, siteID string) (*conduit.SpamModel, error) {
+	if model, ok := c.models[siteID]; ok {
+		return model, nil
+	}
+
+	model, found, err := c.store.SpamModel(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		model = conduit.NewSpamModel(siteID)
+	}
+	if model.SpamTokens == nil {
+		model.SpamTokens = make(map[string]int)
+	}
+	if model.HamTokens == nil {
+		model.HamTokens = make(map[string]int)
+	}
+
+	c.models[siteID] = &model
+	return &model, nil
+}
+
+// Learn updates the model when a moderator changes a comment: it forgets
+// the previous version as an example of its class and learns the updated
+// one. previous is nil for a comment that wasn't stored before, or that the
+// model didn't learn from. Changes that keep the class and the text, such as
+// rejected to spam, leave the model alone.
+func (c *Classifier) Learn(ctx context.Context, previous, updated *conduit.Comment, at time.Time) error {
+	var fromSpam, fromOK bool
+	if previous != nil {
+		fromSpam, fromOK = class(previous.Status)
+	}
+	toSpam, toOK := class(updated.Status)
+
+	sameText := previous != nil && previous.Author == updated.Author && previous.CommentBody == updated.CommentBody
+	if fromOK == toOK && fromSpam == toSpam && (sameText || !toOK) {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	model, err := c.model(ctx, updated.SiteID)
+	if err != nil {
+		return err
+	}
+
+	// Changes are made to a copy, so that a failed write leaves the cache
+	// as it is in the store.
+	next := copyModel(*model)
+
+	if fromOK {
+		train(&next, Features(previous), fromSpam, -1)
+	}
+	if toOK {
+		train(&next, Features(updated), toSpam, 1)
+	}
+	prune(&next)
+	next.UpdatedAt = conduit.Timestamp(at)
+
+	if err := c.store.PutSpamModel(ctx, next); err != nil {
+		return err
+	}
+	*model = next
+
+	return nil
+}
+
+// Rebuild replaces a site's model with one trained on the given comments,
+// each an example of the class of its current status.
+func (c *Classifier) Rebuild(ctx context.Context, siteID string, comments []conduit.Comment, at time.Time) (conduit.SpamModel, error) {
+	model := conduit.NewSpamModel(siteID)
+	for i := range comments {
+		if isSpam, ok := class(comments[i].Status); ok {
+			train(&model, Features(&comments[i]), isSpam, 1)
+		}
+	}
+	prune(&model)
+	model.UpdatedAt = conduit.Timestamp(at)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.store.PutSpamModel(ctx, model); err != nil {
+		return conduit.SpamModel{}, err
+	}
+	cached := copyModel(model)
+	c.models[siteID] = &cached
+
+	return model, nil
+}
+
+// Reset forgets everything the classifier learnt about a site.
+func (c *Classifier) Reset(ctx context.Context, siteID string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.store.DeleteSpamModel(ctx, siteID); err != nil {
+		return err
+	}
+	delete(c.models, siteID)
+
+	return nil
+}
+
+// Model returns a copy of a site's model.
+func (c *Classifier) Model(ctx context.Context, siteID string) (conduit.SpamModel, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	model, err := c.model(ctx, siteID)
+	if err != nil {
+		return conduit.SpamModel{}, err
+	}
+	return copyModel(*model), nil
+}
+
+// Probability is how likely the model thinks the comment is spam. It is
+// false while the model has too few examples to say.
+func (c *Classifier) Probability(ctx context.Context, comment *conduit.Comment) (float64, bool, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	model, err := c.model(ctx, comment.SiteID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	p, ok := probability(model, Features(comment))
+	return p, ok, nil
+}
+
+func (*Classifier) Name() string { return "bayes" }
+
+// Check scores comments the model thinks are likely spam, and records the
+// probability on the comment for the moderator.
+func (c *Classifier) Check(ctx context.Context, comment *conduit.Comment) (Finding, error) {
+	p, ok, err := c.Probability(ctx, comment)
+	if err != nil || !ok {
+		return Finding{}, err
+	}
+	comment.SpamProbability = p
+
+	reason := []string{fmt.Sprintf("%.0f%% likely spam", 100*p)}
+	switch {
+	case p >= almostSurelySpam:
+		return Finding{Score: almostSurelyScore, Reasons: reason}, nil
+	case p >= likelySpam:
+		return Finding{Score: likelyScore, Reasons: reason}, nil
+	default:
+		return Finding{}, nil
+	}
+}
+
+func copyModel(model conduit.SpamModel) conduit.SpamModel {
+	model.SpamTokens = maps.Clone(model.SpamTokens)
+	model.HamTokens = maps.Clone(model.HamTokens)
+	return model
+}
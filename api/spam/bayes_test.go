@@ -0,0 +1,263 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+// modelStore keeps models in memory, counting writes, and fails them while
+// fail is set.
+type modelStore struct {
+	models map[string]conduit.SpamModel
+	puts   int
+	fail   bool
+}
+
+func (ms *modelStore) SpamModel(ctx context.Context, siteID string) (conduit.SpamModel, bool, error) {
+	model, ok := ms.models[siteID]
+	return model, ok, nil
+}
+
+func (ms *modelStore) PutSpamModel(ctx context.Context, model conduit.SpamModel) error {
+	if ms.fail {
+		return errors.New("store unavailable")
+	}
+	ms.puts++
+	ms.models[model.SiteID] = copyModel(model)
+	return nil
+}
+
+func (ms *modelStore) DeleteSpamModel(ctx context.Context, siteID string) error {
+	delete(ms.models, siteID)
+	return nil
+}
+
+func newModelStore() *modelStore {
+	return &modelStore{models: make(map[string]conduit.SpamModel)}
+}
+
+func TestFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment conduit.Comment
+		want    []string
+	}{
+		{"words, once each, lower case", conduit.Comment{CommentBody: "Hello hello, WORLD! It's a world of ideas."},
+			[]string{"hello", "world", "it's", "of", "ideas"}},
+		{"tags stripped, entities decoded", conduit.Comment{CommentBody: `<p class="x">Fish&amp;chips</p><br/>end`},
+			[]string{"fish", "chips", "end"}},
+		{"too long", conduit.Comment{CommentBody: "ok " + strings.Repeat("z", maxWordLength+1)},
+			[]string{"ok"}},
+		{"author", conduit.Comment{Author: "  Jane DOE ", CommentBody: "hi there"},
+			[]string{"hi", "there", "author:jane doe"}},
+		{"link domains", conduit.Comment{CommentBody: `<a href="https://www.Shop.example/buy">shop</a> www.shop.example/x http://other.example.`},
+			[]string{"shop", "www", "example", "http", "other", "domain:shop.example", "domain:other.example"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Features(&tt.comment); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// trained is a model that has seen n comments of each class.
+func trained(n int) conduit.SpamModel {
+	model := conduit.NewSpamModel("example.com")
+	for i := 0; i < n; i++ {
+		train(&model, Features(&conduit.Comment{Author: "Seller", CommentBody: fmt.Sprintf("cheap pills casino bonus %d https://pills.example", i)}), true, 1)
+		train(&model, Features(&conduit.Comment{Author: "Reader", CommentBody: fmt.Sprintf("thanks for the thoughtful post %d", i)}), false, 1)
+	}
+	return model
+}
+
+func TestProbability(t *testing.T) {
+	spammy := Features(&conduit.Comment{Author: "Seller", CommentBody: "cheap casino bonus at https://pills.example"})
+	hammy := Features(&conduit.Comment{Author: "Reader", CommentBody: "thanks, a thoughtful post"})
+	unseen := Features(&conduit.Comment{CommentBody: "entirely novel vocabulary"})
+
+	// Too few examples of either class says nothing.
+	few := trained(minExamples - 1)
+	if _, ok := probability(&few, spammy); ok {
+		t.Fatal("a model with too few examples gave a probability")
+	}
+	lopsided := trained(minExamples)
+	lopsided.NrHam = minExamples - 1
+	if _, ok := probability(&lopsided, spammy); ok {
+		t.Fatal("a model with too little ham gave a probability")
+	}
+
+	model := trained(minExamples)
+
+	pSpam, ok := probability(&model, spammy)
+	if !ok || pSpam < almostSurelySpam {
+		t.Errorf("spam: got %v, %v", pSpam, ok)
+	}
+	pHam, ok := probability(&model, hammy)
+	if !ok || pHam > 0.1 {
+		t.Errorf("ham: got %v, %v", pHam, ok)
+	}
+	pUnseen, ok := probability(&model, unseen)
+	if !ok || pUnseen != 0.5 {
+		t.Errorf("unseen: got %v, %v", pUnseen, ok)
+	}
+}
+
+func TestLearn(t *testing.T) {
+	ctx := conduittest.Context()
+	store := newModelStore()
+	c := NewClassifier(store)
+	now := time.Now()
+
+	comment := conduit.Comment{SiteID: "example.com", CommentID: "1", Author: "Someone", CommentBody: "lovely words", Status: conduit.StatusPending}
+
+	moderate := func(comment conduit.Comment, to conduit.ModerationStatus) conduit.Comment {
+		t.Helper()
+		if err := comment.Moderate(to, "admin@example.com", now); err != nil {
+			t.Fatal(err)
+		}
+		return comment
+	}
+
+	counts := func(wantSpam, wantHam int) conduit.SpamModel {
+		t.Helper()
+		model, err := c.Model(ctx, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if model.NrSpam != wantSpam || model.NrHam != wantHam {
+			t.Fatalf("got %d spam and %d ham, want %d and %d", model.NrSpam, model.NrHam, wantSpam, wantHam)
+		}
+		return model
+	}
+
+	approved := moderate(comment, conduit.StatusApproved)
+	if err := c.Learn(ctx, &comment, &approved, now); err != nil {
+		t.Fatal(err)
+	}
+	model := counts(0, 1)
+	if model.HamTokens["lovely"] != 1 || len(model.SpamTokens) != 0 {
+		t.Fatalf("after approval: %+v", model)
+	}
+
+	// Changing its class forgets it as ham.
+	rejected := moderate(approved, conduit.StatusRejected)
+	if err := c.Learn(ctx, &approved, &rejected, now); err != nil {
+		t.Fatal(err)
+	}
+	model = counts(1, 0)
+	if model.SpamTokens["lovely"] != 1 || len(model.HamTokens) != 0 {
+		t.Fatalf("after rejection: %+v", model)
+	}
+
+	// Rejected and spam are the same class: nothing to learn or write.
+	puts := store.puts
+	spam := moderate(rejected, conduit.StatusSpam)
+	if err := c.Learn(ctx, &rejected, &spam, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	model = counts(1, 0)
+	if store.puts != puts || !time.Time(model.UpdatedAt).Equal(now) {
+		t.Fatalf("rejected to spam wrote the model: %d writes, updated %v", store.puts-puts, time.Time(model.UpdatedAt))
+	}
+
+	// Editing a spam comment swaps its features.
+	edited := spam
+	edited.CommentBody = "other words"
+	if err := c.Learn(ctx, &spam, &edited, now); err != nil {
+		t.Fatal(err)
+	}
+	model = counts(1, 0)
+	if model.SpamTokens["lovely"] != 0 || model.SpamTokens["other"] != 1 || model.SpamTokens["words"] != 1 {
+		t.Fatalf("after the edit: %+v", model.SpamTokens)
+	}
+
+	// Pending comments aren't examples.
+	other := conduit.Comment{SiteID: "example.com", CommentID: "2", Author: "Other", CommentBody: "new words", Status: conduit.StatusPending}
+	if err := c.Learn(ctx, nil, &other, now); err != nil {
+		t.Fatal(err)
+	}
+	counts(1, 0)
+}
+
+func TestLearnWriteFailure(t *testing.T) {
+	ctx := conduittest.Context()
+	store := newModelStore()
+	c := NewClassifier(store)
+	now := time.Now()
+
+	first := conduit.Comment{SiteID: "example.com", CommentID: "1", Author: "Someone", CommentBody: "kept", Status: conduit.StatusApproved}
+	if err := c.Learn(ctx, nil, &first, now); err != nil {
+		t.Fatal(err)
+	}
+	before, err := c.Model(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.fail = true
+	second := conduit.Comment{SiteID: "example.com", CommentID: "2", Author: "Someone", CommentBody: "lost", Status: conduit.StatusSpam}
+	if err := c.Learn(ctx, nil, &second, now.Add(time.Hour)); err == nil {
+		t.Fatal("a failed write wasn't reported")
+	}
+
+	after, err := c.Model(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.NrSpam != before.NrSpam || after.NrHam != before.NrHam || after.SpamTokens["lost"] != 0 ||
+		!time.Time(after.UpdatedAt).Equal(time.Time(before.UpdatedAt)) {
+		t.Fatalf("the cache changed: %+v, was %+v", after, before)
+	}
+
+	// Once the store is back, the next change is made on the model as stored.
+	store.fail = false
+	if err := c.Learn(ctx, nil, &second, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if stored := store.models["example.com"]; stored.NrSpam != 1 || stored.NrHam != 1 || stored.HamTokens["kept"] != 1 {
+		t.Fatalf("stored %+v", stored)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	model := conduit.NewSpamModel("example.com")
+	for i := 0; i < maxVocabulary; i++ {
+		model.HamTokens[fmt.Sprintf("ham%05d", i)] = 1
+	}
+
+	// At the limit, nothing goes.
+	prune(&model)
+	if nr := Vocabulary(model); nr != maxVocabulary {
+		t.Fatalf("pruned at the limit, to %d", nr)
+	}
+
+	// One over, and the rarest go until there are 90% of the limit.
+	model.SpamTokens["common"] = 50
+	model.HamTokens["common"] = 50
+	model.SpamTokens["frequent"] = 3
+	prune(&model)
+
+	if nr := Vocabulary(model); nr != maxVocabulary*9/10 {
+		t.Fatalf("pruned to %d features, want %d", nr, maxVocabulary*9/10)
+	}
+	if model.SpamTokens["common"] != 50 || model.HamTokens["common"] != 50 || model.SpamTokens["frequent"] != 3 {
+		t.Fatal("pruning dropped a frequent feature")
+	}
+	// Ties between the rarest go in name order.
+	if _, ok := model.HamTokens["ham00000"]; ok {
+		t.Fatal("kept the first of the rarest")
+	}
+	if _, ok := model.HamTokens[fmt.Sprintf("ham%05d", maxVocabulary-1)]; !ok {
+		t.Fatal("dropped the last of the rarest")
+	}
+}
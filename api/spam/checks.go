@@ -0,0 +1,224 @@
+package spam
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// How much each kind of finding counts towards Policy.RejectScore.
+const (
+	extraLinkScore   = 2  // per link over the limit
+	blockedWordScore = 5  // per blocked word
+	duplicateScore   = 5  // same body on the same site within the window
+	badNetworkScore  = 10 // from a configured bad network
+	spamAddressScore = 5  // from an address that has sent spam before
+)
+
+// Bodies shorter than this are too likely to repeat innocently ("Thanks!").
+const minDuplicateLength = 20
+
+var linkPattern = regexp.MustCompile(`(?i)(?:https?://|www\.)[^\s"'<>]+`)
+
+// Links returns the distinct URLs in a comment body. Sanitized bodies keep
+// anchors, so a link's href and its text are usually the same URL.
+func Links(body string) []string {
+	seen := make(map[string]bool)
+	var links []string
+	for _, link := range linkPattern.FindAllString(body, -1) {
+		link = strings.TrimRight(link, ".,;:!?)")
+		if !seen[strings.ToLower(link)] {
+			seen[strings.ToLower(link)] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// LinkCount scores comments with more than Max links.
+type LinkCount struct {
+	Max int
+}
+
+func (LinkCount) Name() string { return "links" }
+
+func (lc LinkCount) Check(ctx context.Context, comment *conduit.Comment) (Finding, error) {
+	nr := len(Links(comment.CommentBody))
+	if nr <= lc.Max {
+		return Finding{}, nil
+	}
+	return Finding{
+		Score:   float64(extraLinkScore * (nr - lc.Max)),
+		Reasons: []string{fmt.Sprintf("%d links, at most %d expected", nr, lc.Max)},
+	}, nil
+}
+
+// BlockedWords scores comments that use any of a list of words, as whole
+// words and ignoring case, in the author's name or the body.
+type BlockedWords struct {
+	words    []string
+	patterns []*regexp.Regexp
+}
+
+func NewBlockedWords(words []string) *BlockedWords {
+	bw := &BlockedWords{}
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		bw.words = append(bw.words, word)
+		bw.patterns = append(bw.patterns, blockedPattern(word))
+	}
+	return bw
+}
+
+// blockedPattern matches a word on its own. \b only sits between a word
+// character and something else, so it can't mark the end of "c++"; there
+// anything but a word character will do.
+func blockedPattern(word string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(word)
+	if isWordByte(word[0]) {
+		pattern = `\b` + pattern
+	} else {
+		pattern = `(?:^|\W)` + pattern
+	}
+	if isWordByte(word[len(word)-1]) {
+		pattern += `\b`
+	} else {
+		pattern += `(?:\W|$)`
+	}
+	return regexp.MustCompile(`(?i)` + pattern)
+}
+
+// isWordByte is \w.
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+func (*BlockedWords) Name() string { return "words" }
+
+func (bw *BlockedWords) Check(ctx context.Context, comment *conduit.Comment) (Finding, error) {
+	var finding Finding
+	for i, pattern := range bw.patterns {
+		if pattern.MatchString(comment.Author) || pattern.MatchString(comment.CommentBody) {
+			finding.Score += blockedWordScore
+			finding.Reasons = append(finding.Reasons, fmt.Sprintf("blocked word %q", bw.words[i]))
+		}
+	}
+	return finding, nil
+}
+
+// DuplicateBody scores a comment whose body was already posted to the same
+// site within the window, which is what spam runs look like. It only
+// remembers bodies it has seen since the server started.
+type DuplicateBody struct {
+	window time.Duration
+
+	mtx  sync.Mutex
+	seen map[[sha256.Size]byte]time.Time
+}
+
+func NewDuplicateBody(window time.Duration) *DuplicateBody {
+	return &DuplicateBody{window: window, seen: make(map[[sha256.Size]byte]time.Time)}
+}
+
+func (*DuplicateBody) Name() string { return "duplicate" }
+
+func (db *DuplicateBody) Check(ctx context.Context, comment *conduit.Comment) (Finding, error) {
+	body := strings.ToLower(strings.Join(strings.Fields(comment.CommentBody), " "))
+	if len(body) < minDuplicateLength {
+		return Finding{}, nil
+	}
+	key := sha256.Sum256([]byte(comment.SiteID + "\x00" + body))
+
+	now := time.Time(comment.Timestamp)
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	for k, at := range db.seen {
+		if now.Sub(at) > db.window {
+			delete(db.seen, k)
+		}
+	}
+
+	_, dup := db.seen[key]
+	db.seen[key] = now
+
+	if !dup {
+		return Finding{}, nil
+	}
+	return Finding{Score: duplicateScore, Reasons: []string{"same text posted recently"}}, nil
+}
+
+// BadIP scores comments from configured networks, and from addresses that
+// have had a comment marked as spam.
+type BadIP struct {
+	networks []netip.Prefix
+
+	mtx       sync.Mutex
+	addresses map[netip.Addr]bool
+}
+
+func NewBadIP(networks []netip.Prefix) *BadIP {
+	return &BadIP{networks: networks, addresses: make(map[netip.Addr]bool)}
+}
+
+func (*BadIP) Name() string { return "ip" }
+
+// Add remembers an address that sent spam. Anything that doesn't parse as
+// an address is ignored.
+func (b *BadIP) Add(sourceAddress string) {
+	addr, ok := parseAddr(sourceAddress)
+	if !ok {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.addresses[addr] = true
+}
+
+func (b *BadIP) Check(ctx context.Context, comment *conduit.Comment) (Finding, error) {
+	addr, ok := parseAddr(comment.SourceAddress)
+	if !ok {
+		return Finding{}, nil
+	}
+
+	for _, network := range b.networks {
+		if network.Contains(addr) {
+			return Finding{Score: badNetworkScore, Reasons: []string{fmt.Sprintf("address in %s", network)}}, nil
+		}
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.addresses[addr] {
+		return Finding{Score: spamAddressScore, Reasons: []string{"address has sent spam before"}}, nil
+	}
+
+	return Finding{}, nil
+}
+
+// parseAddr reads a source address, which is a bare IP from a proxy header
+// or host:port from the connection.
+func parseAddr(s string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
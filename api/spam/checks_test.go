@@ -0,0 +1,157 @@
+package spam
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+func TestLinks(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"no links here", nil},
+		{"see https://example.com/a.", []string{"https://example.com/a"}},
+		{"(www.example.com/b), and http://example.org!", []string{"www.example.com/b", "http://example.org"}},
+		{"https://example.com/a?x=1;", []string{"https://example.com/a?x=1"}},
+		{`<a href="https://example.com/a">https://example.com/a</a>`, []string{"https://example.com/a"}},
+		{"HTTPS://Example.com/A and https://example.com/a", []string{"HTTPS://Example.com/A"}},
+		{"wwwexample.com and example.com aren't links", nil},
+	}
+
+	for _, tt := range tests {
+		if got := Links(tt.body); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Links(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestLinkCount(t *testing.T) {
+	check := LinkCount{Max: 2}
+
+	tests := []struct {
+		body string
+		want float64
+	}{
+		{"https://a.example https://b.example", 0},
+		{"https://a.example https://b.example https://a.example", 0},
+		{"https://a.example https://b.example www.c.example", extraLinkScore},
+		{"https://a.example https://b.example www.c.example http://d.example", 2 * extraLinkScore},
+	}
+
+	for _, tt := range tests {
+		finding, err := check.Check(conduittest.Context(), &conduit.Comment{CommentBody: tt.body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finding.Score != tt.want || (tt.want > 0) != (len(finding.Reasons) == 1) {
+			t.Errorf("%q: got %+v, want score %v", tt.body, finding, tt.want)
+		}
+	}
+}
+
+func TestBlockedWords(t *testing.T) {
+	check := NewBlockedWords([]string{"casino", " cheap pills ", "", "c++"})
+
+	tests := []struct {
+		author, body string
+		want         float64
+	}{
+		{"Someone", "A thoughtful reply", 0},
+		{"Someone", "Best CASINO in town", blockedWordScore},
+		{"Casino Bonus", "Nice post", blockedWordScore},
+		{"Someone", "casinos and casinoroyale", 0},
+		{"Someone", "Cheap Pills, casino!", 2 * blockedWordScore},
+		{"Someone", "cheap  pills", 0},
+		{"Someone", "I write C++ for a living", blockedWordScore},
+	}
+
+	for _, tt := range tests {
+		finding, err := check.Check(conduittest.Context(), &conduit.Comment{Author: tt.author, CommentBody: tt.body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finding.Score != tt.want {
+			t.Errorf("%q by %q: got %+v, want score %v", tt.body, tt.author, finding, tt.want)
+		}
+	}
+}
+
+func TestDuplicateBody(t *testing.T) {
+	check := NewDuplicateBody(time.Hour)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	long := "This is a long enough comment to repeat"
+	exactlyShort := strings.Repeat("x", minDuplicateLength-1)
+	exactlyLong := strings.Repeat("y", minDuplicateLength)
+
+	tests := []struct {
+		name   string
+		siteID string
+		body   string
+		after  time.Duration
+		want   float64
+	}{
+		{"first time", "a.example", long, 0, 0},
+		{"again", "a.example", long, time.Minute, duplicateScore},
+		{"again, with other spacing and case", "a.example", "  this is a LONG enough\ncomment to repeat ", 2 * time.Minute, duplicateScore},
+		{"on another site", "b.example", long, 3 * time.Minute, 0},
+		{"within the window of the last one", "a.example", long, 62 * time.Minute, duplicateScore},
+		{"after the window", "a.example", long, 3 * time.Hour, 0},
+		{"one under the minimum", "a.example", exactlyShort, 0, 0},
+		{"one under the minimum, again", "a.example", exactlyShort, time.Minute, 0},
+		{"at the minimum", "a.example", exactlyLong, 0, 0},
+		{"at the minimum, again", "a.example", exactlyLong, time.Minute, duplicateScore},
+	}
+
+	for _, tt := range tests {
+		comment := conduit.Comment{SiteID: tt.siteID, CommentBody: tt.body, Timestamp: conduit.Timestamp(start.Add(tt.after))}
+		finding, err := check.Check(conduittest.Context(), &comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finding.Score != tt.want {
+			t.Errorf("%s: got %+v, want score %v", tt.name, finding, tt.want)
+		}
+	}
+}
+
+func TestBadIP(t *testing.T) {
+	check := NewBadIP([]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("2001:db8:bad::/48")})
+	check.Add("192.0.2.7:1234")
+	check.Add("2001:db8::1")
+	check.Add("not an address")
+
+	tests := []struct {
+		address string
+		want    float64
+	}{
+		{"198.51.100.1", 0},
+		{"203.0.113.5", badNetworkScore},
+		{"203.0.113.5:443", badNetworkScore},
+		{"::ffff:203.0.113.5", badNetworkScore},
+		{"[2001:db8:bad::1]:80", badNetworkScore},
+		{"192.0.2.7", spamAddressScore},
+		{"[::ffff:192.0.2.7]:9", spamAddressScore},
+		{"192.0.2.8", 0},
+		{"[2001:db8::1]:80", spamAddressScore},
+		{"2001:db8::2", 0},
+		{"not an address", 0},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		finding, err := check.Check(conduittest.Context(), &conduit.Comment{SourceAddress: tt.address})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finding.Score != tt.want {
+			t.Errorf("%q: got %+v, want score %v", tt.address, finding, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Package spam scores new comments before they are stored. Each Check looks
+// at one thing and returns a score with its reasons; a Chain adds them up
+// and a Policy turns the total into a moderation status.
+package spam
+
+import (
+	"context"
+	"strings"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// Finding is what a check, or a whole chain, made of a comment. A score of 0
+// means nothing looked wrong.
+type Finding struct {
+	Score   float64
+	Reasons []string
+}
+
+type Check interface {
+	Name() string
+	Check(ctx context.Context, comment *conduit.Comment) (Finding, error)
+}
+
+// Chain runs checks in order.
+type Chain []Check
+
+// Run adds up the findings of every check, prefixing each reason with the
+// name of its check. A check that fails is logged and skipped, so a broken
+// check lets comments through to the moderation queue instead of losing
+// them.
+func (chain Chain) Run(ctx context.Context, comment *conduit.Comment) Finding {
+	logger := conduit.GetLogger(ctx)
+
+	var total Finding
+
+	for _, check := range chain {
+		finding, err := check.Check(ctx, comment)
+		if err != nil {
+			logger.Error("spam check failed", "check", check.Name(), "comment_id", comment.CommentID, "error", err)
+			continue
+		}
+
+		total.Score += finding.Score
+		for _, reason := range finding.Reasons {
+			total.Reasons = append(total.Reasons, check.Name()+": "+reason)
+		}
+	}
+
+	return total
+}
+
+// Policy decides what happens to a scored comment.
+type Policy struct {
+	// Comments scoring at least this much are marked as spam.
+	RejectScore float64
+
+	// Comments from these emails are approved straight away, as long as
+	// nothing at all looked wrong and Known vouches for the author.
+	TrustedAuthors []string
+
+	// Known says whether the author of a comment has had one approved
+	// before, from the same email and address. The email is whatever the
+	// commenter typed, so on its own it would let anyone who knows a
+	// trusted author's email skip moderation. Without Known, nobody does.
+	Known func(ctx context.Context, comment *conduit.Comment) (bool, error)
+}
+
+func (p Policy) Decide(ctx context.Context, comment *conduit.Comment, finding Finding) conduit.ModerationStatus {
+	if finding.Score >= p.RejectScore {
+		return conduit.StatusSpam
+	}
+
+	if finding.Score == 0 && p.isTrusted(comment.AuthorEmail) && p.Known != nil {
+		known, err := p.Known(ctx, comment)
+		if err != nil {
+			conduit.GetLogger(ctx).Error("failed to look up trusted author", "comment_id", comment.CommentID, "error", err)
+		} else if known {
+			return conduit.StatusApproved
+		}
+	}
+
+	return conduit.StatusPending
+}
+
+func (p Policy) isTrusted(email string) bool {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return false
+	}
+	for _, trusted := range p.TrustedAuthors {
+		if strings.EqualFold(email, strings.TrimSpace(trusted)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SameAddress says whether two source addresses are the same client
+// address, whether or not they carry a port.
+func SameAddress(a, b string) bool {
+	addrA, okA := parseAddr(a)
+	addrB, okB := parseAddr(b)
+	return okA && okB && addrA == addrB
+}
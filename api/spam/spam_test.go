@@ -0,0 +1,107 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+)
+
+// fixedCheck finds the same thing in every comment, or fails.
+type fixedCheck struct {
+	name    string
+	finding Finding
+	err     error
+}
+
+func (fc fixedCheck) Name() string { return fc.name }
+
+func (fc fixedCheck) Check(ctx context.Context, comment *conduit.Comment) (Finding, error) {
+	return fc.finding, fc.err
+}
+
+func TestChainRun(t *testing.T) {
+	chain := Chain{
+		fixedCheck{name: "one", finding: Finding{Score: 2, Reasons: []string{"a", "b"}}},
+		fixedCheck{name: "broken", finding: Finding{Score: 100, Reasons: []string{"ignored"}}, err: errors.New("unavailable")},
+		fixedCheck{name: "clean"},
+		fixedCheck{name: "two", finding: Finding{Score: 3, Reasons: []string{"c"}}},
+	}
+
+	finding := chain.Run(conduittest.Context(), &conduit.Comment{CommentID: "1"})
+
+	if finding.Score != 5 {
+		t.Errorf("got score %v, want 5", finding.Score)
+	}
+	if got := strings.Join(finding.Reasons, "; "); got != "one: a; one: b; two: c" {
+		t.Errorf("got reasons %q", got)
+	}
+
+	if finding := (Chain{}).Run(conduittest.Context(), &conduit.Comment{}); finding.Score != 0 || finding.Reasons != nil {
+		t.Errorf("empty chain found %+v", finding)
+	}
+}
+
+func TestPolicyDecide(t *testing.T) {
+	errLookup := errors.New("lookup failed")
+
+	known := func(ok bool, err error) func(context.Context, *conduit.Comment) (bool, error) {
+		return func(context.Context, *conduit.Comment) (bool, error) { return ok, err }
+	}
+
+	tests := []struct {
+		name  string
+		email string
+		score float64
+		known func(context.Context, *conduit.Comment) (bool, error)
+		want  conduit.ModerationStatus
+	}{
+		{"clean", "someone@example.com", 0, known(true, nil), conduit.StatusPending},
+		{"below the reject score", "someone@example.com", 9.5, nil, conduit.StatusPending},
+		{"at the reject score", "someone@example.com", 10, nil, conduit.StatusSpam},
+		{"over the reject score", "someone@example.com", 11, nil, conduit.StatusSpam},
+		{"trusted and known", "owner@example.com", 0, known(true, nil), conduit.StatusApproved},
+		{"trusted, known, other case", " OWNER@example.com ", 0, known(true, nil), conduit.StatusApproved},
+		{"trusted but unknown", "owner@example.com", 0, known(false, nil), conduit.StatusPending},
+		{"trusted without a lookup", "owner@example.com", 0, nil, conduit.StatusPending},
+		{"trusted, lookup failed", "owner@example.com", 0, known(true, errLookup), conduit.StatusPending},
+		{"trusted with a finding", "owner@example.com", 1, known(true, nil), conduit.StatusPending},
+		{"trusted at the reject score", "owner@example.com", 10, known(true, nil), conduit.StatusSpam},
+		{"no email", "", 0, known(true, nil), conduit.StatusPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := Policy{RejectScore: 10, TrustedAuthors: []string{"owner@example.com", ""}, Known: tt.known}
+			comment := conduit.Comment{AuthorEmail: tt.email}
+
+			if got := policy.Decide(conduittest.Context(), &comment, Finding{Score: tt.score}); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameAddress(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"192.0.2.1", "192.0.2.1", true},
+		{"192.0.2.1", "192.0.2.1:4321", true},
+		{"::ffff:192.0.2.1", "192.0.2.1", true},
+		{"[2001:db8::1]:80", "2001:db8::1", true},
+		{"192.0.2.1", "192.0.2.2", false},
+		{"", "", false},
+		{"unknown", "unknown", false},
+	}
+
+	for _, tt := range tests {
+		if got := SameAddress(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameAddress(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type AddressRuleStore struct {
+	*DB
+}
+
+func NewAddressRuleStore(db *DB) *AddressRuleStore {
+	return &AddressRuleStore{db}
+}
+
+func (as *AddressRuleStore) AddressRules(ctx context.Context) ([]conduit.AddressRule, error) {
+	logger := conduit.GetLogger(ctx)
+
+	rows, err := as.DB.QueryContext(ctx, `
+		SELECT prefix, list, reason, created_by, created_at_ms, expires_at_ms
+		FROM address_rules
+		`)
+	if err != nil {
+		logger.Error("query failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]conduit.AddressRule, 0)
+	for rows.Next() {
+		var rule conduit.AddressRule
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&rule.Prefix, &rule.List, &rule.Reason, &rule.CreatedBy, &createdAt, &expiresAt); err != nil {
+			logger.Error("scan failed", "error", err)
+			return nil, err
+		}
+		rule.CreatedAt = conduit.Timestamp(time.UnixMilli(createdAt))
+		if expiresAt != 0 {
+			t := conduit.Timestamp(time.UnixMilli(expiresAt))
+			rule.ExpiresAt = &t
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (as *AddressRuleStore) PutAddressRule(ctx context.Context, rule conduit.AddressRule) error {
+	logger := conduit.GetLogger(ctx)
+
+	var expiresAt int64
+	if rule.ExpiresAt != nil {
+		expiresAt = time.Time(*rule.ExpiresAt).UnixMilli()
+	}
+
+	_, err := as.DB.ExecContext(ctx, `
+		INSERT OR REPLACE INTO address_rules (prefix, list, reason, created_by, created_at_ms, expires_at_ms)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`, rule.Prefix, rule.List, rule.Reason, rule.CreatedBy, time.Time(rule.CreatedAt).UnixMilli(), expiresAt)
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (as *AddressRuleStore) DeleteAddressRule(ctx context.Context, prefix string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := as.DB.ExecContext(ctx, "DELETE FROM address_rules WHERE prefix = ?", prefix)
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
@@ -3,7 +3,9 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -19,31 +21,139 @@ func NewCommentService(db *DB) *CommentService {
 	return &CommentService{db}
 }
 
+// whereClause turns the optional parts of a filter into SQL conditions. The
+// caller is responsible for checking which fields are mandatory.
+func whereClause(filter conduit.CommentFilter) (string, []interface{}) {
+	where := " WHERE 1=1"
+	args := []interface{}{}
+
+	if filter.CommentID != nil {
+		where += " AND comment_id = ?"
+		args = append(args, *filter.CommentID)
+	}
+
+	if filter.SiteID != nil {
+		where += " AND site_id = ?"
+		args = append(args, *filter.SiteID)
+	}
+
+	if filter.PostID != nil {
+		where += " AND post_id = ?"
+		args = append(args, *filter.PostID)
+	}
+
+	if filter.ParentID != nil {
+		where += " AND parent_id = ?"
+		args = append(args, *filter.ParentID)
+	}
+
+	if filter.Status != nil {
+		where += " AND status = ?"
+		args = append(args, *filter.Status)
+	}
+
+	if filter.IsActive != nil {
+		where += " AND is_active = ?"
+		args = append(args, *filter.IsActive)
+	}
+
+	return where, args
+}
+
 func (cs *CommentService) NrComments(ctx context.Context, filter conduit.CommentFilter) (int, error) {
-	query := "SELECT COUNT(*) FROM comments WHERE site_id = ? AND post_id = ?"
+	logger := conduit.GetLogger(ctx)
 
 	if filter.SiteID == nil {
-		return 0, fmt.Errorf("need SiteID for count query")
+		return -1, fmt.Errorf("need SiteID for count query")
 	}
 	if filter.PostID == nil {
-		return 0, fmt.Errorf("need PostID for count query")
+		return -1, fmt.Errorf("need PostID for count query")
 	}
 
+	where, args := whereClause(filter)
+	query := "SELECT COUNT(*) FROM comments" + where
+
 	var count int
-	err := cs.DB.QueryRow(query, filter.SiteID, filter.PostID).Scan(&count)
+	err := cs.DB.QueryRowContext(ctx, query, args...).Scan(&count)
 	if err != nil {
+		logger.Error("count failed", "query", query, "args", args, "error", err)
 		return -1, err
 	}
 
 	return count, nil
 }
 
+func (cs *CommentService) NrCommentsByPost(ctx context.Context, filter conduit.CommentFilter, postIDs []string) (map[string]int, error) {
+	logger := conduit.GetLogger(ctx)
+
+	if filter.SiteID == nil {
+		return nil, fmt.Errorf("need SiteID for count query")
+	}
+
+	counts := make(map[string]int)
+	for _, postID := range postIDs {
+		counts[postID] = 0
+	}
+	if len(postIDs) == 0 {
+		return counts, nil
+	}
+
+	filter.PostID = nil
+	where, args := whereClause(filter)
+
+	where += " AND post_id IN (?" + strings.Repeat(", ?", len(postIDs)-1) + ")"
+	for _, postID := range postIDs {
+		args = append(args, postID)
+	}
+
+	query := "SELECT post_id, COUNT(*) FROM comments" + where + " GROUP BY post_id"
+
+	rows, err := cs.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error("count failed", "query", query, "args", args, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID string
+		var count int
+		if err := rows.Scan(&postID, &count); err != nil {
+			logger.Error("scan failed", "error", err)
+			return nil, err
+		}
+		counts[postID] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("row iteration failed", "error", err)
+		return nil, err
+	}
+
+	return counts, nil
+}
+
 func (cs *CommentService) UpsertComment(ctx context.Context, c *conduit.Comment) error {
 	logger := conduit.GetLogger(ctx)
 
-	upsert, err := cs.DB.Prepare(`
-		INSERT OR REPLACE INTO comments (comment_id, site_id, post_id, timestamp, source_address, author, author_email, comment, is_active)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	stored := *c
+	stored.ResolveStatus()
+
+	history, err := json.Marshal(stored.History)
+	if err != nil {
+		logger.Error("failed to marshal history", "error", err)
+		return err
+	}
+
+	spamReasons, err := json.Marshal(stored.SpamReasons)
+	if err != nil {
+		logger.Error("failed to marshal spam reasons", "error", err)
+		return err
+	}
+
+	upsert, err := cs.DB.PrepareContext(ctx, `
+		INSERT OR REPLACE INTO comments (comment_id, site_id, post_id, parent_id, timestamp, timestamp_ms, source_address, author, author_email, comment, status, history, kind, source_url, spam_score, spam_reasons, spam_probability, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		`)
 	if err != nil {
 		logger.Error("prepare failed", "error", err)
@@ -51,7 +161,8 @@ func (cs *CommentService) UpsertComment(ctx context.Context, c *conduit.Comment)
 	}
 	defer upsert.Close()
 
-	_, err = upsert.Exec(c.CommentID, c.SiteID, c.PostID, time.Time(c.Timestamp), c.SourceAddress, c.Author, c.AuthorEmail, c.CommentBody, c.IsActive)
+	_, err = upsert.ExecContext(ctx, stored.CommentID, stored.SiteID, stored.PostID, stored.ParentID, time.Time(stored.Timestamp), time.Time(stored.Timestamp).UnixMilli(), stored.SourceAddress,
+		stored.Author, stored.AuthorEmail, stored.CommentBody, stored.Status, string(history), stored.Kind, stored.SourceURL, stored.SpamScore, string(spamReasons), stored.SpamProbability, stored.IsActive)
 	if err != nil {
 		logger.Error("exec failed", "error", err)
 		return err
@@ -59,68 +170,96 @@ func (cs *CommentService) UpsertComment(ctx context.Context, c *conduit.Comment)
 	return nil
 }
 
-func (cs *CommentService) Comments(ctx context.Context, commentFilter conduit.CommentFilter) ([]conduit.Comment, error) {
+func (cs *CommentService) Comments(ctx context.Context, commentFilter conduit.CommentFilter, page conduit.PageRequest) (conduit.CommentPage, error) {
 	logger := conduit.GetLogger(ctx)
 
 	var rows *sql.Rows
 	var err error
 
-	query := "SELECT comment_id, site_id, post_id, timestamp, author, author_email, comment, is_active FROM comments WHERE 1=1"
-	args := []interface{}{}
+	empty := conduit.CommentPage{Comments: make([]conduit.Comment, 0)}
 
-	if commentFilter.SiteID != nil && *commentFilter.SiteID != "" {
-		query += " AND site_id = ?"
-		args = append(args, *commentFilter.SiteID)
+	if commentFilter.SiteID == nil {
+		return empty, fmt.Errorf("need SiteID for Comment query")
 	}
 
-	if commentFilter.PostID != nil && *commentFilter.PostID != "" {
-		query += " AND post_id = ?"
-		args = append(args, *commentFilter.PostID)
+	where, args := whereClause(commentFilter)
+
+	direction, after := "ASC", ">"
+	if page.Order == conduit.NewestFirst {
+		direction, after = "DESC", "<"
 	}
 
-	if commentFilter.IsActive != nil {
-		query += " AND is_active = ?"
-		args = append(args, *commentFilter.IsActive)
+	if page.Cursor != "" {
+		ms, commentID, err := conduit.DecodeCursor(page.Cursor)
+		if err != nil {
+			return empty, err
+		}
+		where += " AND (timestamp_ms " + after + " ? OR (timestamp_ms = ? AND comment_id " + after + " ?))"
+		args = append(args, ms, ms, commentID)
 	}
 
-	empty := make([]conduit.Comment, 0)
+	query := "SELECT comment_id, site_id, post_id, parent_id, timestamp, source_address, author, author_email, comment, status, history, kind, source_url, spam_score, spam_reasons, spam_probability, is_active FROM comments" + where
+	query += " ORDER BY timestamp_ms " + direction + ", comment_id " + direction
+
+	// One extra row tells us whether there is another page.
+	if page.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, page.Limit+1)
+	}
 
-	rows, err = cs.DB.Query(query, args...)
+	rows, err = cs.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		logger.Error("query failed", "query", query, "args", args, "error", err)
 		return empty, err
 	}
 	defer rows.Close()
 
-	var comments []conduit.Comment
+	comments := make([]conduit.Comment, 0)
 
 	for rows.Next() {
 		var c conduit.Comment
 		var t time.Time
-		err = rows.Scan(&c.CommentID, &c.SiteID, &c.PostID, &c.SourceAddress, &t, &c.Author, &c.AuthorEmail, &c.CommentBody, &c.IsActive)
+		var history, spamReasons string
+		err = rows.Scan(&c.CommentID, &c.SiteID, &c.PostID, &c.ParentID, &t, &c.SourceAddress, &c.Author, &c.AuthorEmail, &c.CommentBody, &c.Status, &history, &c.Kind, &c.SourceURL, &c.SpamScore, &spamReasons, &c.SpamProbability, &c.IsActive)
 		if err != nil {
 			logger.Error("scan failed", "error", err)
 			return empty, err
 		}
 		c.Timestamp = conduit.Timestamp(t)
 
+		if err = json.Unmarshal([]byte(history), &c.History); err != nil {
+			logger.Error("failed to unmarshal history", "error", err, "comment_id", c.CommentID)
+			return empty, err
+		}
+
+		if err = json.Unmarshal([]byte(spamReasons), &c.SpamReasons); err != nil {
+			logger.Error("failed to unmarshal spam reasons", "error", err, "comment_id", c.CommentID)
+			return empty, err
+		}
+		c.ResolveStatus()
+
 		comments = append(comments, c)
 	}
 
-	return comments, nil
+	if err = rows.Err(); err != nil {
+		logger.Error("row iteration failed", "error", err)
+		return empty, err
+	}
+
+	return page.NewPage(comments), nil
 }
 
 func (cs *CommentService) DeleteComment(ctx context.Context, comment *conduit.Comment) error {
 	logger := conduit.GetLogger(ctx)
 
-	stmt, err := cs.DB.Prepare("DELETE FROM comments WHERE comment_id = ")
+	stmt, err := cs.DB.PrepareContext(ctx, "DELETE FROM comments WHERE site_id = ? AND comment_id = ?")
 	if err != nil {
 		logger.Error("failed to prepare DELETE query", "error", err)
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(comment.CommentID)
+	_, err = stmt.ExecContext(ctx, comment.SiteID, comment.CommentID)
 	if err != nil {
 		logger.Error("failed to DELETE comment", "error", err, "comment_id", comment.CommentID)
 		return err
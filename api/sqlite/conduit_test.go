@@ -0,0 +1,95 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+	"github.com/carlohamalainen/carlo-comments/conduit/conduittest"
+	"github.com/carlohamalainen/carlo-comments/config"
+	"github.com/carlohamalainen/carlo-comments/sqlite"
+)
+
+func TestConduit(t *testing.T) {
+	db, err := sqlite.Open(conduittest.Context(), config.Config{SqlitePath: filepath.Join(t.TempDir(), "comments.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("CommentService", func(t *testing.T) { conduittest.TestCommentService(t, sqlite.NewCommentService(db)) })
+	t.Run("PostRegistry", func(t *testing.T) { conduittest.TestPostRegistry(t, sqlite.NewPostRegistry(db)) })
+	t.Run("Outbox", func(t *testing.T) { conduittest.TestOutbox(t, sqlite.NewOutbox(db)) })
+	t.Run("UsedTokens", func(t *testing.T) { conduittest.TestUsedTokens(t, sqlite.NewUsedTokens(db)) })
+	t.Run("SubscriptionStore", func(t *testing.T) { conduittest.TestSubscriptionStore(t, sqlite.NewSubscriptionStore(db)) })
+	t.Run("SpamModelStore", func(t *testing.T) { conduittest.TestSpamModelStore(t, sqlite.NewSpamModelStore(db)) })
+	t.Run("AddressRuleStore", func(t *testing.T) { conduittest.TestAddressRuleStore(t, sqlite.NewAddressRuleStore(db)) })
+}
+
+// A database from before the columns and the (site_id, comment_id) key is
+// brought up to date when opened.
+func TestMigrateBaselineSchema(t *testing.T) {
+	ctx := conduittest.Context()
+	path := filepath.Join(t.TempDir(), "comments.db")
+
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+		CREATE TABLE comments (
+			comment_id TEXT PRIMARY KEY,
+			site_id TEXT NOT NULL,
+			post_id TEXT NOT NULL,
+			timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			author TEXT NOT NULL,
+			author_email TEXT NOT NULL,
+			comment TEXT NOT NULL,
+			is_active INTEGER CHECK (is_active IN (0, 1))
+		);
+		INSERT INTO comments (comment_id, site_id, post_id, timestamp, author, author_email, comment, is_active)
+		VALUES ('1', 'a.example', '/post/', '2024-01-01 12:00:00+00:00', 'Someone', 'someone@example.org', 'Hello', 1);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	// Twice, since a migrated database is opened again on every start.
+	for i := 0; i < 2; i++ {
+		db, err := sqlite.Open(ctx, config.Config{SqlitePath: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs := sqlite.NewCommentService(db)
+
+		siteID, commentID := "a.example", "1"
+		found, err := cs.Comments(ctx, conduit.CommentFilter{SiteID: &siteID, CommentID: &commentID}, conduit.PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found.Comments) != 1 {
+			t.Fatalf("open %d: got %+v", i, found.Comments)
+		}
+		c := found.Comments[0]
+		if c.Status != conduit.StatusApproved || c.CommentBody != "Hello" || !time.Time(c.Timestamp).Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+			t.Fatalf("open %d: got %+v", i, c)
+		}
+
+		// The same ID on another site is a different comment.
+		other := c
+		other.SiteID = "b.example"
+		if err := cs.UpsertComment(ctx, &other); err != nil {
+			t.Fatal(err)
+		}
+		for _, siteID := range []string{"a.example", "b.example"} {
+			postID := "/post/"
+			if nr, err := cs.NrComments(ctx, conduit.CommentFilter{SiteID: &siteID, PostID: &postID}); err != nil || nr != 1 {
+				t.Fatalf("open %d: %s has %d comments, %v", i, siteID, nr, err)
+			}
+		}
+
+		db.Close()
+	}
+}
@@ -3,6 +3,8 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -26,22 +28,324 @@ func Open(ctx context.Context, cfg config.Config) (*DB, error) {
 		return nil, err
 	}
 
+	_, err = db.Exec(commentsTable("comments"))
+	if err != nil {
+		logger.Error("failed to exec CREATE TABLE for comments", "error", err)
+		return nil, err
+	}
+
+	// Databases created before source_address was stored.
+	err = addColumnIfMissing(ctx, db, "comments", "source_address", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	err = addColumnIfMissing(ctx, db, "comments", "parent_id", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	err = addColumnIfMissing(ctx, db, "comments", "status", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	err = addColumnIfMissing(ctx, db, "comments", "history", "TEXT NOT NULL DEFAULT '[]'")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	err = addColumnIfMissing(ctx, db, "comments", "kind", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	err = addColumnIfMissing(ctx, db, "comments", "source_url", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	err = addColumnIfMissing(ctx, db, "comments", "spam_score", "REAL NOT NULL DEFAULT 0")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	err = addColumnIfMissing(ctx, db, "comments", "spam_reasons", "TEXT NOT NULL DEFAULT '[]'")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	err = addColumnIfMissing(ctx, db, "comments", "spam_probability", "REAL NOT NULL DEFAULT 0")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	// The timestamp column is stored as text, which doesn't sort by time,
+	// so listings are ordered by timestamp_ms.
+	err = addColumnIfMissing(ctx, db, "comments", "timestamp_ms", "INTEGER")
+	if err != nil {
+		logger.Error("failed to migrate comments table", "error", err)
+		return nil, err
+	}
+
+	// Databases created when comment_id alone was the key. This has to come
+	// after the columns are added and before the indexes, which go with the
+	// old table.
+	err = rekeyComments(ctx, db)
+	if err != nil {
+		logger.Error("failed to migrate comments primary key", "error", err)
+		return nil, err
+	}
+
+	// Rows from before the moderation status existed; see conduit.StatusFromIsActive.
+	_, err = db.Exec(`
+		UPDATE comments SET status = CASE WHEN is_active = 1 THEN 'approved' ELSE 'pending' END
+		WHERE status = ''
+	`)
+	if err != nil {
+		logger.Error("failed to migrate is_active to status", "error", err)
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS comments_post ON comments (site_id, post_id, status)`)
+	if err != nil {
+		logger.Error("failed to exec CREATE INDEX for comments", "error", err)
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS comments_parent ON comments (site_id, parent_id)`)
+	if err != nil {
+		logger.Error("failed to exec CREATE INDEX for comments", "error", err)
+		return nil, err
+	}
+
+	err = fillTimestampMillis(ctx, db)
+	if err != nil {
+		logger.Error("failed to migrate timestamp to timestamp_ms", "error", err)
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS comments_timestamp ON comments (site_id, timestamp_ms, comment_id)`)
+	if err != nil {
+		logger.Error("failed to exec CREATE INDEX for comments", "error", err)
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS known_posts (
+			site_id TEXT NOT NULL,
+			post_id TEXT NOT NULL,
+			discovered_at_ms INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			PRIMARY KEY (site_id, post_id)
+		);
+    `)
+	if err != nil {
+		logger.Error("failed to exec CREATE TABLE for known_posts", "error", err)
+		return nil, err
+	}
+
 	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS comments (
-			comment_id TEXT PRIMARY KEY,
+		CREATE TABLE IF NOT EXISTS outbox (
+			entry_id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			site_id TEXT NOT NULL,
+			comment_id TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			next_attempt_ms INTEGER NOT NULL,
+			last_error TEXT NOT NULL,
+			created_at_ms INTEGER NOT NULL
+		);
+    `)
+	if err != nil {
+		logger.Error("failed to exec CREATE TABLE for outbox", "error", err)
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS used_tokens (
+			token_id TEXT PRIMARY KEY,
+			expires_at_ms INTEGER NOT NULL
+		);
+    `)
+	if err != nil {
+		logger.Error("failed to exec CREATE TABLE for used_tokens", "error", err)
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscriptions (
+			subscription_id TEXT PRIMARY KEY,
+			site_id TEXT NOT NULL,
+			post_id TEXT NOT NULL,
+			email TEXT NOT NULL,
+			status TEXT NOT NULL,
+			created_at_ms INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_subscriptions_post ON subscriptions (site_id, post_id);
+    `)
+	if err != nil {
+		logger.Error("failed to exec CREATE TABLE for subscriptions", "error", err)
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS spam_models (
+			site_id TEXT PRIMARY KEY,
+			model TEXT NOT NULL,
+			updated_at_ms INTEGER NOT NULL
+		);
+    `)
+	if err != nil {
+		logger.Error("failed to exec CREATE TABLE for spam_models", "error", err)
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS address_rules (
+			prefix TEXT PRIMARY KEY,
+			list TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at_ms INTEGER NOT NULL,
+			expires_at_ms INTEGER NOT NULL DEFAULT 0
+		);
+    `)
+	if err != nil {
+		logger.Error("failed to exec CREATE TABLE for address_rules", "error", err)
+		return nil, err
+	}
+
+	return &DB{db}, nil
+}
+
+// commentsTable is the schema of the comments table, under a name so that
+// rekeyComments can build the new table next to the old one. CommentIDs are
+// unique within a site only.
+func commentsTable(name string) string {
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			comment_id TEXT NOT NULL,
 			site_id TEXT NOT NULL,
 			post_id TEXT NOT NULL,
+			parent_id TEXT NOT NULL DEFAULT '',
 			timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			timestamp_ms INTEGER,
+			source_address TEXT NOT NULL DEFAULT '',
 			author TEXT NOT NULL,
 			author_email TEXT NOT NULL,
 			comment TEXT NOT NULL,
-			is_active INTEGER CHECK (is_active IN (0, 1))
+			status TEXT NOT NULL DEFAULT '',
+			history TEXT NOT NULL DEFAULT '[]',
+			kind TEXT NOT NULL DEFAULT '',
+			source_url TEXT NOT NULL DEFAULT '',
+			spam_score REAL NOT NULL DEFAULT 0,
+			spam_reasons TEXT NOT NULL DEFAULT '[]',
+			spam_probability REAL NOT NULL DEFAULT 0,
+			is_active INTEGER CHECK (is_active IN (0, 1)),
+			PRIMARY KEY (site_id, comment_id)
 		);
-    `)
+    `, name)
+}
+
+// rekeyComments moves a comments table keyed by comment_id alone to the key
+// (site_id, comment_id). SQLite can't change a primary key in place, so the
+// rows are copied to a new table that then takes the old one's name.
+func rekeyComments(ctx context.Context, db *sql.DB) error {
+	var siteKeyed bool
+	err := db.QueryRowContext(ctx, "SELECT pk > 0 FROM pragma_table_info('comments') WHERE name = 'site_id'").Scan(&siteKeyed)
+	if err != nil || siteKeyed {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		logger.Error("failed to exec CREATE TABLE for comments", "error", err)
-		return nil, err
+		return err
 	}
+	defer tx.Rollback()
 
-	return &DB{db}, nil
+	const columns = "comment_id, site_id, post_id, parent_id, timestamp, timestamp_ms, source_address, author, author_email, comment, status, history, kind, source_url, spam_score, spam_reasons, spam_probability, is_active"
+
+	for _, stmt := range []string{
+		commentsTable("comments_rekeyed"),
+		"INSERT INTO comments_rekeyed (" + columns + ") SELECT " + columns + " FROM comments",
+		"DROP TABLE comments",
+		"ALTER TABLE comments_rekeyed RENAME TO comments",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// addColumnIfMissing is the poor man's schema migration: CREATE TABLE IF NOT
+// EXISTS leaves an older table alone, so new columns have to be added by hand.
+func addColumnIfMissing(ctx context.Context, db *sql.DB, table, column, decl string) error {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
+// fillTimestampMillis sets timestamp_ms on rows written before it existed.
+func fillTimestampMillis(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "SELECT site_id, comment_id, timestamp FROM comments WHERE timestamp_ms IS NULL")
+	if err != nil {
+		return err
+	}
+
+	type key struct{ siteID, commentID string }
+	millis := make(map[key]int64)
+	for rows.Next() {
+		var k key
+		var t time.Time
+		if err := rows.Scan(&k.siteID, &k.commentID, &t); err != nil {
+			rows.Close()
+			return err
+		}
+		millis[k] = t.UnixMilli()
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for k, ms := range millis {
+		_, err := db.ExecContext(ctx, "UPDATE comments SET timestamp_ms = ? WHERE site_id = ? AND comment_id = ?", ms, k.siteID, k.commentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
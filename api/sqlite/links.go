@@ -0,0 +1,13 @@
+package sqlite
+
+import "github.com/carlohamalainen/carlo-comments/conduit"
+
+// SQLite has no console to link to.
+
+func (cs *CommentService) CommentLink(c *conduit.Comment) string {
+	return ""
+}
+
+func (cs *CommentService) PendingLink(siteID string) string {
+	return ""
+}
@@ -0,0 +1,94 @@
+package sqlite
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type Outbox struct {
+	*DB
+}
+
+func NewOutbox(db *DB) *Outbox {
+	return &Outbox{db}
+}
+
+func (o *Outbox) PutOutboxEntry(ctx context.Context, entry conduit.OutboxEntry) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := o.DB.ExecContext(ctx, `
+		INSERT OR REPLACE INTO outbox (entry_id, kind, site_id, comment_id, recipient, status, attempts, next_attempt_ms, last_error, created_at_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, entry.EntryID, entry.Kind, entry.SiteID, entry.CommentID, entry.Recipient, entry.Status, entry.Attempts,
+		time.Time(entry.NextAttempt).UnixMilli(), entry.LastError, time.Time(entry.CreatedAt).UnixMilli())
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (o *Outbox) OutboxEntries(ctx context.Context, filter conduit.OutboxFilter) ([]conduit.OutboxEntry, error) {
+	logger := conduit.GetLogger(ctx)
+
+	var conditions []string
+	var args []interface{}
+
+	if filter.EntryID != nil {
+		conditions = append(conditions, "entry_id = ?")
+		args = append(args, *filter.EntryID)
+	}
+	if filter.Status != nil {
+		conditions = append(conditions, "status = ?")
+		args = append(args, *filter.Status)
+	}
+	if filter.DueBy != nil {
+		conditions = append(conditions, "next_attempt_ms <= ?")
+		args = append(args, filter.DueBy.UnixMilli())
+	}
+
+	query := "SELECT entry_id, kind, site_id, comment_id, recipient, status, attempts, next_attempt_ms, last_error, created_at_ms FROM outbox"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at_ms, entry_id"
+
+	rows, err := o.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error("query failed", "query", query, "args", args, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]conduit.OutboxEntry, 0)
+	for rows.Next() {
+		var e conduit.OutboxEntry
+		var nextAttempt, createdAt int64
+		err := rows.Scan(&e.EntryID, &e.Kind, &e.SiteID, &e.CommentID, &e.Recipient, &e.Status, &e.Attempts, &nextAttempt, &e.LastError, &createdAt)
+		if err != nil {
+			logger.Error("scan failed", "error", err)
+			return nil, err
+		}
+		e.NextAttempt = conduit.Timestamp(time.UnixMilli(nextAttempt))
+		e.CreatedAt = conduit.Timestamp(time.UnixMilli(createdAt))
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func (o *Outbox) DeleteOutboxEntry(ctx context.Context, entryID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := o.DB.ExecContext(ctx, "DELETE FROM outbox WHERE entry_id = ?", entryID)
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type PostRegistry struct {
+	*DB
+}
+
+func NewPostRegistry(db *DB) *PostRegistry {
+	return &PostRegistry{db}
+}
+
+func (pr *PostRegistry) KnownPosts(ctx context.Context, siteID string) ([]conduit.KnownPost, error) {
+	logger := conduit.GetLogger(ctx)
+
+	rows, err := pr.DB.QueryContext(ctx, "SELECT site_id, post_id, discovered_at_ms, source FROM known_posts WHERE site_id = ?", siteID)
+	if err != nil {
+		logger.Error("query failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]conduit.KnownPost, 0)
+	for rows.Next() {
+		var p conduit.KnownPost
+		var discoveredAt int64
+		if err := rows.Scan(&p.SiteID, &p.PostID, &discoveredAt, &p.Source); err != nil {
+			logger.Error("scan failed", "error", err)
+			return nil, err
+		}
+		p.DiscoveredAt = conduit.Timestamp(time.UnixMilli(discoveredAt))
+		posts = append(posts, p)
+	}
+
+	return posts, rows.Err()
+}
+
+func (pr *PostRegistry) AddKnownPost(ctx context.Context, post conduit.KnownPost) (bool, error) {
+	logger := conduit.GetLogger(ctx)
+
+	result, err := pr.DB.ExecContext(ctx, `
+		INSERT OR IGNORE INTO known_posts (site_id, post_id, discovered_at_ms, source)
+		VALUES (?, ?, ?, ?)
+		`, post.SiteID, post.PostID, time.Time(post.DiscoveredAt).UnixMilli(), post.Source)
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		logger.Error("rows affected failed", "error", err)
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (pr *PostRegistry) RemoveKnownPost(ctx context.Context, siteID string, postID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := pr.DB.ExecContext(ctx, "DELETE FROM known_posts WHERE site_id = ? AND post_id = ?", siteID, postID)
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
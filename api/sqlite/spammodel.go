@@ -0,0 +1,75 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+// SpamModelStore keeps each site's model as one JSON document.
+type SpamModelStore struct {
+	*DB
+}
+
+func NewSpamModelStore(db *DB) *SpamModelStore {
+	return &SpamModelStore{db}
+}
+
+func (ms *SpamModelStore) SpamModel(ctx context.Context, siteID string) (conduit.SpamModel, bool, error) {
+	logger := conduit.GetLogger(ctx)
+
+	var data string
+	err := ms.DB.QueryRowContext(ctx, "SELECT model FROM spam_models WHERE site_id = ?", siteID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return conduit.SpamModel{}, false, nil
+	}
+	if err != nil {
+		logger.Error("query failed", "error", err)
+		return conduit.SpamModel{}, false, err
+	}
+
+	var model conduit.SpamModel
+	if err := json.Unmarshal([]byte(data), &model); err != nil {
+		logger.Error("failed to unmarshal spam model", "error", err, "site_id", siteID)
+		return conduit.SpamModel{}, false, err
+	}
+
+	return model, true, nil
+}
+
+func (ms *SpamModelStore) PutSpamModel(ctx context.Context, model conduit.SpamModel) error {
+	logger := conduit.GetLogger(ctx)
+
+	data, err := json.Marshal(model)
+	if err != nil {
+		logger.Error("failed to marshal spam model", "error", err)
+		return err
+	}
+
+	_, err = ms.DB.ExecContext(ctx, `
+		INSERT OR REPLACE INTO spam_models (site_id, model, updated_at_ms)
+		VALUES (?, ?, ?)
+		`, model.SiteID, string(data), time.Time(model.UpdatedAt).UnixMilli())
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (ms *SpamModelStore) DeleteSpamModel(ctx context.Context, siteID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := ms.DB.ExecContext(ctx, "DELETE FROM spam_models WHERE site_id = ?", siteID)
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
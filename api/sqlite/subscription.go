@@ -0,0 +1,72 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type SubscriptionStore struct {
+	*DB
+}
+
+func NewSubscriptionStore(db *DB) *SubscriptionStore {
+	return &SubscriptionStore{db}
+}
+
+func (ss *SubscriptionStore) PostSubscriptions(ctx context.Context, siteID, postID string) ([]conduit.Subscription, error) {
+	logger := conduit.GetLogger(ctx)
+
+	rows, err := ss.DB.QueryContext(ctx, `
+		SELECT subscription_id, site_id, post_id, email, status, created_at_ms
+		FROM subscriptions WHERE site_id = ? AND post_id = ?
+		`, siteID, postID)
+	if err != nil {
+		logger.Error("query failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := make([]conduit.Subscription, 0)
+	for rows.Next() {
+		var sub conduit.Subscription
+		var createdAt int64
+		if err := rows.Scan(&sub.SubscriptionID, &sub.SiteID, &sub.PostID, &sub.Email, &sub.Status, &createdAt); err != nil {
+			logger.Error("scan failed", "error", err)
+			return nil, err
+		}
+		sub.CreatedAt = conduit.Timestamp(time.UnixMilli(createdAt))
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+func (ss *SubscriptionStore) PutSubscription(ctx context.Context, sub conduit.Subscription) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := ss.DB.ExecContext(ctx, `
+		INSERT OR REPLACE INTO subscriptions (subscription_id, site_id, post_id, email, status, created_at_ms)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`, sub.SubscriptionID, sub.SiteID, sub.PostID, sub.Email, sub.Status, time.Time(sub.CreatedAt).UnixMilli())
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (ss *SubscriptionStore) DeleteSubscription(ctx context.Context, siteID, postID, subscriptionID string) error {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := ss.DB.ExecContext(ctx, "DELETE FROM subscriptions WHERE site_id = ? AND post_id = ? AND subscription_id = ?",
+		siteID, postID, subscriptionID)
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return err
+	}
+
+	return nil
+}
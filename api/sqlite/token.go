@@ -0,0 +1,43 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/conduit"
+)
+
+type UsedTokens struct {
+	*DB
+}
+
+func NewUsedTokens(db *DB) *UsedTokens {
+	return &UsedTokens{db}
+}
+
+func (ut *UsedTokens) UseToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	logger := conduit.GetLogger(ctx)
+
+	_, err := ut.DB.ExecContext(ctx, "DELETE FROM used_tokens WHERE expires_at_ms < ?", time.Now().UnixMilli())
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return false, err
+	}
+
+	result, err := ut.DB.ExecContext(ctx, `
+		INSERT OR IGNORE INTO used_tokens (token_id, expires_at_ms)
+		VALUES (?, ?)
+		`, tokenID, expiresAt.UnixMilli())
+	if err != nil {
+		logger.Error("exec failed", "error", err)
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		logger.Error("rows affected failed", "error", err)
+		return false, err
+	}
+
+	return n == 1, nil
+}
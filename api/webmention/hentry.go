@@ -0,0 +1,154 @@
+package webmention
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Enough of microformats2 (https://microformats.org/wiki/h-entry) to show a
+// mention as a comment: the author, the content and when it was published.
+
+func hasClass(n *html.Node, class string) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoot is true for the root of a nested microformat, h-card and so on.
+func isRoot(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if strings.HasPrefix(c, "h-") {
+			return true
+		}
+	}
+	return false
+}
+
+// find is a depth first search for the first element with the class. It
+// doesn't look inside nested microformats unless nested is set.
+func find(n *html.Node, class string, nested bool) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if hasClass(c, class) {
+			return c
+		}
+		if !nested && isRoot(c) {
+			continue
+		}
+		if found := find(c, class, nested); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func text(n *html.Node) string {
+	var b strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func innerHTML(n *html.Node) string {
+	var buf bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&buf, c); err != nil {
+			return ""
+		}
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+var publishedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parsePublished(n *html.Node) time.Time {
+	value := attr(n, "datetime")
+	if value == "" {
+		value = text(n)
+	}
+	for _, layout := range publishedLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseEntry reads the first h-entry on the page. Without one, the page title
+// stands in for the content.
+func parseEntry(doc *html.Node) Mention {
+	var m Mention
+
+	entry := find(doc, "h-entry", true)
+	if entry == nil {
+		m.Content = html.EscapeString(pageTitle(doc))
+		return m
+	}
+
+	if author := find(entry, "p-author", false); author != nil {
+		m.Author = text(author)
+		if name := find(author, "p-name", false); name != nil && hasClass(author, "h-card") {
+			m.Author = text(name)
+		}
+	}
+
+	switch {
+	case find(entry, "e-content", false) != nil:
+		m.Content = innerHTML(find(entry, "e-content", false))
+	case find(entry, "p-summary", false) != nil:
+		m.Content = html.EscapeString(text(find(entry, "p-summary", false)))
+	case find(entry, "p-name", false) != nil:
+		m.Content = html.EscapeString(text(find(entry, "p-name", false)))
+	default:
+		m.Content = html.EscapeString(pageTitle(doc))
+	}
+
+	if published := find(entry, "dt-published", false); published != nil {
+		m.Published = parsePublished(published)
+	}
+
+	return m
+}
+
+func pageTitle(doc *html.Node) string {
+	var title string
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if title != "" {
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "title" {
+			title = text(n)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	return title
+}
@@ -0,0 +1,187 @@
+// Package webmention verifies W3C Webmentions: it fetches the source page,
+// checks that it really links to the target, and reads the h-entry
+// microformat for who wrote it and what they said.
+package webmention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// ErrNoLink means the source doesn't (or no longer does) link to the target,
+// including when the source is gone. A mention it made before should be
+// withdrawn.
+var ErrNoLink = errors.New("source does not link to target")
+
+// maxSourceSize stops a misbehaving source from feeding us an endless body.
+const maxSourceSize = 1 << 20
+
+// Mention is what the source page says about itself. Content is HTML taken
+// straight from the page and must be sanitized before use.
+type Mention struct {
+	Author    string
+	Content   string
+	Published time.Time // zero if the page doesn't say
+}
+
+// NewClient returns a client that refuses to connect to loopback, private and
+// link-local addresses, since the source URL comes from anyone on the
+// internet.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+				return fmt.Errorf("refusing to connect to %s", host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// ParseURL accepts only absolute http and https URLs.
+func ParseURL(rawURL string) (*url.URL, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL scheme: %s", parsedURL.Scheme)
+	}
+	if parsedURL.Host == "" {
+		return nil, fmt.Errorf("invalid URL: no host")
+	}
+	return parsedURL, nil
+}
+
+// sameURL compares URLs the way people write links: the fragment, a
+// trailing slash and the case of the host don't matter.
+func sameURL(a, b *url.URL) bool {
+	return a.Scheme == b.Scheme &&
+		strings.EqualFold(a.Host, b.Host) &&
+		strings.TrimSuffix(a.Path, "/") == strings.TrimSuffix(b.Path, "/") &&
+		a.RawQuery == b.RawQuery
+}
+
+// Verify fetches source and checks that it links to target.
+func Verify(ctx context.Context, client *http.Client, source, target string) (Mention, error) {
+	sourceURL, err := ParseURL(source)
+	if err != nil {
+		return Mention{}, err
+	}
+
+	targetURL, err := ParseURL(target)
+	if err != nil {
+		return Mention{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL.String(), nil)
+	if err != nil {
+		return Mention{}, err
+	}
+	req.Header.Set("Accept", "text/html, */*;q=0.5")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Mention{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound:
+		return Mention{}, fmt.Errorf("%w: GET %s: %s", ErrNoLink, source, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return Mention{}, fmt.Errorf("GET %s: %s", source, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceSize))
+	if err != nil {
+		return Mention{}, err
+	}
+
+	// Relative links resolve against wherever redirects ended up.
+	pageURL := resp.Request.URL
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		// Not a page we can read, but a plain mention still counts.
+		if !strings.Contains(string(body), target) {
+			return Mention{}, ErrNoLink
+		}
+		return Mention{Author: sourceURL.Hostname()}, nil
+	}
+
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return Mention{}, fmt.Errorf("failed to parse source: %v", err)
+	}
+
+	if !linksTo(doc, pageURL, targetURL) {
+		return Mention{}, ErrNoLink
+	}
+
+	m := parseEntry(doc)
+	if m.Author == "" {
+		m.Author = sourceURL.Hostname()
+	}
+
+	return m, nil
+}
+
+// linksTo looks for the target in anything that can link or embed.
+func linksTo(doc *html.Node, page, target *url.URL) bool {
+	found := false
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if found {
+			return
+		}
+		if n.Type == html.ElementNode {
+			for _, key := range []string{"href", "src"} {
+				if ref := attr(n, key); ref != "" {
+					if refURL, err := page.Parse(strings.TrimSpace(ref)); err == nil && sameURL(refURL, target) {
+						found = true
+						return
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+
+	return found
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package webmention_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlohamalainen/carlo-comments/webmention"
+)
+
+const target = "https://example.com/posts/hello/"
+
+// source serves pages by path.
+func source(t *testing.T, pages map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		contentType := "text/html; charset=utf-8"
+		if strings.HasSuffix(r.URL.Path, ".txt") {
+			contentType = "text/plain"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(page))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestVerify(t *testing.T) {
+	server := source(t, map[string]string{
+		"/entry": `<html><head><title>A reply</title></head><body>
+			<article class="h-entry">
+				<a class="p-author h-card" href="https://someone.example.net/"><span class="p-name">Some One</span></a>
+				<time class="dt-published" datetime="2026-10-01T12:00:00Z">1 October</time>
+				<div class="e-content">I liked <a href="https://example.com/posts/hello#comments">this post</a>.</div>
+			</article></body></html>`,
+		"/plain-page": `<html><head><title>Just a title</title></head><body><a href="https://EXAMPLE.com/posts/hello">link</a></body></html>`,
+		"/unrelated":  `<html><head><title>Nothing here</title></head><body><a href="https://example.com/posts/other/">other</a></body></html>`,
+		"/text.txt":   "see " + target,
+	})
+
+	tests := []struct {
+		name      string
+		path      string
+		wantErr   error
+		author    string
+		content   string
+		published time.Time
+	}{
+		{"h-entry", "/entry", nil, "Some One",
+			`I liked <a href="https://example.com/posts/hello#comments">this post</a>.`,
+			time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)},
+		{"no h-entry", "/plain-page", nil, "127.0.0.1", "Just a title", time.Time{}},
+		{"no link", "/unrelated", webmention.ErrNoLink, "", "", time.Time{}},
+		{"gone", "/missing", webmention.ErrNoLink, "", "", time.Time{}},
+		{"plain text", "/text.txt", nil, "127.0.0.1", "", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mention, err := webmention.Verify(context.Background(), server.Client(), server.URL+tt.path, target)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if mention.Author != tt.author || mention.Content != tt.content || !mention.Published.Equal(tt.published) {
+				t.Fatalf("got %+v", mention)
+			}
+		})
+	}
+}
+
+func TestNewClientRefusesPrivateAddresses(t *testing.T) {
+	server := source(t, map[string]string{"/entry": `<a href="` + target + `">hello</a>`})
+
+	client := webmention.NewClient(time.Second)
+
+	sources := []string{
+		server.URL + "/entry",
+		"http://10.0.0.1/",
+		"http://192.168.1.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/",
+		"http://[fd00::1]/",
+	}
+
+	for _, source := range sources {
+		_, err := webmention.Verify(context.Background(), client, source, target)
+		if err == nil || !strings.Contains(err.Error(), "refusing to connect") {
+			t.Errorf("%s: got error %v, want a refusal", source, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+#!/bin/bash
+
+# Adds the ParentIndex used for threaded replies to a table that was created
+# before it existed in dynamodb-schema.json.
+
+TABLE_NAME=${TABLE_NAME:-BlogComments}
+
+aws dynamodb update-table \
+    --table-name "${TABLE_NAME}" \
+    --attribute-definitions AttributeName=SiteID,AttributeType=S AttributeName=ParentID,AttributeType=S \
+    --global-secondary-index-updates \
+        '[{"Create":{"IndexName":"ParentIndex","KeySchema":[{"AttributeName":"SiteID","KeyType":"HASH"},{"AttributeName":"ParentID","KeyType":"RANGE"}],"Projection":{"ProjectionType":"ALL"}}}]' \
+    --region us-east-1
@@ -0,0 +1,39 @@
+#!/bin/bash
+
+# Adds the TimestampIndex used to page through a site's comments to a table
+# that was created before it existed in dynamodb-schema.json. Whole-site
+# listings fail until the index has finished building.
+#
+# The index is sorted on TimeOrder, the Timestamp zero padded to 16 digits,
+# then "#" and the CommentID. The API writes it with every comment; this
+# fills it in on comments written before it did, which would otherwise be
+# missing from the index.
+
+set -euo pipefail
+
+TABLE_NAME=${TABLE_NAME:-BlogComments}
+REGION=${REGION:-us-east-1}
+
+aws dynamodb update-table \
+    --table-name "${TABLE_NAME}" \
+    --attribute-definitions AttributeName=SiteID,AttributeType=S AttributeName=TimeOrder,AttributeType=S \
+    --global-secondary-index-updates \
+        '[{"Create":{"IndexName":"TimestampIndex","KeySchema":[{"AttributeName":"SiteID","KeyType":"HASH"},{"AttributeName":"TimeOrder","KeyType":"RANGE"}],"Projection":{"ProjectionType":"ALL"}}}]' \
+    --region "${REGION}"
+
+aws dynamodb scan \
+    --table-name "${TABLE_NAME}" \
+    --projection-expression "SiteID, CommentID, #ts" \
+    --filter-expression "attribute_not_exists(TimeOrder)" \
+    --expression-attribute-names '{"#ts":"Timestamp"}' \
+    --region "${REGION}" \
+    --output json |
+jq -c '.Items[] | {key: {SiteID: .SiteID, CommentID: .CommentID}, order: {":order": {S: ("\(.Timestamp.N | tonumber | tostring | ("0" * (16 - length)) + .)#\(.CommentID.S)")}}}' |
+while read -r item; do
+    aws dynamodb update-item \
+        --table-name "${TABLE_NAME}" \
+        --key "$(jq -c .key <<< "${item}")" \
+        --update-expression "SET TimeOrder = :order" \
+        --expression-attribute-values "$(jq -c .order <<< "${item}")" \
+        --region "${REGION}"
+done
@@ -3,4 +3,17 @@
 aws dynamodb create-table \
     --cli-input-json file://dynamodb-schema.json \
     --billing-mode PAY_PER_REQUEST \
-    --region us-east-1
\ No newline at end of file
+    --region us-east-1
+
+# Known posts, the notification outbox and other non-comment items.
+aws dynamodb create-table \
+    --cli-input-json file://dynamodb-meta-schema.json \
+    --billing-mode PAY_PER_REQUEST \
+    --region us-east-1
+
+# Lets DynamoDB drop used moderation tokens once they have expired.
+aws dynamodb wait table-exists --table-name BlogCommentsMeta --region us-east-1
+aws dynamodb update-time-to-live \
+    --table-name BlogCommentsMeta \
+    --time-to-live-specification "Enabled=true, AttributeName=ExpiresAt" \
+    --region us-east-1
@@ -29,7 +29,7 @@ kubectl create secret generic ${SECRETS} \
     --from-literal=ADMIN_PASS=${ADMIN_PASS} \
     --from-literal=S3_REGION=${S3_REGION} \
     --from-literal=S3_BUCKET=${S3_BUCKET} \
-    --from-literal=SES_IDENTITY=${SES_IDENTITY} \
+    --from-literal=SES_IDENTITY=${SES_IDENTITY:-} \
     --from-literal=CF_SITE_KEY=${CF_SITE_KEY} \
     --from-literal=CF_SECRET_KEY=${CF_SECRET_KEY}
 
@@ -56,6 +56,9 @@ kubectl create configmap ${CONFIG_MAP} \
     --from-literal=HANDLER_TIMEOUT=${HANDLER_TIMEOUT} \
     --from-literal=LIMITER_RATE=${LIMITER_RATE} \
     --from-literal=LIMITER_BURST=${LIMITER_BURST} \
+    --from-literal=TRUSTED_PROXIES=${TRUSTED_PROXIES:-} \
+    --from-literal=CLIENT_IP_HEADER=${CLIENT_IP_HEADER:-X-Forwarded-For} \
+    --from-literal=PROXY_PROTOCOL=${PROXY_PROTOCOL:-false} \
     --from-literal=COMMENT_HOST=${COMMENT_HOST} \
     --from-literal=CORS_ALLOWED_ORIGINS="https://carlo-hamalainen.net,http://localhost,http://localhost:8000,http://localhost:1313" # FIXME
 kubectl -n ${NAMESPACE} describe configmap ${CONFIG_MAP}
\ No newline at end of file